@@ -6,6 +6,7 @@ import (
 	"strings"
 
 	"github.com/bvnk/bank/appauth"
+	"github.com/bvnk/bank/sanctions"
 	"github.com/shopspring/decimal"
 )
 
@@ -58,6 +59,11 @@ Accounts (acmt) transactions are as follows:
 1103 - MerchantAccountDelete
 1104 - MerchantAccountSearch
 
+## Staff
+1200 - SetOverdraft
+1201 - FreezeAccount
+1202 - UnfreezeAccount
+
 */
 
 /* acmt~1~
@@ -106,6 +112,7 @@ type AccountDetails struct {
 	Overdraft         decimal.Decimal
 	AvailableBalance  decimal.Decimal
 	Type              string
+	Status            string
 	Timestamp         int
 }
 
@@ -137,6 +144,22 @@ const (
 	OPENING_OVERDRAFT = 0.
 )
 
+// Account statuses. Only active accounts can send or receive payments
+const (
+	ACCOUNT_STATUS_ACTIVE  = "active"
+	ACCOUNT_STATUS_PENDING = "pending"
+	ACCOUNT_STATUS_FROZEN  = "frozen"
+	ACCOUNT_STATUS_CLOSED  = "closed"
+)
+
+// LocalBankNumber is the number other banks know this bank by
+func LocalBankNumber() string {
+	if Config.BankNumber != "" {
+		return Config.BankNumber
+	}
+	return BANK_NUMBER
+}
+
 func ProcessAccount(data []string) (result interface{}, err error) {
 	if len(data) < 3 {
 		return "", errors.New("accounts.ProcessAccount: Not enough fields, minimum 3")
@@ -262,6 +285,26 @@ func ProcessAccount(data []string) (result interface{}, err error) {
 		if err != nil {
 			return "", errors.New("accounts.ProcessAccount: " + err.Error())
 		}
+	// Set an account's overdraft (staff)
+	case 1200:
+		if len(data) < 7 {
+			err = errors.New("accounts.ProcessAccount: Not all fields present")
+			return
+		}
+		result, err = setOverdraft(data)
+		if err != nil {
+			return "", errors.New("accounts.ProcessAccount: " + err.Error())
+		}
+	// Freeze or unfreeze an account (staff)
+	case 1201, 1202:
+		if len(data) < 6 {
+			err = errors.New("accounts.ProcessAccount: Not all fields present")
+			return
+		}
+		result, err = freezeAccount(data, acmtType == 1201)
+		if err != nil {
+			return "", errors.New("accounts.ProcessAccount: " + err.Error())
+		}
 	default:
 		err = errors.New("accounts.ProcessAccount: ACMT transaction code invalid")
 		break
@@ -290,15 +333,50 @@ func openAccount(data []string) (result interface{}, err error) {
 	if err != nil {
 		return "", errors.New("accounts.openAccount: " + err.Error())
 	}
+
+	// Screen the holder against the sanctions list
+	screening, err := sanctions.Screen(accountHolderDetailsObject.GivenName + " " + accountHolderDetailsObject.FamilyName)
+	if err != nil {
+		return "", errors.New("accounts.openAccount: " + err.Error())
+	}
+	if screening.Action == sanctions.ACTION_BLOCK {
+		return "", errors.New("accounts.openAccount: Account holder matches sanctions list")
+	}
+
+	// A possible match is opened pending until it is reviewed
+	if screening.Action == sanctions.ACTION_REVIEW {
+		accountHolderObject.Status = ACCOUNT_STATUS_PENDING
+	}
 	err = createAccount(&accountHolderObject, &accountHolderDetailsObject)
 	if err != nil {
 		return "", errors.New("accounts.openAccount: " + err.Error())
 	}
 
+	if screening.Action == sanctions.ACTION_REVIEW {
+		err = sanctions.RecordReview(accountHolderObject.AccountNumber, screening)
+		if err != nil {
+			return "", errors.New("accounts.openAccount: " + err.Error())
+		}
+	}
+
 	result = accountHolderObject.AccountNumber
 	return
 }
 
+// resolveSanctionsReview activates an account held for a sanctions match that
+// was cleared, and freezes one whose match was confirmed
+func resolveSanctionsReview(accountNumber string, decision string) (err error) {
+	status := ACCOUNT_STATUS_ACTIVE
+	if decision == sanctions.REVIEW_STATUS_CONFIRMED {
+		status = ACCOUNT_STATUS_FROZEN
+	}
+	err = setAccountStatus(accountNumber, status)
+	if err != nil {
+		return errors.New("accounts.resolveSanctionsReview: " + err.Error())
+	}
+	return
+}
+
 func closeAccount(data []string) (result interface{}, err error) {
 	// Validate string against required info/length
 	if len(data) < 15 {
@@ -343,7 +421,7 @@ func setAccountDetails(data []string) (accountDetails AccountDetails, err error)
 	if data[3] == "" {
 		return AccountDetails{}, errors.New("accounts.setAccountDetails: Given name cannot be empty")
 	}
-	accountDetails.BankNumber = BANK_NUMBER
+	accountDetails.BankNumber = LocalBankNumber()
 	accountDetails.AccountHolderName = data[4] + "," + data[3] // Family Name, Given Name
 	accountDetails.AccountBalance = decimal.NewFromFloat(OPENING_BALANCE)
 	accountDetails.Overdraft = decimal.NewFromFloat(OPENING_OVERDRAFT)
@@ -540,6 +618,25 @@ func CheckUserAccountValidFromToken(userID string, accountNumber string) (err er
 	return
 }
 
+// CheckMerchantAccount checks a merchant client token's merchant holds the
+// account. Without a merchant, as for a user's own token, there is nothing to check.
+func CheckMerchantAccount(merchantID string, accountNumber string) (err error) {
+	if merchantID == "" {
+		return
+	}
+
+	merchantAccountNumbers, err := getAllMerchantAccountNumbersByMerchantID(merchantID)
+	if err != nil {
+		return errors.New("accounts.CheckMerchantAccount: " + err.Error())
+	}
+	for _, v := range merchantAccountNumbers {
+		if v == accountNumber {
+			return
+		}
+	}
+	return errors.New("accounts.CheckMerchantAccount: Account not held by merchant")
+}
+
 func merchantAccountCreate(data []string) (result interface{}, err error) {
 	tokenUser, err := appauth.GetUserFromToken(data[0])
 	if err != nil {
@@ -557,11 +654,31 @@ func merchantAccountCreate(data []string) (result interface{}, err error) {
 		return "", errors.New("accounts.merchantAccountCreate: " + err.Error())
 	}
 
+	// Screen the merchant against the sanctions list
+	screening, err := sanctions.Screen(merchantObject.Name)
+	if err != nil {
+		return "", errors.New("accounts.merchantAccountCreate: " + err.Error())
+	}
+	if screening.Action == sanctions.ACTION_BLOCK {
+		return "", errors.New("accounts.merchantAccountCreate: Merchant matches sanctions list")
+	}
+
+	// A possible match is opened pending until it is reviewed
+	if screening.Action == sanctions.ACTION_REVIEW {
+		accountDetails.Status = ACCOUNT_STATUS_PENDING
+	}
 	err = createMerchantAccount(&merchantObject, &accountDetails, &accountHolder)
 	if err != nil {
 		return "", errors.New("accounts.merchantAccountCreate: " + err.Error())
 	}
 
+	if screening.Action == sanctions.ACTION_REVIEW {
+		err = sanctions.RecordReview(accountDetails.AccountNumber, screening)
+		if err != nil {
+			return "", errors.New("accounts.merchantAccountCreate: " + err.Error())
+		}
+	}
+
 	result = merchantObject.ID
 	return
 }
@@ -730,7 +847,7 @@ func setMerchantDetails(data []string, identificationNumber string, setType stri
 	// @FIXME We leave logo out for now, not sure how to parse pictures
 	merchantDetails.IdentificationNumber = identificationNumber
 
-	accountDetails.BankNumber = BANK_NUMBER
+	accountDetails.BankNumber = LocalBankNumber()
 	accountDetails.AccountHolderName = data[3] // Business Name
 	accountDetails.AccountBalance = decimal.NewFromFloat(OPENING_BALANCE)
 	accountDetails.Overdraft = decimal.NewFromFloat(OPENING_OVERDRAFT)
@@ -816,3 +933,15 @@ func GetAccountByAccountNumber(accountNumber string) (accountDetails AccountDeta
 
 	return
 }
+
+// CheckAccountActive makes sure an account can send or receive payments
+func CheckAccountActive(accountNumber string) (err error) {
+	status, err := getAccountStatus(accountNumber)
+	if err != nil {
+		return errors.New("accounts.CheckAccountActive: " + err.Error())
+	}
+	if status != ACCOUNT_STATUS_ACTIVE {
+		return errors.New("accounts.CheckAccountActive: Account is " + status)
+	}
+	return
+}
@@ -10,13 +10,16 @@ import (
 	"time"
 
 	"github.com/bvnk/bank/configuration"
+	"github.com/bvnk/bank/sanctions"
 	"github.com/satori/go.uuid"
+	"github.com/shopspring/decimal"
 )
 
 var Config configuration.Configuration
 
 func SetConfig(config *configuration.Configuration) {
 	Config = *config
+	sanctions.SetAccountResolver(resolveSanctionsReview)
 }
 
 func loadDatabase() (db *sql.DB, err error) {
@@ -73,8 +76,8 @@ func deleteAccount(accountDetails *AccountDetails, accountHolderDetails *Account
 
 func doCreateAccount(sqlTime int32, accountDetails *AccountDetails, accountHolderDetails *AccountHolderDetails) (err error) {
 	// Create account
-	insertStatement := "INSERT INTO accounts (`accountNumber`, `bankNumber`, `accountHolderName`, `accountBalance`, `overdraft`, `availableBalance`, `type`, `timestamp`) "
-	insertStatement += "VALUES(?, ?, ?, ?, ?, ?, ?, ?)"
+	insertStatement := "INSERT INTO accounts (`accountNumber`, `bankNumber`, `accountHolderName`, `accountBalance`, `overdraft`, `availableBalance`, `type`, `status`, `timestamp`) "
+	insertStatement += "VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?)"
 	stmtIns, err := Config.Db.Prepare(insertStatement)
 	if err != nil {
 		return errors.New("accounts.doCreateAccount: " + err.Error())
@@ -87,7 +90,12 @@ func doCreateAccount(sqlTime int32, accountDetails *AccountDetails, accountHolde
 	newUuid := uuid.NewV4()
 	accountDetails.AccountNumber = newUuid.String()
 
-	_, err = stmtIns.Exec(accountDetails.AccountNumber, accountDetails.BankNumber, accountDetails.AccountHolderName, accountDetails.AccountBalance, accountDetails.Overdraft, accountDetails.AvailableBalance, accountDetails.Type, sqlTime)
+	// Accounts are active unless opened pending a review
+	if accountDetails.Status == "" {
+		accountDetails.Status = ACCOUNT_STATUS_ACTIVE
+	}
+
+	_, err = stmtIns.Exec(accountDetails.AccountNumber, accountDetails.BankNumber, accountDetails.AccountHolderName, accountDetails.AccountBalance, accountDetails.Overdraft, accountDetails.AvailableBalance, accountDetails.Type, accountDetails.Status, sqlTime)
 	if err != nil {
 		return errors.New("accounts.doCreateAccount: " + err.Error())
 	}
@@ -217,7 +225,7 @@ func doDeleteAccountUserAccounts(accountHolderDetails *AccountHolderDetails) (er
 }
 
 func getAccountDetails(id string) (accountDetails AccountDetails, err error) {
-	err = Config.Db.QueryRow("SELECT `accountNumber`, `bankNumber`, `accountHolderName`, `accountBalance`, `overdraft`, `availableBalance` FROM `accounts` WHERE `accountNumber` = ?", id).Scan(&accountDetails.AccountNumber, &accountDetails.BankNumber, &accountDetails.AccountHolderName, &accountDetails.AccountBalance, &accountDetails.Overdraft, &accountDetails.AvailableBalance)
+	err = Config.Db.QueryRow("SELECT `accountNumber`, `bankNumber`, `accountHolderName`, `accountBalance`, `overdraft`, `availableBalance`, `type`, `status` FROM `accounts` WHERE `accountNumber` = ?", id).Scan(&accountDetails.AccountNumber, &accountDetails.BankNumber, &accountDetails.AccountHolderName, &accountDetails.AccountBalance, &accountDetails.Overdraft, &accountDetails.AvailableBalance, &accountDetails.Type, &accountDetails.Status)
 	switch {
 	case err == sql.ErrNoRows:
 		return AccountDetails{}, errors.New("accounts.getAccountDetails: Account not found")
@@ -228,6 +236,47 @@ func getAccountDetails(id string) (accountDetails AccountDetails, err error) {
 	return
 }
 
+func getAccountStatus(accountNumber string) (status string, err error) {
+	err = Config.Db.QueryRow("SELECT `status` FROM `accounts` WHERE `accountNumber` = ?", accountNumber).Scan(&status)
+	switch {
+	case err == sql.ErrNoRows:
+		return "", errors.New("accounts.getAccountStatus: Account not found")
+	case err != nil:
+		return "", errors.New("accounts.getAccountStatus: " + err.Error())
+	}
+
+	return
+}
+
+func setAccountStatus(accountNumber string, status string) (err error) {
+	updateStatement := "UPDATE accounts SET `status` = ?, `timestamp` = ? WHERE `accountNumber` = ?"
+	stmtUpd, err := Config.Db.Prepare(updateStatement)
+	if err != nil {
+		return errors.New("accounts.setAccountStatus: " + err.Error())
+	}
+	defer stmtUpd.Close()
+
+	t := time.Now()
+	sqlTime := int32(t.Unix())
+
+	_, err = stmtUpd.Exec(status, sqlTime, accountNumber)
+	if err != nil {
+		return errors.New("accounts.setAccountStatus: " + err.Error())
+	}
+
+	return
+}
+
+// updateOverdraft moves the available balance by the change in overdraft. MySQL
+// sets columns in order, so the available balance is worked out from the old overdraft.
+func updateOverdraft(accountNumber string, overdraft decimal.Decimal) (err error) {
+	_, err = Config.Db.Exec("UPDATE `accounts` SET `availableBalance` = `availableBalance` - `overdraft` + ?, `overdraft` = ?, `timestamp` = ? WHERE `accountNumber` = ?", overdraft, overdraft, time.Now().Unix(), accountNumber)
+	if err != nil {
+		return errors.New("accounts.updateOverdraft: " + err.Error())
+	}
+	return
+}
+
 func getAccountUser(id string) (accountDetails AccountHolderDetails, err error) {
 	err = Config.Db.QueryRow("SELECT `accountHolderGivenName`, `accountHolderFamilyName`, `accountHolderDateOfBirth`, `accountHolderIdentificationNumber`, `accountHolderContactNumber1`, `accountHolderContactNumber2`, `accountHolderEmailAddress`, `accountHolderAddressLine1`, `accountHolderAddressLine2`, `accountHolderAddressLine3`, `accountHolderPostalCode` FROM `accounts_users` WHERE `accountHolderIdentificationNumber` = ?", id).Scan(&accountDetails.GivenName, &accountDetails.FamilyName, &accountDetails.DateOfBirth, &accountDetails.IdentificationNumber, &accountDetails.ContactNumber1, &accountDetails.ContactNumber2, &accountDetails.EmailAddress, &accountDetails.AddressLine1, &accountDetails.AddressLine2, &accountDetails.AddressLine3, &accountDetails.PostalCode)
 
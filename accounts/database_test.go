@@ -56,6 +56,7 @@ func TestDoCreateAccount(t *testing.T) {
 		decimal.NewFromFloat(0.),
 		decimal.NewFromFloat(0.),
 		"cheque",
+		"",
 		0,
 	}
 
@@ -97,6 +98,7 @@ func BenchmarkDoCreateAccount(b *testing.B) {
 			decimal.NewFromFloat(0.),
 			decimal.NewFromFloat(0.),
 			"cheque",
+			"",
 			0,
 		}
 
@@ -130,6 +132,7 @@ func TestDoAccountMeta(t *testing.T) {
 		decimal.NewFromFloat(0.),
 		decimal.NewFromFloat(0.),
 		"cheque",
+		"",
 		0,
 	}
 
@@ -171,6 +174,7 @@ func BenchmarkDoAccountMeta(b *testing.B) {
 			decimal.NewFromFloat(0.),
 			decimal.NewFromFloat(0.),
 			"cheque",
+			"",
 			0,
 		}
 
@@ -205,6 +209,7 @@ func TestGetAccount(t *testing.T) {
 		decimal.NewFromFloat(0.),
 		decimal.NewFromFloat(0.),
 		"cheque",
+		"",
 		0,
 	}
 
@@ -286,6 +291,7 @@ func BenchmarkDoGetAccount(b *testing.B) {
 			decimal.NewFromFloat(0.),
 			decimal.NewFromFloat(0.),
 			"cheque",
+			"",
 			0,
 		}
 
@@ -324,6 +330,7 @@ func TestGetAccountMeta(t *testing.T) {
 		decimal.NewFromFloat(0.),
 		decimal.NewFromFloat(0.),
 		"cheque",
+		"",
 		0,
 	}
 
@@ -416,6 +423,7 @@ func BenchmarkDoGetAccountMeta(b *testing.B) {
 			decimal.NewFromFloat(0.),
 			decimal.NewFromFloat(0.),
 			"cheque",
+			"",
 			0,
 		}
 
@@ -472,6 +480,7 @@ func TestGetSingleAccountDetail(t *testing.T) {
 		decimal.NewFromFloat(0.),
 		decimal.NewFromFloat(0.),
 		"cheque",
+		"",
 		0,
 	}
 
@@ -549,6 +558,7 @@ func BenchmarkGetSingleAccountDetail(b *testing.B) {
 			decimal.NewFromFloat(0.),
 			decimal.NewFromFloat(0.),
 			"cheque",
+			"",
 			0,
 		}
 
@@ -587,6 +597,7 @@ func TestGetSingleAccountNumberByID(t *testing.T) {
 		decimal.NewFromFloat(0.),
 		decimal.NewFromFloat(0.),
 		"cheque",
+		"",
 		0,
 	}
 
@@ -679,6 +690,7 @@ func BenchmarkGetSingleAccountNumberByID(b *testing.B) {
 			decimal.NewFromFloat(0.),
 			decimal.NewFromFloat(0.),
 			"cheque",
+			"",
 			0,
 		}
 
@@ -0,0 +1,70 @@
+package accounts
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/bvnk/bank/appauth"
+	"github.com/bvnk/bank/money"
+	"github.com/shopspring/decimal"
+)
+
+// setOverdraft changes how far an account may go below zero. The available
+// balance moves by the change, so held amounts stay held.
+func setOverdraft(data []string) (result string, err error) {
+	//~acmt~1200~accountNumber~overdraft~basicAuthUser~basicAuthPassword
+	_, err = appauth.CheckPermission(data[:7], appauth.PERMISSION_OVERDRAFT)
+	if err != nil {
+		return "", errors.New("accounts.setOverdraft: " + err.Error())
+	}
+
+	accountNumber := data[3]
+	overdraft, err := decimal.NewFromString(data[4])
+	if err != nil {
+		return "", errors.New("accounts.setOverdraft: Overdraft not valid")
+	}
+	if overdraft.Sign() < 0 || !money.Valid(overdraft) {
+		return "", errors.New("accounts.setOverdraft: Overdraft must be a positive amount with at most " + strconv.Itoa(money.SCALE) + " decimal places")
+	}
+
+	_, err = getAccountDetails(accountNumber)
+	if err != nil {
+		return "", errors.New("accounts.setOverdraft: " + err.Error())
+	}
+
+	err = updateOverdraft(accountNumber, overdraft)
+	if err != nil {
+		return "", errors.New("accounts.setOverdraft: " + err.Error())
+	}
+	return "Overdraft updated", nil
+}
+
+// freezeAccount stops an active account sending or receiving payments, or
+// lets a frozen one again. Pending and closed accounts are left as they are.
+func freezeAccount(data []string, freeze bool) (result string, err error) {
+	//~acmt~1201|1202~accountNumber~basicAuthUser~basicAuthPassword
+	_, err = appauth.CheckPermission(data[:6], appauth.PERMISSION_FREEZE)
+	if err != nil {
+		return "", errors.New("accounts.freezeAccount: " + err.Error())
+	}
+
+	accountNumber := data[3]
+	status, err := getAccountStatus(accountNumber)
+	if err != nil {
+		return "", errors.New("accounts.freezeAccount: " + err.Error())
+	}
+
+	from, to, result := ACCOUNT_STATUS_ACTIVE, ACCOUNT_STATUS_FROZEN, "Account frozen"
+	if !freeze {
+		from, to, result = ACCOUNT_STATUS_FROZEN, ACCOUNT_STATUS_ACTIVE, "Account unfrozen"
+	}
+	if status != from {
+		return "", errors.New("accounts.freezeAccount: Account is " + status)
+	}
+
+	err = setAccountStatus(accountNumber, to)
+	if err != nil {
+		return "", errors.New("accounts.freezeAccount: " + err.Error())
+	}
+	return
+}
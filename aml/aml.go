@@ -0,0 +1,391 @@
+package aml
+
+/*
+AML package monitors transactions for money laundering patterns and manages the
+cases analysts work through.
+
+Every saved transaction is checked against the monitoring rules (see monitor.go).
+A rule that matches raises an alert, and alerts are grouped into one case per
+account for as long as that case is open.
+
+All AML transactions are staff only, the basic auth user and password are always
+the last two values.
+
+AML transactions are as follows:
+1 - ListCases
+2 - ViewCase
+3 - AssignCase
+4 - CommentCase
+5 - CloseCase
+6 - EscalateCase
+7 - ExportCases
+
+*/
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bvnk/bank/appauth"
+	"github.com/bvnk/bank/money"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	CASE_STATUS_OPEN      = "open"
+	CASE_STATUS_ESCALATED = "escalated"
+	CASE_STATUS_CLOSED    = "closed"
+)
+
+type Case struct {
+	ID            int64
+	AccountNumber string
+	Status        string
+	Assignee      string
+	Resolution    string
+	Created       int32
+	Timestamp     int32
+	Alerts        []Alert
+	Comments      []Comment
+}
+
+type Alert struct {
+	ID            int64
+	CaseID        int64
+	Rule          string
+	AccountNumber string
+	TransactionID int64
+	Amount        decimal.Decimal
+	Details       string
+	Timestamp     int32
+}
+
+type Comment struct {
+	ID        int64
+	CaseID    int64
+	Author    string
+	Comment   string
+	Timestamp int32
+}
+
+func ProcessAML(data []string) (result interface{}, err error) {
+	if len(data) < 5 {
+		return "", errors.New("aml.ProcessAML: Not all data is present")
+	}
+
+	// ~aml~type~...~basicAuthUser~basicAuthPassword
+	analyst, err := appauth.CheckPermission(data, appauth.PERMISSION_AML)
+	if err != nil {
+		return "", errors.New("aml.ProcessAML: " + err.Error())
+	}
+
+	switch data[2] {
+	// List cases
+	case "1":
+		// ~aml~1~status~basicAuthUser~basicAuthPassword
+		if len(data) < 6 {
+			return "", errors.New("aml.ProcessAML: Not all data is present")
+		}
+		result, err = listCases(data[3])
+		if err != nil {
+			return "", errors.New("aml.ProcessAML: " + err.Error())
+		}
+	// View a case with its alerts and comments
+	case "2":
+		// ~aml~2~caseID~basicAuthUser~basicAuthPassword
+		if len(data) < 6 {
+			return "", errors.New("aml.ProcessAML: Not all data is present")
+		}
+		result, err = viewCase(data[3])
+		if err != nil {
+			return "", errors.New("aml.ProcessAML: " + err.Error())
+		}
+	// Assign a case to an analyst
+	case "3":
+		// ~aml~3~caseID~assignee~basicAuthUser~basicAuthPassword
+		if len(data) < 7 {
+			return "", errors.New("aml.ProcessAML: Not all data is present")
+		}
+		result, err = assignCase(data[3], data[4], analyst)
+		if err != nil {
+			return "", errors.New("aml.ProcessAML: " + err.Error())
+		}
+	// Comment on a case
+	case "4":
+		// ~aml~4~caseID~comment~basicAuthUser~basicAuthPassword
+		if len(data) < 7 {
+			return "", errors.New("aml.ProcessAML: Not all data is present")
+		}
+		result, err = commentCase(data[3], data[4], analyst)
+		if err != nil {
+			return "", errors.New("aml.ProcessAML: " + err.Error())
+		}
+	// Close a case
+	case "5":
+		// ~aml~5~caseID~resolution~basicAuthUser~basicAuthPassword
+		if len(data) < 7 {
+			return "", errors.New("aml.ProcessAML: Not all data is present")
+		}
+		result, err = closeCase(data[3], data[4], analyst)
+		if err != nil {
+			return "", errors.New("aml.ProcessAML: " + err.Error())
+		}
+	// Escalate a case
+	case "6":
+		// ~aml~6~caseID~reason~basicAuthUser~basicAuthPassword
+		if len(data) < 7 {
+			return "", errors.New("aml.ProcessAML: Not all data is present")
+		}
+		result, err = escalateCase(data[3], data[4], analyst)
+		if err != nil {
+			return "", errors.New("aml.ProcessAML: " + err.Error())
+		}
+	// Export cases as a CSV report
+	case "7":
+		// ~aml~7~status~basicAuthUser~basicAuthPassword
+		if len(data) < 6 {
+			return "", errors.New("aml.ProcessAML: Not all data is present")
+		}
+		result, err = exportCases(data[3])
+		if err != nil {
+			return "", errors.New("aml.ProcessAML: " + err.Error())
+		}
+	default:
+		return "", errors.New("aml.ProcessAML: No valid option chosen")
+	}
+
+	return
+}
+
+func listCases(status string) (result []Case, err error) {
+	if status != "" && !validStatus(status) {
+		return nil, errors.New("aml.listCases: Status not valid, must be one of open, escalated, closed")
+	}
+
+	result, err = getCases(status)
+	if err != nil {
+		return nil, errors.New("aml.listCases: " + err.Error())
+	}
+	return
+}
+
+func viewCase(caseIDStr string) (result Case, err error) {
+	caseID, err := parseCaseID(caseIDStr)
+	if err != nil {
+		return Case{}, errors.New("aml.viewCase: " + err.Error())
+	}
+
+	result, err = getCase(caseID)
+	if err != nil {
+		return Case{}, errors.New("aml.viewCase: " + err.Error())
+	}
+	result.Alerts, err = getCaseAlerts(caseID)
+	if err != nil {
+		return Case{}, errors.New("aml.viewCase: " + err.Error())
+	}
+	result.Comments, err = getCaseComments(caseID)
+	if err != nil {
+		return Case{}, errors.New("aml.viewCase: " + err.Error())
+	}
+	return
+}
+
+func assignCase(caseIDStr string, assignee string, analyst string) (result string, err error) {
+	if assignee == "" {
+		return "", errors.New("aml.assignCase: Assignee cannot be empty")
+	}
+
+	amlCase, err := getOpenCase(caseIDStr)
+	if err != nil {
+		return "", errors.New("aml.assignCase: " + err.Error())
+	}
+
+	err = updateCase(amlCase.ID, amlCase.Status, assignee, "")
+	if err != nil {
+		return "", errors.New("aml.assignCase: " + err.Error())
+	}
+	err = saveComment(amlCase.ID, analyst, "Assigned to "+assignee)
+	if err != nil {
+		return "", errors.New("aml.assignCase: " + err.Error())
+	}
+
+	result = "Case assigned"
+	return
+}
+
+func commentCase(caseIDStr string, comment string, analyst string) (result string, err error) {
+	if strings.TrimSpace(comment) == "" {
+		return "", errors.New("aml.commentCase: Comment cannot be empty")
+	}
+
+	caseID, err := parseCaseID(caseIDStr)
+	if err != nil {
+		return "", errors.New("aml.commentCase: " + err.Error())
+	}
+	_, err = getCase(caseID)
+	if err != nil {
+		return "", errors.New("aml.commentCase: " + err.Error())
+	}
+
+	err = saveComment(caseID, analyst, comment)
+	if err != nil {
+		return "", errors.New("aml.commentCase: " + err.Error())
+	}
+
+	result = "Comment added"
+	return
+}
+
+func closeCase(caseIDStr string, resolution string, analyst string) (result string, err error) {
+	if strings.TrimSpace(resolution) == "" {
+		return "", errors.New("aml.closeCase: Resolution cannot be empty")
+	}
+
+	amlCase, err := getOpenCase(caseIDStr)
+	if err != nil {
+		return "", errors.New("aml.closeCase: " + err.Error())
+	}
+
+	err = updateCase(amlCase.ID, CASE_STATUS_CLOSED, amlCase.Assignee, resolution)
+	if err != nil {
+		return "", errors.New("aml.closeCase: " + err.Error())
+	}
+	err = saveComment(amlCase.ID, analyst, "Closed: "+resolution)
+	if err != nil {
+		return "", errors.New("aml.closeCase: " + err.Error())
+	}
+
+	result = "Case closed"
+	return
+}
+
+func escalateCase(caseIDStr string, reason string, analyst string) (result string, err error) {
+	if strings.TrimSpace(reason) == "" {
+		return "", errors.New("aml.escalateCase: Reason cannot be empty")
+	}
+
+	amlCase, err := getOpenCase(caseIDStr)
+	if err != nil {
+		return "", errors.New("aml.escalateCase: " + err.Error())
+	}
+	if amlCase.Status == CASE_STATUS_ESCALATED {
+		return "", errors.New("aml.escalateCase: Case is already escalated")
+	}
+
+	err = updateCase(amlCase.ID, CASE_STATUS_ESCALATED, amlCase.Assignee, "")
+	if err != nil {
+		return "", errors.New("aml.escalateCase: " + err.Error())
+	}
+	err = saveComment(amlCase.ID, analyst, "Escalated: "+reason)
+	if err != nil {
+		return "", errors.New("aml.escalateCase: " + err.Error())
+	}
+
+	result = "Case escalated"
+	return
+}
+
+func exportCases(status string) (result string, err error) {
+	cases, err := listCases(status)
+	if err != nil {
+		return "", errors.New("aml.exportCases: " + err.Error())
+	}
+
+	for i := range cases {
+		cases[i].Alerts, err = getCaseAlerts(cases[i].ID)
+		if err != nil {
+			return "", errors.New("aml.exportCases: " + err.Error())
+		}
+	}
+
+	result, err = casesReport(cases)
+	if err != nil {
+		return "", errors.New("aml.exportCases: " + err.Error())
+	}
+	return
+}
+
+// casesReport writes one CSV row per case, with a summary of its alerts
+func casesReport(cases []Case) (report string, err error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+
+	err = w.Write([]string{"CaseID", "AccountNumber", "Status", "Assignee", "Opened", "Updated", "Alerts", "Rules", "AlertedAmount", "Resolution"})
+	if err != nil {
+		return "", errors.New("aml.casesReport: " + err.Error())
+	}
+
+	for _, c := range cases {
+		rules := []string{}
+		seen := make(map[string]bool)
+		total := decimal.Zero
+		for _, alert := range c.Alerts {
+			total = total.Add(alert.Amount)
+			if !seen[alert.Rule] {
+				seen[alert.Rule] = true
+				rules = append(rules, alert.Rule)
+			}
+		}
+
+		err = w.Write([]string{
+			strconv.FormatInt(c.ID, 10),
+			c.AccountNumber,
+			c.Status,
+			c.Assignee,
+			time.Unix(int64(c.Created), 0).UTC().Format(time.RFC3339),
+			time.Unix(int64(c.Timestamp), 0).UTC().Format(time.RFC3339),
+			strconv.Itoa(len(c.Alerts)),
+			strings.Join(rules, " "),
+			money.Format(total),
+			c.Resolution,
+		})
+		if err != nil {
+			return "", errors.New("aml.casesReport: " + err.Error())
+		}
+	}
+
+	w.Flush()
+	if err = w.Error(); err != nil {
+		return "", errors.New("aml.casesReport: " + err.Error())
+	}
+
+	report = buf.String()
+	return
+}
+
+// getOpenCase loads a case that can still be worked on
+func getOpenCase(caseIDStr string) (amlCase Case, err error) {
+	caseID, err := parseCaseID(caseIDStr)
+	if err != nil {
+		return Case{}, errors.New("aml.getOpenCase: " + err.Error())
+	}
+
+	amlCase, err = getCase(caseID)
+	if err != nil {
+		return Case{}, errors.New("aml.getOpenCase: " + err.Error())
+	}
+	if amlCase.Status == CASE_STATUS_CLOSED {
+		return Case{}, errors.New("aml.getOpenCase: Case is closed")
+	}
+	return
+}
+
+func parseCaseID(caseIDStr string) (caseID int64, err error) {
+	caseID, err = strconv.ParseInt(caseIDStr, 10, 64)
+	if err != nil {
+		return 0, errors.New("aml.parseCaseID: Case ID not valid")
+	}
+	return
+}
+
+func validStatus(status string) bool {
+	switch status {
+	case CASE_STATUS_OPEN, CASE_STATUS_ESCALATED, CASE_STATUS_CLOSED:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,114 @@
+package aml
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/bvnk/bank/configuration"
+	"github.com/shopspring/decimal"
+)
+
+func setTestConfig() {
+	Config = configuration.Configuration{}
+}
+
+func TestStructuringBand(t *testing.T) {
+	setTestConfig()
+	low, high := structuringBand()
+	if !low.Equals(decimal.NewFromFloat(9000)) || !high.Equals(decimal.NewFromFloat(10000)) {
+		t.Errorf("StructuringBand does not pass. Looking for %v - %v, got %v - %v", 9000, 10000, low, high)
+	}
+
+	tst := []struct {
+		amount   float64
+		expected bool
+	}{
+		{8999.99, false},
+		{9000, true},
+		{9999.99, true},
+		{10000, false},
+	}
+	for _, test := range tst {
+		if inBand(decimal.NewFromFloat(test.amount), low, high) != test.expected {
+			t.Errorf("InBand does not pass for %v. Looking for %v", test.amount, test.expected)
+		}
+	}
+}
+
+func TestCheckStructuring(t *testing.T) {
+	setTestConfig()
+	if matched, _ := checkStructuring(DEFAULT_STRUCTURING_COUNT - 1); matched {
+		t.Errorf("CheckStructuring does not pass. Looking for %v, got %v", false, matched)
+	}
+	if matched, _ := checkStructuring(DEFAULT_STRUCTURING_COUNT); !matched {
+		t.Errorf("CheckStructuring does not pass. Looking for %v, got %v", true, matched)
+	}
+}
+
+func TestCheckRapidMovement(t *testing.T) {
+	setTestConfig()
+	tst := []struct {
+		inflow   float64
+		outflow  float64
+		expected bool
+	}{
+		// Below the minimum received
+		{1000, 1000, false},
+		// Most of it kept
+		{10000, 2000, false},
+		// Nearly all of it sent on
+		{10000, 9500, true},
+		{10000, 9000, true},
+	}
+	for _, test := range tst {
+		matched, _ := checkRapidMovement(decimal.NewFromFloat(test.inflow), decimal.NewFromFloat(test.outflow))
+		if matched != test.expected {
+			t.Errorf("CheckRapidMovement does not pass for %v in, %v out. Looking for %v, got %v", test.inflow, test.outflow, test.expected, matched)
+		}
+	}
+}
+
+func TestCheckLargeCash(t *testing.T) {
+	setTestConfig()
+	Config.AML.LargeCashAmount = decimal.NewFromFloat(5000)
+	if matched, _ := checkLargeCash(decimal.NewFromFloat(4999)); matched {
+		t.Errorf("CheckLargeCash does not pass. Looking for %v, got %v", false, matched)
+	}
+	if matched, _ := checkLargeCash(decimal.NewFromFloat(5000)); !matched {
+		t.Errorf("CheckLargeCash does not pass. Looking for %v, got %v", true, matched)
+	}
+}
+
+func TestCasesReport(t *testing.T) {
+	cases := []Case{
+		Case{ID: 1, AccountNumber: "acc-1", Status: CASE_STATUS_OPEN, Alerts: []Alert{
+			Alert{Rule: RULE_STRUCTURING, Amount: decimal.NewFromFloat(9500)},
+			Alert{Rule: RULE_STRUCTURING, Amount: decimal.NewFromFloat(9600)},
+			Alert{Rule: RULE_LARGE_CASH, Amount: decimal.NewFromFloat(12000)},
+		}},
+		Case{ID: 2, AccountNumber: "acc-2", Status: CASE_STATUS_CLOSED, Resolution: "Salary, explained"},
+	}
+
+	report, err := casesReport(cases)
+	if err != nil {
+		t.Errorf("CasesReport does not pass. ERROR: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(report), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("CasesReport does not pass. Looking for %v lines, got %v", 3, len(lines))
+	}
+	if !strings.HasPrefix(lines[1], "1,acc-1,open,") || !strings.HasSuffix(lines[1], ",3,structuring large-cash-deposit,31100.00,") {
+		t.Errorf("CasesReport does not pass. Got %v", lines[1])
+	}
+	if !strings.HasSuffix(lines[2], ",0,,0.00,\"Salary, explained\"") {
+		t.Errorf("CasesReport does not pass. Got %v", lines[2])
+	}
+}
+
+func TestProcessAMLNotAllData(t *testing.T) {
+	_, err := ProcessAML([]string{"", "aml", "1"})
+	if err == nil {
+		t.Errorf("ProcessAML does not pass. Looking for an error, got none")
+	}
+}
@@ -0,0 +1,218 @@
+package aml
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/bvnk/bank/configuration"
+	"github.com/shopspring/decimal"
+)
+
+var Config configuration.Configuration
+
+func SetConfig(config *configuration.Configuration) {
+	Config = *config
+}
+
+func countInBand(accountNumber string, deposit bool, low decimal.Decimal, high decimal.Decimal, since time.Time) (count int, err error) {
+	query := "SELECT COUNT(*) FROM `transactions` WHERE `senderAccountNumber` = ? AND `type` != ? "
+	if deposit {
+		query = "SELECT COUNT(*) FROM `transactions` WHERE `receiverAccountNumber` = ? AND `type` = ? "
+	}
+	query += "AND `transactionAmount` >= ? AND `transactionAmount` < ? AND `timestamp` >= ? AND `status` != 'rejected'"
+
+	err = Config.Db.QueryRow(query, accountNumber, PAIN_TYPE_DEPOSIT, low, high, since.Unix()).Scan(&count)
+	if err != nil {
+		return 0, errors.New("aml.countInBand: " + err.Error())
+	}
+	return
+}
+
+func getFlows(accountNumber string, since time.Time) (inflow decimal.Decimal, outflow decimal.Decimal, err error) {
+	err = Config.Db.QueryRow("SELECT COALESCE(SUM(`transactionAmount`), 0) FROM `transactions` WHERE `receiverAccountNumber` = ? AND `timestamp` >= ? AND `status` != 'rejected'", accountNumber, since.Unix()).Scan(&inflow)
+	if err != nil {
+		return decimal.Zero, decimal.Zero, errors.New("aml.getFlows: " + err.Error())
+	}
+	err = Config.Db.QueryRow("SELECT COALESCE(SUM(`transactionAmount`), 0) FROM `transactions` WHERE `senderAccountNumber` = ? AND `timestamp` >= ? AND `status` != 'rejected'", accountNumber, since.Unix()).Scan(&outflow)
+	if err != nil {
+		return decimal.Zero, decimal.Zero, errors.New("aml.getFlows: " + err.Error())
+	}
+	return
+}
+
+func hasAlertSince(rule string, accountNumber string, since time.Time) (exists bool, err error) {
+	count := 0
+	err = Config.Db.QueryRow("SELECT COUNT(*) FROM `aml_alerts` WHERE `rule` = ? AND `accountNumber` = ? AND `timestamp` >= ?", rule, accountNumber, since.Unix()).Scan(&count)
+	if err != nil {
+		return false, errors.New("aml.hasAlertSince: " + err.Error())
+	}
+
+	exists = count > 0
+	return
+}
+
+// getActiveCaseID returns the open or escalated case for an account, 0 if there is none
+func getActiveCaseID(accountNumber string) (caseID int64, err error) {
+	err = Config.Db.QueryRow("SELECT `id` FROM `aml_cases` WHERE `accountNumber` = ? AND `status` != ? ORDER BY `id` DESC LIMIT 1", accountNumber, CASE_STATUS_CLOSED).Scan(&caseID)
+	switch {
+	case err == sql.ErrNoRows:
+		return 0, nil
+	case err != nil:
+		return 0, errors.New("aml.getActiveCaseID: " + err.Error())
+	}
+	return
+}
+
+func createCase(accountNumber string) (caseID int64, err error) {
+	insertStatement := "INSERT INTO aml_cases (`accountNumber`, `status`, `assignee`, `resolution`, `created`, `timestamp`) "
+	insertStatement += "VALUES(?, ?, '', '', ?, ?)"
+	stmtIns, err := Config.Db.Prepare(insertStatement)
+	if err != nil {
+		return 0, errors.New("aml.createCase: " + err.Error())
+	}
+	defer stmtIns.Close()
+
+	t := time.Now()
+	sqlTime := int32(t.Unix())
+
+	res, err := stmtIns.Exec(accountNumber, CASE_STATUS_OPEN, sqlTime, sqlTime)
+	if err != nil {
+		return 0, errors.New("aml.createCase: " + err.Error())
+	}
+
+	caseID, err = res.LastInsertId()
+	if err != nil {
+		return 0, errors.New("aml.createCase: " + err.Error())
+	}
+	return
+}
+
+func updateCase(caseID int64, status string, assignee string, resolution string) (err error) {
+	updateStatement := "UPDATE aml_cases SET `status` = ?, `assignee` = ?, `resolution` = ?, `timestamp` = ? WHERE `id` = ?"
+	stmtUpd, err := Config.Db.Prepare(updateStatement)
+	if err != nil {
+		return errors.New("aml.updateCase: " + err.Error())
+	}
+	defer stmtUpd.Close()
+
+	t := time.Now()
+	sqlTime := int32(t.Unix())
+
+	_, err = stmtUpd.Exec(status, assignee, resolution, sqlTime, caseID)
+	if err != nil {
+		return errors.New("aml.updateCase: " + err.Error())
+	}
+	return
+}
+
+func saveAlert(caseID int64, rule string, accountNumber string, transactionID int64, amount decimal.Decimal, details string) (err error) {
+	insertStatement := "INSERT INTO aml_alerts (`caseID`, `rule`, `accountNumber`, `transactionID`, `amount`, `details`, `timestamp`) "
+	insertStatement += "VALUES(?, ?, ?, ?, ?, ?, ?)"
+	stmtIns, err := Config.Db.Prepare(insertStatement)
+	if err != nil {
+		return errors.New("aml.saveAlert: " + err.Error())
+	}
+	defer stmtIns.Close()
+
+	t := time.Now()
+	sqlTime := int32(t.Unix())
+
+	_, err = stmtIns.Exec(caseID, rule, accountNumber, transactionID, amount, details, sqlTime)
+	if err != nil {
+		return errors.New("aml.saveAlert: " + err.Error())
+	}
+
+	// Bring the case to the top of the queue
+	_, err = Config.Db.Exec("UPDATE aml_cases SET `timestamp` = ? WHERE `id` = ?", sqlTime, caseID)
+	if err != nil {
+		return errors.New("aml.saveAlert: " + err.Error())
+	}
+	return
+}
+
+func saveComment(caseID int64, author string, comment string) (err error) {
+	insertStatement := "INSERT INTO aml_cases_comments (`caseID`, `author`, `comment`, `timestamp`) "
+	insertStatement += "VALUES(?, ?, ?, ?)"
+	stmtIns, err := Config.Db.Prepare(insertStatement)
+	if err != nil {
+		return errors.New("aml.saveComment: " + err.Error())
+	}
+	defer stmtIns.Close()
+
+	t := time.Now()
+	sqlTime := int32(t.Unix())
+
+	_, err = stmtIns.Exec(caseID, author, comment, sqlTime)
+	if err != nil {
+		return errors.New("aml.saveComment: " + err.Error())
+	}
+	return
+}
+
+func getCase(caseID int64) (amlCase Case, err error) {
+	err = Config.Db.QueryRow("SELECT `id`, `accountNumber`, `status`, `assignee`, `resolution`, `created`, `timestamp` FROM `aml_cases` WHERE `id` = ?", caseID).Scan(&amlCase.ID, &amlCase.AccountNumber, &amlCase.Status, &amlCase.Assignee, &amlCase.Resolution, &amlCase.Created, &amlCase.Timestamp)
+	switch {
+	case err == sql.ErrNoRows:
+		return Case{}, errors.New("aml.getCase: Case not found")
+	case err != nil:
+		return Case{}, errors.New("aml.getCase: " + err.Error())
+	}
+	return
+}
+
+func getCases(status string) (cases []Case, err error) {
+	var rows *sql.Rows
+	if status == "" {
+		rows, err = Config.Db.Query("SELECT `id`, `accountNumber`, `status`, `assignee`, `resolution`, `created`, `timestamp` FROM `aml_cases` ORDER BY `timestamp` DESC")
+	} else {
+		rows, err = Config.Db.Query("SELECT `id`, `accountNumber`, `status`, `assignee`, `resolution`, `created`, `timestamp` FROM `aml_cases` WHERE `status` = ? ORDER BY `timestamp` DESC", status)
+	}
+	if err != nil {
+		return nil, errors.New("aml.getCases: " + err.Error())
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		c := Case{}
+		if err := rows.Scan(&c.ID, &c.AccountNumber, &c.Status, &c.Assignee, &c.Resolution, &c.Created, &c.Timestamp); err != nil {
+			return nil, errors.New("aml.getCases: " + err.Error())
+		}
+		cases = append(cases, c)
+	}
+	return
+}
+
+func getCaseAlerts(caseID int64) (alerts []Alert, err error) {
+	rows, err := Config.Db.Query("SELECT `id`, `caseID`, `rule`, `accountNumber`, `transactionID`, `amount`, `details`, `timestamp` FROM `aml_alerts` WHERE `caseID` = ? ORDER BY `id`", caseID)
+	if err != nil {
+		return nil, errors.New("aml.getCaseAlerts: " + err.Error())
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		a := Alert{}
+		if err := rows.Scan(&a.ID, &a.CaseID, &a.Rule, &a.AccountNumber, &a.TransactionID, &a.Amount, &a.Details, &a.Timestamp); err != nil {
+			return nil, errors.New("aml.getCaseAlerts: " + err.Error())
+		}
+		alerts = append(alerts, a)
+	}
+	return
+}
+
+func getCaseComments(caseID int64) (comments []Comment, err error) {
+	rows, err := Config.Db.Query("SELECT `id`, `caseID`, `author`, `comment`, `timestamp` FROM `aml_cases_comments` WHERE `caseID` = ? ORDER BY `id`", caseID)
+	if err != nil {
+		return nil, errors.New("aml.getCaseComments: " + err.Error())
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		c := Comment{}
+		if err := rows.Scan(&c.ID, &c.CaseID, &c.Author, &c.Comment, &c.Timestamp); err != nil {
+			return nil, errors.New("aml.getCaseComments: " + err.Error())
+		}
+		comments = append(comments, c)
+	}
+	return
+}
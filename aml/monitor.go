@@ -0,0 +1,201 @@
+package aml
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+const (
+	RULE_STRUCTURING    = "structuring"
+	RULE_RAPID_MOVEMENT = "rapid-movement"
+	RULE_LARGE_CASH     = "large-cash-deposit"
+
+	// PAIN type of a cash deposit made at the bank
+	PAIN_TYPE_DEPOSIT = 1000
+)
+
+// Defaults used when the configuration does not set a value
+const (
+	DEFAULT_STRUCTURING_THRESHOLD     = 10000.
+	DEFAULT_STRUCTURING_MARGIN        = 10. // Percent below the threshold
+	DEFAULT_STRUCTURING_COUNT         = 3
+	DEFAULT_STRUCTURING_WINDOW_HOURS  = 72
+	DEFAULT_RAPID_MOVEMENT_RATIO      = 0.9
+	DEFAULT_RAPID_MOVEMENT_MIN_AMOUNT = 5000.
+	DEFAULT_RAPID_MOVEMENT_HOURS      = 48
+	DEFAULT_LARGE_CASH_AMOUNT         = 10000.
+)
+
+// Transaction is a saved transaction to be monitored
+type Transaction struct {
+	ID                    int64
+	PainType              int64
+	SenderAccountNumber   string
+	ReceiverAccountNumber string
+	Amount                decimal.Decimal
+	Timestamp             time.Time
+}
+
+// Monitor checks a saved transaction against the monitoring rules and raises
+// an alert on the account for each rule that matches
+func Monitor(transaction Transaction) (err error) {
+	deposit := transaction.PainType == PAIN_TYPE_DEPOSIT
+
+	// Deposits are monitored on the receiving account, payments on the sender
+	accountNumber := transaction.SenderAccountNumber
+	if deposit {
+		accountNumber = transaction.ReceiverAccountNumber
+	}
+
+	if deposit {
+		if matched, details := checkLargeCash(transaction.Amount); matched {
+			err = raiseAlert(RULE_LARGE_CASH, accountNumber, transaction, details)
+			if err != nil {
+				return errors.New("aml.Monitor: " + err.Error())
+			}
+		}
+	}
+
+	low, high := structuringBand()
+	if inBand(transaction.Amount, low, high) {
+		count, err := countInBand(accountNumber, deposit, low, high, transaction.Timestamp.Add(-structuringWindow()))
+		if err != nil {
+			return errors.New("aml.Monitor: " + err.Error())
+		}
+		if matched, details := checkStructuring(count); matched {
+			err = raiseAlertOnce(RULE_STRUCTURING, accountNumber, transaction, details, structuringWindow())
+			if err != nil {
+				return errors.New("aml.Monitor: " + err.Error())
+			}
+		}
+	}
+
+	if !deposit {
+		since := transaction.Timestamp.Add(-rapidMovementWindow())
+		inflow, outflow, err := getFlows(accountNumber, since)
+		if err != nil {
+			return errors.New("aml.Monitor: " + err.Error())
+		}
+		if matched, details := checkRapidMovement(inflow, outflow); matched {
+			err = raiseAlertOnce(RULE_RAPID_MOVEMENT, accountNumber, transaction, details, rapidMovementWindow())
+			if err != nil {
+				return errors.New("aml.Monitor: " + err.Error())
+			}
+		}
+	}
+
+	return
+}
+
+// raiseAlertOnce raises an alert unless the same rule already alerted on the
+// account within the window, so a pattern is only reported once
+func raiseAlertOnce(rule string, accountNumber string, transaction Transaction, details string, window time.Duration) (err error) {
+	exists, err := hasAlertSince(rule, accountNumber, transaction.Timestamp.Add(-window))
+	if err != nil {
+		return errors.New("aml.raiseAlertOnce: " + err.Error())
+	}
+	if exists {
+		return
+	}
+
+	err = raiseAlert(rule, accountNumber, transaction, details)
+	if err != nil {
+		return errors.New("aml.raiseAlertOnce: " + err.Error())
+	}
+	return
+}
+
+// raiseAlert adds an alert to the account's open case, opening one if there is none
+func raiseAlert(rule string, accountNumber string, transaction Transaction, details string) (err error) {
+	caseID, err := getActiveCaseID(accountNumber)
+	if err != nil {
+		return errors.New("aml.raiseAlert: " + err.Error())
+	}
+	if caseID == 0 {
+		caseID, err = createCase(accountNumber)
+		if err != nil {
+			return errors.New("aml.raiseAlert: " + err.Error())
+		}
+	}
+
+	err = saveAlert(caseID, rule, accountNumber, transaction.ID, transaction.Amount, details)
+	if err != nil {
+		return errors.New("aml.raiseAlert: " + err.Error())
+	}
+	return
+}
+
+func checkLargeCash(amount decimal.Decimal) (matched bool, details string) {
+	largeCash := configDecimal(Config.AML.LargeCashAmount, DEFAULT_LARGE_CASH_AMOUNT)
+	if amount.Cmp(largeCash) == -1 {
+		return false, ""
+	}
+	return true, "cash deposit of " + amount.String() + " is at or above " + largeCash.String()
+}
+
+// checkStructuring takes the number of amounts in the structuring band within
+// the window, including the transaction being checked
+func checkStructuring(count int) (matched bool, details string) {
+	structuringCount := Config.AML.StructuringCount
+	if structuringCount == 0 {
+		structuringCount = DEFAULT_STRUCTURING_COUNT
+	}
+	if count < structuringCount {
+		return false, ""
+	}
+
+	low, high := structuringBand()
+	return true, fmt.Sprintf("%d amounts between %s and %s within %s", count, low.String(), high.String(), structuringWindow())
+}
+
+// checkRapidMovement takes what the account received and sent within the
+// window, including the transaction being checked
+func checkRapidMovement(inflow decimal.Decimal, outflow decimal.Decimal) (matched bool, details string) {
+	minAmount := configDecimal(Config.AML.RapidMovementMinAmount, DEFAULT_RAPID_MOVEMENT_MIN_AMOUNT)
+	ratio := configDecimal(Config.AML.RapidMovementRatio, DEFAULT_RAPID_MOVEMENT_RATIO)
+
+	if inflow.Cmp(minAmount) == -1 {
+		return false, ""
+	}
+	if outflow.Cmp(inflow.Mul(ratio)) == -1 {
+		return false, ""
+	}
+
+	return true, fmt.Sprintf("received %s and sent %s within %s", inflow.String(), outflow.String(), rapidMovementWindow())
+}
+
+// structuringBand is the range [low, high) of amounts just under the threshold
+func structuringBand() (low decimal.Decimal, high decimal.Decimal) {
+	high = configDecimal(Config.AML.StructuringThreshold, DEFAULT_STRUCTURING_THRESHOLD)
+	margin := configDecimal(Config.AML.StructuringMargin, DEFAULT_STRUCTURING_MARGIN)
+	low = high.Sub(high.Mul(margin).Div(decimal.NewFromFloat(100)))
+	return
+}
+
+func inBand(amount decimal.Decimal, low decimal.Decimal, high decimal.Decimal) bool {
+	return amount.Cmp(low) >= 0 && amount.Cmp(high) == -1
+}
+
+func structuringWindow() time.Duration {
+	if Config.AML.StructuringWindowHours == 0 {
+		return DEFAULT_STRUCTURING_WINDOW_HOURS * time.Hour
+	}
+	return time.Duration(Config.AML.StructuringWindowHours) * time.Hour
+}
+
+func rapidMovementWindow() time.Duration {
+	if Config.AML.RapidMovementWindowHours == 0 {
+		return DEFAULT_RAPID_MOVEMENT_HOURS * time.Hour
+	}
+	return time.Duration(Config.AML.RapidMovementWindowHours) * time.Hour
+}
+
+func configDecimal(value decimal.Decimal, defaultValue float64) decimal.Decimal {
+	if value.Sign() == 0 {
+		return decimal.NewFromFloat(defaultValue)
+	}
+	return value
+}
@@ -0,0 +1,416 @@
+package appauth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/bvnk/bank/audit"
+)
+
+// Scopes a merchant's API key can be given, and its tokens carry
+const (
+	SCOPE_PAYMENTS_READ   = "payments:read"
+	SCOPE_PAYMENTS_CREATE = "payments:create"
+)
+
+const (
+	API_KEY_STATUS_ACTIVE  = "active"
+	API_KEY_STATUS_REVOKED = "revoked"
+
+	// Client IDs are this prefix and random hex, secrets random hex
+	API_KEY_CLIENT_PREFIX = "mk_"
+	API_KEY_CLIENT_BYTES  = 8
+	API_KEY_SECRET_BYTES  = 32
+
+	// A rotated key's previous secret still works this long, to roll the new one out
+	API_KEY_ROTATION_GRACE = 24 * time.Hour
+
+	// Tokens issued to a key, removed if it is revoked
+	API_KEY_TOKENS_PREFIX = "apikeytokens:"
+
+	CLIENT_TOKEN_TYPE = "Bearer"
+)
+
+var validScopes = []string{SCOPE_PAYMENTS_READ, SCOPE_PAYMENTS_CREATE}
+
+// Given unwrapped by ClientCredentialsToken, for the token endpoint to answer
+// with the OAuth2 error
+var (
+	ErrInvalidClient = errors.New("Client authentication failed")
+	ErrInvalidScope  = errors.New("Scope not allowed for this client")
+)
+
+// APIKey is a merchant's key as listed, without its secret
+type APIKey struct {
+	ClientID   string
+	MerchantID string
+	Scopes     []string
+	Status     string
+	LastUsed   int32
+	Timestamp  int32
+}
+
+// NewAPIKey is a key as created or rotated. The secret is only ever shown here.
+type NewAPIKey struct {
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+}
+
+// ClientToken is the OAuth2 client credentials response, named as RFC 6749 has it
+type ClientToken struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+	Scope       string `json:"scope"`
+}
+
+// storedAPIKey is a key as kept in merchant_api_keys
+type storedAPIKey struct {
+	APIKey
+	UserID                string
+	SecretHash            string
+	PreviousSecretHash    string
+	PreviousSecretExpires int64
+}
+
+func hasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// parseScopes reads scopes separated by spaces, as OAuth2 has them, or commas
+func parseScopes(scopes string) (parsed []string, err error) {
+	fields := strings.FieldsFunc(scopes, func(r rune) bool {
+		return r == ' ' || r == ','
+	})
+	for _, scope := range fields {
+		if !hasScope(validScopes, scope) {
+			return nil, errors.New("appauth.parseScopes: Scope not valid, must be one of " + strings.Join(validScopes, ", "))
+		}
+		if !hasScope(parsed, scope) {
+			parsed = append(parsed, scope)
+		}
+	}
+	if len(parsed) == 0 {
+		return nil, errors.New("appauth.parseScopes: At least one scope is required")
+	}
+	return
+}
+
+func apiKeySecretHash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(size int) (random string, err error) {
+	b := make([]byte, size)
+	_, err = rand.Read(b)
+	if err != nil {
+		return "", errors.New("appauth.randomHex: " + err.Error())
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// CreateAPIKey gives a merchant a key for calling the API, for a user who
+// holds the merchant's accounts
+func CreateAPIKey(token string, merchantID string, scopes string) (key NewAPIKey, err error) {
+	userID, err := GetUserFromToken(token)
+	if err != nil {
+		return NewAPIKey{}, errors.New("appauth.CreateAPIKey: " + err.Error())
+	}
+	err = checkMerchantUser(userID, merchantID)
+	if err != nil {
+		return NewAPIKey{}, errors.New("appauth.CreateAPIKey: " + err.Error())
+	}
+	key.Scopes, err = parseScopes(scopes)
+	if err != nil {
+		return NewAPIKey{}, errors.New("appauth.CreateAPIKey: " + err.Error())
+	}
+
+	clientID, err := randomHex(API_KEY_CLIENT_BYTES)
+	if err != nil {
+		return NewAPIKey{}, errors.New("appauth.CreateAPIKey: " + err.Error())
+	}
+	key.ClientID = API_KEY_CLIENT_PREFIX + clientID
+	key.ClientSecret, err = randomHex(API_KEY_SECRET_BYTES)
+	if err != nil {
+		return NewAPIKey{}, errors.New("appauth.CreateAPIKey: " + err.Error())
+	}
+
+	err = saveAPIKey(key.ClientID, merchantID, userID, apiKeySecretHash(key.ClientSecret), key.Scopes)
+	if err != nil {
+		return NewAPIKey{}, errors.New("appauth.CreateAPIKey: " + err.Error())
+	}
+
+	err = audit.Record(audit.Event{Type: audit.EVENT_API_KEY_CREATED, Actor: userID, Subject: key.ClientID, Detail: merchantID})
+	if err != nil {
+		return NewAPIKey{}, errors.New("appauth.CreateAPIKey: " + err.Error())
+	}
+	return
+}
+
+// ListAPIKeys gives a merchant's keys by their client IDs
+func ListAPIKeys(token string, merchantID string) (keys []APIKey, err error) {
+	userID, err := GetUserFromToken(token)
+	if err != nil {
+		return nil, errors.New("appauth.ListAPIKeys: " + err.Error())
+	}
+	err = checkMerchantUser(userID, merchantID)
+	if err != nil {
+		return nil, errors.New("appauth.ListAPIKeys: " + err.Error())
+	}
+
+	keys, err = getMerchantAPIKeys(merchantID)
+	if err != nil {
+		return nil, errors.New("appauth.ListAPIKeys: " + err.Error())
+	}
+	return
+}
+
+// RotateAPIKey gives a key a new secret. The previous one still works for
+// API_KEY_ROTATION_GRACE, and tokens already issued until they expire.
+func RotateAPIKey(token string, merchantID string, clientID string) (key NewAPIKey, err error) {
+	userID, stored, err := getMerchantAPIKey(token, merchantID, clientID)
+	if err != nil {
+		return NewAPIKey{}, errors.New("appauth.RotateAPIKey: " + err.Error())
+	}
+
+	key.ClientID = clientID
+	key.Scopes = stored.Scopes
+	key.ClientSecret, err = randomHex(API_KEY_SECRET_BYTES)
+	if err != nil {
+		return NewAPIKey{}, errors.New("appauth.RotateAPIKey: " + err.Error())
+	}
+
+	err = updateAPIKeySecret(clientID, apiKeySecretHash(key.ClientSecret), stored.SecretHash, time.Now().Add(API_KEY_ROTATION_GRACE).Unix())
+	if err != nil {
+		return NewAPIKey{}, errors.New("appauth.RotateAPIKey: " + err.Error())
+	}
+
+	err = audit.Record(audit.Event{Type: audit.EVENT_API_KEY_ROTATED, Actor: userID, Subject: clientID, Detail: merchantID})
+	if err != nil {
+		return NewAPIKey{}, errors.New("appauth.RotateAPIKey: " + err.Error())
+	}
+	return
+}
+
+// RevokeAPIKey stops a key working, along with every token it was issued
+func RevokeAPIKey(token string, merchantID string, clientID string) (result string, err error) {
+	userID, _, err := getMerchantAPIKey(token, merchantID, clientID)
+	if err != nil {
+		return "", errors.New("appauth.RevokeAPIKey: " + err.Error())
+	}
+
+	err = updateAPIKeyStatus(clientID, API_KEY_STATUS_REVOKED)
+	if err != nil {
+		return "", errors.New("appauth.RevokeAPIKey: " + err.Error())
+	}
+	err = revokeClientTokens(clientID)
+	if err != nil {
+		return "", errors.New("appauth.RevokeAPIKey: " + err.Error())
+	}
+
+	err = audit.Record(audit.Event{Type: audit.EVENT_API_KEY_REVOKED, Actor: userID, Subject: clientID, Detail: merchantID})
+	if err != nil {
+		return "", errors.New("appauth.RevokeAPIKey: " + err.Error())
+	}
+	return "API key revoked", nil
+}
+
+// ClientCredentialsToken gives an access token for a merchant's API key, with
+// the scopes asked for or all of the key's. A wrong key or secret gives
+// ErrInvalidClient and a scope the key does not have ErrInvalidScope, unwrapped.
+func ClientCredentialsToken(clientID string, clientSecret string, scope string) (token ClientToken, err error) {
+	stored, err := getAPIKey(clientID)
+	if err == sql.ErrNoRows {
+		return ClientToken{}, ErrInvalidClient
+	} else if err != nil {
+		return ClientToken{}, errors.New("appauth.ClientCredentialsToken: " + err.Error())
+	}
+	if stored.Status != API_KEY_STATUS_ACTIVE || !checkAPIKeySecret(stored, clientSecret, time.Now()) {
+		return ClientToken{}, ErrInvalidClient
+	}
+
+	scopes := stored.Scopes
+	if strings.TrimSpace(scope) != "" {
+		scopes, err = parseScopes(scope)
+		if err != nil {
+			return ClientToken{}, ErrInvalidScope
+		}
+		for _, s := range scopes {
+			if !hasScope(stored.Scopes, s) {
+				return ClientToken{}, ErrInvalidScope
+			}
+		}
+	}
+
+	issued, err := store.IssueClient(stored.UserID, stored.MerchantID, clientID, scopes)
+	if err != nil {
+		return ClientToken{}, errors.New("appauth.ClientCredentialsToken: " + err.Error())
+	}
+	err = store.kv.SAdd(API_KEY_TOKENS_PREFIX+clientID, issued)
+	if err != nil {
+		return ClientToken{}, errors.New("appauth.ClientCredentialsToken: Could not add token to key. " + err.Error())
+	}
+	err = store.kv.Expire(API_KEY_TOKENS_PREFIX+clientID, store.AbsoluteTimeout)
+	if err != nil {
+		return ClientToken{}, errors.New("appauth.ClientCredentialsToken: Could not extend key tokens. " + err.Error())
+	}
+
+	err = updateAPIKeyLastUsed(clientID)
+	if err != nil {
+		return ClientToken{}, errors.New("appauth.ClientCredentialsToken: " + err.Error())
+	}
+
+	return ClientToken{
+		AccessToken: issued,
+		TokenType:   CLIENT_TOKEN_TYPE,
+		ExpiresIn:   int(store.AbsoluteTimeout.Seconds()),
+		Scope:       strings.Join(scopes, " "),
+	}, nil
+}
+
+// checkAPIKeySecret checks a secret against the key's, or its previous one
+// while a rotation's grace lasts
+func checkAPIKeySecret(stored storedAPIKey, secret string, now time.Time) bool {
+	hash := []byte(apiKeySecretHash(secret))
+	if subtle.ConstantTimeCompare(hash, []byte(stored.SecretHash)) == 1 {
+		return true
+	}
+	return stored.PreviousSecretHash != "" && now.Unix() < stored.PreviousSecretExpires &&
+		subtle.ConstantTimeCompare(hash, []byte(stored.PreviousSecretHash)) == 1
+}
+
+// getMerchantAPIKey gives a merchant's key for a user who holds its accounts
+func getMerchantAPIKey(token string, merchantID string, clientID string) (userID string, stored storedAPIKey, err error) {
+	userID, err = GetUserFromToken(token)
+	if err != nil {
+		return "", storedAPIKey{}, err
+	}
+	err = checkMerchantUser(userID, merchantID)
+	if err != nil {
+		return "", storedAPIKey{}, err
+	}
+
+	stored, err = getAPIKey(clientID)
+	if err == sql.ErrNoRows || (err == nil && stored.MerchantID != merchantID) {
+		return "", storedAPIKey{}, errors.New("API key not found")
+	} else if err != nil {
+		return "", storedAPIKey{}, err
+	}
+	if stored.Status != API_KEY_STATUS_ACTIVE {
+		return "", storedAPIKey{}, errors.New("API key is " + stored.Status)
+	}
+	return
+}
+
+func revokeClientTokens(clientID string) (err error) {
+	tokens, err := store.kv.SMembers(API_KEY_TOKENS_PREFIX + clientID)
+	if err != nil {
+		return errors.New("appauth.revokeClientTokens: Could not get key tokens. " + err.Error())
+	}
+	for _, token := range tokens {
+		err = store.Revoke(token)
+		if err != nil {
+			return errors.New("appauth.revokeClientTokens: " + err.Error())
+		}
+	}
+	err = store.kv.Del(API_KEY_TOKENS_PREFIX + clientID)
+	if err != nil {
+		return errors.New("appauth.revokeClientTokens: Could not remove key tokens. " + err.Error())
+	}
+	return
+}
+
+// checkMerchantUser checks the user holds one of the merchant's accounts
+func checkMerchantUser(userID string, merchantID string) (err error) {
+	count := 0
+	err = Config.Db.QueryRow("SELECT COUNT(*) FROM `merchant_users_accounts` WHERE `merchantID` = ? AND `accountHolderIdentificationNumber` = ?", merchantID, userID).Scan(&count)
+	if err != nil {
+		return errors.New("appauth.checkMerchantUser: " + err.Error())
+	}
+	if count == 0 {
+		return errors.New("appauth.checkMerchantUser: Merchant not found")
+	}
+	return
+}
+
+// getAPIKey gives sql.ErrNoRows unwrapped if the key does not exist
+func getAPIKey(clientID string) (stored storedAPIKey, err error) {
+	scopes := ""
+	err = Config.Db.QueryRow("SELECT `clientID`, `merchantID`, `accountHolderIdentificationNumber`, `secretHash`, `previousSecretHash`, `previousSecretExpires`, `scopes`, `status`, `lastUsed`, `timestamp` FROM `merchant_api_keys` WHERE `clientID` = ?", clientID).Scan(
+		&stored.ClientID, &stored.MerchantID, &stored.UserID, &stored.SecretHash, &stored.PreviousSecretHash, &stored.PreviousSecretExpires, &scopes, &stored.Status, &stored.LastUsed, &stored.Timestamp)
+	switch {
+	case err == sql.ErrNoRows:
+		return storedAPIKey{}, err
+	case err != nil:
+		return storedAPIKey{}, errors.New("appauth.getAPIKey: " + err.Error())
+	}
+	stored.Scopes = strings.Fields(scopes)
+	return
+}
+
+func getMerchantAPIKeys(merchantID string) (keys []APIKey, err error) {
+	rows, err := Config.Db.Query("SELECT `clientID`, `merchantID`, `scopes`, `status`, `lastUsed`, `timestamp` FROM `merchant_api_keys` WHERE `merchantID` = ? ORDER BY `timestamp`", merchantID)
+	if err != nil {
+		return nil, errors.New("appauth.getMerchantAPIKeys: " + err.Error())
+	}
+	defer rows.Close()
+
+	keys = []APIKey{}
+	for rows.Next() {
+		key := APIKey{}
+		scopes := ""
+		err = rows.Scan(&key.ClientID, &key.MerchantID, &scopes, &key.Status, &key.LastUsed, &key.Timestamp)
+		if err != nil {
+			return nil, errors.New("appauth.getMerchantAPIKeys: " + err.Error())
+		}
+		key.Scopes = strings.Fields(scopes)
+		keys = append(keys, key)
+	}
+	return
+}
+
+func saveAPIKey(clientID string, merchantID string, userID string, secretHash string, scopes []string) (err error) {
+	_, err = Config.Db.Exec("INSERT INTO `merchant_api_keys` (`clientID`, `merchantID`, `accountHolderIdentificationNumber`, `secretHash`, `scopes`, `status`, `timestamp`) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		clientID, merchantID, userID, secretHash, strings.Join(scopes, " "), API_KEY_STATUS_ACTIVE, time.Now().Unix())
+	if err != nil {
+		return errors.New("appauth.saveAPIKey: " + err.Error())
+	}
+	return
+}
+
+func updateAPIKeySecret(clientID string, secretHash string, previousSecretHash string, previousSecretExpires int64) (err error) {
+	_, err = Config.Db.Exec("UPDATE `merchant_api_keys` SET `secretHash` = ?, `previousSecretHash` = ?, `previousSecretExpires` = ? WHERE `clientID` = ?", secretHash, previousSecretHash, previousSecretExpires, clientID)
+	if err != nil {
+		return errors.New("appauth.updateAPIKeySecret: " + err.Error())
+	}
+	return
+}
+
+func updateAPIKeyStatus(clientID string, status string) (err error) {
+	_, err = Config.Db.Exec("UPDATE `merchant_api_keys` SET `status` = ? WHERE `clientID` = ?", status, clientID)
+	if err != nil {
+		return errors.New("appauth.updateAPIKeyStatus: " + err.Error())
+	}
+	return
+}
+
+func updateAPIKeyLastUsed(clientID string) (err error) {
+	_, err = Config.Db.Exec("UPDATE `merchant_api_keys` SET `lastUsed` = ? WHERE `clientID` = ?", time.Now().Unix(), clientID)
+	if err != nil {
+		return errors.New("appauth.updateAPIKeyLastUsed: " + err.Error())
+	}
+	return
+}
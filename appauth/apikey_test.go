@@ -0,0 +1,98 @@
+package appauth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseScopes(t *testing.T) {
+	scopes, err := parseScopes("payments:read payments:create,payments:read")
+	if err != nil || len(scopes) != 2 || scopes[0] != SCOPE_PAYMENTS_READ || scopes[1] != SCOPE_PAYMENTS_CREATE {
+		t.Errorf("ParseScopes does not pass. Looking for %v, got %v %v", validScopes, scopes, err)
+	}
+
+	for _, invalid := range []string{"", " , ", "payments:delete", "payments:read admin"} {
+		if _, err := parseScopes(invalid); err == nil {
+			t.Errorf("ParseScopes %q does not pass. Looking for %v, got %v", invalid, "error", err)
+		}
+	}
+}
+
+func TestCheckAPIKeySecret(t *testing.T) {
+	now := time.Unix(1480000000, 0)
+	stored := storedAPIKey{
+		SecretHash:            apiKeySecretHash("new-secret"),
+		PreviousSecretHash:    apiKeySecretHash("old-secret"),
+		PreviousSecretExpires: now.Add(API_KEY_ROTATION_GRACE).Unix(),
+	}
+
+	if !checkAPIKeySecret(stored, "new-secret", now) {
+		t.Errorf("CheckAPIKeySecret does not pass. Looking for %v, got %v", true, false)
+	}
+	if checkAPIKeySecret(stored, "wrong-secret", now) {
+		t.Errorf("CheckAPIKeySecret wrong secret does not pass. Looking for %v, got %v", false, true)
+	}
+
+	// The old secret works until the grace ends
+	if !checkAPIKeySecret(stored, "old-secret", now.Add(time.Hour)) {
+		t.Errorf("CheckAPIKeySecret grace does not pass. Looking for %v, got %v", true, false)
+	}
+	if checkAPIKeySecret(stored, "old-secret", now.Add(API_KEY_ROTATION_GRACE)) {
+		t.Errorf("CheckAPIKeySecret grace ended does not pass. Looking for %v, got %v", false, true)
+	}
+}
+
+func TestClientToken(t *testing.T) {
+	kv := setTestStore()
+
+	token, err := store.IssueClient("user", "merchant", "mk_0123456789abcdef", []string{SCOPE_PAYMENTS_READ})
+	if err != nil {
+		t.Fatalf("ClientToken IssueClient does not pass. Looking for %v, got %v", nil, err)
+	}
+
+	// Only usable where a scope allows it
+	if _, err := GetUserFromToken(token); err == nil {
+		t.Errorf("ClientToken GetUserFromToken does not pass. Looking for %v, got %v", "error", err)
+	}
+	user, merchantID, err := GetUserFromScopedToken(token, SCOPE_PAYMENTS_READ)
+	if err != nil || user != "user" || merchantID != "merchant" {
+		t.Errorf("ClientToken scope does not pass. Looking for %v %v, got %v %v %v", "user", "merchant", user, merchantID, err)
+	}
+	if _, _, err := GetUserFromScopedToken(token, SCOPE_PAYMENTS_CREATE); err == nil {
+		t.Errorf("ClientToken missing scope does not pass. Looking for %v, got %v", "error", err)
+	}
+
+	// Lasts the absolute timeout, used or not, and is not extended
+	kv.now = kv.now.Add(50 * time.Minute)
+	if err := CheckToken(token); err != nil {
+		t.Errorf("ClientToken lifetime does not pass. Looking for %v, got %v", nil, err)
+	}
+	kv.now = kv.now.Add(10 * time.Minute)
+	if err := CheckToken(token); err == nil {
+		t.Errorf("ClientToken expired does not pass. Looking for %v, got %v", "error", err)
+	}
+}
+
+func TestUserTokenScopes(t *testing.T) {
+	setTestStore()
+
+	token, _ := store.Issue("user", "session")
+	user, merchantID, err := GetUserFromScopedToken(token, SCOPE_PAYMENTS_CREATE)
+	if err != nil || user != "user" || merchantID != "" {
+		t.Errorf("UserTokenScopes does not pass. Looking for %v %v, got %v %v %v", "user", "", user, merchantID, err)
+	}
+}
+
+func TestRevokeClientTokens(t *testing.T) {
+	setTestStore()
+
+	token, _ := store.IssueClient("user", "merchant", "mk_0123456789abcdef", []string{SCOPE_PAYMENTS_READ})
+	store.kv.SAdd(API_KEY_TOKENS_PREFIX+"mk_0123456789abcdef", token)
+
+	if err := revokeClientTokens("mk_0123456789abcdef"); err != nil {
+		t.Fatalf("RevokeClientTokens does not pass. Looking for %v, got %v", nil, err)
+	}
+	if err := CheckToken(token); err == nil {
+		t.Errorf("RevokeClientTokens check does not pass. Looking for %v, got %v", "error", err)
+	}
+}
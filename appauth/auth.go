@@ -2,31 +2,38 @@ package appauth
 
 import (
 	"database/sql"
-	"encoding/hex"
 	"errors"
 	"math/rand"
 	"time"
 
-	"gopkg.in/redis.v3"
-
 	"github.com/bvnk/bank/configuration"
-	"github.com/pzduniak/argon2"
 	"github.com/satori/go.uuid"
 )
 
 const (
-	TOKEN_TTL           = time.Hour // One hour
+	TOKEN_TTL           = 15 * time.Minute // Fifteen minutes, refresh tokens last longer
 	MIN_PASSWORD_LENGTH = 8
 	LETTER_BYTES        = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
 )
 
 var Config configuration.Configuration
 
+// Tokens and sessions, kept in Redis
+var store *TokenStore
+
 func SetConfig(config *configuration.Configuration) {
 	Config = *config
+	store = NewTokenStore(redisKeyValue{Config.Redis}, Config.Auth)
+	notifier = LogNotifier{Path: Config.Auth.NotifyLogPath}
+}
+
+func ProcessAppAuth(data []string) (result interface{}, err error) {
+	return ProcessAppAuthFrom(data, "")
 }
 
-func ProcessAppAuth(data []string) (result string, err error) {
+// ProcessAppAuthFrom is ProcessAppAuth for a request from remoteAddr, which
+// failed logins are counted against
+func ProcessAppAuthFrom(data []string, remoteAddr string) (result interface{}, err error) {
 	//@TODO: Change from []string to something more solid, struct/interface/key-pair
 	if len(data) < 3 {
 		return "", errors.New("appauth.ProcessAppAuth: Not all required fields present")
@@ -48,7 +55,12 @@ func ProcessAppAuth(data []string) (result string, err error) {
 		if len(data) < 5 {
 			return "", errors.New("appauth.ProcessAppAuth: Not all required fields present")
 		}
-		result, err = CreateToken(data[3], data[4])
+		// TOKEN~appauth~2~authUser~password~device, the device is optional
+		device := ""
+		if len(data) > 5 {
+			device = data[5]
+		}
+		result, err = Login(data[3], data[4], device, remoteAddr)
 		if err != nil {
 			return "", err
 		}
@@ -68,7 +80,291 @@ func ProcessAppAuth(data []string) (result string, err error) {
 		if len(data) < 5 {
 			return "", errors.New("appauth.ProcessAppAuth: Not all required fields present")
 		}
-		result, err = RemoveUserPassword(data[3], data[4])
+		result, err = RemoveUserPassword(data[3], data[4], remoteAddr)
+		if err != nil {
+			return "", err
+		}
+		return result, nil
+	// Refresh tokens
+	case "5":
+		// 0~appauth~5~refreshToken
+		if len(data) < 4 {
+			return "", errors.New("appauth.ProcessAppAuth: Not all required fields present")
+		}
+		result, err = RefreshSession(data[3])
+		if err != nil {
+			return "", err
+		}
+		return result, nil
+	// List sessions
+	case "6":
+		// TOKEN~appauth~6
+		result, err = ListSessions(data[0])
+		if err != nil {
+			return "", err
+		}
+		return result, nil
+	// Log out a session
+	case "7":
+		// TOKEN~appauth~7~sessionID
+		if len(data) < 4 {
+			return "", errors.New("appauth.ProcessAppAuth: Not all required fields present")
+		}
+		result, err = RevokeSession(data[0], data[3])
+		if err != nil {
+			return "", err
+		}
+		return result, nil
+	// Log out everywhere
+	case "8":
+		// TOKEN~appauth~8
+		result, err = RevokeAllSessions(data[0])
+		if err != nil {
+			return "", err
+		}
+		return result, nil
+	// Start two-factor enrolment
+	case "9":
+		// TOKEN~appauth~9
+		result, err = EnrolTOTP(data[0])
+		if err != nil {
+			return "", err
+		}
+		return result, nil
+	// Confirm two-factor enrolment
+	case "10":
+		// TOKEN~appauth~10~code
+		if len(data) < 4 {
+			return "", errors.New("appauth.ProcessAppAuth: Not all required fields present")
+		}
+		result, err = ConfirmTOTP(data[0], data[3])
+		if err != nil {
+			return "", err
+		}
+		return result, nil
+	// Disable two-factor
+	case "11":
+		// TOKEN~appauth~11~code
+		if len(data) < 4 {
+			return "", errors.New("appauth.ProcessAppAuth: Not all required fields present")
+		}
+		result, err = DisableTOTP(data[0], data[3], remoteAddr)
+		if err != nil {
+			return "", err
+		}
+		return result, nil
+	// Second step of logging in
+	case "12":
+		// 0~appauth~12~challenge~code
+		if len(data) < 5 {
+			return "", errors.New("appauth.ProcessAppAuth: Not all required fields present")
+		}
+		result, err = LoginTOTP(data[3], data[4], remoteAddr)
+		if err != nil {
+			return "", err
+		}
+		return result, nil
+	// Step up before a payment
+	case "13":
+		// TOKEN~appauth~13~code
+		if len(data) < 4 {
+			return "", errors.New("appauth.ProcessAppAuth: Not all required fields present")
+		}
+		result, err = StepUp(data[0], data[3], remoteAddr)
+		if err != nil {
+			return "", err
+		}
+		return result, nil
+	// Unlock a login locked after too many failures
+	case "14":
+		// 0~appauth~14~authUser~basicAuthUser~basicAuthPassword
+		if len(data) < 6 {
+			return "", errors.New("appauth.ProcessAppAuth: Not all required fields present")
+		}
+		_, err = CheckPermission(data[:6], PERMISSION_UNLOCK)
+		if err != nil {
+			return "", err
+		}
+		result, err = UnlockLogin(data[3], data[4])
+		if err != nil {
+			return "", err
+		}
+		return result, nil
+	// Change password
+	case "15":
+		// TOKEN~appauth~15~currentPassword~newPassword
+		if len(data) < 5 {
+			return "", errors.New("appauth.ProcessAppAuth: Not all required fields present")
+		}
+		result, err = ChangePassword(data[0], data[3], data[4], remoteAddr)
+		if err != nil {
+			return "", err
+		}
+		return result, nil
+	// Forgot password, send a reset code
+	case "16":
+		// 0~appauth~16~authUser
+		if len(data) < 4 {
+			return "", errors.New("appauth.ProcessAppAuth: Not all required fields present")
+		}
+		result, err = RequestPasswordReset(data[3], remoteAddr)
+		if err != nil {
+			return "", err
+		}
+		return result, nil
+	// Reset password with the code
+	case "17":
+		// 0~appauth~17~authUser~code~newPassword
+		if len(data) < 6 {
+			return "", errors.New("appauth.ProcessAppAuth: Not all required fields present")
+		}
+		result, err = ResetPassword(data[3], data[4], data[5])
+		if err != nil {
+			return "", err
+		}
+		return result, nil
+	// Add a staff user
+	case "18":
+		// 0~appauth~18~username~password~role~basicAuthUser~basicAuthPassword
+		if len(data) < 8 {
+			return "", errors.New("appauth.ProcessAppAuth: Not all required fields present")
+		}
+		// The new user's password is left out of the audit log
+		createdBy, err := CheckPermission([]string{data[0], data[1], data[2], data[3], data[5], data[6], data[7]}, PERMISSION_STAFF)
+		if err != nil {
+			return "", err
+		}
+		result, err = CreateStaff(data[3], data[4], data[5], createdBy)
+		if err != nil {
+			return "", err
+		}
+		return result, nil
+	// Change a staff user's role, or disable them
+	case "19":
+		// 0~appauth~19~username~role~basicAuthUser~basicAuthPassword
+		if len(data) < 7 {
+			return "", errors.New("appauth.ProcessAppAuth: Not all required fields present")
+		}
+		_, err = CheckPermission(data[:7], PERMISSION_STAFF)
+		if err != nil {
+			return "", err
+		}
+		result, err = SetStaffRole(data[3], data[4])
+		if err != nil {
+			return "", err
+		}
+		return result, nil
+	// List staff users
+	case "20":
+		// 0~appauth~20~basicAuthUser~basicAuthPassword
+		if len(data) < 5 {
+			return "", errors.New("appauth.ProcessAppAuth: Not all required fields present")
+		}
+		_, err = CheckPermission(data[:5], PERMISSION_STAFF)
+		if err != nil {
+			return "", err
+		}
+		result, err = ListStaff()
+		if err != nil {
+			return "", err
+		}
+		return result, nil
+	// Get a token with a merchant's API key, the OAuth2 client credentials grant
+	case "21":
+		// 0~appauth~21~clientID~clientSecret~scope
+		if len(data) < 6 {
+			return "", errors.New("appauth.ProcessAppAuth: Not all required fields present")
+		}
+		result, err = ClientCredentialsToken(data[3], data[4], data[5])
+		if err != nil {
+			return "", err
+		}
+		return result, nil
+	// Create a merchant API key
+	case "22":
+		// TOKEN~appauth~22~merchantID~scopes
+		if len(data) < 5 {
+			return "", errors.New("appauth.ProcessAppAuth: Not all required fields present")
+		}
+		result, err = CreateAPIKey(data[0], data[3], data[4])
+		if err != nil {
+			return "", err
+		}
+		return result, nil
+	// List a merchant's API keys
+	case "23":
+		// TOKEN~appauth~23~merchantID
+		if len(data) < 4 {
+			return "", errors.New("appauth.ProcessAppAuth: Not all required fields present")
+		}
+		result, err = ListAPIKeys(data[0], data[3])
+		if err != nil {
+			return "", err
+		}
+		return result, nil
+	// Give a merchant API key a new secret
+	case "24":
+		// TOKEN~appauth~24~merchantID~clientID
+		if len(data) < 5 {
+			return "", errors.New("appauth.ProcessAppAuth: Not all required fields present")
+		}
+		result, err = RotateAPIKey(data[0], data[3], data[4])
+		if err != nil {
+			return "", err
+		}
+		return result, nil
+	// Revoke a merchant API key
+	case "25":
+		// TOKEN~appauth~25~merchantID~clientID
+		if len(data) < 5 {
+			return "", errors.New("appauth.ProcessAppAuth: Not all required fields present")
+		}
+		result, err = RevokeAPIKey(data[0], data[3], data[4])
+		if err != nil {
+			return "", err
+		}
+		return result, nil
+	// Enrol a client certificate for the TCP server
+	case "26":
+		// 0~appauth~26~fingerprint~subject~kind~identity~scopes~basicAuthUser~basicAuthPassword
+		if len(data) < 10 {
+			return "", errors.New("appauth.ProcessAppAuth: Not all required fields present")
+		}
+		createdBy, err := CheckPermission(data[:10], PERMISSION_CERTIFICATES)
+		if err != nil {
+			return "", err
+		}
+		result, err = EnrolCertificate(data[3], data[4], data[5], data[6], data[7], createdBy)
+		if err != nil {
+			return "", err
+		}
+		return result, nil
+	// Revoke a client certificate
+	case "27":
+		// 0~appauth~27~fingerprint~basicAuthUser~basicAuthPassword
+		if len(data) < 6 {
+			return "", errors.New("appauth.ProcessAppAuth: Not all required fields present")
+		}
+		revokedBy, err := CheckPermission(data[:6], PERMISSION_CERTIFICATES)
+		if err != nil {
+			return "", err
+		}
+		result, err = RevokeCertificate(data[3], revokedBy)
+		if err != nil {
+			return "", err
+		}
+		return result, nil
+	// List client certificates
+	case "28":
+		// 0~appauth~28~basicAuthUser~basicAuthPassword
+		if len(data) < 5 {
+			return "", errors.New("appauth.ProcessAppAuth: Not all required fields present")
+		}
+		_, err = CheckPermission(data[:5], PERMISSION_CERTIFICATES)
+		if err != nil {
+			return "", err
+		}
+		result, err = ListCertificates()
 		if err != nil {
 			return "", err
 		}
@@ -104,43 +400,27 @@ func CreateUserPassword(user string, clearTextPassword string) (result string, e
 	}
 	defer rows.Close()
 
-	var authUser string
 	count = 0
 	for rows.Next() {
-		if err := rows.Scan(&authUser); err != nil {
-			return "", errors.New("appauth.CreateUserPassword: Could not retreive authUser")
-		}
 		count++
 	}
 
 	if count > 0 {
-		// Return authUser with no error
-		return authUser, nil
+		return "", errors.New("appauth.CreateUserPassword: Account already has a password")
 	}
 
-	// Check password length
-	if len(clearTextPassword) < MIN_PASSWORD_LENGTH {
-		return "", errors.New("appauth.CreateUserPassword: Password must be at least " + string(MIN_PASSWORD_LENGTH) + " characters")
-	}
-
-	// Generate salt
-	randomStrIn := RandStringBytes(32)
-	saltOutput, err := argon2.Key([]byte(randomStrIn), []byte(Config.PasswordSalt), 3, 4, 4096, 64, argon2.Argon2i)
+	err = checkPasswordStrength(clearTextPassword, user)
 	if err != nil {
-		return "", errors.New("appauth.CreateUserPassword: Could not generate secure hash. " + err.Error())
+		return "", errors.New("appauth.CreateUserPassword: " + err.Error())
 	}
-	userSalt := hex.EncodeToString(saltOutput)
 
-	// Generate hash
-	userPasswordSalt := userSalt + clearTextPassword
-	hashOutput, err := argon2.Key([]byte(userPasswordSalt), []byte(Config.PasswordSalt), 3, 4, 4096, 64, argon2.Argon2i)
+	userHashedPassword, err := hashPassword(clearTextPassword)
 	if err != nil {
-		return "", errors.New("appauth.CreateUserPassword: Could not generate secure hash. " + err.Error())
+		return "", errors.New("appauth.CreateUserPassword: " + err.Error())
 	}
-	userHashedPassword := hex.EncodeToString(hashOutput)
 
 	// Generate authUser number
-	authUser = uuid.NewV4().String()
+	authUser := uuid.NewV4().String()
 
 	// Prepare statement for inserting data
 	insertStatement := "INSERT INTO accounts_user_auth (`accountHolderIdentificationNumber`, `authUser`, `password`, `salt`, `timestamp`) "
@@ -155,7 +435,7 @@ func CreateUserPassword(user string, clearTextPassword string) (result string, e
 	t := time.Now()
 	sqlTime := int32(t.Unix())
 
-	_, err = stmtIns.Exec(user, authUser, userHashedPassword, userSalt, sqlTime)
+	_, err = stmtIns.Exec(user, authUser, userHashedPassword, "", sqlTime)
 
 	if err != nil {
 		return "", errors.New("appauth.CreateUserPassword: Could not save account. " + err.Error())
@@ -165,7 +445,9 @@ func CreateUserPassword(user string, clearTextPassword string) (result string, e
 	return
 }
 
-func RemoveUserPassword(user string, clearTextPassword string) (result string, err error) {
+// RemoveUserPassword removes a user's login once given their password. Wrong
+// passwords count towards locking the login, as they do logging in.
+func RemoveUserPassword(user string, clearTextPassword string, remoteAddr string) (result string, err error) {
 	// Check for existing account
 	rows, err := Config.Db.Query("SELECT * FROM `accounts_user_auth` WHERE `accountHolderIdentificationNumber` = ?", user)
 	if err != nil {
@@ -183,21 +465,31 @@ func RemoveUserPassword(user string, clearTextPassword string) (result string, e
 		return "", errors.New("appauth.RemoveUserPassword: Account auth does not exists")
 	}
 
+	authUser, err := getAuthUser(user)
+	if err != nil {
+		return "", errors.New("appauth.RemoveUserPassword: " + err.Error())
+	}
+	err = checkLoginAllowed(authUser, remoteAddr)
+	if err != nil {
+		return "", errors.New("appauth.RemoveUserPassword: " + err.Error())
+	}
+
 	userHashedPassword, userSalt, err := getUserPasswordSaltFromUID(user)
 	if err != nil {
-		return "", errors.New("appauth.CreateUserPassword: Could not retrieve user details. " + err.Error())
+		return "", errors.New("appauth.RemoveUserPassword: Could not retrieve user details. " + err.Error())
 	}
 
-	// Generate hash
-	userPasswordSalt := userSalt + clearTextPassword
-	hashOutput, err := argon2.Key([]byte(userPasswordSalt), []byte(Config.PasswordSalt), 3, 4, 4096, 64, argon2.Argon2i)
+	ok, _, err := verifyPassword(userHashedPassword, userSalt, clearTextPassword)
 	if err != nil {
-		return "", errors.New("appauth.CreateUserPassword: Could not generate secure hash. " + err.Error())
+		return "", errors.New("appauth.RemoveUserPassword: " + err.Error())
 	}
-	hash := hex.EncodeToString(hashOutput)
 
-	if hash != userHashedPassword {
-		return "", errors.New("appauth.CreateToken: Authentication credentials invalid")
+	if !ok {
+		err = recordLoginFailure(authUser, remoteAddr, "wrong password to remove login")
+		if err != nil {
+			return "", errors.New("appauth.RemoveUserPassword: " + err.Error())
+		}
+		return "", errors.New("appauth.RemoveUserPassword: " + errLoginRefused.Error())
 	}
 
 	// Prepare statement for inserting data
@@ -227,38 +519,95 @@ func RemoveUserPassword(user string, clearTextPassword string) (result string, e
 	return
 }
 
+// CreateToken logs a user in and gives only the access token of the new session
 func CreateToken(authUser string, password string) (token string, err error) {
+	tokens, err := Login(authUser, password, "", "")
+	if err != nil {
+		return "", err
+	}
+	if tokens.Challenge != "" {
+		return "", errors.New("appauth.CreateToken: Two-factor authentication required")
+	}
+	return tokens.AccessToken, nil
+}
+
+// Login checks a user's password and starts a session on the device. If the
+// user has two-factor authentication the session only starts once LoginTOTP is
+// given a code for the challenge.
+func Login(authUser string, password string, device string, remoteAddr string) (tokens Tokens, err error) {
+	err = checkLoginAllowed(authUser, remoteAddr)
+	if err != nil {
+		return Tokens{}, errors.New("appauth.Login: " + err.Error())
+	}
+
+	found := true
 	hashedPassword := ""
 	userSalt := ""
 	userID := ""
 	err = Config.Db.QueryRow("SELECT `password`, `salt`, `accountHolderIdentificationNumber` FROM `accounts_user_auth` WHERE `authUser` = ?", authUser).Scan(&hashedPassword, &userSalt, &userID)
 	switch {
 	case err == sql.ErrNoRows:
-		break
+		found = false
 	case err != nil:
-		return "", errors.New("appauth.CreateToken: Could not retreive account details: " + err.Error())
+		return Tokens{}, errors.New("appauth.Login: Could not retreive account details: " + err.Error())
 	}
 
-	// Generate hash
-	userPasswordSalt := userSalt + password
-	output, err := argon2.Key([]byte(userPasswordSalt), []byte(Config.PasswordSalt), 3, 4, 4096, 64, argon2.Argon2i)
-	if err != nil {
-		return "", errors.New("appauth.CreateUserPassword: Could not generate secure hash. " + err.Error())
+	ok, rehash := false, false
+	if found {
+		ok, rehash, err = verifyPassword(hashedPassword, userSalt, password)
+		if err != nil {
+			return Tokens{}, errors.New("appauth.Login: " + err.Error())
+		}
+	} else {
+		verifyUnknownUser(password)
+	}
+
+	if !ok {
+		reason := "wrong password"
+		if !found {
+			reason = "unknown user"
+		}
+		err = recordLoginFailure(authUser, remoteAddr, reason)
+		if err != nil {
+			return Tokens{}, errors.New("appauth.Login: " + err.Error())
+		}
+		return Tokens{}, errors.New("appauth.Login: " + errLoginRefused.Error())
 	}
 
-	hash := hex.EncodeToString(output)
+	// Replace hashes made before the current algorithm and parameters, now the password is known
+	if rehash {
+		newHash, err := hashPassword(password)
+		if err != nil {
+			return Tokens{}, errors.New("appauth.Login: " + err.Error())
+		}
+		err = updatePassword(userID, newHash)
+		if err != nil {
+			return Tokens{}, errors.New("appauth.Login: " + err.Error())
+		}
+	}
 
-	if hash != hashedPassword {
-		return "", errors.New("appauth.CreateToken: Authentication credentials invalid")
+	_, status, _, err := getTOTP(authUser)
+	if err != nil {
+		return Tokens{}, errors.New("appauth.Login: " + err.Error())
+	}
+	// Failures are only forgotten once the second factor passes too, or a
+	// known password would give endless rounds of guesses at the code
+	if status == TOTP_STATUS_ACTIVE {
+		challenge, err := createLoginChallenge(userID, authUser, device)
+		if err != nil {
+			return Tokens{}, errors.New("appauth.Login: " + err.Error())
+		}
+		return Tokens{Challenge: challenge}, nil
 	}
 
-	newUuid := uuid.NewV4()
-	token = newUuid.String()
+	err = clearLoginFailures(authUser)
+	if err != nil {
+		return Tokens{}, errors.New("appauth.Login: " + err.Error())
+	}
 
-	// @TODO Remove all tokens for this user
-	err = Config.Redis.Set(token, userID, TOKEN_TTL).Err()
+	tokens, err = createSession(userID, device)
 	if err != nil {
-		return "", errors.New("appauth.CreateToken: Could not set token. " + err.Error())
+		return Tokens{}, errors.New("appauth.Login: " + err.Error())
 	}
 
 	return
@@ -266,51 +615,67 @@ func CreateToken(authUser string, password string) (token string, err error) {
 
 func RemoveToken(token string) (result string, err error) {
 	//TEST 0~appauth~480e67e3-e2c9-48ee-966c-8d251474b669
-	_, err = Config.Redis.Del(token).Result()
-
-	if err == redis.Nil {
-		return "", errors.New("appauth.RemoveToken: Token not found. " + err.Error())
-	} else if err != nil {
-		return "", errors.New("appauth.RemoveToken: Could not remove token. " + err.Error())
-	} else {
-		result = "Token removed"
+	err = store.Revoke(token)
+	if err != nil {
+		return "", errors.New("appauth.RemoveToken: " + err.Error())
 	}
 
+	result = "Token removed"
 	return
 }
 
+// CheckToken checks a token is valid and extends it. Merchant client tokens are
+// valid too, what they may do is checked by GetUserFromScopedToken.
 func CheckToken(token string) (err error) {
 	//TEST 0~appauth~480e67e3-e2c9-48ee-966c-8d251474b669
-	user, err := Config.Redis.Get(token).Result()
-
-	if err == redis.Nil {
-		return errors.New("appauth.CheckToken: Token not found. " + err.Error())
-	} else if err != nil {
-		return errors.New("appauth.CheckToken: Could not get token. " + err.Error())
-	} else {
-		// Extend token
-		err := Config.Redis.Set(user, token, TOKEN_TTL).Err()
-		if err != nil {
-			return errors.New("appauth.CheckToken: Could not extend token. " + err.Error())
-		}
+	_, err = useToken(token)
+	if err != nil {
+		return errors.New("appauth.CheckToken: " + err.Error())
 	}
 
 	return
 }
 
+// GetUserFromToken gives the user a token was issued to and extends it. Merchant
+// client tokens are refused, they can only be used where a scope allows them.
 func GetUserFromToken(token string) (user string, err error) {
 	//TEST 0~appauth~~181ac0ae-45cb-461d-b740-15ce33e4612f~testPassword
-	user, err = Config.Redis.Get(token).Result()
+	stored, err := useToken(token)
 	if err != nil {
-		return "", errors.New("appauth.GetUserFromToken: Could not get token. " + err.Error())
+		return "", errors.New("appauth.GetUserFromToken: " + err.Error())
+	}
+	if stored.ClientID != "" {
+		return "", errors.New("appauth.GetUserFromToken: Token not valid for this request")
 	}
 
-	// If valid then extend
-	if user != "" {
-		err := Config.Redis.Set(user, token, TOKEN_TTL).Err()
-		if err != nil {
-			return "", errors.New("appauth.GetUserFromToken: Could not extend token. " + err.Error())
-		}
+	return stored.UserID, nil
+}
+
+// GetUserFromScopedToken gives the user a token acts for and extends it. A
+// merchant client token must have the scope, and gives its merchant, whose
+// accounts it is limited to. A user's own token has every scope.
+func GetUserFromScopedToken(token string, scope string) (user string, merchantID string, err error) {
+	stored, err := useToken(token)
+	if err != nil {
+		return "", "", errors.New("appauth.GetUserFromScopedToken: " + err.Error())
+	}
+	if stored.ClientID != "" && !hasScope(stored.Scopes, scope) {
+		return "", "", errors.New("appauth.GetUserFromScopedToken: Token does not have scope " + scope)
+	}
+
+	return stored.UserID, stored.MerchantID, nil
+}
+
+// useToken gives a token if it is valid and extends it
+func useToken(token string) (stored accessToken, err error) {
+	stored, err = store.get(token)
+	if err != nil {
+		return accessToken{}, err
+	}
+
+	err = store.Touch(token)
+	if err != nil {
+		return accessToken{}, err
 	}
 
 	return
@@ -335,14 +700,3 @@ func getUserPasswordSaltFromUID(user string) (hashedPassword string, userSalt st
 
 	return
 }
-
-func CheckBasicAuth(username string, password string) (err error) {
-	confUser := Config.HttpAuthUser
-	confPass := Config.HttpAuthPass
-
-	if (confUser != username) || (confPass != password) {
-		return errors.New("appauth.CheckBasicAuth: Basic Auth incorrect. Access denied.")
-	}
-
-	return
-}
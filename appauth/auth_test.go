@@ -3,6 +3,7 @@ package appauth
 import (
 	"testing"
 
+	"github.com/bvnk/bank/audit"
 	"github.com/bvnk/bank/configuration"
 )
 
@@ -53,7 +54,7 @@ func TestCreateRemoveUserPassword(t *testing.T) {
 		t.Errorf("CreateRemoveUserPassword Create does not pass. Looking for %v, got %v", nil, err)
 	}
 
-	_, err = RemoveUserPassword(user, password)
+	_, err = RemoveUserPassword(user, password, "")
 	if err != nil {
 		t.Errorf("CreateRemoveUserPassword Remove does not pass. Looking for %v, got %v", nil, err)
 	}
@@ -80,7 +81,7 @@ func BenchmarkCreateRemoveUserPassword(b *testing.B) {
 
 	for n := 0; n < b.N; n++ {
 		_, _ = CreateUserPassword(user, password)
-		_, _ = RemoveUserPassword(user, password)
+		_, _ = RemoveUserPassword(user, password, "")
 	}
 }
 
@@ -110,7 +111,7 @@ func TestCreateRemoveCheckToken(t *testing.T) {
 		t.Errorf("CreateRemoveCheckToken Delete does not pass. Looking for %v, got %v", nil, err)
 	}
 
-	_, err = RemoveUserPassword(user, password)
+	_, err = RemoveUserPassword(user, password, "")
 	if err != nil {
 		t.Errorf("CreateRemoveCheckToken Remove does not pass. Looking for %v, got %v", nil, err)
 	}
@@ -130,7 +131,7 @@ func BenchmarkCreateRemoveCheckToken(b *testing.B) {
 		token, _ := CreateToken(user, password)
 		_ = CheckToken(token)
 		_, _ = RemoveToken(token)
-		_, _ = RemoveUserPassword(user, password)
+		_, _ = RemoveUserPassword(user, password, "")
 	}
 }
 
@@ -164,7 +165,7 @@ func TestGetUserFromToken(t *testing.T) {
 		t.Errorf("GetUserFromToken Delete does not pass. Looking for %v, got %v", nil, err)
 	}
 
-	_, err = RemoveUserPassword(user, password)
+	_, err = RemoveUserPassword(user, password, "")
 	if err != nil {
 		t.Errorf("GetUserFromToken Remove does not pass. Looking for %v, got %v", nil, err)
 	}
@@ -183,6 +184,28 @@ func BenchmarkGetUserFromToken(b *testing.B) {
 		token, _ := CreateToken(user, password)
 		_, _ = GetUserFromToken(token)
 		_, _ = RemoveToken(token)
-		_, _ = RemoveUserPassword(user, password)
+		_, _ = RemoveUserPassword(user, password, "")
+	}
+}
+
+func TestRemoveUserPasswordAttempts(t *testing.T) {
+	kv := setTestStore()
+	d, _, restore := setTestUser(t, "password")
+	defer restore()
+
+	if _, err := RemoveUserPassword("user", "wrong", "10.0.0.1"); err == nil {
+		t.Errorf("RemoveUserPasswordAttempts wrong password does not pass. Looking for %v, got %v", "error", err)
+	}
+	if failures, _, _ := kv.Get(userLoginKey(LOGIN_FAILURES_PREFIX, "authuser")); failures != "1" {
+		t.Errorf("RemoveUserPasswordAttempts count does not pass. Looking for %v, got %v", "1", failures)
+	}
+	if details := d.eventDetails(audit.EVENT_LOGIN_FAILED); len(details) != 1 {
+		t.Errorf("RemoveUserPasswordAttempts audit does not pass. Looking for %v, got %v", 1, details)
+	}
+
+	// A locked login cannot be removed, even with the password
+	kv.Set(userLoginKey(LOGIN_LOCK_PREFIX, "authuser"), "1480000000", 0)
+	if _, err := RemoveUserPassword("user", "password", "10.0.0.1"); err == nil {
+		t.Errorf("RemoveUserPasswordAttempts locked does not pass. Looking for %v, got %v", "error", err)
 	}
 }
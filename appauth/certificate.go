@@ -0,0 +1,297 @@
+package appauth
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"database/sql"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/bvnk/bank/audit"
+)
+
+// Who a client certificate can be enrolled as. An institution is a merchant,
+// acting for one of its account holders with scopes as its API keys do.
+const (
+	CERTIFICATE_KIND_INSTITUTION = "institution"
+	CERTIFICATE_KIND_STAFF       = "staff"
+)
+
+const (
+	CERTIFICATE_STATUS_ACTIVE  = "active"
+	CERTIFICATE_STATUS_REVOKED = "revoked"
+
+	// Tokens for a certificate's connection have this and its fingerprint as
+	// their client ID
+	CERTIFICATE_CLIENT_PREFIX = "cert:"
+)
+
+// Certificate is an enrolled client certificate, known by the SHA-256
+// fingerprint of its public key. The subject is kept to show who it is.
+type Certificate struct {
+	Fingerprint string
+	Subject     string
+	Kind        string
+	// The merchant ID or staff username
+	Identity  string
+	Scopes    []string
+	Status    string
+	CreatedBy string
+	Timestamp int32
+}
+
+// storedCertificate is a certificate as kept in client_certificates
+type storedCertificate struct {
+	Certificate
+	UserID string
+}
+
+// CertificateFingerprint is the hex SHA-256 of a certificate's public key, so a
+// renewed certificate for the same key keeps its identity
+func CertificateFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return hex.EncodeToString(sum[:])
+}
+
+// ParseCertificatePEM gives the fingerprint and subject of a PEM certificate,
+// to enrol it with
+func ParseCertificatePEM(data []byte) (fingerprint string, subject string, err error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return "", "", errors.New("appauth.ParseCertificatePEM: No PEM certificate found")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return "", "", errors.New("appauth.ParseCertificatePEM: " + err.Error())
+	}
+	return CertificateFingerprint(cert), cert.Subject.String(), nil
+}
+
+// normaliseFingerprint reads a fingerprint in either case, with or without the
+// colons tools often show them with
+func normaliseFingerprint(fingerprint string) (normalised string, err error) {
+	normalised = strings.ToLower(strings.Replace(strings.TrimSpace(fingerprint), ":", "", -1))
+	decoded, err := hex.DecodeString(normalised)
+	if err != nil || len(decoded) != sha256.Size {
+		return "", errors.New("appauth.normaliseFingerprint: Fingerprint must be a SHA-256 in hex")
+	}
+	return
+}
+
+// EnrolCertificate registers a client certificate as a merchant, with the
+// scopes it may use, or as a staff user
+func EnrolCertificate(fingerprint string, subject string, kind string, identity string, scopes string, createdBy string) (result string, err error) {
+	fingerprint, err = normaliseFingerprint(fingerprint)
+	if err != nil {
+		return "", errors.New("appauth.EnrolCertificate: " + err.Error())
+	}
+
+	stored := storedCertificate{Certificate: Certificate{Fingerprint: fingerprint, Subject: subject, Kind: kind, Identity: identity}}
+	switch kind {
+	case CERTIFICATE_KIND_INSTITUTION:
+		stored.Scopes, err = parseScopes(scopes)
+		if err != nil {
+			return "", errors.New("appauth.EnrolCertificate: " + err.Error())
+		}
+		stored.UserID, err = getMerchantHolder(identity)
+		if err == sql.ErrNoRows {
+			return "", errors.New("appauth.EnrolCertificate: Merchant not found")
+		} else if err != nil {
+			return "", errors.New("appauth.EnrolCertificate: " + err.Error())
+		}
+	case CERTIFICATE_KIND_STAFF:
+		_, _, _, err = getStaffUser(identity)
+		if err == sql.ErrNoRows {
+			return "", errors.New("appauth.EnrolCertificate: Staff user not found")
+		} else if err != nil {
+			return "", errors.New("appauth.EnrolCertificate: " + err.Error())
+		}
+	default:
+		return "", errors.New("appauth.EnrolCertificate: Kind not valid, must be one of institution, staff")
+	}
+
+	// A revoked certificate stays revoked, its key needs a new one
+	_, err = getCertificate(fingerprint)
+	if err == nil {
+		return "", errors.New("appauth.EnrolCertificate: Certificate already enrolled")
+	} else if err != sql.ErrNoRows {
+		return "", errors.New("appauth.EnrolCertificate: " + err.Error())
+	}
+
+	err = saveCertificate(stored, createdBy)
+	if err != nil {
+		return "", errors.New("appauth.EnrolCertificate: " + err.Error())
+	}
+	err = audit.Record(audit.Event{Type: audit.EVENT_CERTIFICATE_ENROLLED, Actor: createdBy, Subject: fingerprint, Detail: kind + " " + identity})
+	if err != nil {
+		return "", errors.New("appauth.EnrolCertificate: " + err.Error())
+	}
+	return "Certificate enrolled", nil
+}
+
+// RevokeCertificate stops a client certificate connecting at all
+func RevokeCertificate(fingerprint string, revokedBy string) (result string, err error) {
+	fingerprint, err = normaliseFingerprint(fingerprint)
+	if err != nil {
+		return "", errors.New("appauth.RevokeCertificate: " + err.Error())
+	}
+
+	stored, err := getCertificate(fingerprint)
+	if err == sql.ErrNoRows {
+		return "", errors.New("appauth.RevokeCertificate: Certificate not found")
+	} else if err != nil {
+		return "", errors.New("appauth.RevokeCertificate: " + err.Error())
+	}
+	if stored.Status != CERTIFICATE_STATUS_ACTIVE {
+		return "", errors.New("appauth.RevokeCertificate: Certificate is " + stored.Status)
+	}
+
+	err = updateCertificateStatus(fingerprint, CERTIFICATE_STATUS_REVOKED)
+	if err != nil {
+		return "", errors.New("appauth.RevokeCertificate: " + err.Error())
+	}
+	err = audit.Record(audit.Event{Type: audit.EVENT_CERTIFICATE_REVOKED, Actor: revokedBy, Subject: fingerprint, Detail: stored.Kind + " " + stored.Identity})
+	if err != nil {
+		return "", errors.New("appauth.RevokeCertificate: " + err.Error())
+	}
+	return "Certificate revoked", nil
+}
+
+// ListCertificates gives every enrolled client certificate
+func ListCertificates() (certificates []Certificate, err error) {
+	certificates, err = getCertificates()
+	if err != nil {
+		return nil, errors.New("appauth.ListCertificates: " + err.Error())
+	}
+	return
+}
+
+// CertificateToken gives a token for a connection made with a verified client
+// certificate, acting for whoever it is enrolled as. A certificate that is not
+// enrolled gives no token, and the connection is left to the token it sends.
+// The token is for the one connection and should be removed after it.
+func CertificateToken(cert *x509.Certificate) (token string, err error) {
+	stored, err := getCertificate(CertificateFingerprint(cert))
+	if err == sql.ErrNoRows {
+		return "", nil
+	} else if err != nil {
+		return "", errors.New("appauth.CertificateToken: " + err.Error())
+	}
+	if stored.Status != CERTIFICATE_STATUS_ACTIVE {
+		return "", errors.New("appauth.CertificateToken: Certificate is " + stored.Status)
+	}
+
+	issued := accessToken{Issued: store.now().Unix(), ClientID: CERTIFICATE_CLIENT_PREFIX + stored.Fingerprint}
+	switch stored.Kind {
+	case CERTIFICATE_KIND_INSTITUTION:
+		issued.UserID = stored.UserID
+		issued.MerchantID = stored.Identity
+		issued.Scopes = stored.Scopes
+	case CERTIFICATE_KIND_STAFF:
+		issued.Staff = stored.Identity
+	}
+
+	token, err = store.issue(issued, store.IdleTimeout)
+	if err != nil {
+		return "", errors.New("appauth.CertificateToken: " + err.Error())
+	}
+	return
+}
+
+// tokenStaff gives the staff user a token is for, if it is one, and the role
+// it was given with. A certificate's token has no role, it is looked up
+// when the token is used.
+func tokenStaff(token string) (username string, role string) {
+	if token == "" || token == "0" || store == nil {
+		return "", ""
+	}
+	stored, err := store.get(token)
+	if err != nil {
+		return "", ""
+	}
+	return stored.Staff, stored.StaffRole
+}
+
+// certificateStaffRole gives the role of a staff user enrolled with a
+// certificate, which they need no password for
+func certificateStaffRole(username string) (role string, err error) {
+	_, role, status, err := getStaffUser(username)
+	if err == sql.ErrNoRows {
+		return "", errStaffDenied
+	} else if err != nil {
+		return "", err
+	}
+	if status != STAFF_STATUS_ACTIVE {
+		return "", errStaffDenied
+	}
+	return role, nil
+}
+
+// getMerchantHolder gives the first holder of a merchant's accounts, who its
+// certificate acts for. sql.ErrNoRows is given unwrapped if there is none.
+func getMerchantHolder(merchantID string) (userID string, err error) {
+	err = Config.Db.QueryRow("SELECT `accountHolderIdentificationNumber` FROM `merchant_users_accounts` WHERE `merchantID` = ? ORDER BY `id` LIMIT 1", merchantID).Scan(&userID)
+	switch {
+	case err == sql.ErrNoRows:
+		return "", err
+	case err != nil:
+		return "", errors.New("appauth.getMerchantHolder: " + err.Error())
+	}
+	return
+}
+
+// getCertificate gives sql.ErrNoRows unwrapped if the certificate is not enrolled
+func getCertificate(fingerprint string) (stored storedCertificate, err error) {
+	scopes := ""
+	err = Config.Db.QueryRow("SELECT `fingerprint`, `subject`, `kind`, `identity`, `accountHolderIdentificationNumber`, `scopes`, `status`, `createdBy`, `timestamp` FROM `client_certificates` WHERE `fingerprint` = ?", fingerprint).Scan(
+		&stored.Fingerprint, &stored.Subject, &stored.Kind, &stored.Identity, &stored.UserID, &scopes, &stored.Status, &stored.CreatedBy, &stored.Timestamp)
+	switch {
+	case err == sql.ErrNoRows:
+		return storedCertificate{}, err
+	case err != nil:
+		return storedCertificate{}, errors.New("appauth.getCertificate: " + err.Error())
+	}
+	stored.Scopes = strings.Fields(scopes)
+	return
+}
+
+func getCertificates() (certificates []Certificate, err error) {
+	rows, err := Config.Db.Query("SELECT `fingerprint`, `subject`, `kind`, `identity`, `scopes`, `status`, `createdBy`, `timestamp` FROM `client_certificates` ORDER BY `timestamp`")
+	if err != nil {
+		return nil, errors.New("appauth.getCertificates: " + err.Error())
+	}
+	defer rows.Close()
+
+	certificates = []Certificate{}
+	for rows.Next() {
+		c := Certificate{}
+		scopes := ""
+		err = rows.Scan(&c.Fingerprint, &c.Subject, &c.Kind, &c.Identity, &scopes, &c.Status, &c.CreatedBy, &c.Timestamp)
+		if err != nil {
+			return nil, errors.New("appauth.getCertificates: " + err.Error())
+		}
+		c.Scopes = strings.Fields(scopes)
+		certificates = append(certificates, c)
+	}
+	return
+}
+
+func saveCertificate(stored storedCertificate, createdBy string) (err error) {
+	_, err = Config.Db.Exec("INSERT INTO `client_certificates` (`fingerprint`, `subject`, `kind`, `identity`, `accountHolderIdentificationNumber`, `scopes`, `status`, `createdBy`, `timestamp`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		stored.Fingerprint, stored.Subject, stored.Kind, stored.Identity, stored.UserID, strings.Join(stored.Scopes, " "), CERTIFICATE_STATUS_ACTIVE, createdBy, time.Now().Unix())
+	if err != nil {
+		return errors.New("appauth.saveCertificate: " + err.Error())
+	}
+	return
+}
+
+func updateCertificateStatus(fingerprint string, status string) (err error) {
+	_, err = Config.Db.Exec("UPDATE `client_certificates` SET `status` = ? WHERE `fingerprint` = ?", status, fingerprint)
+	if err != nil {
+		return errors.New("appauth.updateCertificateStatus: " + err.Error())
+	}
+	return
+}
@@ -0,0 +1,100 @@
+package appauth
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testCertificate(t *testing.T, commonName string, seed byte) *x509.Certificate {
+	private := ed25519.NewKeyFromSeed([]byte(strings.Repeat(string([]byte{seed}), ed25519.SeedSize)))
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Unix(1480000000, 0),
+		NotAfter:     time.Unix(1480000000, 0).Add(365 * 24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(nil, template, template, private.Public(), private)
+	if err != nil {
+		t.Fatalf("Could not create certificate. %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Could not parse certificate. %v", err)
+	}
+	return cert
+}
+
+func TestCertificateFingerprint(t *testing.T) {
+	first := CertificateFingerprint(testCertificate(t, "first", 1))
+	if len(first) != 64 {
+		t.Errorf("CertificateFingerprint does not pass. Looking for %v, got %v", "64 hex characters", first)
+	}
+
+	// The same key keeps its fingerprint with another subject, another key does not
+	if renewed := CertificateFingerprint(testCertificate(t, "renewed", 1)); renewed != first {
+		t.Errorf("CertificateFingerprint renewed does not pass. Looking for %v, got %v", first, renewed)
+	}
+	if other := CertificateFingerprint(testCertificate(t, "first", 2)); other == first {
+		t.Errorf("CertificateFingerprint other key does not pass. Looking for %v, got %v", "another fingerprint", other)
+	}
+}
+
+func TestParseCertificatePEM(t *testing.T) {
+	cert := testCertificate(t, "merchant", 1)
+	fingerprint, subject, err := ParseCertificatePEM(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}))
+	if err != nil || fingerprint != CertificateFingerprint(cert) || subject != "CN=merchant" {
+		t.Errorf("ParseCertificatePEM does not pass. Looking for %v %v, got %v %v %v", CertificateFingerprint(cert), "CN=merchant", fingerprint, subject, err)
+	}
+
+	for _, invalid := range []string{"", "not a certificate", string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: cert.Raw}))} {
+		if _, _, err := ParseCertificatePEM([]byte(invalid)); err == nil {
+			t.Errorf("ParseCertificatePEM %q does not pass. Looking for %v, got %v", invalid, "error", err)
+		}
+	}
+}
+
+func TestNormaliseFingerprint(t *testing.T) {
+	fingerprint := strings.Repeat("ab", 32)
+	for _, given := range []string{fingerprint, strings.ToUpper(fingerprint), strings.TrimSuffix(strings.Repeat("AB:", 32), ":")} {
+		if normalised, err := normaliseFingerprint(given); err != nil || normalised != fingerprint {
+			t.Errorf("NormaliseFingerprint %v does not pass. Looking for %v, got %v %v", given, fingerprint, normalised, err)
+		}
+	}
+
+	for _, invalid := range []string{"", "abcd", strings.Repeat("zz", 32)} {
+		if _, err := normaliseFingerprint(invalid); err == nil {
+			t.Errorf("NormaliseFingerprint %q does not pass. Looking for %v, got %v", invalid, "error", err)
+		}
+	}
+}
+
+func TestCertificateStaffToken(t *testing.T) {
+	setTestStore()
+
+	token, _ := store.issue(accessToken{Issued: store.now().Unix(), ClientID: CERTIFICATE_CLIENT_PREFIX + "fingerprint", Staff: "staff"}, store.IdleTimeout)
+	if staff, role := tokenStaff(token); staff != "staff" || role != "" {
+		t.Errorf("CertificateStaffToken does not pass. Looking for %v, got %v", "staff", staff)
+	}
+
+	// It acts for no user
+	if _, err := GetUserFromToken(token); err == nil {
+		t.Errorf("CertificateStaffToken GetUserFromToken does not pass. Looking for %v, got %v", "error", err)
+	}
+	if _, _, err := GetUserFromScopedToken(token, SCOPE_PAYMENTS_READ); err == nil {
+		t.Errorf("CertificateStaffToken scope does not pass. Looking for %v, got %v", "error", err)
+	}
+
+	// A user's own token is not a staff user's
+	userToken, _ := store.Issue("user", "session")
+	for _, other := range []string{userToken, "0", ""} {
+		if staff, _ := tokenStaff(other); staff != "" {
+			t.Errorf("CertificateStaffToken %q does not pass. Looking for %v, got %v", other, "", staff)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package appauth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	legacyargon2 "github.com/pzduniak/argon2"
+	"golang.org/x/crypto/argon2"
+)
+
+// Argon2id parameters for new hashes. They are kept in each hash, so raising
+// them only changes hashes made from now on, and older ones as users log in.
+const (
+	ARGON2_TIME       = 3
+	ARGON2_MEMORY     = 64 * 1024 // KiB
+	ARGON2_THREADS    = 4
+	ARGON2_KEY_LENGTH = 32
+	ARGON2_SALT_SIZE  = 16
+
+	ARGON2_PHC_PREFIX = "$argon2id$"
+)
+
+// argon2Params are the parameters a hash was made with
+type argon2Params struct {
+	Memory  uint32
+	Time    uint32
+	Threads uint8
+}
+
+var currentArgon2Params = argon2Params{Memory: ARGON2_MEMORY, Time: ARGON2_TIME, Threads: ARGON2_THREADS}
+
+// A hash checked against for users that do not exist, so they take as long as a wrong password
+var unknownUserHash string
+var unknownUserHashOnce sync.Once
+
+// hashPassword gives a new password's hash as a PHC string:
+// $argon2id$v=19$m=65536,t=3,p=4$salt$hash, both in unpadded base64
+func hashPassword(clearTextPassword string) (hash string, err error) {
+	salt := make([]byte, ARGON2_SALT_SIZE)
+	_, err = rand.Read(salt)
+	if err != nil {
+		return "", errors.New("appauth.hashPassword: Could not generate salt. " + err.Error())
+	}
+	return encodeArgon2Hash(currentArgon2Params, salt, clearTextPassword), nil
+}
+
+func encodeArgon2Hash(params argon2Params, salt []byte, clearTextPassword string) string {
+	key := argon2.IDKey([]byte(clearTextPassword), salt, params.Time, params.Memory, params.Threads, ARGON2_KEY_LENGTH)
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", ARGON2_PHC_PREFIX, argon2.Version, params.Memory, params.Time, params.Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+}
+
+func decodeArgon2Hash(hash string) (params argon2Params, salt []byte, key []byte, err error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return argon2Params{}, nil, nil, errors.New("appauth.decodeArgon2Hash: Not an Argon2id hash")
+	}
+
+	var version int
+	_, err = fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return argon2Params{}, nil, nil, errors.New("appauth.decodeArgon2Hash: Unsupported Argon2 version")
+	}
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Threads)
+	if err != nil {
+		return argon2Params{}, nil, nil, errors.New("appauth.decodeArgon2Hash: Could not read parameters. " + err.Error())
+	}
+
+	salt, err = base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return argon2Params{}, nil, nil, errors.New("appauth.decodeArgon2Hash: Could not decode salt. " + err.Error())
+	}
+	key, err = base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return argon2Params{}, nil, nil, errors.New("appauth.decodeArgon2Hash: Could not decode hash. " + err.Error())
+	}
+	return
+}
+
+// verifyPassword checks a password against a user's stored hash. Hashes from
+// before PHC strings were kept, with the user's salt in its own column, are
+// still checked. rehash is set when the password matched a hash that is not
+// Argon2id with the current parameters, which should then be replaced.
+func verifyPassword(storedHash string, legacySalt string, clearTextPassword string) (ok bool, rehash bool, err error) {
+	if !strings.HasPrefix(storedHash, ARGON2_PHC_PREFIX) {
+		ok, err = verifyLegacyPassword(storedHash, legacySalt, clearTextPassword)
+		if err != nil {
+			return false, false, errors.New("appauth.verifyPassword: " + err.Error())
+		}
+		return ok, ok, nil
+	}
+
+	params, salt, key, err := decodeArgon2Hash(storedHash)
+	if err != nil {
+		return false, false, errors.New("appauth.verifyPassword: " + err.Error())
+	}
+	other := argon2.IDKey([]byte(clearTextPassword), salt, params.Time, params.Memory, params.Threads, uint32(len(key)))
+	ok = subtle.ConstantTimeCompare(key, other) == 1
+	return ok, ok && (params != currentArgon2Params || len(key) != ARGON2_KEY_LENGTH), nil
+}
+
+// verifyLegacyPassword checks the Argon2i hashes of the user's salt and the
+// password, keyed with Config.PasswordSalt, that were used before
+func verifyLegacyPassword(storedHash string, legacySalt string, clearTextPassword string) (ok bool, err error) {
+	output, err := legacyargon2.Key([]byte(legacySalt+clearTextPassword), []byte(Config.PasswordSalt), 3, 4, 4096, 64, legacyargon2.Argon2i)
+	if err != nil {
+		return false, errors.New("appauth.verifyLegacyPassword: Could not generate secure hash. " + err.Error())
+	}
+	return subtle.ConstantTimeCompare([]byte(hex.EncodeToString(output)), []byte(storedHash)) == 1, nil
+}
+
+// verifyUnknownUser takes as long as checking a password, and always fails
+func verifyUnknownUser(clearTextPassword string) {
+	unknownUserHashOnce.Do(func() {
+		unknownUserHash, _ = hashPassword(RandStringBytes(32))
+	})
+	_, _, _ = verifyPassword(unknownUserHash, "", clearTextPassword)
+}
@@ -0,0 +1,66 @@
+package appauth
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	legacyargon2 "github.com/pzduniak/argon2"
+)
+
+func TestHashPassword(t *testing.T) {
+	hash, err := hashPassword("test-password")
+	if err != nil {
+		t.Fatalf("HashPassword does not pass. Looking for %v, got %v", nil, err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=65536,t=3,p=4$") {
+		t.Errorf("HashPassword format does not pass. Looking for %v, got %v", "PHC string", hash)
+	}
+
+	ok, rehash, err := verifyPassword(hash, "", "test-password")
+	if err != nil || !ok || rehash {
+		t.Errorf("HashPassword verify does not pass. Looking for %v %v, got %v %v %v", true, false, ok, rehash, err)
+	}
+	if ok, _, _ := verifyPassword(hash, "", "wrong-password"); ok {
+		t.Errorf("HashPassword wrong password does not pass. Looking for %v, got %v", false, ok)
+	}
+
+	// Salted, the same password hashes differently
+	if other, _ := hashPassword("test-password"); other == hash {
+		t.Errorf("HashPassword salt does not pass. Looking for %v, got %v", "different hashes", other)
+	}
+}
+
+func TestVerifyPasswordRehash(t *testing.T) {
+	// Made with lower parameters than now
+	hash := encodeArgon2Hash(argon2Params{Memory: 4096, Time: 1, Threads: 1}, []byte("0123456789abcdef"), "test-password")
+
+	ok, rehash, err := verifyPassword(hash, "", "test-password")
+	if err != nil || !ok || !rehash {
+		t.Errorf("VerifyPasswordRehash does not pass. Looking for %v %v, got %v %v %v", true, true, ok, rehash, err)
+	}
+	if _, rehash, _ := verifyPassword(hash, "", "wrong-password"); rehash {
+		t.Errorf("VerifyPasswordRehash wrong password does not pass. Looking for %v, got %v", false, rehash)
+	}
+
+	if _, _, err := verifyPassword("$argon2id$v=19$m=x$salt$hash", "", "test-password"); err == nil {
+		t.Errorf("VerifyPasswordRehash malformed does not pass. Looking for %v, got %v", "error", err)
+	}
+}
+
+func TestVerifyLegacyPassword(t *testing.T) {
+	salt := strings.Repeat("ab", 64)
+	output, err := legacyargon2.Key([]byte(salt+"test-password"), []byte(Config.PasswordSalt), 3, 4, 4096, 64, legacyargon2.Argon2i)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := hex.EncodeToString(output)
+
+	ok, rehash, err := verifyPassword(hash, salt, "test-password")
+	if err != nil || !ok || !rehash {
+		t.Errorf("VerifyLegacyPassword does not pass. Looking for %v %v, got %v %v %v", true, true, ok, rehash, err)
+	}
+	if ok, _, _ := verifyPassword(hash, salt, "wrong-password"); ok {
+		t.Errorf("VerifyLegacyPassword wrong password does not pass. Looking for %v, got %v", false, ok)
+	}
+}
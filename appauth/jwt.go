@@ -0,0 +1,207 @@
+package appauth
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/bvnk/bank/configuration"
+)
+
+const (
+	TOKEN_MODE_OPAQUE = "opaque"
+	TOKEN_MODE_JWT    = "jwt"
+
+	JWT_ALGORITHM_HS256 = "HS256"
+	JWT_ALGORITHM_EDDSA = "EdDSA"
+
+	// Shorter HS256 secrets are refused
+	JWT_MIN_SECRET_SIZE = 32
+
+	// Revoked JWT access tokens, by their ID, until they would have expired
+	JWT_DENYLIST_PREFIX = "jwtdenylist:"
+)
+
+type jwtHeader struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ"`
+	KeyID     string `json:"kid"`
+}
+
+// jwtClaims are what an access token carries, with the names registered for
+// them where there is one
+type jwtClaims struct {
+	Subject    string `json:"sub"`
+	ID         string `json:"jti"`
+	IssuedAt   int64  `json:"iat"`
+	Expires    int64  `json:"exp"`
+	SessionID  string `json:"sid,omitempty"`
+	ClientID   string `json:"client_id,omitempty"`
+	MerchantID string `json:"merchant_id,omitempty"`
+	Scope      string `json:"scope,omitempty"`
+	Staff      string `json:"staff,omitempty"`
+	StaffRole  string `json:"staff_role,omitempty"`
+}
+
+type jwtKey struct {
+	algorithm  string
+	secret     []byte
+	privateKey ed25519.PrivateKey
+	publicKey  ed25519.PublicKey
+}
+
+// jwtSigner signs access tokens with one key and checks them with any it has,
+// so keys can be rotated without logging everyone out
+type jwtSigner struct {
+	signingKeyID string
+	keys         map[string]jwtKey
+}
+
+func newJWTSigner(config configuration.Auth) (signer *jwtSigner, err error) {
+	signer = &jwtSigner{signingKeyID: config.JWTSigningKeyID, keys: map[string]jwtKey{}}
+	for _, k := range config.JWTKeys {
+		if k.ID == "" {
+			return nil, errors.New("appauth.newJWTSigner: Key ID is required")
+		}
+		if _, ok := signer.keys[k.ID]; ok {
+			return nil, errors.New("appauth.newJWTSigner: Key " + k.ID + " given twice")
+		}
+		key, err := newJWTKey(k)
+		if err != nil {
+			return nil, errors.New("appauth.newJWTSigner: Key " + k.ID + " not valid. " + err.Error())
+		}
+		signer.keys[k.ID] = key
+	}
+
+	key, ok := signer.keys[signer.signingKeyID]
+	if !ok {
+		return nil, errors.New("appauth.newJWTSigner: Signing key not found in keys")
+	}
+	if key.algorithm == JWT_ALGORITHM_EDDSA && key.privateKey == nil {
+		return nil, errors.New("appauth.newJWTSigner: Signing key has no private key")
+	}
+	return
+}
+
+func newJWTKey(config configuration.JWTKey) (key jwtKey, err error) {
+	key.algorithm = config.Algorithm
+	switch config.Algorithm {
+	case JWT_ALGORITHM_HS256:
+		key.secret, err = base64.StdEncoding.DecodeString(config.Secret)
+		if err != nil {
+			return jwtKey{}, errors.New("Could not decode secret. " + err.Error())
+		}
+		if len(key.secret) < JWT_MIN_SECRET_SIZE {
+			return jwtKey{}, errors.New("Secret must be at least 32 bytes")
+		}
+	case JWT_ALGORITHM_EDDSA:
+		if config.PrivateKey != "" {
+			private, err := base64.StdEncoding.DecodeString(config.PrivateKey)
+			if err != nil {
+				return jwtKey{}, errors.New("Could not decode private key. " + err.Error())
+			}
+			// Either the seed or the seed and public key
+			switch len(private) {
+			case ed25519.SeedSize:
+				key.privateKey = ed25519.NewKeyFromSeed(private)
+			case ed25519.PrivateKeySize:
+				key.privateKey = ed25519.PrivateKey(private)
+			default:
+				return jwtKey{}, errors.New("Private key is not an Ed25519 key")
+			}
+			key.publicKey = key.privateKey.Public().(ed25519.PublicKey)
+		}
+		if config.PublicKey != "" {
+			public, err := base64.StdEncoding.DecodeString(config.PublicKey)
+			if err != nil {
+				return jwtKey{}, errors.New("Could not decode public key. " + err.Error())
+			}
+			if len(public) != ed25519.PublicKeySize {
+				return jwtKey{}, errors.New("Public key is not an Ed25519 key")
+			}
+			if key.publicKey != nil && subtle.ConstantTimeCompare(public, key.publicKey) != 1 {
+				return jwtKey{}, errors.New("Public key does not match private key")
+			}
+			key.publicKey = ed25519.PublicKey(public)
+		}
+		if key.publicKey == nil {
+			return jwtKey{}, errors.New("Private or public key is required")
+		}
+	default:
+		return jwtKey{}, errors.New("Algorithm not valid, must be one of HS256, EdDSA")
+	}
+	return
+}
+
+func (k jwtKey) sign(input string) []byte {
+	if k.algorithm == JWT_ALGORITHM_EDDSA {
+		return ed25519.Sign(k.privateKey, []byte(input))
+	}
+	mac := hmac.New(sha256.New, k.secret)
+	mac.Write([]byte(input))
+	return mac.Sum(nil)
+}
+
+func (k jwtKey) verify(input string, signature []byte) bool {
+	if k.algorithm == JWT_ALGORITHM_EDDSA {
+		return ed25519.Verify(k.publicKey, []byte(input), signature)
+	}
+	return hmac.Equal(k.sign(input), signature)
+}
+
+// sign gives the claims as a JWT signed with the signing key
+func (j *jwtSigner) sign(claims jwtClaims) (token string, err error) {
+	key := j.keys[j.signingKeyID]
+	header, err := json.Marshal(jwtHeader{Algorithm: key.algorithm, Type: "JWT", KeyID: j.signingKeyID})
+	if err != nil {
+		return "", errors.New("appauth.jwtSigner.sign: Could not encode header. " + err.Error())
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", errors.New("appauth.jwtSigner.sign: Could not encode claims. " + err.Error())
+	}
+
+	input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return input + "." + base64.RawURLEncoding.EncodeToString(key.sign(input)), nil
+}
+
+// verify gives a JWT's claims if one of the keys signed it. The algorithm must
+// be the key's, so a token cannot choose how it is checked. Expiry is for the
+// caller to check.
+func (j *jwtSigner) verify(token string) (claims jwtClaims, err error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return jwtClaims{}, errors.New("Token not valid")
+	}
+
+	header := jwtHeader{}
+	decoded, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(decoded, &header) != nil {
+		return jwtClaims{}, errors.New("Token not valid")
+	}
+	key, ok := j.keys[header.KeyID]
+	if !ok || header.Algorithm != key.algorithm {
+		return jwtClaims{}, errors.New("Token not valid")
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !key.verify(parts[0]+"."+parts[1], signature) {
+		return jwtClaims{}, errors.New("Token not valid")
+	}
+
+	decoded, err = base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || json.Unmarshal(decoded, &claims) != nil {
+		return jwtClaims{}, errors.New("Token not valid")
+	}
+	return
+}
+
+// isJWT tells a JWT from an opaque token, which has no dots
+func isJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
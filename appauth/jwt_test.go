@@ -0,0 +1,165 @@
+package appauth
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bvnk/bank/configuration"
+)
+
+var testHS256Key = configuration.JWTKey{ID: "hs", Algorithm: JWT_ALGORITHM_HS256, Secret: base64.StdEncoding.EncodeToString([]byte(strings.Repeat("s", 32)))}
+
+func testEdDSAKey(id string, seed byte) configuration.JWTKey {
+	private := ed25519.NewKeyFromSeed([]byte(strings.Repeat(string([]byte{seed}), ed25519.SeedSize)))
+	return configuration.JWTKey{ID: id, Algorithm: JWT_ALGORITHM_EDDSA, PrivateKey: base64.StdEncoding.EncodeToString(private.Seed())}
+}
+
+// setTestJWTStore is setTestStore issuing JWTs signed with signingKeyID
+func setTestJWTStore(signingKeyID string, keys ...configuration.JWTKey) *memoryKeyValue {
+	kv := newMemoryKeyValue()
+	store = NewTokenStore(kv, configuration.Auth{IdleTimeoutMinutes: 15, AbsoluteTimeoutMinutes: 60, TokenMode: TOKEN_MODE_JWT, JWTSigningKeyID: signingKeyID, JWTKeys: keys})
+	store.now = func() time.Time { return kv.now }
+	return kv
+}
+
+func TestNewJWTSigner(t *testing.T) {
+	if _, err := newJWTSigner(configuration.Auth{JWTSigningKeyID: "hs", JWTKeys: []configuration.JWTKey{testHS256Key}}); err != nil {
+		t.Errorf("NewJWTSigner does not pass. Looking for %v, got %v", nil, err)
+	}
+
+	public := configuration.JWTKey{ID: "ed", Algorithm: JWT_ALGORITHM_EDDSA, PublicKey: base64.StdEncoding.EncodeToString(make([]byte, ed25519.PublicKeySize))}
+	tests := []configuration.Auth{
+		{JWTSigningKeyID: "missing", JWTKeys: []configuration.JWTKey{testHS256Key}},
+		{JWTSigningKeyID: "hs", JWTKeys: []configuration.JWTKey{testHS256Key, testHS256Key}},
+		{JWTSigningKeyID: "short", JWTKeys: []configuration.JWTKey{{ID: "short", Algorithm: JWT_ALGORITHM_HS256, Secret: base64.StdEncoding.EncodeToString([]byte("short"))}}},
+		{JWTSigningKeyID: "none", JWTKeys: []configuration.JWTKey{{ID: "none", Algorithm: "none"}}},
+		// A public key can check tokens but not sign them
+		{JWTSigningKeyID: "ed", JWTKeys: []configuration.JWTKey{public}},
+	}
+	for i, test := range tests {
+		if _, err := newJWTSigner(test); err == nil {
+			t.Errorf("NewJWTSigner %v does not pass. Looking for %v, got %v", i, "error", err)
+		}
+	}
+
+	s := NewTokenStore(newMemoryKeyValue(), configuration.Auth{TokenMode: "signed"})
+	if _, err := s.Issue("user", "session"); err == nil {
+		t.Errorf("NewJWTSigner token mode does not pass. Looking for %v, got %v", "error", err)
+	}
+}
+
+func TestJWTSignVerify(t *testing.T) {
+	for _, key := range []configuration.JWTKey{testHS256Key, testEdDSAKey("ed", 1)} {
+		signer, err := newJWTSigner(configuration.Auth{JWTSigningKeyID: key.ID, JWTKeys: []configuration.JWTKey{key}})
+		if err != nil {
+			t.Fatalf("JWTSignVerify %v does not pass. Looking for %v, got %v", key.Algorithm, nil, err)
+		}
+
+		token, err := signer.sign(jwtClaims{Subject: "user", ID: "id", Expires: 1480000900})
+		if err != nil {
+			t.Fatalf("JWTSignVerify %v sign does not pass. Looking for %v, got %v", key.Algorithm, nil, err)
+		}
+		claims, err := signer.verify(token)
+		if err != nil || claims.Subject != "user" {
+			t.Errorf("JWTSignVerify %v does not pass. Looking for %v, got %v %v", key.Algorithm, "user", claims.Subject, err)
+		}
+
+		// Changing the claims breaks the signature
+		parts := strings.Split(token, ".")
+		parts[1] = base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"other","jti":"id","exp":1480000900}`))
+		if _, err := signer.verify(strings.Join(parts, ".")); err == nil {
+			t.Errorf("JWTSignVerify %v tampered does not pass. Looking for %v, got %v", key.Algorithm, "error", err)
+		}
+
+		// The header cannot choose another algorithm
+		parts = strings.Split(token, ".")
+		parts[0] = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT","kid":"` + key.ID + `"}`))
+		if _, err := signer.verify(parts[0] + "." + parts[1] + "."); err == nil {
+			t.Errorf("JWTSignVerify %v alg none does not pass. Looking for %v, got %v", key.Algorithm, "error", err)
+		}
+	}
+}
+
+func TestJWTTokens(t *testing.T) {
+	kv := setTestJWTStore("ed", testEdDSAKey("ed", 1))
+
+	token, err := store.Issue("user", "session")
+	if err != nil || !isJWT(token) {
+		t.Fatalf("JWTTokens Issue does not pass. Looking for %v, got %v %v", "JWT", token, err)
+	}
+	if _, found, _ := kv.Get(tokenKey(token)); found {
+		t.Errorf("JWTTokens stored does not pass. Looking for %v, got %v", "no key", "key")
+	}
+
+	user, err := GetUserFromToken(token)
+	if err != nil || user != "user" {
+		t.Errorf("JWTTokens does not pass. Looking for %v, got %v %v", "user", user, err)
+	}
+
+	// Not extended by use
+	kv.now = kv.now.Add(10 * time.Minute)
+	CheckToken(token)
+	kv.now = kv.now.Add(5 * time.Minute)
+	if err := CheckToken(token); err == nil {
+		t.Errorf("JWTTokens expired does not pass. Looking for %v, got %v", "error", err)
+	}
+}
+
+func TestJWTRevoke(t *testing.T) {
+	kv := setTestJWTStore("hs", testHS256Key)
+
+	token, _ := store.Issue("user", "session")
+	if _, err := RemoveToken(token); err != nil {
+		t.Fatalf("JWTRevoke does not pass. Looking for %v, got %v", nil, err)
+	}
+	if err := CheckToken(token); err == nil {
+		t.Errorf("JWTRevoke check does not pass. Looking for %v, got %v", "error", err)
+	}
+
+	// The denylist only keeps it until it would have expired
+	claims, _ := store.jwt.verify(token)
+	if expires := kv.expires[JWT_DENYLIST_PREFIX+claims.ID]; !expires.Equal(kv.now.Add(15 * time.Minute)) {
+		t.Errorf("JWTRevoke denylist does not pass. Looking for %v, got %v", "expiry at 15 minutes", expires)
+	}
+}
+
+func TestJWTKeyRotation(t *testing.T) {
+	kv := setTestJWTStore("old", testEdDSAKey("old", 1))
+	token, _ := store.Issue("user", "session")
+
+	// Signing with a new key, tokens from the old one still work while it is kept
+	store = NewTokenStore(kv, configuration.Auth{IdleTimeoutMinutes: 15, TokenMode: TOKEN_MODE_JWT, JWTSigningKeyID: "new", JWTKeys: []configuration.JWTKey{testEdDSAKey("new", 2), testEdDSAKey("old", 1)}})
+	store.now = func() time.Time { return kv.now }
+	if err := CheckToken(token); err != nil {
+		t.Errorf("JWTKeyRotation old key does not pass. Looking for %v, got %v", nil, err)
+	}
+	newToken, _ := store.Issue("user", "session")
+	header, _ := base64.RawURLEncoding.DecodeString(strings.Split(newToken, ".")[0])
+	if !strings.Contains(string(header), `"kid":"new"`) {
+		t.Errorf("JWTKeyRotation new key does not pass. Looking for %v, got %v", "kid new", string(header))
+	}
+
+	store = NewTokenStore(kv, configuration.Auth{IdleTimeoutMinutes: 15, TokenMode: TOKEN_MODE_JWT, JWTSigningKeyID: "new", JWTKeys: []configuration.JWTKey{testEdDSAKey("new", 2)}})
+	store.now = func() time.Time { return kv.now }
+	if err := CheckToken(token); err == nil {
+		t.Errorf("JWTKeyRotation removed key does not pass. Looking for %v, got %v", "error", err)
+	}
+	if err := CheckToken(newToken); err != nil {
+		t.Errorf("JWTKeyRotation new token does not pass. Looking for %v, got %v", nil, err)
+	}
+}
+
+func TestJWTModeOpaqueTokens(t *testing.T) {
+	kv := setTestStore()
+	token, _ := store.Issue("user", "session")
+
+	// Opaque tokens from before the mode changed keep working
+	store = NewTokenStore(kv, configuration.Auth{IdleTimeoutMinutes: 15, AbsoluteTimeoutMinutes: 60, TokenMode: TOKEN_MODE_JWT, JWTSigningKeyID: "hs", JWTKeys: []configuration.JWTKey{testHS256Key}})
+	store.now = func() time.Time { return kv.now }
+	if err := CheckToken(token); err != nil {
+		t.Errorf("JWTModeOpaqueTokens does not pass. Looking for %v, got %v", nil, err)
+	}
+}
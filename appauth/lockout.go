@@ -0,0 +1,159 @@
+package appauth
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/bvnk/bank/audit"
+)
+
+const (
+	// Failed logins are forgotten this long after the last one, or on logging in
+	LOGIN_FAILURE_WINDOW = 24 * time.Hour
+	// Failures allowed before each further attempt must wait, doubling each time
+	LOGIN_BACKOFF_AFTER_USER = 3
+	LOGIN_BACKOFF_AFTER_IP   = 10
+	LOGIN_BACKOFF_BASE       = time.Second
+	LOGIN_BACKOFF_MAX        = 15 * time.Minute
+	// Failures for a user before logging in is locked until unlocked by staff,
+	// if Config.Auth.LockoutAttempts is not set
+	DEFAULT_LOCKOUT_ATTEMPTS = 10
+
+	LOGIN_FAILURES_PREFIX = "loginfailures:"
+	LOGIN_BACKOFF_PREFIX  = "loginbackoff:"
+	LOGIN_LOCK_PREFIX     = "loginlock:"
+)
+
+// Every refused login gets the same error, so it does not tell whether the user exists
+var errLoginRefused = errors.New("Authentication credentials invalid")
+var errLoginBlocked = errors.New("Too many failed attempts, try again later")
+
+func userLoginKey(prefix string, authUser string) string {
+	return prefix + "user:" + authUser
+}
+
+func ipLoginKey(prefix string, remoteAddr string) string {
+	return prefix + "ip:" + remoteAddr
+}
+
+// loginBackoff is how long to wait after a number of failures, nothing up to allowed
+func loginBackoff(failures int64, allowed int64) time.Duration {
+	if failures <= allowed {
+		return 0
+	}
+	backoff := LOGIN_BACKOFF_BASE
+	for i := allowed + 1; i < failures; i++ {
+		backoff *= 2
+		if backoff >= LOGIN_BACKOFF_MAX {
+			return LOGIN_BACKOFF_MAX
+		}
+	}
+	return backoff
+}
+
+func lockoutAttempts() int64 {
+	if Config.Auth.LockoutAttempts > 0 {
+		return int64(Config.Auth.LockoutAttempts)
+	}
+	return DEFAULT_LOCKOUT_ATTEMPTS
+}
+
+// checkLoginAllowed refuses a login that is locked or backing off. Names that do
+// not exist are counted and locked the same way, so they look no different.
+func checkLoginAllowed(authUser string, remoteAddr string) (err error) {
+	keys := []string{userLoginKey(LOGIN_LOCK_PREFIX, authUser), userLoginKey(LOGIN_BACKOFF_PREFIX, authUser)}
+	if remoteAddr != "" {
+		keys = append(keys, ipLoginKey(LOGIN_BACKOFF_PREFIX, remoteAddr))
+	}
+
+	for _, key := range keys {
+		_, found, err := store.kv.Get(key)
+		if err != nil {
+			return errors.New("appauth.checkLoginAllowed: Could not get login attempts. " + err.Error())
+		}
+		if found {
+			return errors.New("appauth.checkLoginAllowed: " + errLoginBlocked.Error())
+		}
+	}
+	return
+}
+
+// recordLoginFailure counts a failed login for the user and address, backing
+// off further attempts and locking the user once there are too many
+func recordLoginFailure(authUser string, remoteAddr string, reason string) (err error) {
+	userFailures, err := countLoginFailure(userLoginKey(LOGIN_FAILURES_PREFIX, authUser), userLoginKey(LOGIN_BACKOFF_PREFIX, authUser), LOGIN_BACKOFF_AFTER_USER)
+	if err != nil {
+		return errors.New("appauth.recordLoginFailure: " + err.Error())
+	}
+	if remoteAddr != "" {
+		_, err = countLoginFailure(ipLoginKey(LOGIN_FAILURES_PREFIX, remoteAddr), ipLoginKey(LOGIN_BACKOFF_PREFIX, remoteAddr), LOGIN_BACKOFF_AFTER_IP)
+		if err != nil {
+			return errors.New("appauth.recordLoginFailure: " + err.Error())
+		}
+	}
+
+	err = audit.Record(audit.Event{Type: audit.EVENT_LOGIN_FAILED, Actor: authUser, RemoteAddr: remoteAddr, Detail: reason})
+	if err != nil {
+		return errors.New("appauth.recordLoginFailure: " + err.Error())
+	}
+
+	if userFailures != lockoutAttempts() {
+		return
+	}
+	// No expiry, only unlocking removes it
+	err = store.kv.Set(userLoginKey(LOGIN_LOCK_PREFIX, authUser), strconv.FormatInt(time.Now().Unix(), 10), 0)
+	if err != nil {
+		return errors.New("appauth.recordLoginFailure: Could not lock login. " + err.Error())
+	}
+	err = audit.Record(audit.Event{Type: audit.EVENT_LOGIN_LOCKED, Actor: authUser, RemoteAddr: remoteAddr, Detail: strconv.FormatInt(userFailures, 10) + " failed attempts"})
+	if err != nil {
+		return errors.New("appauth.recordLoginFailure: " + err.Error())
+	}
+	return
+}
+
+func countLoginFailure(failuresKey string, backoffKey string, allowed int64) (failures int64, err error) {
+	failures, err = store.kv.Incr(failuresKey)
+	if err != nil {
+		return 0, errors.New("appauth.countLoginFailure: Could not count failure. " + err.Error())
+	}
+	err = store.kv.Expire(failuresKey, LOGIN_FAILURE_WINDOW)
+	if err != nil {
+		return 0, errors.New("appauth.countLoginFailure: Could not count failure. " + err.Error())
+	}
+
+	backoff := loginBackoff(failures, allowed)
+	if backoff == 0 {
+		return
+	}
+	err = store.kv.Set(backoffKey, strconv.FormatInt(failures, 10), backoff)
+	if err != nil {
+		return 0, errors.New("appauth.countLoginFailure: Could not back off. " + err.Error())
+	}
+	return
+}
+
+// clearLoginFailures forgets a user's failures once they log in. The address
+// keeps its count, one good login does not excuse guessing at other users.
+func clearLoginFailures(authUser string) (err error) {
+	err = store.kv.Del(userLoginKey(LOGIN_FAILURES_PREFIX, authUser), userLoginKey(LOGIN_BACKOFF_PREFIX, authUser))
+	if err != nil {
+		return errors.New("appauth.clearLoginFailures: Could not clear failures. " + err.Error())
+	}
+	return
+}
+
+// UnlockLogin lets a locked user log in again
+func UnlockLogin(authUser string, staff string) (result string, err error) {
+	err = store.kv.Del(userLoginKey(LOGIN_LOCK_PREFIX, authUser), userLoginKey(LOGIN_FAILURES_PREFIX, authUser), userLoginKey(LOGIN_BACKOFF_PREFIX, authUser))
+	if err != nil {
+		return "", errors.New("appauth.UnlockLogin: Could not unlock login. " + err.Error())
+	}
+
+	err = audit.Record(audit.Event{Type: audit.EVENT_LOGIN_UNLOCKED, Actor: staff, Subject: authUser})
+	if err != nil {
+		return "", errors.New("appauth.UnlockLogin: " + err.Error())
+	}
+	return "Login unlocked", nil
+}
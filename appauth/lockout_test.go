@@ -0,0 +1,99 @@
+package appauth
+
+import (
+	"database/sql/driver"
+	"testing"
+	"time"
+)
+
+// setTestUser keeps the user authuser, with the ID user, password as their
+// password and two-factor authentication, in a test database. It gives the
+// two-factor secret.
+func setTestUser(t *testing.T, password string) (d *testDriver, secret []byte, restore func()) {
+	encoded, _ := newTOTPSecret()
+	secret, _ = decodeTOTPSecret(encoded)
+	hash, err := hashPassword(password)
+	if err != nil {
+		t.Fatalf("Could not hash password. %v", err)
+	}
+	d = &testDriver{
+		rows: map[string][]driver.Value{
+			testRowKey("`accounts_user_auth`", "authuser"): {hash, "", "user"},
+			testRowKey("`accounts_user_auth`", "user"):     {"authuser"},
+			testRowKey("`password`, `salt` FROM", "user"):  {hash, ""},
+			testRowKey("`accounts_user_totp`", "authuser"): {encoded, TOTP_STATUS_ACTIVE, int64(0)},
+		},
+		unchanged: []string{"accounts_user_recovery_codes"},
+	}
+	return d, secret, setTestDB(t, d)
+}
+
+func TestLoginBackoff(t *testing.T) {
+	tests := []struct {
+		failures int64
+		backoff  time.Duration
+	}{
+		{1, 0},
+		{3, 0},
+		{4, time.Second},
+		{5, 2 * time.Second},
+		{8, 16 * time.Second},
+		{20, LOGIN_BACKOFF_MAX},
+	}
+
+	for _, test := range tests {
+		if backoff := loginBackoff(test.failures, LOGIN_BACKOFF_AFTER_USER); backoff != test.backoff {
+			t.Errorf("LoginBackoff does not pass after %v failures. Looking for %v, got %v", test.failures, test.backoff, backoff)
+		}
+	}
+}
+
+func TestCountLoginFailure(t *testing.T) {
+	kv := setTestStore()
+
+	failuresKey := userLoginKey(LOGIN_FAILURES_PREFIX, "user")
+	backoffKey := userLoginKey(LOGIN_BACKOFF_PREFIX, "user")
+	for i := 0; i < LOGIN_BACKOFF_AFTER_USER; i++ {
+		if _, err := countLoginFailure(failuresKey, backoffKey, LOGIN_BACKOFF_AFTER_USER); err != nil {
+			t.Fatalf("CountLoginFailure does not pass. Looking for %v, got %v", nil, err)
+		}
+	}
+	if err := checkLoginAllowed("user", "10.0.0.1"); err != nil {
+		t.Errorf("CountLoginFailure allowed does not pass. Looking for %v, got %v", nil, err)
+	}
+
+	// One more and the next attempt waits
+	failures, _ := countLoginFailure(failuresKey, backoffKey, LOGIN_BACKOFF_AFTER_USER)
+	if failures != LOGIN_BACKOFF_AFTER_USER+1 {
+		t.Errorf("CountLoginFailure count does not pass. Looking for %v, got %v", LOGIN_BACKOFF_AFTER_USER+1, failures)
+	}
+	if err := checkLoginAllowed("user", "10.0.0.1"); err == nil {
+		t.Errorf("CountLoginFailure backoff does not pass. Looking for %v, got %v", "error", err)
+	}
+	// Other users are not held up
+	if err := checkLoginAllowed("other", "10.0.0.2"); err != nil {
+		t.Errorf("CountLoginFailure other user does not pass. Looking for %v, got %v", nil, err)
+	}
+
+	kv.now = kv.now.Add(time.Second)
+	if err := checkLoginAllowed("user", "10.0.0.1"); err != nil {
+		t.Errorf("CountLoginFailure after backoff does not pass. Looking for %v, got %v", nil, err)
+	}
+
+	if err := clearLoginFailures("user"); err != nil {
+		t.Fatalf("CountLoginFailure clear does not pass. Looking for %v, got %v", nil, err)
+	}
+	if failures, _ := countLoginFailure(failuresKey, backoffKey, LOGIN_BACKOFF_AFTER_USER); failures != 1 {
+		t.Errorf("CountLoginFailure after clear does not pass. Looking for %v, got %v", 1, failures)
+	}
+}
+
+func TestCheckLoginLocked(t *testing.T) {
+	setTestStore()
+
+	// A lock has no expiry, it stays until unlocked
+	store.kv.Set(userLoginKey(LOGIN_LOCK_PREFIX, "user"), "1480000000", 0)
+	if err := checkLoginAllowed("user", ""); err == nil {
+		t.Errorf("CheckLoginLocked does not pass. Looking for %v, got %v", "error", err)
+	}
+}
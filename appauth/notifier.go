@@ -0,0 +1,58 @@
+package appauth
+
+import (
+	"errors"
+	"log"
+	"os"
+	"sync"
+)
+
+// Notification is a message for a user, such as a password reset code
+type Notification struct {
+	UserID  string
+	Email   string
+	Subject string
+	Message string
+}
+
+// Notifier sends users messages outside of the app. Set one that sends email
+// or SMS with SetNotifier, the default only writes them to a log file.
+type Notifier interface {
+	Notify(notification Notification) error
+}
+
+var notifier Notifier = LogNotifier{}
+
+// SetNotifier changes how users are sent messages
+func SetNotifier(n Notifier) {
+	notifier = n
+}
+
+// LogNotifier writes messages to the file at Path, or the standard logger if
+// there is none. It is for running locally, codes in it can be used by anyone
+// who can read the file.
+type LogNotifier struct {
+	Path string
+}
+
+var logNotifierLock sync.Mutex
+
+func (l LogNotifier) Notify(notification Notification) (err error) {
+	logNotifierLock.Lock()
+	defer logNotifierLock.Unlock()
+
+	if l.Path == "" {
+		log.Printf("Notification to %s <%s>: %s: %s", notification.UserID, notification.Email, notification.Subject, notification.Message)
+		return
+	}
+
+	f, err := os.OpenFile(l.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return errors.New("appauth.LogNotifier.Notify: Could not open log. " + err.Error())
+	}
+	defer f.Close()
+
+	logger := log.New(f, "", log.LstdFlags)
+	logger.Printf("Notification to %s <%s>: %s: %s", notification.UserID, notification.Email, notification.Subject, notification.Message)
+	return
+}
@@ -0,0 +1,348 @@
+package appauth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/bvnk/bank/audit"
+)
+
+const (
+	// Longer passwords are refused, hashing them would be slow
+	MAX_PASSWORD_LENGTH = 128
+	// Passwords this long need not mix kinds of characters
+	PASSPHRASE_LENGTH = 16
+	// Kinds of characters, from lower case, upper case, digits and symbols, a
+	// password shorter than PASSPHRASE_LENGTH must mix
+	PASSWORD_CHARACTER_KINDS = 2
+
+	// A reset code is valid for this time and number of attempts
+	PASSWORD_RESET_DIGITS   = 8
+	PASSWORD_RESET_TTL      = 15 * time.Minute
+	PASSWORD_RESET_ATTEMPTS = 5
+	// Codes a user, or an address, can ask for in PASSWORD_RESET_REQUEST_WINDOW.
+	// Each new code has its own attempts, so they are limited too.
+	PASSWORD_RESET_REQUESTS_USER  = 3
+	PASSWORD_RESET_REQUESTS_IP    = 10
+	PASSWORD_RESET_REQUEST_WINDOW = time.Hour
+
+	PASSWORD_RESET_PREFIX          = "passwordreset:"
+	PASSWORD_RESET_REQUESTS_PREFIX = "passwordresetrequests:"
+)
+
+var errResetRequestsBlocked = errors.New("Too many reset requests, try again later")
+
+// Refused however they are written
+var commonPasswords = map[string]bool{
+	"password": true, "password1": true, "password123": true, "passw0rd": true,
+	"12345678": true, "123456789": true, "1234567890": true, "87654321": true,
+	"qwerty123": true, "qwertyuiop": true, "1q2w3e4r": true, "abc12345": true,
+	"iloveyou": true, "sunshine": true, "princess": true, "football": true,
+	"baseball": true, "welcome1": true, "letmein1": true, "trustno1": true,
+	"admin123": true, "changeme": true, "11111111": true, "00000000": true,
+}
+
+// passwordReset is a forgotten password waiting for its code
+type passwordReset struct {
+	UserID   string
+	CodeHash string
+	Attempts int
+}
+
+// checkPasswordStrength refuses passwords that are short, common, made of one
+// kind of character or that contain the user's names for themselves
+func checkPasswordStrength(password string, names ...string) (err error) {
+	if len(password) < MIN_PASSWORD_LENGTH {
+		return errors.New("appauth.checkPasswordStrength: Password must be at least " + strconv.Itoa(MIN_PASSWORD_LENGTH) + " characters")
+	}
+	if len(password) > MAX_PASSWORD_LENGTH {
+		return errors.New("appauth.checkPasswordStrength: Password must be at most " + strconv.Itoa(MAX_PASSWORD_LENGTH) + " characters")
+	}
+
+	lower := strings.ToLower(password)
+	if commonPasswords[lower] {
+		return errors.New("appauth.checkPasswordStrength: Password is too common")
+	}
+	for _, name := range names {
+		if name != "" && strings.Contains(lower, strings.ToLower(name)) {
+			return errors.New("appauth.checkPasswordStrength: Password must not contain the user name")
+		}
+	}
+
+	if len(password) >= PASSPHRASE_LENGTH {
+		return
+	}
+	if passwordCharacterKinds(password) < PASSWORD_CHARACTER_KINDS {
+		return errors.New("appauth.checkPasswordStrength: Password must mix letters, digits or symbols, or be at least " + strconv.Itoa(PASSPHRASE_LENGTH) + " characters")
+	}
+	return
+}
+
+func passwordCharacterKinds(password string) (kinds int) {
+	var hasLower, hasUpper, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		default:
+			hasSymbol = true
+		}
+	}
+	for _, has := range []bool{hasLower, hasUpper, hasDigit, hasSymbol} {
+		if has {
+			kinds++
+		}
+	}
+	return
+}
+
+// ChangePassword sets a new password for the token's user, who must give their
+// current one. Wrong ones count towards locking the login, so a stolen token
+// cannot be used to guess it. Every session is logged out, including this one.
+func ChangePassword(token string, currentPassword string, newPassword string, remoteAddr string) (result string, err error) {
+	userID, err := GetUserFromToken(token)
+	if err != nil {
+		return "", errors.New("appauth.ChangePassword: " + err.Error())
+	}
+	authUser, err := getAuthUser(userID)
+	if err != nil {
+		return "", errors.New("appauth.ChangePassword: " + err.Error())
+	}
+	err = checkLoginAllowed(authUser, remoteAddr)
+	if err != nil {
+		return "", errors.New("appauth.ChangePassword: " + err.Error())
+	}
+
+	hashedPassword, salt, err := getUserPasswordSaltFromUID(userID)
+	if err != nil {
+		return "", errors.New("appauth.ChangePassword: " + err.Error())
+	}
+	ok, _, err := verifyPassword(hashedPassword, salt, currentPassword)
+	if err != nil {
+		return "", errors.New("appauth.ChangePassword: " + err.Error())
+	}
+	if !ok {
+		err = recordLoginFailure(authUser, remoteAddr, "wrong current password")
+		if err != nil {
+			return "", errors.New("appauth.ChangePassword: " + err.Error())
+		}
+		return "", errors.New("appauth.ChangePassword: " + errLoginRefused.Error())
+	}
+
+	err = setPassword(userID, authUser, newPassword)
+	if err != nil {
+		return "", errors.New("appauth.ChangePassword: " + err.Error())
+	}
+
+	err = audit.Record(audit.Event{Type: audit.EVENT_PASSWORD_CHANGED, Actor: authUser})
+	if err != nil {
+		return "", errors.New("appauth.ChangePassword: " + err.Error())
+	}
+	return "Password changed, log in again", nil
+}
+
+// RequestPasswordReset sends a user a code to set a new password with. The
+// answer is the same whether or not the user exists, and requests are limited
+// for each name and address either way.
+func RequestPasswordReset(authUser string, remoteAddr string) (result string, err error) {
+	result = "If the user exists a reset code has been sent"
+
+	err = countResetRequest(authUser, remoteAddr)
+	if err != nil {
+		return "", errors.New("appauth.RequestPasswordReset: " + err.Error())
+	}
+
+	userID, email, err := getUserContact(authUser)
+	if err == sql.ErrNoRows {
+		return result, nil
+	} else if err != nil {
+		return "", errors.New("appauth.RequestPasswordReset: " + err.Error())
+	}
+
+	code, err := newPasswordResetCode()
+	if err != nil {
+		return "", errors.New("appauth.RequestPasswordReset: " + err.Error())
+	}
+	// A new code replaces any earlier one
+	err = savePasswordReset(authUser, passwordReset{UserID: userID, CodeHash: recoveryCodeHash(code)})
+	if err != nil {
+		return "", errors.New("appauth.RequestPasswordReset: " + err.Error())
+	}
+
+	err = notifier.Notify(Notification{
+		UserID:  userID,
+		Email:   email,
+		Subject: "Password reset",
+		Message: "Your password reset code is " + code + ". It expires in " + strconv.Itoa(int(PASSWORD_RESET_TTL.Minutes())) + " minutes.",
+	})
+	if err != nil {
+		return "", errors.New("appauth.RequestPasswordReset: Could not send code. " + err.Error())
+	}
+
+	err = audit.Record(audit.Event{Type: audit.EVENT_PASSWORD_RESET_REQUESTED, Actor: authUser})
+	if err != nil {
+		return "", errors.New("appauth.RequestPasswordReset: " + err.Error())
+	}
+	return
+}
+
+// countResetRequest counts a reset request for the user and address, refusing
+// it once either has asked too often
+func countResetRequest(authUser string, remoteAddr string) (err error) {
+	keys := map[string]int64{userLoginKey(PASSWORD_RESET_REQUESTS_PREFIX, authUser): PASSWORD_RESET_REQUESTS_USER}
+	if remoteAddr != "" {
+		keys[ipLoginKey(PASSWORD_RESET_REQUESTS_PREFIX, remoteAddr)] = PASSWORD_RESET_REQUESTS_IP
+	}
+
+	blocked := false
+	for key, allowed := range keys {
+		requests, err := store.kv.Incr(key)
+		if err != nil {
+			return errors.New("appauth.countResetRequest: Could not count request. " + err.Error())
+		}
+		// The window starts at the first request
+		if requests == 1 {
+			err = store.kv.Expire(key, PASSWORD_RESET_REQUEST_WINDOW)
+			if err != nil {
+				return errors.New("appauth.countResetRequest: Could not count request. " + err.Error())
+			}
+		}
+		if requests > allowed {
+			blocked = true
+		}
+	}
+	if blocked {
+		return errors.New("appauth.countResetRequest: " + errResetRequestsBlocked.Error())
+	}
+	return
+}
+
+// ResetPassword sets a new password with a code from RequestPasswordReset.
+// Every session is logged out.
+func ResetPassword(authUser string, code string, newPassword string) (result string, err error) {
+	value, found, err := store.kv.Get(PASSWORD_RESET_PREFIX + authUser)
+	if err != nil {
+		return "", errors.New("appauth.ResetPassword: Could not get reset. " + err.Error())
+	}
+	if !found {
+		return "", errors.New("appauth.ResetPassword: Reset code invalid or expired")
+	}
+	reset := passwordReset{}
+	err = json.Unmarshal([]byte(value), &reset)
+	if err != nil {
+		return "", errors.New("appauth.ResetPassword: Could not decode reset. " + err.Error())
+	}
+
+	if subtle.ConstantTimeCompare([]byte(recoveryCodeHash(code)), []byte(reset.CodeHash)) != 1 {
+		reset.Attempts++
+		if reset.Attempts >= PASSWORD_RESET_ATTEMPTS {
+			_ = store.kv.Del(PASSWORD_RESET_PREFIX + authUser)
+			return "", errors.New("appauth.ResetPassword: Too many attempts, request a new code")
+		}
+		_ = savePasswordReset(authUser, reset)
+		return "", errors.New("appauth.ResetPassword: Reset code invalid or expired")
+	}
+
+	err = setPassword(reset.UserID, authUser, newPassword)
+	if err != nil {
+		return "", errors.New("appauth.ResetPassword: " + err.Error())
+	}
+
+	err = store.kv.Del(PASSWORD_RESET_PREFIX + authUser)
+	if err != nil {
+		return "", errors.New("appauth.ResetPassword: Could not remove reset. " + err.Error())
+	}
+	// Failures guessing the old password no longer matter, a lock still needs staff
+	err = clearLoginFailures(authUser)
+	if err != nil {
+		return "", errors.New("appauth.ResetPassword: " + err.Error())
+	}
+
+	err = audit.Record(audit.Event{Type: audit.EVENT_PASSWORD_RESET, Actor: authUser})
+	if err != nil {
+		return "", errors.New("appauth.ResetPassword: " + err.Error())
+	}
+	return "Password reset, log in again", nil
+}
+
+// setPassword checks and saves a user's new password, then logs them out everywhere
+func setPassword(userID string, authUser string, newPassword string) (err error) {
+	err = checkPasswordStrength(newPassword, userID, authUser)
+	if err != nil {
+		return errors.New("appauth.setPassword: " + err.Error())
+	}
+
+	hash, err := hashPassword(newPassword)
+	if err != nil {
+		return errors.New("appauth.setPassword: " + err.Error())
+	}
+	err = updatePassword(userID, hash)
+	if err != nil {
+		return errors.New("appauth.setPassword: " + err.Error())
+	}
+
+	err = revokeUserSessions(userID)
+	if err != nil {
+		return errors.New("appauth.setPassword: " + err.Error())
+	}
+	return
+}
+
+// newPasswordResetCode gives digits, to be easy to type from a message
+func newPasswordResetCode() (code string, err error) {
+	max := big.NewInt(1)
+	for i := 0; i < PASSWORD_RESET_DIGITS; i++ {
+		max.Mul(max, big.NewInt(10))
+	}
+	n, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", errors.New("appauth.newPasswordResetCode: " + err.Error())
+	}
+	return fmt.Sprintf("%0*d", PASSWORD_RESET_DIGITS, n), nil
+}
+
+func savePasswordReset(authUser string, reset passwordReset) (err error) {
+	value, err := json.Marshal(reset)
+	if err != nil {
+		return errors.New("appauth.savePasswordReset: Could not encode reset. " + err.Error())
+	}
+	err = store.kv.Set(PASSWORD_RESET_PREFIX+authUser, string(value), PASSWORD_RESET_TTL)
+	if err != nil {
+		return errors.New("appauth.savePasswordReset: Could not set reset. " + err.Error())
+	}
+	return
+}
+
+// getUserContact gives sql.ErrNoRows unwrapped if the user does not exist
+func getUserContact(authUser string) (userID string, email string, err error) {
+	err = Config.Db.QueryRow("SELECT a.`accountHolderIdentificationNumber`, IFNULL(u.`accountHolderEmailAddress`, '') FROM `accounts_user_auth` a LEFT JOIN `accounts_users` u ON u.`accountHolderIdentificationNumber` = a.`accountHolderIdentificationNumber` WHERE a.`authUser` = ? LIMIT 1", authUser).Scan(&userID, &email)
+	switch {
+	case err == sql.ErrNoRows:
+		return "", "", err
+	case err != nil:
+		return "", "", errors.New("appauth.getUserContact: " + err.Error())
+	}
+	return
+}
+
+// updatePassword saves a PHC hash, which needs no salt of its own
+func updatePassword(userID string, hash string) (err error) {
+	_, err = Config.Db.Exec("UPDATE `accounts_user_auth` SET `password` = ?, `salt` = '', `timestamp` = ? WHERE `accountHolderIdentificationNumber` = ?", hash, time.Now().Unix(), userID)
+	if err != nil {
+		return errors.New("appauth.updatePassword: " + err.Error())
+	}
+	return
+}
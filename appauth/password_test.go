@@ -0,0 +1,137 @@
+package appauth
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/bvnk/bank/audit"
+)
+
+func TestCheckPasswordStrength(t *testing.T) {
+	tests := []struct {
+		password string
+		valid    bool
+	}{
+		{"short1!", false},
+		{"test-password", true},
+		{"Password123", false},
+		{"alllowercase", false},
+		{"12345678901", false},
+		{"correct horse battery staple", true},
+		{"nodigitsbutverylong", true},
+		{"mix3dCase", true},
+		{strings.Repeat("aB1", 50), false},
+	}
+
+	for _, test := range tests {
+		err := checkPasswordStrength(test.password)
+		if (err == nil) != test.valid {
+			t.Errorf("CheckPasswordStrength does not pass for %v. Looking for valid %v, got %v", test.password, test.valid, err)
+		}
+	}
+
+	// The user's own names are not allowed in it
+	if err := checkPasswordStrength("my-jsmith-pass", "user-id", "JSmith"); err == nil {
+		t.Errorf("CheckPasswordStrength user name does not pass. Looking for %v, got %v", "error", err)
+	}
+}
+
+func TestNewPasswordResetCode(t *testing.T) {
+	code, err := newPasswordResetCode()
+	if err != nil || len(code) != PASSWORD_RESET_DIGITS || strings.Trim(code, "0123456789") != "" {
+		t.Errorf("NewPasswordResetCode does not pass. Looking for %v digits, got %v %v", PASSWORD_RESET_DIGITS, code, err)
+	}
+}
+
+func TestResetPasswordAttempts(t *testing.T) {
+	setTestStore()
+
+	savePasswordReset("user", passwordReset{UserID: "id", CodeHash: recoveryCodeHash("12345678")})
+	for i := 1; i < PASSWORD_RESET_ATTEMPTS; i++ {
+		if _, err := ResetPassword("user", "00000000", "new-password1"); err == nil {
+			t.Fatalf("ResetPasswordAttempts wrong code does not pass. Looking for %v, got %v", "error", err)
+		}
+	}
+	if _, found, _ := store.kv.Get(PASSWORD_RESET_PREFIX + "user"); !found {
+		t.Fatalf("ResetPasswordAttempts does not pass. Looking for %v, got %v", "reset kept", found)
+	}
+
+	// The last attempt throws the code away, even the right one no longer works
+	ResetPassword("user", "00000000", "new-password1")
+	if _, err := ResetPassword("user", "12345678", "new-password1"); err == nil {
+		t.Errorf("ResetPasswordAttempts too many does not pass. Looking for %v, got %v", "error", err)
+	}
+}
+
+func TestChangePasswordAttempts(t *testing.T) {
+	kv := setTestStore()
+	d, _, restore := setTestUser(t, "password")
+	defer restore()
+	token, _ := store.Issue("user", "session")
+
+	if _, err := ChangePassword(token, "wrong", "new-password1", "10.0.0.1"); err == nil {
+		t.Errorf("ChangePasswordAttempts wrong password does not pass. Looking for %v, got %v", "error", err)
+	}
+	if failures, _, _ := kv.Get(userLoginKey(LOGIN_FAILURES_PREFIX, "authuser")); failures != "1" {
+		t.Errorf("ChangePasswordAttempts count does not pass. Looking for %v, got %v", "1", failures)
+	}
+	if details := d.eventDetails(audit.EVENT_LOGIN_FAILED); len(details) != 1 || details[0] != "wrong current password" {
+		t.Errorf("ChangePasswordAttempts audit does not pass. Looking for %v, got %v", "wrong current password", details)
+	}
+
+	// Locked, even the right password is refused
+	kv.Set(userLoginKey(LOGIN_LOCK_PREFIX, "authuser"), "1480000000", 0)
+	if _, err := ChangePassword(token, "password", "new-password1", "10.0.0.1"); err == nil {
+		t.Errorf("ChangePasswordAttempts locked does not pass. Looking for %v, got %v", "error", err)
+	}
+}
+
+func TestRequestPasswordResetLimit(t *testing.T) {
+	kv := setTestStore()
+	defer setTestDB(t, &testDriver{})()
+
+	for i := 0; i < PASSWORD_RESET_REQUESTS_USER; i++ {
+		if _, err := RequestPasswordReset("user", "10.0.0.1"); err != nil {
+			t.Fatalf("RequestPasswordResetLimit does not pass. Looking for %v, got %v", nil, err)
+		}
+	}
+	if _, err := RequestPasswordReset("user", "10.0.0.2"); err == nil {
+		t.Errorf("RequestPasswordResetLimit user does not pass. Looking for %v, got %v", "error", err)
+	}
+
+	// The address is limited across users
+	for i := PASSWORD_RESET_REQUESTS_USER; i < PASSWORD_RESET_REQUESTS_IP; i++ {
+		RequestPasswordReset("other"+strconv.Itoa(i), "10.0.0.1")
+	}
+	if _, err := RequestPasswordReset("another", "10.0.0.1"); err == nil {
+		t.Errorf("RequestPasswordResetLimit address does not pass. Looking for %v, got %v", "error", err)
+	}
+
+	kv.now = kv.now.Add(PASSWORD_RESET_REQUEST_WINDOW)
+	if _, err := RequestPasswordReset("user", "10.0.0.1"); err != nil {
+		t.Errorf("RequestPasswordResetLimit after window does not pass. Looking for %v, got %v", nil, err)
+	}
+}
+
+func TestLogNotifier(t *testing.T) {
+	dir, err := ioutil.TempDir("", "notifier")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "notifications.log")
+	err = LogNotifier{Path: path}.Notify(Notification{UserID: "id", Email: "user@example.com", Subject: "Password reset", Message: "Your code is 12345678"})
+	if err != nil {
+		t.Fatalf("LogNotifier does not pass. Looking for %v, got %v", nil, err)
+	}
+
+	content, _ := ioutil.ReadFile(path)
+	if !strings.Contains(string(content), "user@example.com") || !strings.Contains(string(content), "12345678") {
+		t.Errorf("LogNotifier does not pass. Looking for %v, got %v", "the message", string(content))
+	}
+}
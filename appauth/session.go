@@ -0,0 +1,361 @@
+package appauth
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/satori/go.uuid"
+)
+
+const (
+	REFRESH_TOKEN_TTL = 30 * 24 * time.Hour // Thirty days
+
+	SESSION_PREFIX       = "session:"
+	REFRESH_TOKEN_PREFIX = "refresh:"
+	USER_SESSIONS_PREFIX = "sessions:"
+)
+
+// Tokens are what a client gets on logging in or refreshing. The access token
+// authenticates requests, the refresh token gets new tokens once it expires.
+// Users with two-factor authentication only get a Challenge from the password,
+// the tokens come from giving a one-time code with it.
+type Tokens struct {
+	AccessToken  string
+	RefreshToken string
+	SessionID    string
+	ExpiresIn    int
+	Challenge    string
+}
+
+// Session is a device a user is logged in on
+type Session struct {
+	ID       string
+	Device   string
+	Created  int32
+	LastUsed int32
+	Current  bool
+}
+
+// storedSession is a session as kept in Redis. Only the latest refresh token
+// of a session is valid, presenting an earlier one means it was stolen.
+type storedSession struct {
+	ID           string
+	UserID       string
+	Device       string
+	AccessToken  string
+	RefreshToken string
+	Created      int32
+	LastUsed     int32
+}
+
+func sessionKey(sessionID string) string {
+	return SESSION_PREFIX + sessionID
+}
+
+func refreshTokenKey(refreshToken string) string {
+	return REFRESH_TOKEN_PREFIX + refreshToken
+}
+
+func userSessionsKey(userID string) string {
+	return USER_SESSIONS_PREFIX + userID
+}
+
+// createSession logs a user in on a device
+func createSession(userID string, device string) (tokens Tokens, err error) {
+	sqlTime := int32(time.Now().Unix())
+	s := storedSession{
+		ID:       uuid.NewV4().String(),
+		UserID:   userID,
+		Device:   device,
+		Created:  sqlTime,
+		LastUsed: sqlTime,
+	}
+
+	tokens, err = issueTokens(&s, "")
+	if err != nil {
+		return Tokens{}, errors.New("appauth.createSession: " + err.Error())
+	}
+
+	err = store.kv.SAdd(userSessionsKey(userID), s.ID)
+	if err != nil {
+		return Tokens{}, errors.New("appauth.createSession: Could not index session. " + err.Error())
+	}
+	err = store.kv.Expire(userSessionsKey(userID), REFRESH_TOKEN_TTL)
+	if err != nil {
+		return Tokens{}, errors.New("appauth.createSession: Could not index session. " + err.Error())
+	}
+	return
+}
+
+// errSessionChanged is given by issueTokens if the session was refreshed or
+// logged out since it was read
+var errSessionChanged = errors.New("Session changed")
+
+// issueTokens gives a session a new access and refresh token, replacing its current access token.
+// The session is only replaced if it is still stored as previous, or does not
+// exist yet if previous is empty, so two refreshes cannot both succeed.
+// Earlier refresh tokens are kept until they expire, so reuse can be detected.
+func issueTokens(s *storedSession, previous string) (tokens Tokens, err error) {
+	previousAccessToken := s.AccessToken
+
+	s.AccessToken, err = store.Issue(s.UserID, s.ID)
+	if err != nil {
+		return Tokens{}, errors.New("appauth.issueTokens: " + err.Error())
+	}
+	s.RefreshToken = uuid.NewV4().String()
+
+	err = store.kv.Set(refreshTokenKey(s.RefreshToken), s.ID, REFRESH_TOKEN_TTL)
+	if err != nil {
+		return Tokens{}, errors.New("appauth.issueTokens: Could not set refresh token. " + err.Error())
+	}
+	value, err := json.Marshal(s)
+	if err != nil {
+		return Tokens{}, errors.New("appauth.issueTokens: Could not encode session. " + err.Error())
+	}
+	saved, err := store.kv.CompareAndSet(sessionKey(s.ID), previous, string(value), REFRESH_TOKEN_TTL)
+	if err != nil {
+		return Tokens{}, errors.New("appauth.issueTokens: Could not set session. " + err.Error())
+	}
+	if !saved {
+		// The access token was never given out
+		err = store.Revoke(s.AccessToken)
+		if err != nil {
+			return Tokens{}, errors.New("appauth.issueTokens: " + err.Error())
+		}
+		return Tokens{}, errSessionChanged
+	}
+
+	if previousAccessToken != "" {
+		err = store.Revoke(previousAccessToken)
+		if err != nil {
+			return Tokens{}, errors.New("appauth.issueTokens: " + err.Error())
+		}
+	}
+
+	tokens = Tokens{
+		AccessToken:  s.AccessToken,
+		RefreshToken: s.RefreshToken,
+		SessionID:    s.ID,
+		ExpiresIn:    int(store.IdleTimeout.Seconds()),
+	}
+	return
+}
+
+// RefreshSession swaps a refresh token for new tokens. A refresh token that
+// was already used logs the whole session out, as only a stolen copy would be
+// presented twice. That includes two requests with it at once, only one of
+// which can be the first.
+func RefreshSession(refreshToken string) (tokens Tokens, err error) {
+	sessionID, found, err := store.kv.Get(refreshTokenKey(refreshToken))
+	if err != nil {
+		return Tokens{}, errors.New("appauth.RefreshSession: Could not get refresh token. " + err.Error())
+	}
+	if !found {
+		return Tokens{}, errors.New("appauth.RefreshSession: Refresh token invalid")
+	}
+
+	s, value, found, err := getSessionValue(sessionID)
+	if err != nil {
+		return Tokens{}, errors.New("appauth.RefreshSession: " + err.Error())
+	}
+	// The session was logged out
+	if !found {
+		return Tokens{}, errors.New("appauth.RefreshSession: Refresh token invalid")
+	}
+
+	if !refreshReused(s, refreshToken) {
+		s.LastUsed = int32(time.Now().Unix())
+		tokens, err = issueTokens(&s, value)
+		if err == nil {
+			return
+		}
+		if err != errSessionChanged {
+			return Tokens{}, errors.New("appauth.RefreshSession: " + err.Error())
+		}
+	}
+
+	err = revokeSessionID(sessionID)
+	if err != nil {
+		return Tokens{}, errors.New("appauth.RefreshSession: " + err.Error())
+	}
+	return Tokens{}, errors.New("appauth.RefreshSession: Refresh token already used, session logged out")
+}
+
+// refreshReused is true if a refresh token of the session was presented after it was replaced
+func refreshReused(s storedSession, refreshToken string) bool {
+	return s.RefreshToken != refreshToken
+}
+
+// ListSessions gives the devices the token's user is logged in on, marking the one the token is from
+func ListSessions(token string) (sessions []Session, err error) {
+	userID, err := GetUserFromToken(token)
+	if err != nil {
+		return nil, errors.New("appauth.ListSessions: " + err.Error())
+	}
+
+	stored, err := getUserSessions(userID)
+	if err != nil {
+		return nil, errors.New("appauth.ListSessions: " + err.Error())
+	}
+
+	sessions = []Session{}
+	for _, s := range stored {
+		sessions = append(sessions, s.session(token))
+	}
+	sort.Sort(sessionsByLastUsed(sessions))
+	return
+}
+
+// RevokeSession logs a device out. It must be one of the token user's sessions.
+func RevokeSession(token string, sessionID string) (result string, err error) {
+	userID, err := GetUserFromToken(token)
+	if err != nil {
+		return "", errors.New("appauth.RevokeSession: " + err.Error())
+	}
+
+	s, found, err := getSession(sessionID)
+	if err != nil {
+		return "", errors.New("appauth.RevokeSession: " + err.Error())
+	}
+	if !found || s.UserID != userID {
+		return "", errors.New("appauth.RevokeSession: Session not found")
+	}
+
+	err = revokeSession(s)
+	if err != nil {
+		return "", errors.New("appauth.RevokeSession: " + err.Error())
+	}
+	return "Session logged out", nil
+}
+
+// RevokeAllSessions logs the token's user out on every device, including this one
+func RevokeAllSessions(token string) (result string, err error) {
+	userID, err := GetUserFromToken(token)
+	if err != nil {
+		return "", errors.New("appauth.RevokeAllSessions: " + err.Error())
+	}
+
+	err = revokeUserSessions(userID)
+	if err != nil {
+		return "", errors.New("appauth.RevokeAllSessions: " + err.Error())
+	}
+	return "All sessions logged out", nil
+}
+
+// revokeUserSessions logs a user out everywhere
+func revokeUserSessions(userID string) (err error) {
+	sessions, err := getUserSessions(userID)
+	if err != nil {
+		return errors.New("appauth.revokeUserSessions: " + err.Error())
+	}
+	for _, s := range sessions {
+		err = revokeSession(s)
+		if err != nil {
+			return errors.New("appauth.revokeUserSessions: " + err.Error())
+		}
+	}
+	return
+}
+
+// revokeSession removes a session and its access token. Its refresh tokens
+// are left to expire, they no longer lead to a session.
+func revokeSession(s storedSession) (err error) {
+	err = store.Revoke(s.AccessToken)
+	if err != nil {
+		return errors.New("appauth.revokeSession: " + err.Error())
+	}
+	err = store.kv.Del(sessionKey(s.ID))
+	if err != nil {
+		return errors.New("appauth.revokeSession: Could not remove session. " + err.Error())
+	}
+	err = store.kv.SRem(userSessionsKey(s.UserID), s.ID)
+	if err != nil {
+		return errors.New("appauth.revokeSession: Could not remove session from index. " + err.Error())
+	}
+	return
+}
+
+// revokeSessionID logs a session out as it is now, with whichever access token it has
+func revokeSessionID(sessionID string) (err error) {
+	s, found, err := getSession(sessionID)
+	if err != nil {
+		return errors.New("appauth.revokeSessionID: " + err.Error())
+	}
+	if !found {
+		return
+	}
+	err = revokeSession(s)
+	if err != nil {
+		return errors.New("appauth.revokeSessionID: " + err.Error())
+	}
+	return
+}
+
+func getSession(sessionID string) (s storedSession, found bool, err error) {
+	s, _, found, err = getSessionValue(sessionID)
+	if err != nil {
+		return storedSession{}, false, errors.New("appauth.getSession: " + err.Error())
+	}
+	return
+}
+
+// getSessionValue also gives the session as stored, for issueTokens to replace
+func getSessionValue(sessionID string) (s storedSession, value string, found bool, err error) {
+	value, found, err = store.kv.Get(sessionKey(sessionID))
+	if err != nil {
+		return storedSession{}, "", false, errors.New("appauth.getSessionValue: Could not get session. " + err.Error())
+	}
+	if !found {
+		return storedSession{}, "", false, nil
+	}
+
+	err = json.Unmarshal([]byte(value), &s)
+	if err != nil {
+		return storedSession{}, "", false, errors.New("appauth.getSessionValue: Could not decode session. " + err.Error())
+	}
+	return s, value, true, nil
+}
+
+// getUserSessions gives a user's sessions, dropping expired ones from the index
+func getUserSessions(userID string) (sessions []storedSession, err error) {
+	sessionIDs, err := store.kv.SMembers(userSessionsKey(userID))
+	if err != nil {
+		return nil, errors.New("appauth.getUserSessions: Could not get sessions. " + err.Error())
+	}
+
+	for _, sessionID := range sessionIDs {
+		s, found, err := getSession(sessionID)
+		if err != nil {
+			return nil, errors.New("appauth.getUserSessions: " + err.Error())
+		}
+		if !found {
+			err = store.kv.SRem(userSessionsKey(userID), sessionID)
+			if err != nil {
+				return nil, errors.New("appauth.getUserSessions: Could not remove session from index. " + err.Error())
+			}
+			continue
+		}
+		sessions = append(sessions, s)
+	}
+	return
+}
+
+// session is what the user sees of a stored session, without its tokens
+func (s storedSession) session(token string) Session {
+	return Session{
+		ID:       s.ID,
+		Device:   s.Device,
+		Created:  s.Created,
+		LastUsed: s.LastUsed,
+		Current:  s.AccessToken == token,
+	}
+}
+
+// sessionsByLastUsed sorts the most recently used session first
+type sessionsByLastUsed []Session
+
+func (s sessionsByLastUsed) Len() int           { return len(s) }
+func (s sessionsByLastUsed) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s sessionsByLastUsed) Less(i, j int) bool { return s[i].LastUsed > s[j].LastUsed }
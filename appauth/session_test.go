@@ -0,0 +1,146 @@
+package appauth
+
+import (
+	"sort"
+	"sync"
+	"testing"
+)
+
+func TestRefreshReused(t *testing.T) {
+	s := storedSession{ID: "session", RefreshToken: "second"}
+
+	if refreshReused(s, "second") {
+		t.Errorf("RefreshReused does not pass. Looking for %v, got %v", false, true)
+	}
+	// Replaced by the second refresh token
+	if !refreshReused(s, "first") {
+		t.Errorf("RefreshReused replaced token does not pass. Looking for %v, got %v", true, false)
+	}
+}
+
+func TestSessionView(t *testing.T) {
+	s := storedSession{ID: "session", UserID: "user", Device: "phone", AccessToken: "access", RefreshToken: "refresh", Created: 1, LastUsed: 2}
+
+	session := s.session("access")
+	if session.ID != "session" || session.Device != "phone" || !session.Current {
+		t.Errorf("SessionView does not pass. Looking for %v, got %v", "current phone session", session)
+	}
+	if s.session("other").Current {
+		t.Errorf("SessionView other token does not pass. Looking for %v, got %v", false, true)
+	}
+
+	sessions := []Session{{ID: "old", LastUsed: 1}, {ID: "new", LastUsed: 3}, {ID: "middle", LastUsed: 2}}
+	sort.Sort(sessionsByLastUsed(sessions))
+	if sessions[0].ID != "new" || sessions[2].ID != "old" {
+		t.Errorf("SessionView order does not pass. Looking for %v, got %v", "new first", sessions)
+	}
+}
+
+func TestRefreshSession(t *testing.T) {
+	setTestStore()
+
+	first, err := createSession("user", "phone")
+	if err != nil {
+		t.Fatalf("RefreshSession create does not pass. Looking for %v, got %v", nil, err)
+	}
+
+	second, err := RefreshSession(first.RefreshToken)
+	if err != nil || second.SessionID != first.SessionID || second.AccessToken == first.AccessToken {
+		t.Fatalf("RefreshSession does not pass. Looking for %v, got %v %v", "new tokens for the session", second, err)
+	}
+	if err := CheckToken(first.AccessToken); err == nil {
+		t.Errorf("RefreshSession old access token does not pass. Looking for %v, got %v", "error", err)
+	}
+	if err := CheckToken(second.AccessToken); err != nil {
+		t.Errorf("RefreshSession new access token does not pass. Looking for %v, got %v", nil, err)
+	}
+
+	// Presenting the first refresh token again logs the session out
+	if _, err := RefreshSession(first.RefreshToken); err == nil {
+		t.Errorf("RefreshSession reuse does not pass. Looking for %v, got %v", "error", err)
+	}
+	if err := CheckToken(second.AccessToken); err == nil {
+		t.Errorf("RefreshSession reuse access token does not pass. Looking for %v, got %v", "error", err)
+	}
+	if _, err := RefreshSession(second.RefreshToken); err == nil {
+		t.Errorf("RefreshSession reuse refresh token does not pass. Looking for %v, got %v", "error", err)
+	}
+}
+
+func TestRefreshSessionConcurrent(t *testing.T) {
+	setTestStore()
+
+	first, err := createSession("user", "phone")
+	if err != nil {
+		t.Fatalf("RefreshSessionConcurrent create does not pass. Looking for %v, got %v", nil, err)
+	}
+
+	// The same refresh token presented by several requests at once
+	var wg sync.WaitGroup
+	refreshed := make(chan Tokens, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tokens, err := RefreshSession(first.RefreshToken)
+			if err == nil {
+				refreshed <- tokens
+			}
+		}()
+	}
+	wg.Wait()
+	close(refreshed)
+
+	if len(refreshed) != 1 {
+		t.Fatalf("RefreshSessionConcurrent does not pass. Looking for %v, got %v", 1, len(refreshed))
+	}
+	// The rest were reuse, so the session was logged out and did not fork
+	second := <-refreshed
+	if err := CheckToken(second.AccessToken); err == nil {
+		t.Errorf("RefreshSessionConcurrent access token does not pass. Looking for %v, got %v", "error", err)
+	}
+	if _, err := RefreshSession(second.RefreshToken); err == nil {
+		t.Errorf("RefreshSessionConcurrent refresh token does not pass. Looking for %v, got %v", "error", err)
+	}
+	if _, found, _ := getSession(first.SessionID); found {
+		t.Errorf("RefreshSessionConcurrent session does not pass. Looking for %v, got %v", false, found)
+	}
+}
+
+func TestRevokeSessions(t *testing.T) {
+	setTestStore()
+
+	phone, _ := createSession("user", "phone")
+	laptop, _ := createSession("user", "laptop")
+	other, _ := createSession("other", "phone")
+
+	sessions, err := ListSessions(phone.AccessToken)
+	if err != nil || len(sessions) != 2 {
+		t.Fatalf("RevokeSessions list does not pass. Looking for %v, got %v %v", "2 sessions", sessions, err)
+	}
+
+	// Another user's session cannot be logged out
+	if _, err := RevokeSession(phone.AccessToken, other.SessionID); err == nil {
+		t.Errorf("RevokeSessions other user does not pass. Looking for %v, got %v", "error", err)
+	}
+
+	if _, err := RevokeSession(phone.AccessToken, laptop.SessionID); err != nil {
+		t.Errorf("RevokeSessions device does not pass. Looking for %v, got %v", nil, err)
+	}
+	if err := CheckToken(laptop.AccessToken); err == nil {
+		t.Errorf("RevokeSessions device token does not pass. Looking for %v, got %v", "error", err)
+	}
+	if sessions, _ := ListSessions(phone.AccessToken); len(sessions) != 1 || !sessions[0].Current {
+		t.Errorf("RevokeSessions list after does not pass. Looking for %v, got %v", "the current session", sessions)
+	}
+
+	if _, err := RevokeAllSessions(phone.AccessToken); err != nil {
+		t.Errorf("RevokeSessions everywhere does not pass. Looking for %v, got %v", nil, err)
+	}
+	if err := CheckToken(phone.AccessToken); err == nil {
+		t.Errorf("RevokeSessions everywhere token does not pass. Looking for %v, got %v", "error", err)
+	}
+	if err := CheckToken(other.AccessToken); err != nil {
+		t.Errorf("RevokeSessions other user token does not pass. Looking for %v, got %v", nil, err)
+	}
+}
@@ -0,0 +1,343 @@
+package appauth
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/bvnk/bank/audit"
+)
+
+// Staff roles. Each is given the permissions in rolePermissions.
+const (
+	ROLE_TELLER     = "teller"
+	ROLE_OPS        = "ops"
+	ROLE_COMPLIANCE = "compliance"
+	ROLE_ADMIN      = "admin"
+)
+
+// Permissions each staff action needs
+const (
+	PERMISSION_DEPOSIT        = "deposits:create"
+	PERMISSION_OVERDRAFT      = "accounts:overdraft"
+	PERMISSION_FREEZE         = "accounts:freeze"
+	PERMISSION_LIMITS         = "limits:set"
+	PERMISSION_PAYMENT_REVIEW = "payments:review"
+	PERMISSION_RECONCILE      = "balances:reconcile"
+	PERMISSION_INTERBANK      = "interbank:manage"
+	PERMISSION_EOD            = "eod:run"
+	PERMISSION_AML            = "aml:review"
+	PERMISSION_SANCTIONS      = "sanctions:review"
+	PERMISSION_UNLOCK         = "logins:unlock"
+	PERMISSION_AUDIT          = "audit:read"
+	PERMISSION_STAFF          = "staff:manage"
+	PERMISSION_CERTIFICATES   = "certificates:manage"
+)
+
+const (
+	STAFF_STATUS_ACTIVE   = "active"
+	STAFF_STATUS_DISABLED = "disabled"
+)
+
+// Admins have every permission
+var rolePermissions = map[string][]string{
+	ROLE_TELLER:     {PERMISSION_DEPOSIT, PERMISSION_UNLOCK},
+	ROLE_OPS:        {PERMISSION_DEPOSIT, PERMISSION_OVERDRAFT, PERMISSION_LIMITS, PERMISSION_PAYMENT_REVIEW, PERMISSION_RECONCILE, PERMISSION_INTERBANK, PERMISSION_EOD, PERMISSION_UNLOCK},
+	ROLE_COMPLIANCE: {PERMISSION_FREEZE, PERMISSION_PAYMENT_REVIEW, PERMISSION_AML, PERMISSION_SANCTIONS, PERMISSION_AUDIT},
+}
+
+// Staff users' failed logins are counted and locked as this and their
+// username, apart from a customer's login of the same name
+const STAFF_LOGIN_PREFIX = "staff:"
+
+var errStaffDenied = errors.New("Access denied")
+
+type Staff struct {
+	Username  string
+	Role      string
+	Status    string
+	CreatedBy string
+	Timestamp int32
+}
+
+// HasPermission tells whether a role may take an action
+func HasPermission(role string, permission string) bool {
+	if role == ROLE_ADMIN {
+		return true
+	}
+	for _, p := range rolePermissions[role] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+func validRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok || role == ROLE_ADMIN
+}
+
+// authenticateStaff gives the role of a staff user. HttpAuthUser and
+// HttpAuthPass from the config are an admin, to create the first staff with.
+// Wrong passwords back off and lock the same as a customer's login.
+func authenticateStaff(username string, password string, remoteAddr string) (role string, err error) {
+	if username == "" || password == "" {
+		return "", errStaffDenied
+	}
+	loginUser := STAFF_LOGIN_PREFIX + username
+	err = checkLoginAllowed(loginUser, remoteAddr)
+	if err != nil {
+		return "", err
+	}
+	if Config.HttpAuthUser != "" && Config.HttpAuthPass != "" &&
+		subtle.ConstantTimeCompare([]byte(username), []byte(Config.HttpAuthUser)) == 1 &&
+		subtle.ConstantTimeCompare([]byte(password), []byte(Config.HttpAuthPass)) == 1 {
+		return ROLE_ADMIN, clearLoginFailures(loginUser)
+	}
+
+	found := true
+	hash, role, status, err := getStaffUser(username)
+	if err == sql.ErrNoRows {
+		found = false
+	} else if err != nil {
+		return "", err
+	}
+
+	ok := false
+	if found {
+		ok, _, err = verifyPassword(hash, "", password)
+		if err != nil {
+			return "", err
+		}
+	} else {
+		verifyUnknownUser(password)
+	}
+
+	if !ok {
+		reason := "wrong staff password"
+		if !found {
+			reason = "unknown staff user"
+		}
+		err = recordLoginFailure(loginUser, remoteAddr, reason)
+		if err != nil {
+			return "", err
+		}
+		return "", errStaffDenied
+	}
+	if status != STAFF_STATUS_ACTIVE {
+		return "", errStaffDenied
+	}
+	err = clearLoginFailures(loginUser)
+	if err != nil {
+		return "", err
+	}
+	return role, nil
+}
+
+// AuthorizeStaff checks a staff user may take an action, and gives a token for
+// them and their role. Given as a command's first field CheckPermission takes
+// it in place of the password, so it is only checked once. The token is for
+// the one request and should be removed after it. Refusals are recorded as
+// audit events, what the caller then does is for it to record.
+func AuthorizeStaff(username string, password string, permission string, remoteAddr string) (token string, err error) {
+	role, err := authenticateStaff(username, password, remoteAddr)
+	err = checkStaffRole(username, role, permission, err)
+	if err != nil {
+		return "", errors.New("appauth.AuthorizeStaff: " + err.Error())
+	}
+
+	token, err = store.issue(accessToken{Issued: store.now().Unix(), Staff: username, StaffRole: role}, store.IdleTimeout)
+	if err != nil {
+		return "", errors.New("appauth.AuthorizeStaff: " + err.Error())
+	}
+	return
+}
+
+// checkStaffRole checks the role of a staff user, if they were authenticated,
+// has the permission, and records them being refused
+func checkStaffRole(username string, role string, permission string, authErr error) (err error) {
+	err = authErr
+	if err == nil && !HasPermission(role, permission) {
+		err = errStaffDenied
+	}
+	if err != nil {
+		auditErr := audit.Record(audit.Event{Type: audit.EVENT_STAFF_DENIED, Actor: username, Detail: permission})
+		if auditErr != nil {
+			return auditErr
+		}
+		return err
+	}
+	return
+}
+
+// CheckPermission checks the staff user in a command's last two fields may run
+// it, and records that they did with the command, less the password. A staff
+// user's token in the first field, from their client certificate or
+// AuthorizeStaff, is used instead, and the last two fields may be left empty.
+// The username given is the staff user who was allowed, for the command to
+// record as who ran it.
+func CheckPermission(data []string, permission string) (username string, err error) {
+	if len(data) < 2 {
+		return "", errors.New("appauth.CheckPermission: " + errStaffDenied.Error())
+	}
+	username = data[len(data)-2]
+	staff, role := tokenStaff(data[0])
+	if staff != "" {
+		username = staff
+		if role == "" {
+			role, err = certificateStaffRole(staff)
+		}
+	} else {
+		role, err = authenticateStaff(username, data[len(data)-1], "")
+	}
+	err = checkStaffRole(username, role, permission, err)
+	if err != nil {
+		return "", errors.New("appauth.CheckPermission: " + err.Error())
+	}
+
+	action := ""
+	if len(data) > 3 {
+		action = strings.Join(data[1:len(data)-2], "~")
+	}
+	err = audit.Record(audit.Event{Type: audit.EVENT_STAFF_ACTION, Actor: username, Subject: permission, Detail: action})
+	if err != nil {
+		return "", errors.New("appauth.CheckPermission: " + err.Error())
+	}
+	return
+}
+
+// CheckBasicAuth checks the credentials are a staff user's, of any role
+func CheckBasicAuth(username string, password string) (err error) {
+	_, err = authenticateStaff(username, password, "")
+	if err != nil {
+		return errors.New("appauth.CheckBasicAuth: Basic Auth incorrect. " + err.Error())
+	}
+	return
+}
+
+// CreateStaff adds a staff user with a role
+func CreateStaff(username string, password string, role string, createdBy string) (result string, err error) {
+	username = strings.TrimSpace(username)
+	if username == "" || username == Config.HttpAuthUser {
+		return "", errors.New("appauth.CreateStaff: Username not valid")
+	}
+	if !validRole(role) {
+		return "", errors.New("appauth.CreateStaff: Role not valid, must be one of teller, ops, compliance, admin")
+	}
+	err = checkPasswordStrength(password, username)
+	if err != nil {
+		return "", errors.New("appauth.CreateStaff: " + err.Error())
+	}
+
+	_, _, _, err = getStaffUser(username)
+	if err == nil {
+		return "", errors.New("appauth.CreateStaff: Staff user already exists")
+	} else if err != sql.ErrNoRows {
+		return "", errors.New("appauth.CreateStaff: " + err.Error())
+	}
+
+	hash, err := hashPassword(password)
+	if err != nil {
+		return "", errors.New("appauth.CreateStaff: " + err.Error())
+	}
+	err = saveStaffUser(username, hash, role, createdBy)
+	if err != nil {
+		return "", errors.New("appauth.CreateStaff: " + err.Error())
+	}
+	return "Staff user created", nil
+}
+
+// SetStaffRole changes a staff user's role, or disables them with the role "disabled"
+func SetStaffRole(username string, role string) (result string, err error) {
+	_, _, _, err = getStaffUser(username)
+	if err == sql.ErrNoRows {
+		return "", errors.New("appauth.SetStaffRole: Staff user not found")
+	} else if err != nil {
+		return "", errors.New("appauth.SetStaffRole: " + err.Error())
+	}
+
+	if role == STAFF_STATUS_DISABLED {
+		err = updateStaffStatus(username, STAFF_STATUS_DISABLED)
+		if err != nil {
+			return "", errors.New("appauth.SetStaffRole: " + err.Error())
+		}
+		return "Staff user disabled", nil
+	}
+
+	if !validRole(role) {
+		return "", errors.New("appauth.SetStaffRole: Role not valid, must be one of teller, ops, compliance, admin, disabled")
+	}
+	err = updateStaffRole(username, role)
+	if err != nil {
+		return "", errors.New("appauth.SetStaffRole: " + err.Error())
+	}
+	return "Staff user role updated", nil
+}
+
+// ListStaff gives every staff user, without their passwords
+func ListStaff() (staff []Staff, err error) {
+	staff, err = getStaffUsers()
+	if err != nil {
+		return nil, errors.New("appauth.ListStaff: " + err.Error())
+	}
+	return
+}
+
+// getStaffUser gives sql.ErrNoRows unwrapped if the user does not exist
+func getStaffUser(username string) (hash string, role string, status string, err error) {
+	err = Config.Db.QueryRow("SELECT `password`, `role`, `status` FROM `staff_users` WHERE `username` = ?", username).Scan(&hash, &role, &status)
+	switch {
+	case err == sql.ErrNoRows:
+		return "", "", "", err
+	case err != nil:
+		return "", "", "", errors.New("appauth.getStaffUser: " + err.Error())
+	}
+	return
+}
+
+func getStaffUsers() (staff []Staff, err error) {
+	rows, err := Config.Db.Query("SELECT `username`, `role`, `status`, `createdBy`, `timestamp` FROM `staff_users` ORDER BY `username`")
+	if err != nil {
+		return nil, errors.New("appauth.getStaffUsers: " + err.Error())
+	}
+	defer rows.Close()
+
+	staff = []Staff{}
+	for rows.Next() {
+		s := Staff{}
+		err = rows.Scan(&s.Username, &s.Role, &s.Status, &s.CreatedBy, &s.Timestamp)
+		if err != nil {
+			return nil, errors.New("appauth.getStaffUsers: " + err.Error())
+		}
+		staff = append(staff, s)
+	}
+	return
+}
+
+func saveStaffUser(username string, hash string, role string, createdBy string) (err error) {
+	_, err = Config.Db.Exec("INSERT INTO `staff_users` (`username`, `password`, `role`, `status`, `createdBy`, `timestamp`) VALUES (?, ?, ?, ?, ?, ?)",
+		username, hash, role, STAFF_STATUS_ACTIVE, createdBy, time.Now().Unix())
+	if err != nil {
+		return errors.New("appauth.saveStaffUser: " + err.Error())
+	}
+	return
+}
+
+func updateStaffRole(username string, role string) (err error) {
+	_, err = Config.Db.Exec("UPDATE `staff_users` SET `role` = ?, `status` = ?, `timestamp` = ? WHERE `username` = ?", role, STAFF_STATUS_ACTIVE, time.Now().Unix(), username)
+	if err != nil {
+		return errors.New("appauth.updateStaffRole: " + err.Error())
+	}
+	return
+}
+
+func updateStaffStatus(username string, status string) (err error) {
+	_, err = Config.Db.Exec("UPDATE `staff_users` SET `status` = ?, `timestamp` = ? WHERE `username` = ?", status, time.Now().Unix(), username)
+	if err != nil {
+		return errors.New("appauth.updateStaffStatus: " + err.Error())
+	}
+	return
+}
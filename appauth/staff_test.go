@@ -0,0 +1,283 @@
+package appauth
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/bvnk/bank/audit"
+)
+
+func TestHasPermission(t *testing.T) {
+	tests := []struct {
+		role       string
+		permission string
+		want       bool
+	}{
+		{ROLE_TELLER, PERMISSION_DEPOSIT, true},
+		{ROLE_TELLER, PERMISSION_OVERDRAFT, false},
+		{ROLE_TELLER, PERMISSION_STAFF, false},
+		{ROLE_OPS, PERMISSION_EOD, true},
+		{ROLE_OPS, PERMISSION_FREEZE, false},
+		{ROLE_COMPLIANCE, PERMISSION_FREEZE, true},
+		{ROLE_COMPLIANCE, PERMISSION_DEPOSIT, false},
+		{ROLE_ADMIN, PERMISSION_STAFF, true},
+		{ROLE_ADMIN, PERMISSION_AUDIT, true},
+		{"", PERMISSION_DEPOSIT, false},
+		{STAFF_STATUS_DISABLED, PERMISSION_DEPOSIT, false},
+	}
+
+	for _, test := range tests {
+		got := HasPermission(test.role, test.permission)
+		if got != test.want {
+			t.Errorf("HasPermission %v %v does not pass. Looking for %v, got %v", test.role, test.permission, test.want, got)
+		}
+	}
+}
+
+func TestValidRole(t *testing.T) {
+	for _, role := range []string{ROLE_TELLER, ROLE_OPS, ROLE_COMPLIANCE, ROLE_ADMIN} {
+		if !validRole(role) {
+			t.Errorf("ValidRole %v does not pass. Looking for %v, got %v", role, true, false)
+		}
+	}
+	for _, role := range []string{"", "root", STAFF_STATUS_DISABLED} {
+		if validRole(role) {
+			t.Errorf("ValidRole %v does not pass. Looking for %v, got %v", role, false, true)
+		}
+	}
+}
+
+func TestCheckPermissionShortCommand(t *testing.T) {
+	if _, err := CheckPermission([]string{"user"}, PERMISSION_DEPOSIT); err == nil {
+		t.Errorf("CheckPermissionShortCommand does not pass. Looking for %v, got %v", "error", err)
+	}
+}
+
+// testDriver is a database answering a query with the row kept for the longest
+// part of it and its first argument, and recording the audit events saved.
+// Updates to the tables in unchanged affect no rows.
+type testDriver struct {
+	mu        sync.Mutex
+	rows      map[string][]driver.Value
+	unchanged []string
+	events    []audit.Event
+}
+
+// testRowKey is the key of the row a testDriver gives for a query containing
+// match, usually a table in backquotes, and the argument
+func testRowKey(match string, arg string) string {
+	return match + " " + arg
+}
+
+func (d *testDriver) Open(name string) (driver.Conn, error) {
+	return testConn{d}, nil
+}
+
+// eventDetails gives the detail of each audit event of a type
+func (d *testDriver) eventDetails(eventType string) (details []string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, event := range d.events {
+		if event.Type == eventType {
+			details = append(details, event.Detail)
+		}
+	}
+	return
+}
+
+// eventActors gives the actor of each audit event of a type
+func (d *testDriver) eventActors(eventType string) (actors []string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, event := range d.events {
+		if event.Type == eventType {
+			actors = append(actors, event.Actor)
+		}
+	}
+	return
+}
+
+type testConn struct {
+	d *testDriver
+}
+
+func (c testConn) Prepare(query string) (driver.Stmt, error) {
+	return testStmt{c.d, query}, nil
+}
+
+func (c testConn) Close() error {
+	return nil
+}
+
+func (c testConn) Begin() (driver.Tx, error) {
+	return testTx{}, nil
+}
+
+type testTx struct{}
+
+func (tx testTx) Commit() error {
+	return nil
+}
+
+func (tx testTx) Rollback() error {
+	return nil
+}
+
+type testStmt struct {
+	d     *testDriver
+	query string
+}
+
+func (s testStmt) Close() error {
+	return nil
+}
+
+func (s testStmt) NumInput() int {
+	return -1
+}
+
+func (s testStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	if strings.Contains(s.query, "`audit_events`") {
+		s.d.events = append(s.d.events, audit.Event{Type: args[0].(string), Actor: args[1].(string), Subject: args[2].(string), RemoteAddr: args[3].(string), Detail: args[4].(string)})
+	}
+	for _, table := range s.d.unchanged {
+		if strings.Contains(s.query, "`"+table+"`") {
+			return driver.RowsAffected(0), nil
+		}
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (s testStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	rows := &testRows{}
+	match := ""
+	for key, row := range s.d.rows {
+		i := strings.LastIndex(key, " ")
+		if len(args) > 0 && len(key[:i]) > len(match) && strings.Contains(s.query, key[:i]) && fmt.Sprint(args[0]) == key[i+1:] {
+			rows, match = &testRows{values: [][]driver.Value{row}}, key[:i]
+		}
+	}
+	return rows, nil
+}
+
+type testRows struct {
+	values [][]driver.Value
+}
+
+func (r *testRows) Columns() []string {
+	columns := []string{}
+	if len(r.values) > 0 {
+		for i := range r.values[0] {
+			columns = append(columns, "c"+strconv.Itoa(i))
+		}
+	}
+	return columns
+}
+
+func (r *testRows) Close() error {
+	return nil
+}
+
+func (r *testRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
+
+var testDrivers = 0
+
+// setTestDB makes d the database of appauth and audit, until restore is called
+func setTestDB(t *testing.T, d *testDriver) (restore func()) {
+	testDrivers++
+	name := "appauth" + strconv.Itoa(testDrivers)
+	sql.Register(name, d)
+	db, err := sql.Open(name, "")
+	if err != nil {
+		t.Fatalf("Could not open database. %v", err)
+	}
+	appauthDb, auditDb := Config.Db, audit.Config.Db
+	Config.Db, audit.Config.Db = db, db
+	return func() { Config.Db, audit.Config.Db = appauthDb, auditDb }
+}
+
+func TestCheckPermissionCertificate(t *testing.T) {
+	setTestStore()
+	d := &testDriver{rows: map[string][]driver.Value{
+		testRowKey("`staff_users`", "analyst"): {"", ROLE_COMPLIANCE, STAFF_STATUS_ACTIVE},
+	}}
+	defer setTestDB(t, d)()
+
+	// The certificate's staff user is who ran the command, not the user typed in it
+	token, _ := store.issue(accessToken{Issued: store.now().Unix(), ClientID: CERTIFICATE_CLIENT_PREFIX + "fingerprint", Staff: "analyst"}, store.IdleTimeout)
+	username, err := CheckPermission([]string{token, "aml", "4", "case", "other", ""}, PERMISSION_AML)
+	if err != nil || username != "analyst" {
+		t.Errorf("CheckPermissionCertificate does not pass. Looking for %v, got %v %v", "analyst", username, err)
+	}
+	if actors := d.eventActors(audit.EVENT_STAFF_ACTION); len(actors) != 1 || actors[0] != "analyst" {
+		t.Errorf("CheckPermissionCertificate audit does not pass. Looking for %v, got %v", "analyst", actors)
+	}
+
+	// Without the permission the certificate does not fall back to the typed user
+	_, err = CheckPermission([]string{token, "eod", "1", "other", "password"}, PERMISSION_EOD)
+	if err == nil {
+		t.Errorf("CheckPermissionCertificate denied does not pass. Looking for %v, got %v", "error", err)
+	}
+}
+
+func TestAuthorizeStaffAttempts(t *testing.T) {
+	setTestStore()
+	hash, err := hashPassword("correct horse battery")
+	if err != nil {
+		t.Fatalf("Could not hash password. %v", err)
+	}
+	d := &testDriver{rows: map[string][]driver.Value{
+		testRowKey("`staff_users`", "teller"): {hash, ROLE_TELLER, STAFF_STATUS_ACTIVE},
+	}}
+	defer setTestDB(t, d)()
+
+	// Wrong passwords are counted as the staff user, not a customer of the same name
+	for i := 0; i < LOGIN_BACKOFF_AFTER_USER+1; i++ {
+		if _, err := AuthorizeStaff("teller", "wrong", PERMISSION_DEPOSIT, "10.0.0.1"); err == nil {
+			t.Fatalf("AuthorizeStaffAttempts wrong password does not pass. Looking for %v, got %v", "error", err)
+		}
+	}
+	if actors := d.eventActors(audit.EVENT_LOGIN_FAILED); len(actors) != LOGIN_BACKOFF_AFTER_USER+1 || actors[0] != STAFF_LOGIN_PREFIX+"teller" {
+		t.Errorf("AuthorizeStaffAttempts audit does not pass. Looking for %v, got %v", STAFF_LOGIN_PREFIX+"teller", actors)
+	}
+	if _, found, _ := store.kv.Get(userLoginKey(LOGIN_FAILURES_PREFIX, "teller")); found {
+		t.Errorf("AuthorizeStaffAttempts customer does not pass. Looking for %v, got %v", false, found)
+	}
+
+	// Backing off refuses even the right password
+	if _, err := AuthorizeStaff("teller", "correct horse battery", PERMISSION_DEPOSIT, "10.0.0.1"); err == nil {
+		t.Errorf("AuthorizeStaffAttempts backoff does not pass. Looking for %v, got %v", "error", err)
+	}
+
+	UnlockLogin(STAFF_LOGIN_PREFIX+"teller", "admin")
+	token, err := AuthorizeStaff("teller", "correct horse battery", PERMISSION_DEPOSIT, "10.0.0.1")
+	if err != nil || token == "" {
+		t.Fatalf("AuthorizeStaffAttempts unlocked does not pass. Looking for %v, got %v", "token", err)
+	}
+
+	// The token stands in for the password, with the role it was given
+	username, err := CheckPermission([]string{token, "pain", "1000", "account", "other", ""}, PERMISSION_DEPOSIT)
+	if err != nil || username != "teller" {
+		t.Errorf("AuthorizeStaffAttempts token does not pass. Looking for %v, got %v %v", "teller", username, err)
+	}
+	if _, err := CheckPermission([]string{token, "eod", "1", "other", ""}, PERMISSION_EOD); err == nil {
+		t.Errorf("AuthorizeStaffAttempts token role does not pass. Looking for %v, got %v", "error", err)
+	}
+}
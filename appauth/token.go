@@ -0,0 +1,367 @@
+package appauth
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/redis.v3"
+
+	"github.com/bvnk/bank/configuration"
+	"github.com/satori/go.uuid"
+)
+
+const (
+	// An access token lasts this long however it is used
+	TOKEN_MAX_TTL = time.Hour // One hour
+
+	TOKEN_PREFIX = "token:"
+)
+
+// KeyValue is what tokens and sessions are kept in, Redis outside of tests
+type KeyValue interface {
+	// Get gives found false if the key does not exist or expired
+	Get(key string) (value string, found bool, err error)
+	// Set with a ttl of 0 keeps the key until it is removed
+	Set(key string, value string, ttl time.Duration) error
+	Incr(key string) (value int64, err error)
+	Expire(key string, ttl time.Duration) error
+	Del(keys ...string) error
+	SAdd(key string, member string) error
+	SMembers(key string) (members []string, err error)
+	SRem(key string, member string) error
+	// CompareAndSet sets a key only if it still has the value old, an empty
+	// old meaning it does not exist, as one step
+	CompareAndSet(key string, old string, value string, ttl time.Duration) (set bool, err error)
+}
+
+// compareAndSetScript is CompareAndSet for Redis. A script runs without any
+// other command between its GET and SET.
+const compareAndSetScript = `
+local current = redis.call('GET', KEYS[1]) or ''
+if current ~= ARGV[1] then
+	return 0
+end
+if ARGV[3] == '0' then
+	redis.call('SET', KEYS[1], ARGV[2])
+else
+	redis.call('SET', KEYS[1], ARGV[2], 'PX', ARGV[3])
+end
+return 1
+`
+
+// redisKeyValue keeps tokens in Redis
+type redisKeyValue struct {
+	client *redis.Client
+}
+
+func (r redisKeyValue) Get(key string) (value string, found bool, err error) {
+	value, err = r.client.Get(key).Result()
+	if err == redis.Nil {
+		return "", false, nil
+	} else if err != nil {
+		return "", false, err
+	}
+	return value, true, nil
+}
+
+func (r redisKeyValue) Set(key string, value string, ttl time.Duration) error {
+	return r.client.Set(key, value, ttl).Err()
+}
+
+func (r redisKeyValue) Incr(key string) (value int64, err error) {
+	return r.client.Incr(key).Result()
+}
+
+func (r redisKeyValue) Expire(key string, ttl time.Duration) error {
+	return r.client.Expire(key, ttl).Err()
+}
+
+func (r redisKeyValue) Del(keys ...string) error {
+	return r.client.Del(keys...).Err()
+}
+
+func (r redisKeyValue) SAdd(key string, member string) error {
+	return r.client.SAdd(key, member).Err()
+}
+
+func (r redisKeyValue) SMembers(key string) (members []string, err error) {
+	return r.client.SMembers(key).Result()
+}
+
+func (r redisKeyValue) SRem(key string, member string) error {
+	return r.client.SRem(key, member).Err()
+}
+
+func (r redisKeyValue) CompareAndSet(key string, old string, value string, ttl time.Duration) (set bool, err error) {
+	result, err := r.client.Eval(compareAndSetScript, []string{key}, []string{old, value, strconv.FormatInt(int64(ttl/time.Millisecond), 10)}).Result()
+	if err != nil {
+		return false, err
+	}
+	return result == int64(1), nil
+}
+
+// TokenStore issues access tokens and expires them. A token expires once it is
+// unused for IdleTimeout, and AbsoluteTimeout after it was issued however much
+// it is used. In TOKEN_MODE_JWT tokens are signed instead of kept, and as they
+// cannot be extended they expire IdleTimeout after they were issued.
+type TokenStore struct {
+	kv              KeyValue
+	IdleTimeout     time.Duration
+	AbsoluteTimeout time.Duration
+	now             func() time.Time
+	// JWTs are checked whenever there are keys, but only issued in TOKEN_MODE_JWT,
+	// so tokens from before the mode changed keep working
+	issueJWT  bool
+	jwt       *jwtSigner
+	configErr error
+}
+
+// accessToken is what a token is stored as. Tokens from a merchant's API key
+// have its ClientID and act for the user who created the key, limited to the
+// merchant and the Scopes asked for. A staff user's client certificate gives
+// tokens with no user, only the Staff user. A staff user who gave their
+// password for an HTTP request gets one with their StaffRole too, for the
+// request only.
+type accessToken struct {
+	UserID     string
+	SessionID  string
+	Issued     int64
+	ClientID   string   `json:",omitempty"`
+	MerchantID string   `json:",omitempty"`
+	Scopes     []string `json:",omitempty"`
+	Staff      string   `json:",omitempty"`
+	StaffRole  string   `json:",omitempty"`
+}
+
+// NewTokenStore gives a store with the timeouts from config, defaulting to
+// TOKEN_TTL and TOKEN_MAX_TTL. If the token mode or JWT keys are not valid no
+// tokens are issued, and the reason is given each time.
+func NewTokenStore(kv KeyValue, config configuration.Auth) *TokenStore {
+	store := &TokenStore{
+		kv:              kv,
+		IdleTimeout:     TOKEN_TTL,
+		AbsoluteTimeout: TOKEN_MAX_TTL,
+		now:             time.Now,
+	}
+	if config.IdleTimeoutMinutes > 0 {
+		store.IdleTimeout = time.Duration(config.IdleTimeoutMinutes) * time.Minute
+	}
+	if config.AbsoluteTimeoutMinutes > 0 {
+		store.AbsoluteTimeout = time.Duration(config.AbsoluteTimeoutMinutes) * time.Minute
+	}
+	// A token cannot idle past its absolute expiry
+	if store.IdleTimeout > store.AbsoluteTimeout {
+		store.IdleTimeout = store.AbsoluteTimeout
+	}
+
+	switch config.TokenMode {
+	case "", TOKEN_MODE_OPAQUE:
+	case TOKEN_MODE_JWT:
+		store.issueJWT = true
+	default:
+		store.configErr = errors.New("Token mode not valid, must be one of opaque, jwt")
+	}
+	if store.configErr == nil && (store.issueJWT || len(config.JWTKeys) > 0) {
+		store.jwt, store.configErr = newJWTSigner(config)
+	}
+	return store
+}
+
+func tokenKey(token string) string {
+	return TOKEN_PREFIX + token
+}
+
+// Issue gives a new token for a user's session
+func (s *TokenStore) Issue(userID string, sessionID string) (token string, err error) {
+	token, err = s.issue(accessToken{UserID: userID, SessionID: sessionID, Issued: s.now().Unix()}, s.IdleTimeout)
+	if err != nil {
+		return "", errors.New("appauth.TokenStore.Issue: " + err.Error())
+	}
+	return
+}
+
+// IssueClient gives a new token for a merchant's API key. It lasts the absolute
+// timeout, however it is used, as the key can always get another.
+func (s *TokenStore) IssueClient(userID string, merchantID string, clientID string, scopes []string) (token string, err error) {
+	token, err = s.issue(accessToken{UserID: userID, Issued: s.now().Unix(), ClientID: clientID, MerchantID: merchantID, Scopes: scopes}, s.AbsoluteTimeout)
+	if err != nil {
+		return "", errors.New("appauth.TokenStore.IssueClient: " + err.Error())
+	}
+	return
+}
+
+func (s *TokenStore) issue(stored accessToken, ttl time.Duration) (token string, err error) {
+	if s.configErr != nil {
+		return "", errors.New("Token settings not valid. " + s.configErr.Error())
+	}
+
+	if s.issueJWT {
+		return s.jwt.sign(jwtClaims{
+			Subject:    stored.UserID,
+			ID:         uuid.NewV4().String(),
+			IssuedAt:   stored.Issued,
+			Expires:    stored.Issued + int64(ttl/time.Second),
+			SessionID:  stored.SessionID,
+			ClientID:   stored.ClientID,
+			MerchantID: stored.MerchantID,
+			Scope:      strings.Join(stored.Scopes, " "),
+			Staff:      stored.Staff,
+			StaffRole:  stored.StaffRole,
+		})
+	}
+
+	token = uuid.NewV4().String()
+	value, err := json.Marshal(stored)
+	if err != nil {
+		return "", errors.New("Could not encode token. " + err.Error())
+	}
+	err = s.kv.Set(tokenKey(token), string(value), ttl)
+	if err != nil {
+		return "", errors.New("Could not set token. " + err.Error())
+	}
+	return
+}
+
+// Validate gives the user a token was issued to, if it has not expired
+func (s *TokenStore) Validate(token string) (userID string, err error) {
+	stored, err := s.get(token)
+	if err != nil {
+		return "", errors.New("appauth.TokenStore.Validate: " + err.Error())
+	}
+	return stored.UserID, nil
+}
+
+// Touch extends a token by the idle timeout, but never past its absolute expiry.
+// Merchant client tokens and JWTs are not extended.
+func (s *TokenStore) Touch(token string) (err error) {
+	if isJWT(token) {
+		return
+	}
+
+	stored, err := s.get(token)
+	if err != nil {
+		return errors.New("appauth.TokenStore.Touch: " + err.Error())
+	}
+	if stored.ClientID != "" {
+		return
+	}
+
+	ttl := s.touchTTL(stored)
+	err = s.kv.Expire(tokenKey(token), ttl)
+	if err != nil {
+		return errors.New("appauth.TokenStore.Touch: Could not extend token. " + err.Error())
+	}
+	return
+}
+
+// Revoke removes a token, or denies a JWT until it would have expired.
+// Revoking one that already expired is not an error.
+func (s *TokenStore) Revoke(token string) (err error) {
+	if isJWT(token) {
+		err = s.denyJWT(token)
+		if err != nil {
+			return errors.New("appauth.TokenStore.Revoke: " + err.Error())
+		}
+		return
+	}
+
+	err = s.kv.Del(tokenKey(token))
+	if err != nil {
+		return errors.New("appauth.TokenStore.Revoke: Could not remove token. " + err.Error())
+	}
+	return
+}
+
+func (s *TokenStore) get(token string) (stored accessToken, err error) {
+	if isJWT(token) {
+		return s.getJWT(token)
+	}
+
+	value, found, err := s.kv.Get(tokenKey(token))
+	if err != nil {
+		return accessToken{}, errors.New("Could not get token. " + err.Error())
+	}
+	if !found {
+		return accessToken{}, errors.New("Token not found")
+	}
+
+	err = json.Unmarshal([]byte(value), &stored)
+	if err != nil {
+		return accessToken{}, errors.New("Could not decode token. " + err.Error())
+	}
+
+	// The key outlives the absolute expiry if the store was configured longer before
+	if s.touchTTL(stored) <= 0 {
+		_ = s.kv.Del(tokenKey(token))
+		return accessToken{}, errors.New("Token expired")
+	}
+	return
+}
+
+// getJWT checks a JWT's signature and expiry, and that it was not revoked,
+// which is the only lookup made for it
+func (s *TokenStore) getJWT(token string) (stored accessToken, err error) {
+	if s.jwt == nil {
+		return accessToken{}, errors.New("Token not found")
+	}
+	claims, err := s.jwt.verify(token)
+	if err != nil {
+		return accessToken{}, err
+	}
+	if s.now().Unix() >= claims.Expires {
+		return accessToken{}, errors.New("Token expired")
+	}
+
+	_, revoked, err := s.kv.Get(JWT_DENYLIST_PREFIX + claims.ID)
+	if err != nil {
+		return accessToken{}, errors.New("Could not check token. " + err.Error())
+	}
+	if revoked {
+		return accessToken{}, errors.New("Token revoked")
+	}
+
+	return accessToken{
+		UserID:     claims.Subject,
+		SessionID:  claims.SessionID,
+		Issued:     claims.IssuedAt,
+		ClientID:   claims.ClientID,
+		MerchantID: claims.MerchantID,
+		Scopes:     strings.Fields(claims.Scope),
+		Staff:      claims.Staff,
+		StaffRole:  claims.StaffRole,
+	}, nil
+}
+
+// denyJWT keeps a JWT's ID until it would have expired. One that is not ours
+// or has expired is left alone.
+func (s *TokenStore) denyJWT(token string) (err error) {
+	if s.jwt == nil {
+		return
+	}
+	claims, err := s.jwt.verify(token)
+	if err != nil {
+		return nil
+	}
+	ttl := time.Unix(claims.Expires, 0).Sub(s.now())
+	if ttl <= 0 {
+		return
+	}
+
+	err = s.kv.Set(JWT_DENYLIST_PREFIX+claims.ID, "1", ttl)
+	if err != nil {
+		return errors.New("Could not deny token. " + err.Error())
+	}
+	return
+}
+
+// touchTTL is how much longer a token may live if it is used now
+func (s *TokenStore) touchTTL(stored accessToken) time.Duration {
+	remaining := time.Unix(stored.Issued, 0).Add(s.AbsoluteTimeout).Sub(s.now())
+	if remaining < s.IdleTimeout {
+		return remaining
+	}
+	return s.IdleTimeout
+}
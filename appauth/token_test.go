@@ -0,0 +1,222 @@
+package appauth
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bvnk/bank/configuration"
+)
+
+// memoryKeyValue stands in for Redis, with expiry on a clock the test moves.
+// Each call holds the lock, as Redis runs one command at a time.
+type memoryKeyValue struct {
+	mu      sync.Mutex
+	now     time.Time
+	values  map[string]string
+	sets    map[string]map[string]bool
+	expires map[string]time.Time
+}
+
+func newMemoryKeyValue() *memoryKeyValue {
+	return &memoryKeyValue{
+		now:     time.Unix(1480000000, 0),
+		values:  map[string]string{},
+		sets:    map[string]map[string]bool{},
+		expires: map[string]time.Time{},
+	}
+}
+
+func (m *memoryKeyValue) expired(key string) bool {
+	expires, ok := m.expires[key]
+	if ok && !m.now.Before(expires) {
+		delete(m.values, key)
+		delete(m.sets, key)
+		delete(m.expires, key)
+		return true
+	}
+	return false
+}
+
+func (m *memoryKeyValue) Get(key string) (string, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.expired(key) {
+		return "", false, nil
+	}
+	value, ok := m.values[key]
+	return value, ok, nil
+}
+
+func (m *memoryKeyValue) Set(key string, value string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.values[key] = value
+	delete(m.expires, key)
+	if ttl > 0 {
+		m.expires[key] = m.now.Add(ttl)
+	}
+	return nil
+}
+
+func (m *memoryKeyValue) Incr(key string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expired(key)
+	value, _ := strconv.ParseInt(m.values[key], 10, 64)
+	value++
+	m.values[key] = strconv.FormatInt(value, 10)
+	return value, nil
+}
+
+func (m *memoryKeyValue) Expire(key string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.expired(key) {
+		return nil
+	}
+	_, isValue := m.values[key]
+	_, isSet := m.sets[key]
+	if isValue || isSet {
+		m.expires[key] = m.now.Add(ttl)
+	}
+	return nil
+}
+
+func (m *memoryKeyValue) Del(keys ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, key := range keys {
+		delete(m.values, key)
+		delete(m.sets, key)
+		delete(m.expires, key)
+	}
+	return nil
+}
+
+func (m *memoryKeyValue) SAdd(key string, member string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expired(key)
+	if m.sets[key] == nil {
+		m.sets[key] = map[string]bool{}
+	}
+	m.sets[key][member] = true
+	return nil
+}
+
+func (m *memoryKeyValue) SMembers(key string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expired(key)
+	members := []string{}
+	for member := range m.sets[key] {
+		members = append(members, member)
+	}
+	return members, nil
+}
+
+func (m *memoryKeyValue) SRem(key string, member string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.sets[key], member)
+	return nil
+}
+
+func (m *memoryKeyValue) CompareAndSet(key string, old string, value string, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expired(key)
+	if m.values[key] != old {
+		return false, nil
+	}
+	m.values[key] = value
+	delete(m.expires, key)
+	if ttl > 0 {
+		m.expires[key] = m.now.Add(ttl)
+	}
+	return true, nil
+}
+
+// setTestStore keeps tokens in memory for the test, with 15 minute idle and 60 minute absolute timeouts
+func setTestStore() *memoryKeyValue {
+	kv := newMemoryKeyValue()
+	store = NewTokenStore(kv, configuration.Auth{IdleTimeoutMinutes: 15, AbsoluteTimeoutMinutes: 60})
+	store.now = func() time.Time { return kv.now }
+	return kv
+}
+
+func TestNewTokenStore(t *testing.T) {
+	s := NewTokenStore(newMemoryKeyValue(), configuration.Auth{})
+	if s.IdleTimeout != TOKEN_TTL || s.AbsoluteTimeout != TOKEN_MAX_TTL {
+		t.Errorf("NewTokenStore defaults does not pass. Looking for %v %v, got %v %v", TOKEN_TTL, TOKEN_MAX_TTL, s.IdleTimeout, s.AbsoluteTimeout)
+	}
+
+	s = NewTokenStore(newMemoryKeyValue(), configuration.Auth{IdleTimeoutMinutes: 120, AbsoluteTimeoutMinutes: 30})
+	if s.IdleTimeout != 30*time.Minute {
+		t.Errorf("NewTokenStore idle past absolute does not pass. Looking for %v, got %v", 30*time.Minute, s.IdleTimeout)
+	}
+}
+
+func TestTokenIdleTimeout(t *testing.T) {
+	kv := setTestStore()
+
+	token, err := store.Issue("user", "session")
+	if err != nil {
+		t.Fatalf("TokenIdleTimeout Issue does not pass. Looking for %v, got %v", nil, err)
+	}
+
+	// Used every ten minutes it slides past the idle timeout
+	for i := 0; i < 3; i++ {
+		kv.now = kv.now.Add(10 * time.Minute)
+		user, err := GetUserFromToken(token)
+		if err != nil || user != "user" {
+			t.Fatalf("TokenIdleTimeout slide does not pass. Looking for %v, got %v %v", "user", user, err)
+		}
+	}
+
+	kv.now = kv.now.Add(15 * time.Minute)
+	if err := CheckToken(token); err == nil {
+		t.Errorf("TokenIdleTimeout idle does not pass. Looking for %v, got %v", "error", err)
+	}
+
+	// Nothing is written under the user's name
+	if _, found, _ := kv.Get("user"); found {
+		t.Errorf("TokenIdleTimeout user key does not pass. Looking for %v, got %v", "no key", kv.values["user"])
+	}
+}
+
+func TestTokenAbsoluteTimeout(t *testing.T) {
+	kv := setTestStore()
+
+	token, _ := store.Issue("user", "session")
+	for i := 0; i < 5; i++ {
+		kv.now = kv.now.Add(10 * time.Minute)
+		if err := CheckToken(token); err != nil {
+			t.Fatalf("TokenAbsoluteTimeout does not pass after %v minutes. Looking for %v, got %v", (i+1)*10, nil, err)
+		}
+	}
+
+	// Touching at 50 minutes only extends to the absolute expiry at 60
+	if expires := kv.expires[tokenKey(token)]; !expires.Equal(time.Unix(1480000000, 0).Add(time.Hour)) {
+		t.Errorf("TokenAbsoluteTimeout touch does not pass. Looking for %v, got %v", "expiry at 60 minutes", expires)
+	}
+
+	kv.now = kv.now.Add(10 * time.Minute)
+	if err := CheckToken(token); err == nil {
+		t.Errorf("TokenAbsoluteTimeout expired does not pass. Looking for %v, got %v", "error", err)
+	}
+}
+
+func TestTokenRevoke(t *testing.T) {
+	setTestStore()
+
+	token, _ := store.Issue("user", "session")
+	if _, err := RemoveToken(token); err != nil {
+		t.Fatalf("TokenRevoke does not pass. Looking for %v, got %v", nil, err)
+	}
+	if err := CheckToken(token); err == nil {
+		t.Errorf("TokenRevoke check does not pass. Looking for %v, got %v", "error", err)
+	}
+}
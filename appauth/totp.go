@@ -0,0 +1,573 @@
+package appauth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/satori/go.uuid"
+)
+
+const (
+	// RFC 6238 defaults, which authenticator apps expect
+	TOTP_DIGITS = 6
+	TOTP_PERIOD = 30 // Seconds
+	// Codes from one period either side are accepted, for clock drift
+	TOTP_SKEW        = 1
+	TOTP_SECRET_SIZE = 20 // Bytes, the size of a SHA-1 key
+	TOTP_ISSUER      = "BVNK"
+
+	TOTP_STATUS_PENDING = "pending"
+	TOTP_STATUS_ACTIVE  = "active"
+
+	RECOVERY_CODE_COUNT = 10
+
+	// The second step of logging in must be done within this time and attempts
+	LOGIN_CHALLENGE_TTL      = 5 * time.Minute
+	LOGIN_CHALLENGE_ATTEMPTS = 5
+	// A step-up code authorises one payment within this time
+	STEP_UP_TTL = 5 * time.Minute
+
+	LOGIN_CHALLENGE_PREFIX = "challenge:"
+	STEP_UP_PREFIX         = "stepup:"
+)
+
+// TOTPEnrolment is what an authenticator app needs to generate codes.
+// URI is usually shown as a QR code.
+type TOTPEnrolment struct {
+	Secret string
+	URI    string
+}
+
+// loginChallenge is a login waiting for its one-time code
+type loginChallenge struct {
+	UserID   string
+	AuthUser string
+	Device   string
+	Attempts int
+}
+
+// totpCode gives the code for a time step, as in RFC 4226 with the step as the counter
+func totpCode(secret []byte, step int64) string {
+	counter := make([]byte, 8)
+	binary.BigEndian.PutUint64(counter, uint64(step))
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(counter)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	modulo := uint32(1)
+	for i := 0; i < TOTP_DIGITS; i++ {
+		modulo *= 10
+	}
+	return fmt.Sprintf("%0*d", TOTP_DIGITS, value%modulo)
+}
+
+func totpStep(t time.Time) int64 {
+	return t.Unix() / TOTP_PERIOD
+}
+
+// verifyTOTP finds the step a code is for. Steps up to lastStep were already
+// used and are refused, so a code cannot be replayed.
+func verifyTOTP(secret []byte, code string, t time.Time, lastStep int64) (step int64, ok bool) {
+	current := totpStep(t)
+	for step = current - TOTP_SKEW; step <= current+TOTP_SKEW; step++ {
+		if step <= lastStep {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(totpCode(secret, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+func newTOTPSecret() (secret string, err error) {
+	key := make([]byte, TOTP_SECRET_SIZE)
+	_, err = rand.Read(key)
+	if err != nil {
+		return "", errors.New("appauth.newTOTPSecret: " + err.Error())
+	}
+	return base32.StdEncoding.EncodeToString(key), nil
+}
+
+func decodeTOTPSecret(secret string) ([]byte, error) {
+	return base32.StdEncoding.DecodeString(secret)
+}
+
+// totpURI is the key URI format authenticator apps read from a QR code
+func totpURI(authUser string, secret string) string {
+	label := url.PathEscape(TOTP_ISSUER + ":" + authUser)
+	values := url.Values{}
+	values.Set("secret", secret)
+	values.Set("issuer", TOTP_ISSUER)
+	values.Set("algorithm", "SHA1")
+	values.Set("digits", fmt.Sprintf("%d", TOTP_DIGITS))
+	values.Set("period", fmt.Sprintf("%d", TOTP_PERIOD))
+	return "otpauth://totp/" + label + "?" + values.Encode()
+}
+
+// newRecoveryCodes gives codes to show the user once, and the hashes to keep
+func newRecoveryCodes() (codes []string, hashes []string, err error) {
+	for i := 0; i < RECOVERY_CODE_COUNT; i++ {
+		random := make([]byte, 5)
+		_, err = rand.Read(random)
+		if err != nil {
+			return nil, nil, errors.New("appauth.newRecoveryCodes: " + err.Error())
+		}
+		// 40 bits as 8 base32 characters, split to be easier to copy
+		code := strings.ToLower(base32.StdEncoding.EncodeToString(random))
+		code = code[:4] + "-" + code[4:]
+		codes = append(codes, code)
+		hashes = append(hashes, recoveryCodeHash(code))
+	}
+	return
+}
+
+func recoveryCodeHash(code string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(code))))
+	return hex.EncodeToString(sum[:])
+}
+
+// EnrolTOTP starts two-factor authentication for the token's user. It is only
+// used once confirmed with a code, enrolling again before then replaces the secret.
+func EnrolTOTP(token string) (enrolment TOTPEnrolment, err error) {
+	userID, err := GetUserFromToken(token)
+	if err != nil {
+		return TOTPEnrolment{}, errors.New("appauth.EnrolTOTP: " + err.Error())
+	}
+	authUser, err := getAuthUser(userID)
+	if err != nil {
+		return TOTPEnrolment{}, errors.New("appauth.EnrolTOTP: " + err.Error())
+	}
+
+	_, status, _, err := getTOTP(authUser)
+	if err != nil {
+		return TOTPEnrolment{}, errors.New("appauth.EnrolTOTP: " + err.Error())
+	}
+	if status == TOTP_STATUS_ACTIVE {
+		return TOTPEnrolment{}, errors.New("appauth.EnrolTOTP: Two-factor authentication already enabled")
+	}
+
+	secret, err := newTOTPSecret()
+	if err != nil {
+		return TOTPEnrolment{}, errors.New("appauth.EnrolTOTP: " + err.Error())
+	}
+	err = savePendingTOTP(authUser, secret)
+	if err != nil {
+		return TOTPEnrolment{}, errors.New("appauth.EnrolTOTP: " + err.Error())
+	}
+
+	enrolment = TOTPEnrolment{Secret: secret, URI: totpURI(authUser, secret)}
+	return
+}
+
+// ConfirmTOTP turns on two-factor authentication with a code from the
+// authenticator, and gives the recovery codes. They are not shown again.
+func ConfirmTOTP(token string, code string) (recoveryCodes []string, err error) {
+	userID, err := GetUserFromToken(token)
+	if err != nil {
+		return nil, errors.New("appauth.ConfirmTOTP: " + err.Error())
+	}
+	authUser, err := getAuthUser(userID)
+	if err != nil {
+		return nil, errors.New("appauth.ConfirmTOTP: " + err.Error())
+	}
+
+	secret, status, lastStep, err := getTOTP(authUser)
+	if err != nil {
+		return nil, errors.New("appauth.ConfirmTOTP: " + err.Error())
+	}
+	if status != TOTP_STATUS_PENDING {
+		return nil, errors.New("appauth.ConfirmTOTP: No two-factor enrolment to confirm")
+	}
+
+	step, err := checkTOTP(secret, code, lastStep)
+	if err != nil {
+		return nil, errors.New("appauth.ConfirmTOTP: " + err.Error())
+	}
+
+	recoveryCodes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, errors.New("appauth.ConfirmTOTP: " + err.Error())
+	}
+	err = activateTOTP(authUser, step, hashes)
+	if err != nil {
+		return nil, errors.New("appauth.ConfirmTOTP: " + err.Error())
+	}
+	return
+}
+
+// DisableTOTP turns two-factor authentication off, with a code or a recovery
+// code. Wrong codes count towards locking the user's login.
+func DisableTOTP(token string, code string, remoteAddr string) (result string, err error) {
+	userID, err := GetUserFromToken(token)
+	if err != nil {
+		return "", errors.New("appauth.DisableTOTP: " + err.Error())
+	}
+	authUser, err := getAuthUser(userID)
+	if err != nil {
+		return "", errors.New("appauth.DisableTOTP: " + err.Error())
+	}
+
+	err = checkCode(authUser, code, true, remoteAddr, "wrong one-time code to disable two-factor")
+	if err != nil {
+		return "", errors.New("appauth.DisableTOTP: " + err.Error())
+	}
+	err = removeTOTP(authUser)
+	if err != nil {
+		return "", errors.New("appauth.DisableTOTP: " + err.Error())
+	}
+	return "Two-factor authentication disabled", nil
+}
+
+// createLoginChallenge holds a login with a correct password until its code is given
+func createLoginChallenge(userID string, authUser string, device string) (challengeID string, err error) {
+	challengeID = uuid.NewV4().String()
+	err = saveLoginChallenge(challengeID, loginChallenge{UserID: userID, AuthUser: authUser, Device: device})
+	if err != nil {
+		return "", errors.New("appauth.createLoginChallenge: " + err.Error())
+	}
+	return
+}
+
+// LoginTOTP is the second step of logging in, with a code or a recovery code.
+// Wrong codes count towards locking the login as wrong passwords do, and the
+// user's failures are only forgotten once a code is right.
+func LoginTOTP(challengeID string, code string, remoteAddr string) (tokens Tokens, err error) {
+	value, found, err := store.kv.Get(LOGIN_CHALLENGE_PREFIX + challengeID)
+	if err != nil {
+		return Tokens{}, errors.New("appauth.LoginTOTP: Could not get login. " + err.Error())
+	}
+	if !found {
+		return Tokens{}, errors.New("appauth.LoginTOTP: Login expired, log in again")
+	}
+	challenge := loginChallenge{}
+	err = json.Unmarshal([]byte(value), &challenge)
+	if err != nil {
+		return Tokens{}, errors.New("appauth.LoginTOTP: Could not decode login. " + err.Error())
+	}
+
+	err = checkLoginAllowed(challenge.AuthUser, remoteAddr)
+	if err != nil {
+		return Tokens{}, errors.New("appauth.LoginTOTP: " + err.Error())
+	}
+
+	ok, err := checkSecondFactor(challenge.AuthUser, code, true)
+	if err != nil {
+		return Tokens{}, errors.New("appauth.LoginTOTP: " + err.Error())
+	}
+	if !ok {
+		err = recordLoginFailure(challenge.AuthUser, remoteAddr, "wrong one-time code")
+		if err != nil {
+			return Tokens{}, errors.New("appauth.LoginTOTP: " + err.Error())
+		}
+		challenge.Attempts++
+		if challenge.Attempts >= LOGIN_CHALLENGE_ATTEMPTS {
+			_ = store.kv.Del(LOGIN_CHALLENGE_PREFIX + challengeID)
+			return Tokens{}, errors.New("appauth.LoginTOTP: Too many attempts, log in again")
+		}
+		_ = saveLoginChallenge(challengeID, challenge)
+		return Tokens{}, errors.New("appauth.LoginTOTP: Code invalid")
+	}
+
+	err = store.kv.Del(LOGIN_CHALLENGE_PREFIX + challengeID)
+	if err != nil {
+		return Tokens{}, errors.New("appauth.LoginTOTP: Could not remove login. " + err.Error())
+	}
+	err = clearLoginFailures(challenge.AuthUser)
+	if err != nil {
+		return Tokens{}, errors.New("appauth.LoginTOTP: " + err.Error())
+	}
+	tokens, err = createSession(challenge.UserID, challenge.Device)
+	if err != nil {
+		return Tokens{}, errors.New("appauth.LoginTOTP: " + err.Error())
+	}
+	return
+}
+
+// Saving after a wrong code restarts the expiry, the attempts still limit the login
+func saveLoginChallenge(challengeID string, challenge loginChallenge) (err error) {
+	value, err := json.Marshal(challenge)
+	if err != nil {
+		return errors.New("appauth.saveLoginChallenge: Could not encode login. " + err.Error())
+	}
+	err = store.kv.Set(LOGIN_CHALLENGE_PREFIX+challengeID, string(value), LOGIN_CHALLENGE_TTL)
+	if err != nil {
+		return errors.New("appauth.saveLoginChallenge: Could not set login. " + err.Error())
+	}
+	return
+}
+
+// StepUp confirms the token's user is present with a code, authorising the next
+// payment that needs it. Wrong codes count towards locking the user's login, so
+// a stolen token cannot be used to try every code.
+func StepUp(token string, code string, remoteAddr string) (result string, err error) {
+	userID, err := GetUserFromToken(token)
+	if err != nil {
+		return "", errors.New("appauth.StepUp: " + err.Error())
+	}
+	authUser, err := getAuthUser(userID)
+	if err != nil {
+		return "", errors.New("appauth.StepUp: " + err.Error())
+	}
+
+	// Recovery codes are for getting back in, not for approving payments
+	err = checkCode(authUser, code, false, remoteAddr, "wrong step-up code")
+	if err != nil {
+		return "", errors.New("appauth.StepUp: " + err.Error())
+	}
+
+	err = store.kv.Set(STEP_UP_PREFIX+token, userID, STEP_UP_TTL)
+	if err != nil {
+		return "", errors.New("appauth.StepUp: Could not set step-up. " + err.Error())
+	}
+	return "Step-up confirmed", nil
+}
+
+// CheckStepUp uses up the step-up of the token's user. Users without two-factor
+// authentication have nothing to step up with and pass, as do merchant client
+// tokens, which no person is present for.
+func CheckStepUp(token string) (err error) {
+	userID, merchantID, err := GetUserFromScopedToken(token, SCOPE_PAYMENTS_CREATE)
+	if err != nil {
+		return errors.New("appauth.CheckStepUp: " + err.Error())
+	}
+	if merchantID != "" {
+		return
+	}
+	authUser, err := getAuthUser(userID)
+	if err != nil {
+		return errors.New("appauth.CheckStepUp: " + err.Error())
+	}
+	_, status, _, err := getTOTP(authUser)
+	if err != nil {
+		return errors.New("appauth.CheckStepUp: " + err.Error())
+	}
+	if status != TOTP_STATUS_ACTIVE {
+		return
+	}
+
+	_, found, err := store.kv.Get(STEP_UP_PREFIX + token)
+	if err != nil {
+		return errors.New("appauth.CheckStepUp: Could not get step-up. " + err.Error())
+	}
+	if !found {
+		return errors.New("appauth.CheckStepUp: Step-up required, confirm with a one-time code")
+	}
+	err = store.kv.Del(STEP_UP_PREFIX + token)
+	if err != nil {
+		return errors.New("appauth.CheckStepUp: Could not remove step-up. " + err.Error())
+	}
+	return
+}
+
+// checkTOTP checks a code against a secret, giving the step it was for
+func checkTOTP(secret string, code string, lastStep int64) (step int64, err error) {
+	key, err := decodeTOTPSecret(secret)
+	if err != nil {
+		return 0, errors.New("appauth.checkTOTP: Could not decode secret. " + err.Error())
+	}
+	step, ok := verifyTOTP(key, strings.TrimSpace(code), time.Now(), lastStep)
+	if !ok {
+		return 0, errors.New("appauth.checkTOTP: Code invalid")
+	}
+	return
+}
+
+// checkCode checks a code from a user already signed in, refusing while their
+// login is locked or backing off and counting a wrong code as a failed login
+func checkCode(authUser string, code string, allowRecovery bool, remoteAddr string, reason string) (err error) {
+	err = checkLoginAllowed(authUser, remoteAddr)
+	if err != nil {
+		return errors.New("appauth.checkCode: " + err.Error())
+	}
+
+	ok, err := checkSecondFactor(authUser, code, allowRecovery)
+	if err != nil {
+		return errors.New("appauth.checkCode: " + err.Error())
+	}
+	if !ok {
+		err = recordLoginFailure(authUser, remoteAddr, reason)
+		if err != nil {
+			return errors.New("appauth.checkCode: " + err.Error())
+		}
+		return errors.New("appauth.checkCode: Code invalid")
+	}
+
+	err = clearLoginFailures(authUser)
+	if err != nil {
+		return errors.New("appauth.checkCode: " + err.Error())
+	}
+	return
+}
+
+// checkSecondFactor checks a code from the user's authenticator, or a recovery
+// code if allowed. A wrong code is ok false, not an error.
+func checkSecondFactor(authUser string, code string, allowRecovery bool) (ok bool, err error) {
+	secret, status, lastStep, err := getTOTP(authUser)
+	if err != nil {
+		return false, errors.New("appauth.checkSecondFactor: " + err.Error())
+	}
+	if status != TOTP_STATUS_ACTIVE {
+		return false, errors.New("appauth.checkSecondFactor: Two-factor authentication not enabled")
+	}
+
+	step, err := checkTOTP(secret, code, lastStep)
+	if err == nil {
+		err = useTOTPStep(authUser, step)
+		if err != nil {
+			return false, errors.New("appauth.checkSecondFactor: " + err.Error())
+		}
+		return true, nil
+	}
+	if !allowRecovery {
+		return false, nil
+	}
+
+	ok, err = useRecoveryCode(authUser, code)
+	if err != nil {
+		return false, errors.New("appauth.checkSecondFactor: " + err.Error())
+	}
+	return
+}
+
+func getAuthUser(userID string) (authUser string, err error) {
+	err = Config.Db.QueryRow("SELECT `authUser` FROM `accounts_user_auth` WHERE `accountHolderIdentificationNumber` = ?", userID).Scan(&authUser)
+	switch {
+	case err == sql.ErrNoRows:
+		return "", errors.New("appauth.getAuthUser: Account auth does not exist")
+	case err != nil:
+		return "", errors.New("appauth.getAuthUser: " + err.Error())
+	}
+	return
+}
+
+// getTOTP gives an empty status if the user never enrolled
+func getTOTP(authUser string) (secret string, status string, lastStep int64, err error) {
+	err = Config.Db.QueryRow("SELECT `secret`, `status`, `lastStep` FROM `accounts_user_totp` WHERE `authUser` = ?", authUser).Scan(&secret, &status, &lastStep)
+	switch {
+	case err == sql.ErrNoRows:
+		return "", "", 0, nil
+	case err != nil:
+		return "", "", 0, errors.New("appauth.getTOTP: " + err.Error())
+	}
+	return
+}
+
+func savePendingTOTP(authUser string, secret string) (err error) {
+	sqlTime := int32(time.Now().Unix())
+	_, err = Config.Db.Exec("INSERT INTO `accounts_user_totp` (`authUser`, `secret`, `status`, `lastStep`, `timestamp`) VALUES (?, ?, ?, 0, ?) "+
+		"ON DUPLICATE KEY UPDATE `secret` = VALUES(`secret`), `lastStep` = 0, `timestamp` = VALUES(`timestamp`)",
+		authUser, secret, TOTP_STATUS_PENDING, sqlTime)
+	if err != nil {
+		return errors.New("appauth.savePendingTOTP: " + err.Error())
+	}
+	return
+}
+
+// activateTOTP turns on a pending enrolment and replaces the recovery codes
+func activateTOTP(authUser string, step int64, hashes []string) (err error) {
+	tx, err := Config.Db.Begin()
+	if err != nil {
+		return errors.New("appauth.activateTOTP: " + err.Error())
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("UPDATE `accounts_user_totp` SET `status` = ?, `lastStep` = ? WHERE `authUser` = ? AND `status` = ?", TOTP_STATUS_ACTIVE, step, authUser, TOTP_STATUS_PENDING)
+	if err != nil {
+		return errors.New("appauth.activateTOTP: " + err.Error())
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return errors.New("appauth.activateTOTP: " + err.Error())
+	}
+	if affected == 0 {
+		return errors.New("appauth.activateTOTP: No two-factor enrolment to confirm")
+	}
+
+	_, err = tx.Exec("DELETE FROM `accounts_user_recovery_codes` WHERE `authUser` = ?", authUser)
+	if err != nil {
+		return errors.New("appauth.activateTOTP: " + err.Error())
+	}
+	sqlTime := int32(time.Now().Unix())
+	for _, hash := range hashes {
+		_, err = tx.Exec("INSERT INTO `accounts_user_recovery_codes` (`authUser`, `codeHash`, `timestamp`) VALUES (?, ?, ?)", authUser, hash, sqlTime)
+		if err != nil {
+			return errors.New("appauth.activateTOTP: " + err.Error())
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return errors.New("appauth.activateTOTP: " + err.Error())
+	}
+	return
+}
+
+// useTOTPStep records the step of a code that was accepted. Only a later step
+// is recorded, so two requests with the same code cannot both pass.
+func useTOTPStep(authUser string, step int64) (err error) {
+	res, err := Config.Db.Exec("UPDATE `accounts_user_totp` SET `lastStep` = ? WHERE `authUser` = ? AND `lastStep` < ?", step, authUser, step)
+	if err != nil {
+		return errors.New("appauth.useTOTPStep: " + err.Error())
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return errors.New("appauth.useTOTPStep: " + err.Error())
+	}
+	if affected == 0 {
+		return errors.New("appauth.useTOTPStep: Code already used")
+	}
+	return
+}
+
+func useRecoveryCode(authUser string, code string) (used bool, err error) {
+	res, err := Config.Db.Exec("UPDATE `accounts_user_recovery_codes` SET `used` = 1 WHERE `authUser` = ? AND `codeHash` = ? AND `used` = 0", authUser, recoveryCodeHash(code))
+	if err != nil {
+		return false, errors.New("appauth.useRecoveryCode: " + err.Error())
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, errors.New("appauth.useRecoveryCode: " + err.Error())
+	}
+	return affected > 0, nil
+}
+
+func removeTOTP(authUser string) (err error) {
+	tx, err := Config.Db.Begin()
+	if err != nil {
+		return errors.New("appauth.removeTOTP: " + err.Error())
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM `accounts_user_totp` WHERE `authUser` = ?", authUser)
+	if err != nil {
+		return errors.New("appauth.removeTOTP: " + err.Error())
+	}
+	_, err = tx.Exec("DELETE FROM `accounts_user_recovery_codes` WHERE `authUser` = ?", authUser)
+	if err != nil {
+		return errors.New("appauth.removeTOTP: " + err.Error())
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return errors.New("appauth.removeTOTP: " + err.Error())
+	}
+	return
+}
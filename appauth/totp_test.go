@@ -0,0 +1,137 @@
+package appauth
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bvnk/bank/audit"
+)
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 appendix B SHA-1 test vectors, last six digits
+	secret := []byte("12345678901234567890")
+	tests := []struct {
+		time int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+
+	for _, test := range tests {
+		code := totpCode(secret, totpStep(time.Unix(test.time, 0)))
+		if code != test.code {
+			t.Errorf("TOTPCode does not pass for %v. Looking for %v, got %v", test.time, test.code, code)
+		}
+	}
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	now := time.Unix(1111111111, 0)
+	current := totpStep(now)
+
+	// The previous period's code is accepted for clock drift
+	step, ok := verifyTOTP(secret, totpCode(secret, current-1), now, 0)
+	if !ok || step != current-1 {
+		t.Errorf("VerifyTOTP skew does not pass. Looking for %v, got %v %v", current-1, step, ok)
+	}
+
+	if _, ok := verifyTOTP(secret, totpCode(secret, current-2), now, 0); ok {
+		t.Errorf("VerifyTOTP old code does not pass. Looking for %v, got %v", false, ok)
+	}
+
+	// A code already used cannot be used again
+	if _, ok := verifyTOTP(secret, totpCode(secret, current), now, current); ok {
+		t.Errorf("VerifyTOTP replay does not pass. Looking for %v, got %v", false, ok)
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri := totpURI("a3c1e0a4", "JBSWY3DPEHPK3PXP")
+	if !strings.HasPrefix(uri, "otpauth://totp/BVNK:a3c1e0a4?") || !strings.Contains(uri, "secret=JBSWY3DPEHPK3PXP") || !strings.Contains(uri, "issuer=BVNK") {
+		t.Errorf("TOTPURI does not pass. Looking for %v, got %v", "otpauth://totp/BVNK:a3c1e0a4?...", uri)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil || len(codes) != RECOVERY_CODE_COUNT || len(hashes) != RECOVERY_CODE_COUNT {
+		t.Fatalf("RecoveryCodes does not pass. Looking for %v, got %v %v", RECOVERY_CODE_COUNT, len(codes), err)
+	}
+
+	seen := map[string]bool{}
+	for i, code := range codes {
+		if seen[code] || len(code) != 9 {
+			t.Errorf("RecoveryCodes unique does not pass. Looking for %v, got %v", "unique xxxx-xxxx codes", code)
+		}
+		seen[code] = true
+
+		// Codes typed in capitals or with spaces still match
+		if recoveryCodeHash(" "+strings.ToUpper(code)+" ") != hashes[i] {
+			t.Errorf("RecoveryCodes hash does not pass. Looking for %v, got %v", hashes[i], recoveryCodeHash(strings.ToUpper(code)))
+		}
+	}
+}
+
+func TestStepUpAttempts(t *testing.T) {
+	kv := setTestStore()
+	d, secret, restore := setTestUser(t, "password")
+	defer restore()
+	token, _ := store.Issue("user", "session")
+
+	for i := 0; i <= LOGIN_BACKOFF_AFTER_USER; i++ {
+		if _, err := StepUp(token, "wrong", "10.0.0.1"); err == nil {
+			t.Fatalf("StepUpAttempts wrong code does not pass. Looking for %v, got %v", "error", err)
+		}
+	}
+	if details := d.eventDetails(audit.EVENT_LOGIN_FAILED); len(details) != LOGIN_BACKOFF_AFTER_USER+1 || details[0] != "wrong step-up code" {
+		t.Errorf("StepUpAttempts audit does not pass. Looking for %v, got %v", "wrong step-up code", details)
+	}
+
+	// Backing off, even the right code has to wait
+	code := totpCode(secret, totpStep(time.Now()))
+	if _, err := StepUp(token, code, "10.0.0.1"); err == nil {
+		t.Errorf("StepUpAttempts backoff does not pass. Looking for %v, got %v", "error", err)
+	}
+
+	kv.now = kv.now.Add(LOGIN_BACKOFF_BASE)
+	if _, err := StepUp(token, code, "10.0.0.1"); err != nil {
+		t.Errorf("StepUpAttempts does not pass. Looking for %v, got %v", nil, err)
+	}
+	if _, found, _ := kv.Get(userLoginKey(LOGIN_FAILURES_PREFIX, "authuser")); found {
+		t.Errorf("StepUpAttempts clear does not pass. Looking for %v, got %v", false, found)
+	}
+}
+
+func TestLoginTOTPFailures(t *testing.T) {
+	kv := setTestStore()
+	_, secret, restore := setTestUser(t, "password")
+	defer restore()
+
+	tokens, err := Login("authuser", "password", "", "10.0.0.1")
+	if err != nil || tokens.Challenge == "" {
+		t.Fatalf("LoginTOTPFailures login does not pass. Looking for %v, got %v %v", "challenge", tokens, err)
+	}
+	if _, err := LoginTOTP(tokens.Challenge, "wrong", "10.0.0.1"); err == nil {
+		t.Fatalf("LoginTOTPFailures wrong code does not pass. Looking for %v, got %v", "error", err)
+	}
+
+	// The password alone does not forget the wrong code
+	tokens, _ = Login("authuser", "password", "", "10.0.0.1")
+	if failures, _, _ := kv.Get(userLoginKey(LOGIN_FAILURES_PREFIX, "authuser")); failures != "1" {
+		t.Errorf("LoginTOTPFailures count does not pass. Looking for %v, got %v", "1", failures)
+	}
+
+	tokens, err = LoginTOTP(tokens.Challenge, totpCode(secret, totpStep(time.Now())), "10.0.0.1")
+	if err != nil || tokens.AccessToken == "" {
+		t.Errorf("LoginTOTPFailures does not pass. Looking for %v, got %v %v", "tokens", tokens, err)
+	}
+	if _, found, _ := kv.Get(userLoginKey(LOGIN_FAILURES_PREFIX, "authuser")); found {
+		t.Errorf("LoginTOTPFailures clear does not pass. Looking for %v, got %v", false, found)
+	}
+}
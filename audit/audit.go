@@ -0,0 +1,100 @@
+package audit
+
+/*
+Audit package records security events and privileged actions: who did them,
+to what, from where and when. Events are only ever added.
+
+Commands
+1 - ListEvents (staff)
+*/
+
+import (
+	"errors"
+	"strconv"
+	"time"
+)
+
+const (
+	EVENT_LOGIN_FAILED   = "login.failed"
+	EVENT_LOGIN_LOCKED   = "login.locked"
+	EVENT_LOGIN_UNLOCKED = "login.unlocked"
+
+	EVENT_PASSWORD_CHANGED         = "password.changed"
+	EVENT_PASSWORD_RESET_REQUESTED = "password.reset_requested"
+	EVENT_PASSWORD_RESET           = "password.reset"
+
+	// A staff user's action, with the permission it needed, or one they were refused
+	EVENT_STAFF_ACTION = "staff.action"
+	EVENT_STAFF_DENIED = "staff.denied"
+
+	// A merchant API key was created, given a new secret or revoked
+	EVENT_API_KEY_CREATED = "apikey.created"
+	EVENT_API_KEY_ROTATED = "apikey.rotated"
+	EVENT_API_KEY_REVOKED = "apikey.revoked"
+
+	// A client certificate was enrolled for a merchant or staff user, or revoked
+	EVENT_CERTIFICATE_ENROLLED = "certificate.enrolled"
+	EVENT_CERTIFICATE_REVOKED  = "certificate.revoked"
+
+	// Most events listed at once
+	LIST_LIMIT = 1000
+)
+
+type Event struct {
+	ID         int64
+	Type       string
+	Actor      string
+	Subject    string
+	RemoteAddr string
+	Detail     string
+	Timestamp  int32
+}
+
+// ProcessAudit does not check the staff user may list events, as appauth records
+// events and cannot be imported here. Callers check appauth.PERMISSION_AUDIT.
+func ProcessAudit(data []string) (result interface{}, err error) {
+	if len(data) < 3 {
+		return "", errors.New("audit.ProcessAudit: Not all required fields present")
+	}
+
+	switch data[2] {
+	case "1":
+		//~audit~1~type~fromTimestamp~basicAuthUser~basicAuthPassword
+		if len(data) < 7 {
+			return "", errors.New("audit.ProcessAudit: Not all required fields present")
+		}
+		result, err = listEvents(data)
+		if err != nil {
+			return "", errors.New("audit.ProcessAudit: " + err.Error())
+		}
+		return
+	}
+
+	return "", errors.New("audit.ProcessAudit: No valid option chosen")
+}
+
+// Record adds an event, timestamped now
+func Record(event Event) (err error) {
+	event.Timestamp = int32(time.Now().Unix())
+	err = saveEvent(event)
+	if err != nil {
+		return errors.New("audit.Record: " + err.Error())
+	}
+	return
+}
+
+func listEvents(data []string) (events []Event, err error) {
+	from := int64(0)
+	if data[4] != "" {
+		from, err = strconv.ParseInt(data[4], 10, 64)
+		if err != nil {
+			return nil, errors.New("audit.listEvents: From timestamp not valid")
+		}
+	}
+
+	events, err = getEvents(data[3], from)
+	if err != nil {
+		return nil, errors.New("audit.listEvents: " + err.Error())
+	}
+	return
+}
@@ -0,0 +1,51 @@
+package audit
+
+import (
+	"errors"
+
+	"github.com/bvnk/bank/configuration"
+)
+
+var Config configuration.Configuration
+
+func SetConfig(config *configuration.Configuration) {
+	Config = *config
+}
+
+func saveEvent(event Event) (err error) {
+	_, err = Config.Db.Exec("INSERT INTO `audit_events` (`type`, `actor`, `subject`, `remoteAddr`, `detail`, `timestamp`) VALUES (?, ?, ?, ?, ?, ?)",
+		event.Type, event.Actor, event.Subject, event.RemoteAddr, event.Detail, event.Timestamp)
+	if err != nil {
+		return errors.New("audit.saveEvent: " + err.Error())
+	}
+	return
+}
+
+// getEvents gives the latest events from a timestamp, of one type if eventType is set
+func getEvents(eventType string, from int64) (events []Event, err error) {
+	query := "SELECT `id`, `type`, `actor`, `subject`, `remoteAddr`, `detail`, `timestamp` FROM `audit_events` WHERE `timestamp` >= ?"
+	args := []interface{}{from}
+	if eventType != "" {
+		query += " AND `type` = ?"
+		args = append(args, eventType)
+	}
+	query += " ORDER BY `id` DESC LIMIT ?"
+	args = append(args, LIST_LIMIT)
+
+	rows, err := Config.Db.Query(query, args...)
+	if err != nil {
+		return nil, errors.New("audit.getEvents: " + err.Error())
+	}
+	defer rows.Close()
+
+	events = []Event{}
+	for rows.Next() {
+		event := Event{}
+		err = rows.Scan(&event.ID, &event.Type, &event.Actor, &event.Subject, &event.RemoteAddr, &event.Detail, &event.Timestamp)
+		if err != nil {
+			return nil, errors.New("audit.getEvents: " + err.Error())
+		}
+		events = append(events, event)
+	}
+	return
+}
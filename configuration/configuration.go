@@ -13,6 +13,7 @@ import (
 	"github.com/kardianos/osext"
 
 	_ "github.com/go-sql-driver/mysql"
+	"github.com/shopspring/decimal"
 	"gopkg.in/redis.v3"
 )
 
@@ -38,6 +39,141 @@ type Configuration struct {
 	SSLKeyPath    string
 	ApplePushCert string
 	ApplePushKey  string
+	// CA the TCP server checks client certificates against
+	ClientCAPath string
+	// ISO 4217 code of the currency all accounts are held in
+	Currency string
+	// Spending limits on outgoing payments, keyed by account type
+	AccountLimits map[string]Limits
+	// Spending limits on outgoing payments across all of a holder's accounts
+	HolderLimits Limits
+	// Deposit limits, keyed by account type
+	DepositLimits map[string]Limits
+	// Fraud scoring thresholds and rule settings
+	Fraud Fraud
+	// Anti-money-laundering monitoring settings
+	AML AML
+	// Sanctions and watchlist screening
+	Sanctions Sanctions
+	// Number other banks know this bank by. Defaults to accounts.BANK_NUMBER
+	BankNumber string
+	// Other banks payments can be sent to and received from, keyed by bank number
+	Peers map[string]Peer
+	// Days the bank is closed, used to roll the business date at the end of day
+	Calendar Calendar
+	// Logging in and access tokens
+	Auth Auth
+}
+
+// Limits holds the maximum amounts allowed per transaction and per period.
+// A zero amount means no limit is applied.
+type Limits struct {
+	PerTransaction decimal.Decimal
+	Daily          decimal.Decimal
+	Weekly         decimal.Decimal
+	Monthly        decimal.Decimal
+}
+
+// Fraud holds the settings for the fraud scoring rules.
+// Zero values fall back to the defaults in the fraud package.
+type Fraud struct {
+	// Scores at or above these hold a payment for review or block it
+	ReviewScore int
+	BlockScore  int
+	// Travel between consecutive payments faster than this is impossible
+	MaxTravelSpeedKmh float64
+	// Payments to a new payee at or above this amount are suspicious
+	HighAmount decimal.Decimal
+	// More than BurstCount payments within BurstWindowMinutes is a burst
+	BurstCount         int
+	BurstWindowMinutes int
+	// Payments from UnusualHourStart up to UnusualHourEnd (local time) are unusual
+	UnusualHourStart int
+	UnusualHourEnd   int
+}
+
+// AML holds the settings for anti-money-laundering monitoring.
+// Zero values fall back to the defaults in the aml package.
+type AML struct {
+	// StructuringCount or more amounts within StructuringMargin percent below
+	// StructuringThreshold in StructuringWindowHours is structuring
+	StructuringThreshold   decimal.Decimal
+	StructuringMargin      decimal.Decimal
+	StructuringCount       int
+	StructuringWindowHours int
+	// Sending on at least RapidMovementRatio of what was received within
+	// RapidMovementWindowHours, when at least RapidMovementMinAmount was received
+	RapidMovementRatio       decimal.Decimal
+	RapidMovementMinAmount   decimal.Decimal
+	RapidMovementWindowHours int
+	// Cash deposits at or above this amount are reported
+	LargeCashAmount decimal.Decimal
+}
+
+// Sanctions holds the screening list location and match thresholds.
+// No screening is done if ListPath is empty.
+type Sanctions struct {
+	// Path to an OFAC style list, either .csv or .xml
+	ListPath string
+	// Name similarity, from 0 to 1, at or above which a match is reviewed or blocked
+	ReviewScore float64
+	BlockScore  float64
+}
+
+// Peer is another bank payments are exchanged with as pacs.008 messages
+type Peer struct {
+	// Base URL of the peer's HTTP API, e.g. https://localhost:8444
+	URL string
+	// Secret shared by both banks to authenticate the messages between them
+	Secret string
+	// Certificate to trust for the peer when it is self-signed. The system roots are used if empty
+	CACertPath string
+}
+
+// Calendar holds the days the bank does no business on
+type Calendar struct {
+	// Days of the week the bank is closed, e.g. Saturday. Defaults to Saturday and Sunday
+	Weekends []string
+	// Public holidays as YYYY-MM-DD
+	Holidays []string
+}
+
+// Auth holds the settings for logging in and access tokens.
+// Zero values fall back to the defaults in the appauth package.
+type Auth struct {
+	// An access token expires after this many minutes unused
+	IdleTimeoutMinutes int
+	// An access token expires this many minutes after it was issued, however much it is used
+	AbsoluteTimeoutMinutes int
+	// Users with two-factor authentication give a one-time code for payments at or above
+	// this amount, and for payments to someone they have not paid before
+	StepUpAmount decimal.Decimal
+	// Failed logins before a user is locked out until staff unlock them
+	LockoutAttempts int
+	// File password reset codes are written to when no other notifier is set.
+	// For running locally, the standard log is used if it is empty.
+	NotifyLogPath string
+	// How access tokens are issued: "opaque", the default, are looked up in Redis,
+	// "jwt" are signed and checked without a lookup
+	TokenMode string
+	// ID of the key in JWTKeys new JWT access tokens are signed with
+	JWTSigningKeyID string
+	// Keys JWT access tokens are checked with. Keep a retired key until the
+	// tokens signed with it have expired.
+	JWTKeys []JWTKey
+}
+
+// JWTKey is a key JWT access tokens are signed or checked with
+type JWTKey struct {
+	// Given in the token header as kid
+	ID string
+	// HS256 or EdDSA
+	Algorithm string
+	// HS256 secret, base64 and at least 32 bytes
+	Secret string
+	// Ed25519 keys, base64. Only the signing key needs the private key.
+	PrivateKey string
+	PublicKey  string
 }
 
 // Initialization of the working directory. Needed to load asset files.
@@ -0,0 +1,78 @@
+package eod
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/bvnk/bank/configuration"
+)
+
+// Calendar knows which days the bank does business on
+type Calendar struct {
+	weekends map[time.Weekday]bool
+	holidays map[string]bool
+}
+
+// NewCalendar builds a calendar from the configured weekends and holidays.
+// Weekends default to Saturday and Sunday.
+func NewCalendar(config configuration.Calendar) (calendar Calendar, err error) {
+	calendar = Calendar{weekends: map[time.Weekday]bool{}, holidays: map[string]bool{}}
+
+	weekends := config.Weekends
+	if len(weekends) == 0 {
+		weekends = []string{"Saturday", "Sunday"}
+	}
+	for _, day := range weekends {
+		weekday, ok := weekdays[strings.ToLower(day)]
+		if !ok {
+			return Calendar{}, errors.New("eod.NewCalendar: Weekend day not valid: " + day)
+		}
+		calendar.weekends[weekday] = true
+	}
+	if len(calendar.weekends) == 7 {
+		return Calendar{}, errors.New("eod.NewCalendar: Every day cannot be a weekend")
+	}
+
+	for _, holiday := range config.Holidays {
+		date, err := time.Parse(DATE_FORMAT, holiday)
+		if err != nil {
+			return Calendar{}, errors.New("eod.NewCalendar: Holiday not valid, must be YYYY-MM-DD: " + holiday)
+		}
+		calendar.holidays[date.Format(DATE_FORMAT)] = true
+	}
+	return
+}
+
+var weekdays = map[string]time.Weekday{
+	"sunday":    time.Sunday,
+	"monday":    time.Monday,
+	"tuesday":   time.Tuesday,
+	"wednesday": time.Wednesday,
+	"thursday":  time.Thursday,
+	"friday":    time.Friday,
+	"saturday":  time.Saturday,
+}
+
+// IsBusinessDay is false on weekends and holidays
+func (calendar Calendar) IsBusinessDay(date time.Time) bool {
+	return !calendar.weekends[date.Weekday()] && !calendar.holidays[date.Format(DATE_FORMAT)]
+}
+
+// NextBusinessDay is the first business day after date
+func (calendar Calendar) NextBusinessDay(date time.Time) time.Time {
+	next := date.AddDate(0, 0, 1)
+	for !calendar.IsBusinessDay(next) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+// PreviousBusinessDay is the last business day before date
+func (calendar Calendar) PreviousBusinessDay(date time.Time) time.Time {
+	previous := date.AddDate(0, 0, -1)
+	for !calendar.IsBusinessDay(previous) {
+		previous = previous.AddDate(0, 0, -1)
+	}
+	return previous
+}
@@ -0,0 +1,81 @@
+package eod
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bvnk/bank/configuration"
+)
+
+func testDate(date string) time.Time {
+	t, _ := time.Parse(DATE_FORMAT, date)
+	return t
+}
+
+func TestNewCalendar(t *testing.T) {
+	tests := []struct {
+		config configuration.Calendar
+		valid  bool
+	}{
+		{configuration.Calendar{}, true},
+		{configuration.Calendar{Weekends: []string{"Friday", "saturday"}, Holidays: []string{"2016-12-25"}}, true},
+		{configuration.Calendar{Weekends: []string{"Caturday"}}, false},
+		{configuration.Calendar{Holidays: []string{"25/12/2016"}}, false},
+		{configuration.Calendar{Weekends: []string{"Sunday", "Monday", "Tuesday", "Wednesday", "Thursday", "Friday", "Saturday"}}, false},
+	}
+
+	for _, test := range tests {
+		_, err := NewCalendar(test.config)
+		if (err == nil) != test.valid {
+			t.Errorf("NewCalendar does not pass for %v. Looking for valid %v, got %v", test.config, test.valid, err)
+		}
+	}
+}
+
+func TestIsBusinessDay(t *testing.T) {
+	calendar, _ := NewCalendar(configuration.Calendar{Holidays: []string{"2016-12-26"}})
+
+	tests := []struct {
+		date     string
+		expected bool
+	}{
+		{"2016-12-23", true},  // Friday
+		{"2016-12-24", false}, // Saturday
+		{"2016-12-25", false}, // Sunday
+		{"2016-12-26", false}, // Holiday
+		{"2016-12-27", true},
+	}
+
+	for _, test := range tests {
+		if calendar.IsBusinessDay(testDate(test.date)) != test.expected {
+			t.Errorf("IsBusinessDay does not pass for %v. Looking for %v, got %v", test.date, test.expected, !test.expected)
+		}
+	}
+}
+
+func TestNextBusinessDay(t *testing.T) {
+	calendar, _ := NewCalendar(configuration.Calendar{Holidays: []string{"2016-12-26", "2017-01-02"}})
+
+	tests := []struct {
+		date     string
+		next     string
+		previous string
+	}{
+		{"2016-12-21", "2016-12-22", "2016-12-20"},
+		{"2016-12-23", "2016-12-27", "2016-12-22"},
+		{"2016-12-24", "2016-12-27", "2016-12-23"},
+		{"2016-12-30", "2017-01-03", "2016-12-29"},
+		{"2017-01-03", "2017-01-04", "2016-12-30"},
+	}
+
+	for _, test := range tests {
+		next := calendar.NextBusinessDay(testDate(test.date)).Format(DATE_FORMAT)
+		if next != test.next {
+			t.Errorf("NextBusinessDay does not pass for %v. Looking for %v, got %v", test.date, test.next, next)
+		}
+		previous := calendar.PreviousBusinessDay(testDate(test.date)).Format(DATE_FORMAT)
+		if previous != test.previous {
+			t.Errorf("PreviousBusinessDay does not pass for %v. Looking for %v, got %v", test.date, test.previous, previous)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package eod
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/bvnk/bank/configuration"
+)
+
+var Config configuration.Configuration
+
+func SetConfig(config *configuration.Configuration) {
+	Config = *config
+}
+
+// getState reads the business date. Until one is saved it is today and open.
+func getState() (state State, err error) {
+	err = Config.Db.QueryRow("SELECT `businessDate`, `status`, `lockedUntil`, `timestamp` FROM `business_date` WHERE `id` = 1").Scan(&state.BusinessDate, &state.Status, &state.LockedUntil, &state.Timestamp)
+	switch {
+	case err == sql.ErrNoRows:
+		return State{BusinessDate: today(), Status: STATUS_OPEN}, nil
+	case err != nil:
+		return State{}, errors.New("eod.getState: " + err.Error())
+	}
+	return
+}
+
+// claimRun takes the end of day lock, or keeps it for a run that already holds it.
+// claimed is false if another run holds it.
+func claimRun(runID string) (claimed bool, err error) {
+	now := time.Now().Unix()
+
+	// The first run saves the business date
+	_, err = Config.Db.Exec("INSERT IGNORE INTO `business_date` (`id`, `businessDate`, `status`, `lockedBy`, `lockedUntil`, `timestamp`) VALUES(1, ?, ?, '', 0, ?)", today(), STATUS_OPEN, now)
+	if err != nil {
+		return false, errors.New("eod.claimRun: " + err.Error())
+	}
+
+	res, err := Config.Db.Exec("UPDATE `business_date` SET `lockedBy` = ?, `lockedUntil` = ? WHERE `id` = 1 AND (`lockedUntil` < ? OR `lockedBy` = ?)", runID, now+LEASE_SECONDS, now, runID)
+	if err != nil {
+		return false, errors.New("eod.claimRun: " + err.Error())
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, errors.New("eod.claimRun: " + err.Error())
+	}
+
+	// An update with the same lock time within a second changes nothing
+	if affected == 0 {
+		var lockedBy string
+		err = Config.Db.QueryRow("SELECT `lockedBy` FROM `business_date` WHERE `id` = 1").Scan(&lockedBy)
+		if err != nil {
+			return false, errors.New("eod.claimRun: " + err.Error())
+		}
+		return lockedBy == runID, nil
+	}
+
+	claimed = true
+	return
+}
+
+func releaseRun(runID string) (err error) {
+	_, err = Config.Db.Exec("UPDATE `business_date` SET `lockedBy` = '', `lockedUntil` = 0 WHERE `id` = 1 AND `lockedBy` = ?", runID)
+	if err != nil {
+		return errors.New("eod.releaseRun: " + err.Error())
+	}
+	return
+}
+
+// setStatus moves the business date from one status to the next
+func setStatus(businessDate string, from string, to string) (err error) {
+	res, err := Config.Db.Exec("UPDATE `business_date` SET `status` = ?, `timestamp` = ? WHERE `id` = 1 AND `businessDate` = ? AND `status` = ?", to, time.Now().Unix(), businessDate, from)
+	if err != nil {
+		return errors.New("eod.setStatus: " + err.Error())
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return errors.New("eod.setStatus: " + err.Error())
+	}
+	if affected != 1 {
+		return errors.New("eod.setStatus: Business date " + businessDate + " is not " + from)
+	}
+	return
+}
+
+// rollBusinessDate moves a closed business date on to the next one, ready for the beginning of day
+func rollBusinessDate(businessDate string, next string) (err error) {
+	res, err := Config.Db.Exec("UPDATE `business_date` SET `businessDate` = ?, `status` = ?, `timestamp` = ? WHERE `id` = 1 AND `businessDate` = ? AND `status` = ?", next, STATUS_BOD, time.Now().Unix(), businessDate, STATUS_EOD)
+	if err != nil {
+		return errors.New("eod.rollBusinessDate: " + err.Error())
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return errors.New("eod.rollBusinessDate: " + err.Error())
+	}
+	if affected != 1 {
+		return errors.New("eod.rollBusinessDate: Business date " + businessDate + " is not closed")
+	}
+	return
+}
+
+func getCheckpoints(businessDate string) (checkpoints []Checkpoint, err error) {
+	rows, err := Config.Db.Query("SELECT `businessDate`, `phase`, `job`, `status`, `attempts`, `error`, `startedTimestamp`, `completedTimestamp` FROM `eod_checkpoints` WHERE `businessDate` = ? ORDER BY `id`", businessDate)
+	if err != nil {
+		return nil, errors.New("eod.getCheckpoints: " + err.Error())
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		c := Checkpoint{}
+		if err := rows.Scan(&c.BusinessDate, &c.Phase, &c.Job, &c.Status, &c.Attempts, &c.Error, &c.StartedTimestamp, &c.CompletedTimestamp); err != nil {
+			return nil, errors.New("eod.getCheckpoints: " + err.Error())
+		}
+		checkpoints = append(checkpoints, c)
+	}
+	return
+}
+
+func startCheckpoint(businessDate string, phase string, job string) (err error) {
+	insertStatement := "INSERT INTO eod_checkpoints (`businessDate`, `phase`, `job`, `status`, `attempts`, `error`, `startedTimestamp`, `completedTimestamp`) VALUES(?, ?, ?, ?, 1, '', ?, 0) "
+	insertStatement += "ON DUPLICATE KEY UPDATE `status` = VALUES(`status`), `attempts` = (`attempts` + 1), `error` = '', `startedTimestamp` = VALUES(`startedTimestamp`), `completedTimestamp` = 0"
+	_, err = Config.Db.Exec(insertStatement, businessDate, phase, job, JOB_STATUS_RUNNING, time.Now().Unix())
+	if err != nil {
+		return errors.New("eod.startCheckpoint: " + err.Error())
+	}
+	return
+}
+
+func completeCheckpoint(businessDate string, phase string, job string, status string, jobError string) (err error) {
+	_, err = Config.Db.Exec("UPDATE `eod_checkpoints` SET `status` = ?, `error` = ?, `completedTimestamp` = ? WHERE `businessDate` = ? AND `phase` = ? AND `job` = ?", status, jobError, time.Now().Unix(), businessDate, phase, job)
+	if err != nil {
+		return errors.New("eod.completeCheckpoint: " + err.Error())
+	}
+	return
+}
+
+// saveClosingBalances keeps every account's balance at the end of the business date.
+// Transactions with a later value date, booked after the business date was
+// closed, are taken back off the current balances. Running it again for the
+// same date replaces the balances.
+func saveClosingBalances(businessDate string) (err error) {
+	// What each account's transactions after the business date moved, and held
+	// against its available balance. Fees are stored as an amount.
+	later := "SELECT `senderAccountNumber` AS `accountNumber`, IF(`status` = 'approved', -(`transactionAmount` + `feeAmount`), 0) AS `movement`, IF(`status` = 'pending', `transactionAmount` + `feeAmount`, 0) AS `held` "
+	later += "FROM `transactions` WHERE `transaction` = 'pain' AND `valueDate` > ? AND `senderBankNumber` = '' AND ((`status` = 'approved' AND `type` = 1) OR `status` = 'pending') "
+	later += "UNION ALL SELECT `receiverAccountNumber`, IF(`type` = 1000, `transactionAmount` - `feeAmount`, `transactionAmount`), 0 "
+	later += "FROM `transactions` WHERE `transaction` = 'pain' AND `valueDate` > ? AND `receiverBankNumber` = '' AND `status` = 'approved' AND `type` IN (1, 1000)"
+
+	insertStatement := "INSERT INTO accounts_closing_balances (`businessDate`, `accountNumber`, `accountBalance`, `availableBalance`, `timestamp`) "
+	insertStatement += "SELECT ?, a.`accountNumber`, a.`accountBalance` - COALESCE(l.`movement`, 0), a.`availableBalance` - COALESCE(l.`movement`, 0) + COALESCE(l.`held`, 0), ? FROM `accounts` a "
+	insertStatement += "LEFT JOIN (SELECT `accountNumber`, SUM(`movement`) AS `movement`, SUM(`held`) AS `held` FROM (" + later + ") t GROUP BY `accountNumber`) l ON l.`accountNumber` = a.`accountNumber` "
+	insertStatement += "ON DUPLICATE KEY UPDATE `accountBalance` = VALUES(`accountBalance`), `availableBalance` = VALUES(`availableBalance`), `timestamp` = VALUES(`timestamp`)"
+	_, err = Config.Db.Exec(insertStatement, businessDate, time.Now().Unix(), businessDate, businessDate)
+	if err != nil {
+		return errors.New("eod.saveClosingBalances: " + err.Error())
+	}
+	return
+}
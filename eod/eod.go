@@ -0,0 +1,308 @@
+package eod
+
+/*
+EOD package keeps the business date and runs the end of day.
+
+The business date is the day the bank is booking transactions for. It only
+moves when the end of day runs, and then to the next business day in the
+calendar, so weekends and holidays are skipped. Every transaction is given a
+value date from it, separate from the time it was booked.
+
+The end of day runs in order:
+- the business date is closed, transactions booked from now on are value dated
+  the next business day
+- the end of day jobs run for the business date
+- the business date rolls to the next business day
+- the beginning of day jobs run for the new business date, the last of which
+  opens it for booking again
+
+Each job is checkpointed. A run that stops part way through, or a job that
+fails, is picked up by running the end of day again: jobs that are done are
+skipped and the rest run in order. Only one run can be in progress at a time.
+
+All EOD transactions are staff only, the basic auth user and password are
+always the last two values.
+
+EOD transactions are as follows:
+1 - Status
+2 - Run
+
+*/
+
+import (
+	"errors"
+	"time"
+
+	"github.com/bvnk/bank/appauth"
+	"github.com/satori/go.uuid"
+)
+
+const (
+	DATE_FORMAT = "2006-01-02"
+
+	// Business date statuses
+	STATUS_OPEN = "open"
+	STATUS_EOD  = "eod"
+	STATUS_BOD  = "bod"
+
+	PHASE_EOD = "eod"
+	PHASE_BOD = "bod"
+
+	JOB_STATUS_RUNNING = "running"
+	JOB_STATUS_DONE    = "done"
+	JOB_STATUS_FAILED  = "failed"
+
+	// How long a run holds the lock for between jobs
+	LEASE_SECONDS = 3600
+)
+
+// State is the business date and where the end of day is with it
+type State struct {
+	BusinessDate string
+	Status       string
+	LockedUntil  int32
+	Timestamp    int32
+}
+
+// Checkpoint records how far a job got for a business date
+type Checkpoint struct {
+	BusinessDate       string
+	Phase              string
+	Job                string
+	Status             string
+	Attempts           int
+	Error              string
+	StartedTimestamp   int32
+	CompletedTimestamp int32
+}
+
+// Status is the business date with the checkpoints of its last run
+type Status struct {
+	State       State
+	Checkpoints []Checkpoint
+}
+
+// Job is one step of the end or beginning of day. A job must be safe to run
+// again for the same business date, as a failed run is restarted.
+type Job struct {
+	Name string
+	Run  func(businessDate string) error
+}
+
+func ProcessEOD(data []string) (result interface{}, err error) {
+	if len(data) < 5 {
+		return "", errors.New("eod.ProcessEOD: Not all data is present")
+	}
+
+	// ~eod~type~basicAuthUser~basicAuthPassword
+	_, err = appauth.CheckPermission(data, appauth.PERMISSION_EOD)
+	if err != nil {
+		return "", errors.New("eod.ProcessEOD: " + err.Error())
+	}
+
+	switch data[2] {
+	// Business date and job checkpoints
+	case "1":
+		result, err = getStatus()
+		if err != nil {
+			return "", errors.New("eod.ProcessEOD: " + err.Error())
+		}
+	// Run the end of day, or pick up a run that stopped
+	case "2":
+		result, err = Run()
+		if err != nil {
+			return "", errors.New("eod.ProcessEOD: " + err.Error())
+		}
+	default:
+		return "", errors.New("eod.ProcessEOD: EOD type not valid")
+	}
+
+	return
+}
+
+// Run closes the business date, runs the end and beginning of day jobs and
+// opens the next business date. A run that stopped part way through carries on
+// from the first job that is not done.
+func Run() (status Status, err error) {
+	calendar, err := NewCalendar(Config.Calendar)
+	if err != nil {
+		return Status{}, errors.New("eod.Run: " + err.Error())
+	}
+
+	runID := uuid.NewV4().String()
+	claimed, err := claimRun(runID)
+	if err != nil {
+		return Status{}, errors.New("eod.Run: " + err.Error())
+	}
+	if !claimed {
+		return Status{}, errors.New("eod.Run: End of day is already running")
+	}
+	defer releaseRun(runID)
+
+	state, err := getState()
+	if err != nil {
+		return Status{}, errors.New("eod.Run: " + err.Error())
+	}
+
+	if state.Status == STATUS_OPEN {
+		err = setStatus(state.BusinessDate, STATUS_OPEN, STATUS_EOD)
+		if err != nil {
+			return Status{}, errors.New("eod.Run: " + err.Error())
+		}
+		state.Status = STATUS_EOD
+	}
+
+	if state.Status == STATUS_EOD {
+		err = runJobs(runID, state.BusinessDate, PHASE_EOD, eodJobs)
+		if err != nil {
+			return Status{}, errors.New("eod.Run: " + err.Error())
+		}
+
+		date, err := time.Parse(DATE_FORMAT, state.BusinessDate)
+		if err != nil {
+			return Status{}, errors.New("eod.Run: " + err.Error())
+		}
+		next := calendar.NextBusinessDay(date).Format(DATE_FORMAT)
+
+		err = rollBusinessDate(state.BusinessDate, next)
+		if err != nil {
+			return Status{}, errors.New("eod.Run: " + err.Error())
+		}
+		state.BusinessDate, state.Status = next, STATUS_BOD
+	}
+
+	if state.Status == STATUS_BOD {
+		err = runJobs(runID, state.BusinessDate, PHASE_BOD, bodJobs)
+		if err != nil {
+			return Status{}, errors.New("eod.Run: " + err.Error())
+		}
+	}
+
+	status, err = getStatus()
+	if err != nil {
+		return Status{}, errors.New("eod.Run: " + err.Error())
+	}
+	return
+}
+
+// runJobs runs the jobs of a phase in order, skipping those already done.
+// It stops at the first job that fails so later jobs never run without it.
+func runJobs(runID string, businessDate string, phase string, jobs []Job) (err error) {
+	checkpoints, err := getCheckpoints(businessDate)
+	if err != nil {
+		return errors.New("eod.runJobs: " + err.Error())
+	}
+
+	for _, job := range pendingJobs(checkpoints, phase, jobs) {
+		// Keep the lock for as long as jobs are running
+		claimed, err := claimRun(runID)
+		if err != nil {
+			return errors.New("eod.runJobs: " + err.Error())
+		}
+		if !claimed {
+			return errors.New("eod.runJobs: Lost the end of day lock")
+		}
+
+		err = startCheckpoint(businessDate, phase, job.Name)
+		if err != nil {
+			return errors.New("eod.runJobs: " + err.Error())
+		}
+
+		jobErr := job.Run(businessDate)
+		if jobErr != nil {
+			_ = completeCheckpoint(businessDate, phase, job.Name, JOB_STATUS_FAILED, jobErr.Error())
+			return errors.New("eod.runJobs: Job " + job.Name + " failed. " + jobErr.Error())
+		}
+
+		err = completeCheckpoint(businessDate, phase, job.Name, JOB_STATUS_DONE, "")
+		if err != nil {
+			return errors.New("eod.runJobs: " + err.Error())
+		}
+	}
+	return
+}
+
+// pendingJobs are the jobs of a phase that are not done yet, in order
+func pendingJobs(checkpoints []Checkpoint, phase string, jobs []Job) (pending []Job) {
+	done := map[string]bool{}
+	for _, checkpoint := range checkpoints {
+		if checkpoint.Phase == phase && checkpoint.Status == JOB_STATUS_DONE {
+			done[checkpoint.Job] = true
+		}
+	}
+
+	for _, job := range jobs {
+		if !done[job.Name] {
+			pending = append(pending, job)
+		}
+	}
+	return
+}
+
+func getStatus() (status Status, err error) {
+	status.State, err = getState()
+	if err != nil {
+		return Status{}, errors.New("eod.getStatus: " + err.Error())
+	}
+
+	status.Checkpoints, err = getCheckpoints(status.State.BusinessDate)
+	if err != nil {
+		return Status{}, errors.New("eod.getStatus: " + err.Error())
+	}
+	return
+}
+
+// BusinessDate is the date the bank is booking transactions for
+func BusinessDate() (businessDate string, err error) {
+	state, err := getState()
+	if err != nil {
+		return "", errors.New("eod.BusinessDate: " + err.Error())
+	}
+	return state.BusinessDate, nil
+}
+
+// ValueDate is the date a transaction booked now takes effect on
+func ValueDate() (date string, err error) {
+	calendar, err := NewCalendar(Config.Calendar)
+	if err != nil {
+		return "", errors.New("eod.ValueDate: " + err.Error())
+	}
+
+	state, err := getState()
+	if err != nil {
+		return "", errors.New("eod.ValueDate: " + err.Error())
+	}
+
+	date, err = valueDate(state, calendar)
+	if err != nil {
+		return "", errors.New("eod.ValueDate: " + err.Error())
+	}
+	return
+}
+
+// valueDate is the business date, or the next business day once the business
+// date has been closed for the end of day
+func valueDate(state State, calendar Calendar) (date string, err error) {
+	if state.Status != STATUS_EOD {
+		return state.BusinessDate, nil
+	}
+
+	businessDate, err := time.Parse(DATE_FORMAT, state.BusinessDate)
+	if err != nil {
+		return "", errors.New("eod.valueDate: Business date not valid")
+	}
+	return calendar.NextBusinessDay(businessDate).Format(DATE_FORMAT), nil
+}
+
+// today is the calendar date in the bank's time zone
+func today() string {
+	return time.Now().In(location()).Format(DATE_FORMAT)
+}
+
+func location() *time.Location {
+	loc, err := time.LoadLocation(Config.TimeZone)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
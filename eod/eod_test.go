@@ -0,0 +1,69 @@
+package eod
+
+import (
+	"testing"
+
+	"github.com/bvnk/bank/configuration"
+)
+
+func TestValueDate(t *testing.T) {
+	calendar, _ := NewCalendar(configuration.Calendar{})
+
+	tests := []struct {
+		state    State
+		expected string
+	}{
+		{State{BusinessDate: "2016-12-23", Status: STATUS_OPEN}, "2016-12-23"},
+		// Closed on a Friday, value dated Monday
+		{State{BusinessDate: "2016-12-23", Status: STATUS_EOD}, "2016-12-26"},
+		// Already rolled to the new business date
+		{State{BusinessDate: "2016-12-26", Status: STATUS_BOD}, "2016-12-26"},
+	}
+
+	for _, test := range tests {
+		date, err := valueDate(test.state, calendar)
+		if err != nil || date != test.expected {
+			t.Errorf("ValueDate does not pass for %v. Looking for %v, got %v %v", test.state, test.expected, date, err)
+		}
+	}
+
+	if _, err := valueDate(State{BusinessDate: "", Status: STATUS_EOD}, calendar); err == nil {
+		t.Errorf("ValueDate invalid does not pass. Looking for %v, got %v", "error", err)
+	}
+}
+
+func TestPendingJobs(t *testing.T) {
+	noop := func(businessDate string) error { return nil }
+	jobs := []Job{{Name: "first", Run: noop}, {Name: "second", Run: noop}, {Name: "third", Run: noop}}
+
+	checkpoints := []Checkpoint{
+		{Phase: PHASE_EOD, Job: "first", Status: JOB_STATUS_DONE},
+		{Phase: PHASE_EOD, Job: "second", Status: JOB_STATUS_FAILED},
+		// The same job name done in another phase does not count
+		{Phase: PHASE_BOD, Job: "third", Status: JOB_STATUS_DONE},
+	}
+
+	pending := pendingJobs(checkpoints, PHASE_EOD, jobs)
+	if len(pending) != 2 || pending[0].Name != "second" || pending[1].Name != "third" {
+		t.Errorf("PendingJobs does not pass. Looking for %v, got %v", "second, third", pending)
+	}
+
+	if pending := pendingJobs(nil, PHASE_BOD, jobs); len(pending) != 3 {
+		t.Errorf("PendingJobs none done does not pass. Looking for %v, got %v", 3, len(pending))
+	}
+}
+
+func TestJobOrder(t *testing.T) {
+	// The business date is only opened once everything else at the beginning of day is done
+	if bodJobs[len(bodJobs)-1].Name != "openBusinessDate" {
+		t.Errorf("JobOrder does not pass. Looking for %v, got %v", "openBusinessDate", bodJobs[len(bodJobs)-1].Name)
+	}
+
+	names := map[string]bool{}
+	for _, job := range append(append([]Job{}, eodJobs...), bodJobs...) {
+		if names[job.Name] {
+			t.Errorf("JobOrder does not pass. Looking for %v, got %v", "unique job names", job.Name)
+		}
+		names[job.Name] = true
+	}
+}
@@ -0,0 +1,36 @@
+package eod
+
+import (
+	"errors"
+
+	"github.com/bvnk/bank/interbank"
+)
+
+// End of day jobs, in the order they run
+var eodJobs = []Job{
+	// Net the day's payments with other banks into settlements
+	{Name: "interbankNetting", Run: interbankNetting},
+	// Keep each account's closing balance for the business date
+	{Name: "closingBalances", Run: saveClosingBalances},
+}
+
+// Beginning of day jobs, in the order they run. The business date is opened last.
+var bodJobs = []Job{
+	{Name: "openBusinessDate", Run: openBusinessDate},
+}
+
+func interbankNetting(businessDate string) (err error) {
+	_, err = interbank.RunNetting(businessDate)
+	if err != nil {
+		return errors.New("eod.interbankNetting: " + err.Error())
+	}
+	return
+}
+
+func openBusinessDate(businessDate string) (err error) {
+	err = setStatus(businessDate, STATUS_BOD, STATUS_OPEN)
+	if err != nil {
+		return errors.New("eod.openBusinessDate: " + err.Error())
+	}
+	return
+}
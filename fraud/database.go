@@ -0,0 +1,71 @@
+package fraud
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/bvnk/bank/configuration"
+)
+
+var Config configuration.Configuration
+
+func SetConfig(config *configuration.Configuration) {
+	Config = *config
+}
+
+func loadHistory(payment Payment) (history History, err error) {
+	history.LastPayment, err = getLastPayment(payment.SenderAccountNumber)
+	if err != nil {
+		return History{}, errors.New("fraud.loadHistory: " + err.Error())
+	}
+
+	history.KnownPayee, err = IsKnownPayee(payment.SenderAccountNumber, payment.ReceiverAccountNumber)
+	if err != nil {
+		return History{}, errors.New("fraud.loadHistory: " + err.Error())
+	}
+
+	history.RecentPayments, err = countPaymentsSince(payment.SenderAccountNumber, payment.Timestamp.Add(-burstWindow()))
+	if err != nil {
+		return History{}, errors.New("fraud.loadHistory: " + err.Error())
+	}
+
+	return
+}
+
+func getLastPayment(accountNumber string) (lastPayment *PastPayment, err error) {
+	// Transactions store geo as POINT(lat lon)
+	var lat, lon float64
+	var timestamp int64
+	err = Config.Db.QueryRow("SELECT X(`geo`), Y(`geo`), `timestamp` FROM `transactions` WHERE `senderAccountNumber` = ? AND `geo` IS NOT NULL AND `status` != 'rejected' ORDER BY `id` DESC LIMIT 1", accountNumber).Scan(&lat, &lon, &timestamp)
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, nil
+	case err != nil:
+		return nil, errors.New("fraud.getLastPayment: " + err.Error())
+	}
+
+	lastPayment = &PastPayment{lat, lon, time.Unix(timestamp, 0)}
+	return
+}
+
+// IsKnownPayee is true if the sender has made an approved payment to the receiver before
+func IsKnownPayee(senderAccountNumber string, receiverAccountNumber string) (known bool, err error) {
+	count := 0
+	err = Config.Db.QueryRow("SELECT COUNT(*) FROM `transactions` WHERE `senderAccountNumber` = ? AND `receiverAccountNumber` = ? AND `status` = 'approved'", senderAccountNumber, receiverAccountNumber).Scan(&count)
+	if err != nil {
+		return false, errors.New("fraud.IsKnownPayee: " + err.Error())
+	}
+
+	known = count > 0
+	return
+}
+
+func countPaymentsSince(accountNumber string, since time.Time) (count int, err error) {
+	err = Config.Db.QueryRow("SELECT COUNT(*) FROM `transactions` WHERE `senderAccountNumber` = ? AND `timestamp` >= ?", accountNumber, since.Unix()).Scan(&count)
+	if err != nil {
+		return 0, errors.New("fraud.countPaymentsSince: " + err.Error())
+	}
+
+	return
+}
@@ -0,0 +1,132 @@
+// Package fraud scores payments against a set of rules before they are processed.
+// Each rule adds to the score of a payment, and the total decides whether the
+// payment is allowed, held for review or blocked.
+package fraud
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+const (
+	ACTION_ALLOW  = "allow"
+	ACTION_REVIEW = "review"
+	ACTION_BLOCK  = "block"
+
+	DEFAULT_REVIEW_SCORE = 50
+	DEFAULT_BLOCK_SCORE  = 90
+)
+
+// Payment is a payment about to be made
+type Payment struct {
+	SenderAccountNumber   string
+	ReceiverAccountNumber string
+	Amount                decimal.Decimal
+	Lat                   float64
+	Lon                   float64
+	Timestamp             time.Time
+}
+
+// PastPayment is an earlier payment made by the same sender
+type PastPayment struct {
+	Lat       float64
+	Lon       float64
+	Timestamp time.Time
+}
+
+// History is the sender's earlier activity that rules compare a payment against
+type History struct {
+	// Most recent payment by the sender with a location, nil if there is none
+	LastPayment *PastPayment
+	// Whether the sender has paid the receiver before
+	KnownPayee bool
+	// Payments made by the sender within the burst window
+	RecentPayments int
+}
+
+// Rule scores a payment. A score of 0 means the rule did not match.
+type Rule interface {
+	Name() string
+	Score(payment Payment, history History) (score int, reason string)
+}
+
+// Assessment is the outcome of scoring a payment
+type Assessment struct {
+	Score   int
+	Action  string
+	Reasons []string
+}
+
+// rules are guarded by rulesMu, as payments are scored while rules may be registered
+var (
+	rulesMu sync.RWMutex
+	rules   = []Rule{
+		ImpossibleTravelRule{},
+		NewPayeeHighAmountRule{},
+		BurstRule{},
+		UnusualHourRule{},
+	}
+)
+
+// RegisterRule adds a rule to those every payment is scored against
+func RegisterRule(rule Rule) {
+	rulesMu.Lock()
+	defer rulesMu.Unlock()
+	rules = append(rules, rule)
+}
+
+// currentRules gives the rules registered so far. Registering more only
+// appends past the end, so the slice given is not changed under the caller.
+func currentRules() []Rule {
+	rulesMu.RLock()
+	defer rulesMu.RUnlock()
+	return rules
+}
+
+// Evaluate loads the sender's history and scores the payment against all rules
+func Evaluate(payment Payment) (assessment Assessment, err error) {
+	history, err := loadHistory(payment)
+	if err != nil {
+		return Assessment{}, errors.New("fraud.Evaluate: " + err.Error())
+	}
+
+	assessment = Assess(payment, history, currentRules())
+	return
+}
+
+// Assess scores a payment against the given rules and decides on an action
+func Assess(payment Payment, history History, rules []Rule) (assessment Assessment) {
+	for _, rule := range rules {
+		score, reason := rule.Score(payment, history)
+		if score == 0 {
+			continue
+		}
+		assessment.Score += score
+		assessment.Reasons = append(assessment.Reasons, rule.Name()+": "+reason)
+	}
+
+	assessment.Action = action(assessment.Score)
+	return
+}
+
+func action(score int) string {
+	reviewScore := Config.Fraud.ReviewScore
+	if reviewScore == 0 {
+		reviewScore = DEFAULT_REVIEW_SCORE
+	}
+	blockScore := Config.Fraud.BlockScore
+	if blockScore == 0 {
+		blockScore = DEFAULT_BLOCK_SCORE
+	}
+
+	switch {
+	case score >= blockScore:
+		return ACTION_BLOCK
+	case score >= reviewScore:
+		return ACTION_REVIEW
+	}
+	return ACTION_ALLOW
+}
@@ -0,0 +1,79 @@
+package fraud
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+type alwaysRule struct {
+	score int
+}
+
+func (r alwaysRule) Name() string {
+	return "always"
+}
+
+func (r alwaysRule) Score(payment Payment, history History) (int, string) {
+	return r.score, "matched"
+}
+
+func TestAssess(t *testing.T) {
+	setTestConfig()
+	payment := Payment{"sender", "receiver", decimal.NewFromFloat(1), 0, 0, time.Now()}
+
+	assessment := Assess(payment, History{}, []Rule{alwaysRule{10}})
+	if assessment.Action != ACTION_ALLOW {
+		t.Errorf("Assess does not pass. Looking for %v, got %v", ACTION_ALLOW, assessment.Action)
+	}
+
+	assessment = Assess(payment, History{}, []Rule{alwaysRule{30}, alwaysRule{30}})
+	if assessment.Action != ACTION_REVIEW || assessment.Score != 60 {
+		t.Errorf("Assess does not pass. Looking for %v (60), got %v (%v)", ACTION_REVIEW, assessment.Action, assessment.Score)
+	}
+	if len(assessment.Reasons) != 2 || assessment.Reasons[0] != "always: matched" {
+		t.Errorf("Assess does not pass. Looking for %v, got %v", "always: matched", assessment.Reasons)
+	}
+
+	assessment = Assess(payment, History{}, []Rule{alwaysRule{DEFAULT_BLOCK_SCORE}})
+	if assessment.Action != ACTION_BLOCK {
+		t.Errorf("Assess does not pass. Looking for %v, got %v", ACTION_BLOCK, assessment.Action)
+	}
+}
+
+func TestAssessConfiguredThresholds(t *testing.T) {
+	setTestConfig()
+	Config.Fraud.ReviewScore = 10
+	Config.Fraud.BlockScore = 20
+	payment := Payment{"sender", "receiver", decimal.NewFromFloat(1), 0, 0, time.Now()}
+
+	assessment := Assess(payment, History{}, []Rule{alwaysRule{15}})
+	if assessment.Action != ACTION_REVIEW {
+		t.Errorf("AssessConfiguredThresholds does not pass. Looking for %v, got %v", ACTION_REVIEW, assessment.Action)
+	}
+}
+
+func TestRegisterRuleConcurrent(t *testing.T) {
+	registered := currentRules()
+	defer func() { rules = registered }()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			RegisterRule(alwaysRule{0})
+		}()
+		go func() {
+			defer wg.Done()
+			Assess(Payment{}, History{}, currentRules())
+		}()
+	}
+	wg.Wait()
+
+	if len(currentRules()) != len(registered)+10 {
+		t.Errorf("RegisterRuleConcurrent does not pass. Looking for %v, got %v", len(registered)+10, len(currentRules()))
+	}
+}
@@ -0,0 +1,167 @@
+package fraud
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/paulmach/go.geo"
+	"github.com/shopspring/decimal"
+)
+
+// Defaults used when the configuration does not set a value
+const (
+	DEFAULT_MAX_TRAVEL_SPEED_KMH = 900. // Roughly a commercial flight
+	DEFAULT_HIGH_AMOUNT          = 1000.
+	DEFAULT_BURST_COUNT          = 5
+	DEFAULT_BURST_WINDOW_MINUTES = 10
+	DEFAULT_UNUSUAL_HOUR_START   = 1
+	DEFAULT_UNUSUAL_HOUR_END     = 5
+)
+
+// Scores added by each rule when it matches
+const (
+	IMPOSSIBLE_TRAVEL_SCORE     = 60
+	NEW_PAYEE_HIGH_AMOUNT_SCORE = 40
+	BURST_SCORE                 = 30
+	UNUSUAL_HOUR_SCORE          = 15
+)
+
+// Payments closer than this to the last one are never impossible travel
+const MIN_TRAVEL_DISTANCE_KM = 50.
+
+// ImpossibleTravelRule matches payments made further from the sender's last
+// payment than could have been travelled in the time between them
+type ImpossibleTravelRule struct{}
+
+func (r ImpossibleTravelRule) Name() string {
+	return "impossible-travel"
+}
+
+func (r ImpossibleTravelRule) Score(payment Payment, history History) (score int, reason string) {
+	last := history.LastPayment
+	if last == nil || !hasLocation(payment.Lat, payment.Lon) || !hasLocation(last.Lat, last.Lon) {
+		return 0, ""
+	}
+
+	// go.geo points are (lng, lat)
+	from := geo.NewPoint(last.Lon, last.Lat)
+	to := geo.NewPoint(payment.Lon, payment.Lat)
+	distanceKm := from.GeoDistanceFrom(to, true) / 1000
+	if distanceKm < MIN_TRAVEL_DISTANCE_KM {
+		return 0, ""
+	}
+
+	// Treat anything quicker than a minute as a minute
+	hours := payment.Timestamp.Sub(last.Timestamp).Hours()
+	if hours < 1./60 {
+		hours = 1. / 60
+	}
+
+	maxSpeed := Config.Fraud.MaxTravelSpeedKmh
+	if maxSpeed == 0 {
+		maxSpeed = DEFAULT_MAX_TRAVEL_SPEED_KMH
+	}
+
+	speed := distanceKm / hours
+	if speed <= maxSpeed {
+		return 0, ""
+	}
+
+	return IMPOSSIBLE_TRAVEL_SCORE, fmt.Sprintf("%.0fkm from last payment in %.1f hours", distanceKm, payment.Timestamp.Sub(last.Timestamp).Hours())
+}
+
+// NewPayeeHighAmountRule matches high value payments to someone the sender has never paid
+type NewPayeeHighAmountRule struct{}
+
+func (r NewPayeeHighAmountRule) Name() string {
+	return "new-payee-high-amount"
+}
+
+func (r NewPayeeHighAmountRule) Score(payment Payment, history History) (score int, reason string) {
+	if history.KnownPayee {
+		return 0, ""
+	}
+
+	highAmount := Config.Fraud.HighAmount
+	if highAmount.Sign() == 0 {
+		highAmount = decimal.NewFromFloat(DEFAULT_HIGH_AMOUNT)
+	}
+
+	if payment.Amount.Cmp(highAmount) == -1 {
+		return 0, ""
+	}
+
+	return NEW_PAYEE_HIGH_AMOUNT_SCORE, "first payment to payee is " + payment.Amount.String()
+}
+
+// BurstRule matches a sender making many payments in a short time
+type BurstRule struct{}
+
+func (r BurstRule) Name() string {
+	return "burst"
+}
+
+func (r BurstRule) Score(payment Payment, history History) (score int, reason string) {
+	burstCount := Config.Fraud.BurstCount
+	if burstCount == 0 {
+		burstCount = DEFAULT_BURST_COUNT
+	}
+
+	// Include the payment being made
+	if history.RecentPayments+1 <= burstCount {
+		return 0, ""
+	}
+
+	return BURST_SCORE, fmt.Sprintf("%d payments within %s", history.RecentPayments+1, burstWindow())
+}
+
+// UnusualHourRule matches payments made during the night
+type UnusualHourRule struct{}
+
+func (r UnusualHourRule) Name() string {
+	return "unusual-hour"
+}
+
+func (r UnusualHourRule) Score(payment Payment, history History) (score int, reason string) {
+	start := Config.Fraud.UnusualHourStart
+	end := Config.Fraud.UnusualHourEnd
+	if start == 0 && end == 0 {
+		start = DEFAULT_UNUSUAL_HOUR_START
+		end = DEFAULT_UNUSUAL_HOUR_END
+	}
+
+	hour := payment.Timestamp.In(location()).Hour()
+	if !inHours(hour, start, end) {
+		return 0, ""
+	}
+
+	return UNUSUAL_HOUR_SCORE, fmt.Sprintf("made at %02d:00", hour)
+}
+
+// inHours checks hour is within [start, end), wrapping past midnight if start is after end
+func inHours(hour int, start int, end int) bool {
+	if start <= end {
+		return hour >= start && hour < end
+	}
+	return hour >= start || hour < end
+}
+
+// Coordinates of 0,0 are sent when the location is unknown
+func hasLocation(lat float64, lon float64) bool {
+	return lat != 0 || lon != 0
+}
+
+func burstWindow() time.Duration {
+	if Config.Fraud.BurstWindowMinutes == 0 {
+		return DEFAULT_BURST_WINDOW_MINUTES * time.Minute
+	}
+	return time.Duration(Config.Fraud.BurstWindowMinutes) * time.Minute
+}
+
+func location() *time.Location {
+	loc, err := time.LoadLocation(Config.TimeZone)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
@@ -0,0 +1,97 @@
+package fraud
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bvnk/bank/configuration"
+	"github.com/shopspring/decimal"
+)
+
+func setTestConfig() {
+	Config = configuration.Configuration{TimeZone: "UTC"}
+}
+
+func TestImpossibleTravelRule(t *testing.T) {
+	setTestConfig()
+	now := time.Date(2016, time.June, 1, 12, 0, 0, 0, time.UTC)
+
+	// Johannesburg to London in one hour
+	payment := Payment{"sender", "receiver", decimal.NewFromFloat(10), 51.5074, -0.1278, now}
+	history := History{LastPayment: &PastPayment{-26.2041, 28.0473, now.Add(-time.Hour)}}
+
+	score, _ := ImpossibleTravelRule{}.Score(payment, history)
+	if score != IMPOSSIBLE_TRAVEL_SCORE {
+		t.Errorf("ImpossibleTravelRule does not pass. Looking for %v, got %v", IMPOSSIBLE_TRAVEL_SCORE, score)
+	}
+
+	// The same trip over two days is possible
+	history.LastPayment.Timestamp = now.Add(-48 * time.Hour)
+	score, _ = ImpossibleTravelRule{}.Score(payment, history)
+	if score != 0 {
+		t.Errorf("ImpossibleTravelRule does not pass. Looking for %v, got %v", 0, score)
+	}
+
+	// Unknown location is not scored
+	payment.Lat, payment.Lon = 0, 0
+	history.LastPayment.Timestamp = now.Add(-time.Minute)
+	score, _ = ImpossibleTravelRule{}.Score(payment, history)
+	if score != 0 {
+		t.Errorf("ImpossibleTravelRule unknown location does not pass. Looking for %v, got %v", 0, score)
+	}
+}
+
+func TestNewPayeeHighAmountRule(t *testing.T) {
+	setTestConfig()
+	payment := Payment{"sender", "receiver", decimal.NewFromFloat(DEFAULT_HIGH_AMOUNT), 0, 0, time.Now()}
+
+	score, _ := NewPayeeHighAmountRule{}.Score(payment, History{KnownPayee: false})
+	if score != NEW_PAYEE_HIGH_AMOUNT_SCORE {
+		t.Errorf("NewPayeeHighAmountRule does not pass. Looking for %v, got %v", NEW_PAYEE_HIGH_AMOUNT_SCORE, score)
+	}
+
+	score, _ = NewPayeeHighAmountRule{}.Score(payment, History{KnownPayee: true})
+	if score != 0 {
+		t.Errorf("NewPayeeHighAmountRule known payee does not pass. Looking for %v, got %v", 0, score)
+	}
+}
+
+func TestBurstRule(t *testing.T) {
+	setTestConfig()
+	payment := Payment{"sender", "receiver", decimal.NewFromFloat(1), 0, 0, time.Now()}
+
+	score, _ := BurstRule{}.Score(payment, History{RecentPayments: DEFAULT_BURST_COUNT - 1})
+	if score != 0 {
+		t.Errorf("BurstRule does not pass. Looking for %v, got %v", 0, score)
+	}
+
+	score, _ = BurstRule{}.Score(payment, History{RecentPayments: DEFAULT_BURST_COUNT})
+	if score != BURST_SCORE {
+		t.Errorf("BurstRule does not pass. Looking for %v, got %v", BURST_SCORE, score)
+	}
+}
+
+func TestUnusualHourRule(t *testing.T) {
+	setTestConfig()
+	payment := Payment{"sender", "receiver", decimal.NewFromFloat(1), 0, 0, time.Date(2016, time.June, 1, 3, 0, 0, 0, time.UTC)}
+
+	score, _ := UnusualHourRule{}.Score(payment, History{})
+	if score != UNUSUAL_HOUR_SCORE {
+		t.Errorf("UnusualHourRule does not pass. Looking for %v, got %v", UNUSUAL_HOUR_SCORE, score)
+	}
+
+	payment.Timestamp = time.Date(2016, time.June, 1, 14, 0, 0, 0, time.UTC)
+	score, _ = UnusualHourRule{}.Score(payment, History{})
+	if score != 0 {
+		t.Errorf("UnusualHourRule does not pass. Looking for %v, got %v", 0, score)
+	}
+}
+
+func TestInHours(t *testing.T) {
+	if !inHours(23, 22, 4) || !inHours(2, 22, 4) || inHours(12, 22, 4) {
+		t.Errorf("InHours does not pass. Hours past midnight not handled")
+	}
+	if !inHours(1, 1, 5) || inHours(5, 1, 5) {
+		t.Errorf("InHours does not pass. Looking for [1, 5)")
+	}
+}
@@ -7,9 +7,16 @@ import (
 	"net/http"
 
 	"github.com/bvnk/bank/accounts"
+	"github.com/bvnk/bank/aml"
 	"github.com/bvnk/bank/appauth"
+	"github.com/bvnk/bank/audit"
 	"github.com/bvnk/bank/configuration"
+	"github.com/bvnk/bank/eod"
+	"github.com/bvnk/bank/fraud"
+	"github.com/bvnk/bank/interbank"
+	"github.com/bvnk/bank/limits"
 	"github.com/bvnk/bank/push"
+	"github.com/bvnk/bank/sanctions"
 	"github.com/bvnk/bank/transactions"
 )
 
@@ -27,6 +34,16 @@ func RunHttpServer() (err error) {
 	transactions.SetConfig(&Config)
 	appauth.SetConfig(&Config)
 	push.SetConfig(&Config)
+	limits.SetConfig(&Config)
+	fraud.SetConfig(&Config)
+	aml.SetConfig(&Config)
+	sanctions.SetConfig(&Config)
+	interbank.SetConfig(&Config)
+	eod.SetConfig(&Config)
+	audit.SetConfig(&Config)
+
+	// Deliver payments to other banks
+	go transactions.RunInterbank()
 
 	router := NewRouter()
 
@@ -69,3 +86,32 @@ func Response(responseSuccess interface{}, responseError error, w http.ResponseW
 	w.Write(jsonResponse)
 	bLog(0, "Response success: "+string(jsonResponse), trace())
 }
+
+// FileResponse sends content as a file download instead of a JSON response
+func FileResponse(content []byte, contentType string, fileName string, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", "attachment; filename=\""+fileName+"\"")
+	w.WriteHeader(http.StatusOK)
+	w.Write(content)
+	bLog(0, "File response success: "+fileName, trace())
+}
+
+// OAuthResponse answers as OAuth2 has it, with the body or else an error code
+func OAuthResponse(responseSuccess interface{}, oauthError string, status int, w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+
+	if oauthError != "" {
+		responseSuccess = map[string]string{"error": oauthError}
+	}
+	jsonResponse, err := json.Marshal(responseSuccess)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("{\"error\": \"server_error\"}"))
+		return
+	}
+
+	w.WriteHeader(status)
+	w.Write(jsonResponse)
+}
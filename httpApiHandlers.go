@@ -1,21 +1,41 @@
 package main
 
 import (
+	"context"
 	"errors"
+	"io/ioutil"
 	"net/http"
+	"strings"
 
 	"github.com/bvnk/bank/accounts"
+	"github.com/bvnk/bank/aml"
 	"github.com/bvnk/bank/appauth"
+	"github.com/bvnk/bank/audit"
+	"github.com/bvnk/bank/eod"
+	"github.com/bvnk/bank/interbank"
+	"github.com/bvnk/bank/limits"
+	"github.com/bvnk/bank/sanctions"
 	"github.com/bvnk/bank/transactions"
 	"github.com/gorilla/mux"
 )
 
+const (
+	// Largest upload accepted for a pain.001 file
+	PAIN_001_MAX_BYTES = 10 << 20 // 10MB
+	// Largest pacs.008 message accepted from another bank
+	PACS_008_MAX_BYTES = 1 << 20 // 1MB
+)
+
 func Index(w http.ResponseWriter, r *http.Request) {
 }
 
 func getTokenFromHeader(w http.ResponseWriter, r *http.Request) (token string, err error) {
 	// Get token from header
 	token = r.Header.Get("X-Auth-Token")
+	// OAuth2 clients send theirs as a bearer token
+	if token == "" && strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+		token = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	}
 	if token == "" {
 		return "", errors.New("httpApiHandlers: Could not retrieve token from headers")
 	}
@@ -29,6 +49,49 @@ func getTokenFromHeader(w http.ResponseWriter, r *http.Request) (token string, e
 	return
 }
 
+func getBasicAuthFromHeader(r *http.Request) (user string, password string, err error) {
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		return "", "", errors.New("httpApiHandlers: Error retrieving auth headers")
+	}
+
+	if (user == "") || (password == "") {
+		return "", "", errors.New("httpApiHandlers: Auth must be set")
+	}
+
+	return
+}
+
+type staffTokenKey struct{}
+
+// requirePermission refuses a staff route before its handler runs unless the
+// basic auth user's role has the permission. The handler is given a token for
+// the staff user, so the password is not checked again for its command.
+func requirePermission(permission string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		basicAuthUser, basicAuthPassword, err := getBasicAuthFromHeader(r)
+		if err != nil {
+			Response("", err, w, r)
+			return
+		}
+
+		token, err := appauth.AuthorizeStaff(basicAuthUser, basicAuthPassword, permission, remoteHost(r.RemoteAddr))
+		if err != nil {
+			Response("", errors.New("httpApiHandlers: "+err.Error()), w, r)
+			return
+		}
+		defer appauth.RemoveToken(token)
+		next(w, r.WithContext(context.WithValue(r.Context(), staffTokenKey{}, token)))
+	}
+}
+
+// staffToken gives the token requirePermission issued for the request, to be
+// the first field of a staff command, or nothing if there is none
+func staffToken(r *http.Request) string {
+	token, _ := r.Context().Value(staffTokenKey{}).(string)
+	return token
+}
+
 // Extend token
 func AuthIndex(w http.ResponseWriter, r *http.Request) {
 	token, err := getTokenFromHeader(w, r)
@@ -48,7 +111,7 @@ func AuthLogin(w http.ResponseWriter, r *http.Request) {
 	user := r.FormValue("User")
 	password := r.FormValue("Password")
 
-	response, err := appauth.ProcessAppAuth([]string{"0", "appauth", "2", user, password})
+	response, err := appauth.ProcessAppAuthFrom([]string{"0", "appauth", "2", user, password, r.UserAgent()}, remoteHost(r.RemoteAddr))
 	Response(response, err, w, r)
 	return
 }
@@ -74,7 +137,290 @@ func AuthRemove(w http.ResponseWriter, r *http.Request) {
 	user := r.FormValue("User")
 	password := r.FormValue("Password")
 
-	response, err := appauth.ProcessAppAuth([]string{token, "appauth", "4", user, password})
+	response, err := appauth.ProcessAppAuthFrom([]string{token, "appauth", "4", user, password}, remoteHost(r.RemoteAddr))
+	Response(response, err, w, r)
+	return
+}
+
+// Refresh tokens, no access token needed as it may have expired
+func AuthRefresh(w http.ResponseWriter, r *http.Request) {
+	refreshToken := r.FormValue("RefreshToken")
+
+	response, err := appauth.ProcessAppAuth([]string{"0", "appauth", "5", refreshToken})
+	Response(response, err, w, r)
+	return
+}
+
+func AuthSessions(w http.ResponseWriter, r *http.Request) {
+	token, err := getTokenFromHeader(w, r)
+	if err != nil {
+		Response("", err, w, r)
+		return
+	}
+
+	response, err := appauth.ProcessAppAuth([]string{token, "appauth", "6"})
+	Response(response, err, w, r)
+	return
+}
+
+func AuthSessionRemove(w http.ResponseWriter, r *http.Request) {
+	token, err := getTokenFromHeader(w, r)
+	if err != nil {
+		Response("", err, w, r)
+		return
+	}
+
+	vars := mux.Vars(r)
+	sessionID := vars["sessionID"]
+
+	response, err := appauth.ProcessAppAuth([]string{token, "appauth", "7", sessionID})
+	Response(response, err, w, r)
+	return
+}
+
+func AuthSessionsRemove(w http.ResponseWriter, r *http.Request) {
+	token, err := getTokenFromHeader(w, r)
+	if err != nil {
+		Response("", err, w, r)
+		return
+	}
+
+	response, err := appauth.ProcessAppAuth([]string{token, "appauth", "8"})
+	Response(response, err, w, r)
+	return
+}
+
+// Second step of logging in, no access token yet
+func AuthLoginTOTP(w http.ResponseWriter, r *http.Request) {
+	challenge := r.FormValue("Challenge")
+	code := r.FormValue("Code")
+
+	response, err := appauth.ProcessAppAuthFrom([]string{"0", "appauth", "12", challenge, code}, remoteHost(r.RemoteAddr))
+	Response(response, err, w, r)
+	return
+}
+
+func AuthTOTPEnrol(w http.ResponseWriter, r *http.Request) {
+	token, err := getTokenFromHeader(w, r)
+	if err != nil {
+		Response("", err, w, r)
+		return
+	}
+
+	response, err := appauth.ProcessAppAuth([]string{token, "appauth", "9"})
+	Response(response, err, w, r)
+	return
+}
+
+func AuthTOTPConfirm(w http.ResponseWriter, r *http.Request) {
+	token, err := getTokenFromHeader(w, r)
+	if err != nil {
+		Response("", err, w, r)
+		return
+	}
+
+	code := r.FormValue("Code")
+
+	response, err := appauth.ProcessAppAuth([]string{token, "appauth", "10", code})
+	Response(response, err, w, r)
+	return
+}
+
+func AuthTOTPRemove(w http.ResponseWriter, r *http.Request) {
+	token, err := getTokenFromHeader(w, r)
+	if err != nil {
+		Response("", err, w, r)
+		return
+	}
+
+	code := r.FormValue("Code")
+
+	response, err := appauth.ProcessAppAuthFrom([]string{token, "appauth", "11", code}, remoteHost(r.RemoteAddr))
+	Response(response, err, w, r)
+	return
+}
+
+func AuthStepUp(w http.ResponseWriter, r *http.Request) {
+	token, err := getTokenFromHeader(w, r)
+	if err != nil {
+		Response("", err, w, r)
+		return
+	}
+
+	code := r.FormValue("Code")
+
+	response, err := appauth.ProcessAppAuthFrom([]string{token, "appauth", "13", code}, remoteHost(r.RemoteAddr))
+	Response(response, err, w, r)
+	return
+}
+
+// Change the password, which logs out every session
+func AuthPasswordChange(w http.ResponseWriter, r *http.Request) {
+	token, err := getTokenFromHeader(w, r)
+	if err != nil {
+		Response("", err, w, r)
+		return
+	}
+
+	currentPassword := r.FormValue("CurrentPassword")
+	newPassword := r.FormValue("NewPassword")
+
+	response, err := appauth.ProcessAppAuthFrom([]string{token, "appauth", "15", currentPassword, newPassword}, remoteHost(r.RemoteAddr))
+	Response(response, err, w, r)
+	return
+}
+
+// Send a code to reset a forgotten password
+func AuthPasswordForgot(w http.ResponseWriter, r *http.Request) {
+	user := r.FormValue("User")
+
+	response, err := appauth.ProcessAppAuthFrom([]string{"0", "appauth", "16", user}, remoteHost(r.RemoteAddr))
+	Response(response, err, w, r)
+	return
+}
+
+// Set a new password with a reset code
+func AuthPasswordReset(w http.ResponseWriter, r *http.Request) {
+	user := r.FormValue("User")
+	code := r.FormValue("Code")
+	password := r.FormValue("Password")
+
+	response, err := appauth.ProcessAppAuth([]string{"0", "appauth", "17", user, code, password})
+	Response(response, err, w, r)
+	return
+}
+
+// Let a user locked out after too many failed logins log in again (staff)
+func AuthUnlock(w http.ResponseWriter, r *http.Request) {
+	basicAuthUser, basicAuthPassword, err := getBasicAuthFromHeader(r)
+	if err != nil {
+		Response("", err, w, r)
+		return
+	}
+
+	vars := mux.Vars(r)
+	authUser := vars["authUser"]
+
+	response, err := appauth.ProcessAppAuth([]string{staffToken(r), "appauth", "14", authUser, basicAuthUser, basicAuthPassword})
+	Response(response, err, w, r)
+	return
+}
+
+// Add a staff user
+func StaffCreate(w http.ResponseWriter, r *http.Request) {
+	basicAuthUser, basicAuthPassword, err := getBasicAuthFromHeader(r)
+	if err != nil {
+		Response("", err, w, r)
+		return
+	}
+
+	username := r.FormValue("Username")
+	password := r.FormValue("Password")
+	role := r.FormValue("Role")
+
+	response, err := appauth.ProcessAppAuth([]string{staffToken(r), "appauth", "18", username, password, role, basicAuthUser, basicAuthPassword})
+	Response(response, err, w, r)
+	return
+}
+
+// Change a staff user's role
+func StaffUpdate(w http.ResponseWriter, r *http.Request) {
+	basicAuthUser, basicAuthPassword, err := getBasicAuthFromHeader(r)
+	if err != nil {
+		Response("", err, w, r)
+		return
+	}
+
+	vars := mux.Vars(r)
+	username := vars["username"]
+	role := r.FormValue("Role")
+
+	response, err := appauth.ProcessAppAuth([]string{staffToken(r), "appauth", "19", username, role, basicAuthUser, basicAuthPassword})
+	Response(response, err, w, r)
+	return
+}
+
+// Disable a staff user
+func StaffDisable(w http.ResponseWriter, r *http.Request) {
+	basicAuthUser, basicAuthPassword, err := getBasicAuthFromHeader(r)
+	if err != nil {
+		Response("", err, w, r)
+		return
+	}
+
+	vars := mux.Vars(r)
+	username := vars["username"]
+
+	response, err := appauth.ProcessAppAuth([]string{staffToken(r), "appauth", "19", username, appauth.STAFF_STATUS_DISABLED, basicAuthUser, basicAuthPassword})
+	Response(response, err, w, r)
+	return
+}
+
+// List staff users
+func StaffIndex(w http.ResponseWriter, r *http.Request) {
+	basicAuthUser, basicAuthPassword, err := getBasicAuthFromHeader(r)
+	if err != nil {
+		Response("", err, w, r)
+		return
+	}
+
+	response, err := appauth.ProcessAppAuth([]string{staffToken(r), "appauth", "20", basicAuthUser, basicAuthPassword})
+	Response(response, err, w, r)
+	return
+}
+
+// Client certificates
+// List client certificates
+func CertificateIndex(w http.ResponseWriter, r *http.Request) {
+	basicAuthUser, basicAuthPassword, err := getBasicAuthFromHeader(r)
+	if err != nil {
+		Response("", err, w, r)
+		return
+	}
+
+	response, err := appauth.ProcessAppAuth([]string{staffToken(r), "appauth", "28", basicAuthUser, basicAuthPassword})
+	Response(response, err, w, r)
+	return
+}
+
+// Enrol a client certificate, given as PEM or by its fingerprint and subject
+func CertificateCreate(w http.ResponseWriter, r *http.Request) {
+	basicAuthUser, basicAuthPassword, err := getBasicAuthFromHeader(r)
+	if err != nil {
+		Response("", err, w, r)
+		return
+	}
+
+	fingerprint := r.FormValue("Fingerprint")
+	subject := r.FormValue("Subject")
+	if certificate := r.FormValue("Certificate"); certificate != "" {
+		fingerprint, subject, err = appauth.ParseCertificatePEM([]byte(certificate))
+		if err != nil {
+			Response("", errors.New("httpApiHandlers: "+err.Error()), w, r)
+			return
+		}
+	}
+	kind := r.FormValue("Kind")
+	identity := r.FormValue("Identity")
+	scopes := r.FormValue("Scopes")
+
+	response, err := appauth.ProcessAppAuth([]string{staffToken(r), "appauth", "26", fingerprint, subject, kind, identity, scopes, basicAuthUser, basicAuthPassword})
+	Response(response, err, w, r)
+	return
+}
+
+// Revoke a client certificate
+func CertificateRevoke(w http.ResponseWriter, r *http.Request) {
+	basicAuthUser, basicAuthPassword, err := getBasicAuthFromHeader(r)
+	if err != nil {
+		Response("", err, w, r)
+		return
+	}
+
+	vars := mux.Vars(r)
+	fingerprint := vars["fingerprint"]
+
+	response, err := appauth.ProcessAppAuth([]string{staffToken(r), "appauth", "27", fingerprint, basicAuthUser, basicAuthPassword})
 	Response(response, err, w, r)
 	return
 }
@@ -144,6 +490,55 @@ func AccountGet(w http.ResponseWriter, r *http.Request) {
 	return
 }
 
+// Set how far an account may go below zero
+func AccountOverdraft(w http.ResponseWriter, r *http.Request) {
+	basicAuthUser, basicAuthPassword, err := getBasicAuthFromHeader(r)
+	if err != nil {
+		Response("", err, w, r)
+		return
+	}
+
+	vars := mux.Vars(r)
+	accountId := vars["accountId"]
+	overdraft := r.FormValue("Overdraft")
+
+	response, err := accounts.ProcessAccount([]string{staffToken(r), "acmt", "1200", accountId, overdraft, basicAuthUser, basicAuthPassword})
+	Response(response, err, w, r)
+	return
+}
+
+// Freeze an account
+func AccountFreeze(w http.ResponseWriter, r *http.Request) {
+	basicAuthUser, basicAuthPassword, err := getBasicAuthFromHeader(r)
+	if err != nil {
+		Response("", err, w, r)
+		return
+	}
+
+	vars := mux.Vars(r)
+	accountId := vars["accountId"]
+
+	response, err := accounts.ProcessAccount([]string{staffToken(r), "acmt", "1201", accountId, basicAuthUser, basicAuthPassword})
+	Response(response, err, w, r)
+	return
+}
+
+// Unfreeze an account
+func AccountUnfreeze(w http.ResponseWriter, r *http.Request) {
+	basicAuthUser, basicAuthPassword, err := getBasicAuthFromHeader(r)
+	if err != nil {
+		Response("", err, w, r)
+		return
+	}
+
+	vars := mux.Vars(r)
+	accountId := vars["accountId"]
+
+	response, err := accounts.ProcessAccount([]string{staffToken(r), "acmt", "1202", accountId, basicAuthUser, basicAuthPassword})
+	Response(response, err, w, r)
+	return
+}
+
 func AccountRetrieve(w http.ResponseWriter, r *http.Request) {
 	// Set these in the header as they are sensitive
 	ID := r.Header.Get("X-IDNumber")
@@ -232,18 +627,7 @@ func TransactionCreditInitiation(w http.ResponseWriter, r *http.Request) {
 }
 
 func TransactionDepositInitiation(w http.ResponseWriter, r *http.Request) {
-	basicAuthUser, basicAuthPassword, ok := r.BasicAuth()
-	if !ok {
-		Response("", errors.New("httpApiHandlers.TransactionDepositInitiation: Error retrieving auth headers"), w, r)
-		return
-	}
-
-	if (basicAuthUser == "") || (basicAuthPassword == "") {
-		Response("", errors.New("httpApiHandlers.TransactionDepositInitiation: Auth must be set"), w, r)
-		return
-	}
-
-	err := appauth.CheckBasicAuth(basicAuthUser, basicAuthPassword)
+	basicAuthUser, basicAuthPassword, err := getBasicAuthFromHeader(r)
 	if err != nil {
 		Response("", err, w, r)
 		return
@@ -255,7 +639,7 @@ func TransactionDepositInitiation(w http.ResponseWriter, r *http.Request) {
 	lon := r.FormValue("Lon")
 	desc := r.FormValue("Desc")
 
-	response, err := transactions.ProcessPAIN([]string{"", "pain", "1000", accountDetails, amount, lat, lon, desc, basicAuthUser, basicAuthPassword})
+	response, err := transactions.ProcessPAIN([]string{staffToken(r), "pain", "1000", accountDetails, amount, lat, lon, desc, basicAuthUser, basicAuthPassword})
 	Response(response, err, w, r)
 	return
 }
@@ -283,8 +667,336 @@ func TransactionList(w http.ResponseWriter, r *http.Request) {
 	return
 }
 
-// Merchant accounts
-// Merchant account create
+// Statement for an account between two dates, inclusive
+func TransactionStatement(w http.ResponseWriter, r *http.Request) {
+	token, err := getTokenFromHeader(w, r)
+	if err != nil {
+		Response("", err, w, r)
+		return
+	}
+	// Get account number from header
+	accountNumber := r.Header.Get("X-Auth-AccountNumber")
+	if accountNumber == "" {
+		Response("", errors.New("httpApiHandlers.TransactionStatement: Could not retrieve accountNumber from headers"), w, r)
+		return
+	}
+
+	vars := mux.Vars(r)
+	from := vars["from"]
+	to := vars["to"]
+	format := vars["format"]
+
+	response, err := transactions.ProcessCAMT([]string{token, "camt", "1000", accountNumber, from, to, format})
+	if err != nil {
+		Response("", err, w, r)
+		return
+	}
+
+	file, _ := response.(transactions.StatementFile)
+	FileResponse(file.Content, file.ContentType, file.FileName, w, r)
+	return
+}
+
+// camt.052, camt.053 or camt.054 for an account between two dates, inclusive
+func TransactionCamt(w http.ResponseWriter, r *http.Request) {
+	token, err := getTokenFromHeader(w, r)
+	if err != nil {
+		Response("", err, w, r)
+		return
+	}
+	// Get account number from header
+	accountNumber := r.Header.Get("X-Auth-AccountNumber")
+	if accountNumber == "" {
+		Response("", errors.New("httpApiHandlers.TransactionCamt: Could not retrieve accountNumber from headers"), w, r)
+		return
+	}
+
+	vars := mux.Vars(r)
+	camtType := vars["camtType"]
+	from := vars["from"]
+	to := vars["to"]
+
+	// Only the ISO messages are served here, custom types have their own routes
+	if camtType != "52" && camtType != "53" && camtType != "54" {
+		Response("", errors.New("httpApiHandlers.TransactionCamt: CAMT type must be one of 52, 53, 54"), w, r)
+		return
+	}
+
+	response, err := transactions.ProcessCAMT([]string{token, "camt", camtType, accountNumber, from, to})
+	if err != nil {
+		Response("", err, w, r)
+		return
+	}
+
+	file, _ := response.(transactions.StatementFile)
+	FileResponse(file.Content, file.ContentType, file.FileName, w, r)
+	return
+}
+
+// Upload a pain.001 file of credit transfers, the response is a pain.002 status report
+func TransactionPain001(w http.ResponseWriter, r *http.Request) {
+	token, err := getTokenFromHeader(w, r)
+	if err != nil {
+		Response("", err, w, r)
+		return
+	}
+
+	content, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, PAIN_001_MAX_BYTES))
+	if err != nil {
+		Response("", errors.New("httpApiHandlers.TransactionPain001: Could not read file. "+err.Error()), w, r)
+		return
+	}
+
+	report, err := transactions.ProcessPain001(token, content)
+	if err != nil {
+		Response("", err, w, r)
+		return
+	}
+
+	FileResponse(report, "application/xml; charset=UTF-8", "pain.002.xml", w, r)
+	return
+}
+
+// Payments from another bank. The bank authenticates with its bank number and shared secret
+func InterbankPacs008(w http.ResponseWriter, r *http.Request) {
+	bankNumber, secret, err := getBasicAuthFromHeader(r)
+	if err != nil {
+		Response("", err, w, r)
+		return
+	}
+
+	content, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, PACS_008_MAX_BYTES))
+	if err != nil {
+		Response("", errors.New("httpApiHandlers.InterbankPacs008: Could not read message. "+err.Error()), w, r)
+		return
+	}
+
+	report, err := transactions.ProcessPacs008(bankNumber, secret, content)
+	if err != nil {
+		Response("", err, w, r)
+		return
+	}
+
+	FileResponse(report, "application/xml; charset=UTF-8", "pacs.002.xml", w, r)
+	return
+}
+
+// Net unsettled interbank payments into a settlement per peer (staff)
+func InterbankNetting(w http.ResponseWriter, r *http.Request) {
+	basicAuthUser, basicAuthPassword, err := getBasicAuthFromHeader(r)
+	if err != nil {
+		Response("", err, w, r)
+		return
+	}
+
+	businessDate := r.FormValue("BusinessDate")
+
+	response, err := interbank.ProcessPACS([]string{staffToken(r), "pacs", "3", businessDate, basicAuthUser, basicAuthPassword})
+	Response(response, err, w, r)
+	return
+}
+
+// Mark a settlement with a peer as paid (staff)
+func InterbankSettle(w http.ResponseWriter, r *http.Request) {
+	basicAuthUser, basicAuthPassword, err := getBasicAuthFromHeader(r)
+	if err != nil {
+		Response("", err, w, r)
+		return
+	}
+
+	vars := mux.Vars(r)
+	settlementID := vars["settlementID"]
+
+	response, err := interbank.ProcessPACS([]string{staffToken(r), "pacs", "4", settlementID, basicAuthUser, basicAuthPassword})
+	Response(response, err, w, r)
+	return
+}
+
+// Settlement file for a business date (staff)
+func InterbankSettlementFile(w http.ResponseWriter, r *http.Request) {
+	basicAuthUser, basicAuthPassword, err := getBasicAuthFromHeader(r)
+	if err != nil {
+		Response("", err, w, r)
+		return
+	}
+
+	vars := mux.Vars(r)
+	businessDate := vars["businessDate"]
+
+	response, err := interbank.ProcessPACS([]string{staffToken(r), "pacs", "5", businessDate, basicAuthUser, basicAuthPassword})
+	if err != nil {
+		Response("", err, w, r)
+		return
+	}
+
+	file, _ := response.(interbank.File)
+	FileResponse(file.Content, file.ContentType, file.FileName, w, r)
+	return
+}
+
+// Reconciliation of the settlement accounts, as json or csv (staff)
+func InterbankReconciliation(w http.ResponseWriter, r *http.Request) {
+	basicAuthUser, basicAuthPassword, err := getBasicAuthFromHeader(r)
+	if err != nil {
+		Response("", err, w, r)
+		return
+	}
+
+	vars := mux.Vars(r)
+	format := vars["format"]
+
+	response, err := interbank.ProcessPACS([]string{staffToken(r), "pacs", "6", format, basicAuthUser, basicAuthPassword})
+	if err != nil {
+		Response("", err, w, r)
+		return
+	}
+
+	if file, ok := response.(interbank.File); ok {
+		FileResponse(file.Content, file.ContentType, file.FileName, w, r)
+		return
+	}
+	Response(response, err, w, r)
+	return
+}
+
+// Recompute balances from the transactions and report the differences (staff)
+func TransactionReconciliation(w http.ResponseWriter, r *http.Request) {
+	basicAuthUser, basicAuthPassword, err := getBasicAuthFromHeader(r)
+	if err != nil {
+		Response("", err, w, r)
+		return
+	}
+
+	response, err := transactions.ProcessPAIN([]string{staffToken(r), "pain", "1007", "dryrun", basicAuthUser, basicAuthPassword})
+	Response(response, err, w, r)
+	return
+}
+
+// Correct the balances that differ from the transactions (staff)
+func TransactionReconciliationRepair(w http.ResponseWriter, r *http.Request) {
+	basicAuthUser, basicAuthPassword, err := getBasicAuthFromHeader(r)
+	if err != nil {
+		Response("", err, w, r)
+		return
+	}
+
+	response, err := transactions.ProcessPAIN([]string{staffToken(r), "pain", "1007", "repair", basicAuthUser, basicAuthPassword})
+	Response(response, err, w, r)
+	return
+}
+
+// Business date and the end of day checkpoints (staff)
+func EODStatus(w http.ResponseWriter, r *http.Request) {
+	basicAuthUser, basicAuthPassword, err := getBasicAuthFromHeader(r)
+	if err != nil {
+		Response("", err, w, r)
+		return
+	}
+
+	response, err := eod.ProcessEOD([]string{staffToken(r), "eod", "1", basicAuthUser, basicAuthPassword})
+	Response(response, err, w, r)
+	return
+}
+
+// Run the end of day, or pick up a run that stopped (staff)
+func EODRun(w http.ResponseWriter, r *http.Request) {
+	basicAuthUser, basicAuthPassword, err := getBasicAuthFromHeader(r)
+	if err != nil {
+		Response("", err, w, r)
+		return
+	}
+
+	response, err := eod.ProcessEOD([]string{staffToken(r), "eod", "2", basicAuthUser, basicAuthPassword})
+	Response(response, err, w, r)
+	return
+}
+
+func TransactionBatch(w http.ResponseWriter, r *http.Request) {
+	token, err := getTokenFromHeader(w, r)
+	if err != nil {
+		Response("", err, w, r)
+		return
+	}
+	// Get account number from header
+	accountNumber := r.Header.Get("X-Auth-AccountNumber")
+	if accountNumber == "" {
+		Response("", errors.New("httpApiHandlers.TransactionBatch: Could not retrieve accountNumber from headers"), w, r)
+		return
+	}
+
+	mode := r.FormValue("Mode")
+	format := r.FormValue("Format")
+	transfers := r.FormValue("Transfers")
+
+	response, err := transactions.ProcessPAIN([]string{token, "pain", "1005", accountNumber, mode, format, transfers})
+	Response(response, err, w, r)
+	return
+}
+
+func TransactionBatchView(w http.ResponseWriter, r *http.Request) {
+	token, err := getTokenFromHeader(w, r)
+	if err != nil {
+		Response("", err, w, r)
+		return
+	}
+
+	vars := mux.Vars(r)
+	batchID := vars["batchID"]
+
+	response, err := transactions.ProcessPAIN([]string{token, "pain", "1006", batchID})
+	Response(response, err, w, r)
+	return
+}
+
+// List transactions held for review (staff)
+func TransactionPendingList(w http.ResponseWriter, r *http.Request) {
+	basicAuthUser, basicAuthPassword, err := getBasicAuthFromHeader(r)
+	if err != nil {
+		Response("", err, w, r)
+		return
+	}
+
+	response, err := transactions.ProcessPAIN([]string{staffToken(r), "pain", "1002", basicAuthUser, basicAuthPassword})
+	Response(response, err, w, r)
+	return
+}
+
+// Approve a held transaction (staff)
+func TransactionPendingApprove(w http.ResponseWriter, r *http.Request) {
+	basicAuthUser, basicAuthPassword, err := getBasicAuthFromHeader(r)
+	if err != nil {
+		Response("", err, w, r)
+		return
+	}
+
+	vars := mux.Vars(r)
+	transactionID := vars["transactionID"]
+	comment := r.FormValue("Comment")
+
+	response, err := transactions.ProcessPAIN([]string{staffToken(r), "pain", "1003", transactionID, comment, basicAuthUser, basicAuthPassword})
+	Response(response, err, w, r)
+	return
+}
+
+// Reject a held transaction (staff)
+func TransactionPendingReject(w http.ResponseWriter, r *http.Request) {
+	basicAuthUser, basicAuthPassword, err := getBasicAuthFromHeader(r)
+	if err != nil {
+		Response("", err, w, r)
+		return
+	}
+
+	vars := mux.Vars(r)
+	transactionID := vars["transactionID"]
+	comment := r.FormValue("Comment")
+
+	response, err := transactions.ProcessPAIN([]string{staffToken(r), "pain", "1004", transactionID, comment, basicAuthUser, basicAuthPassword})
+	Response(response, err, w, r)
+	return
+}
+
+// Merchant accounts
+// Merchant account create
 func MerchantAccountCreate(w http.ResponseWriter, r *http.Request) {
 	token, err := getTokenFromHeader(w, r)
 	if err != nil {
@@ -436,3 +1148,364 @@ func MerchantAccountSearch(w http.ResponseWriter, r *http.Request) {
 	Response(response, err, w, r)
 	return
 }
+
+// Merchant API keys
+// OAuth2 client credentials grant. Answered as RFC 6749 has it, not with
+// Response, for OAuth2 clients to read.
+func OAuthToken(w http.ResponseWriter, r *http.Request) {
+	if r.FormValue("grant_type") != "client_credentials" {
+		OAuthResponse(nil, "unsupported_grant_type", http.StatusBadRequest, w)
+		return
+	}
+
+	// The client may authenticate with basic auth or in the form
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok {
+		clientID = r.FormValue("client_id")
+		clientSecret = r.FormValue("client_secret")
+	}
+	scope := r.FormValue("scope")
+
+	token, err := appauth.ClientCredentialsToken(clientID, clientSecret, scope)
+	switch {
+	case err == appauth.ErrInvalidClient:
+		w.Header().Set("WWW-Authenticate", "Basic")
+		OAuthResponse(nil, "invalid_client", http.StatusUnauthorized, w)
+	case err == appauth.ErrInvalidScope:
+		OAuthResponse(nil, "invalid_scope", http.StatusBadRequest, w)
+	case err != nil:
+		bLog(3, "OAuth token error: "+err.Error(), trace())
+		OAuthResponse(nil, "server_error", http.StatusInternalServerError, w)
+	default:
+		OAuthResponse(token, "", http.StatusOK, w)
+	}
+	return
+}
+
+// List merchant API keys
+func MerchantKeyList(w http.ResponseWriter, r *http.Request) {
+	token, err := getTokenFromHeader(w, r)
+	if err != nil {
+		Response("", err, w, r)
+		return
+	}
+
+	vars := mux.Vars(r)
+	merchantID := vars["merchantID"]
+
+	response, err := appauth.ProcessAppAuth([]string{token, "appauth", "23", merchantID})
+	Response(response, err, w, r)
+	return
+}
+
+// Create merchant API key
+func MerchantKeyCreate(w http.ResponseWriter, r *http.Request) {
+	token, err := getTokenFromHeader(w, r)
+	if err != nil {
+		Response("", err, w, r)
+		return
+	}
+
+	vars := mux.Vars(r)
+	merchantID := vars["merchantID"]
+	scopes := r.FormValue("Scopes")
+
+	response, err := appauth.ProcessAppAuth([]string{token, "appauth", "22", merchantID, scopes})
+	Response(response, err, w, r)
+	return
+}
+
+// Give merchant API key a new secret
+func MerchantKeyRotate(w http.ResponseWriter, r *http.Request) {
+	token, err := getTokenFromHeader(w, r)
+	if err != nil {
+		Response("", err, w, r)
+		return
+	}
+
+	vars := mux.Vars(r)
+	merchantID := vars["merchantID"]
+	clientID := vars["clientID"]
+
+	response, err := appauth.ProcessAppAuth([]string{token, "appauth", "24", merchantID, clientID})
+	Response(response, err, w, r)
+	return
+}
+
+// Revoke merchant API key
+func MerchantKeyRevoke(w http.ResponseWriter, r *http.Request) {
+	token, err := getTokenFromHeader(w, r)
+	if err != nil {
+		Response("", err, w, r)
+		return
+	}
+
+	vars := mux.Vars(r)
+	merchantID := vars["merchantID"]
+	clientID := vars["clientID"]
+
+	response, err := appauth.ProcessAppAuth([]string{token, "appauth", "25", merchantID, clientID})
+	Response(response, err, w, r)
+	return
+}
+
+// Limits
+// View limits
+func LimitsView(w http.ResponseWriter, r *http.Request) {
+	token, err := getTokenFromHeader(w, r)
+	if err != nil {
+		Response("", err, w, r)
+		return
+	}
+
+	vars := mux.Vars(r)
+	accountNumber := vars["accountNumber"]
+
+	response, err := limits.ProcessLimits([]string{token, "limits", "1", accountNumber})
+	Response(response, err, w, r)
+	return
+}
+
+// Lower a limit
+func LimitsLower(w http.ResponseWriter, r *http.Request) {
+	token, err := getTokenFromHeader(w, r)
+	if err != nil {
+		Response("", err, w, r)
+		return
+	}
+
+	scope := r.FormValue("Scope")
+	accountNumber := r.FormValue("AccountNumber")
+	period := r.FormValue("Period")
+	amount := r.FormValue("Amount")
+
+	response, err := limits.ProcessLimits([]string{token, "limits", "2", scope, accountNumber, period, amount})
+	Response(response, err, w, r)
+	return
+}
+
+// Set a limit (staff)
+func LimitsSet(w http.ResponseWriter, r *http.Request) {
+	basicAuthUser, basicAuthPassword, ok := r.BasicAuth()
+	if !ok {
+		Response("", errors.New("httpApiHandlers.LimitsSet: Error retrieving auth headers"), w, r)
+		return
+	}
+
+	if (basicAuthUser == "") || (basicAuthPassword == "") {
+		Response("", errors.New("httpApiHandlers.LimitsSet: Auth must be set"), w, r)
+		return
+	}
+
+	scope := r.FormValue("Scope")
+	target := r.FormValue("Target")
+	period := r.FormValue("Period")
+	amount := r.FormValue("Amount")
+
+	response, err := limits.ProcessLimits([]string{staffToken(r), "limits", "3", scope, target, period, amount, basicAuthUser, basicAuthPassword})
+	Response(response, err, w, r)
+	return
+}
+
+// AML (staff)
+// List cases
+func AMLCaseList(w http.ResponseWriter, r *http.Request) {
+	basicAuthUser, basicAuthPassword, err := getBasicAuthFromHeader(r)
+	if err != nil {
+		Response("", err, w, r)
+		return
+	}
+
+	status := r.FormValue("Status")
+
+	response, err := aml.ProcessAML([]string{staffToken(r), "aml", "1", status, basicAuthUser, basicAuthPassword})
+	Response(response, err, w, r)
+	return
+}
+
+// View a case
+func AMLCaseView(w http.ResponseWriter, r *http.Request) {
+	basicAuthUser, basicAuthPassword, err := getBasicAuthFromHeader(r)
+	if err != nil {
+		Response("", err, w, r)
+		return
+	}
+
+	vars := mux.Vars(r)
+	caseID := vars["caseID"]
+
+	response, err := aml.ProcessAML([]string{staffToken(r), "aml", "2", caseID, basicAuthUser, basicAuthPassword})
+	Response(response, err, w, r)
+	return
+}
+
+// Assign a case
+func AMLCaseAssign(w http.ResponseWriter, r *http.Request) {
+	basicAuthUser, basicAuthPassword, err := getBasicAuthFromHeader(r)
+	if err != nil {
+		Response("", err, w, r)
+		return
+	}
+
+	vars := mux.Vars(r)
+	caseID := vars["caseID"]
+	assignee := r.FormValue("Assignee")
+
+	response, err := aml.ProcessAML([]string{staffToken(r), "aml", "3", caseID, assignee, basicAuthUser, basicAuthPassword})
+	Response(response, err, w, r)
+	return
+}
+
+// Comment on a case
+func AMLCaseComment(w http.ResponseWriter, r *http.Request) {
+	basicAuthUser, basicAuthPassword, err := getBasicAuthFromHeader(r)
+	if err != nil {
+		Response("", err, w, r)
+		return
+	}
+
+	vars := mux.Vars(r)
+	caseID := vars["caseID"]
+	comment := r.FormValue("Comment")
+
+	response, err := aml.ProcessAML([]string{staffToken(r), "aml", "4", caseID, comment, basicAuthUser, basicAuthPassword})
+	Response(response, err, w, r)
+	return
+}
+
+// Close a case
+func AMLCaseClose(w http.ResponseWriter, r *http.Request) {
+	basicAuthUser, basicAuthPassword, err := getBasicAuthFromHeader(r)
+	if err != nil {
+		Response("", err, w, r)
+		return
+	}
+
+	vars := mux.Vars(r)
+	caseID := vars["caseID"]
+	resolution := r.FormValue("Resolution")
+
+	response, err := aml.ProcessAML([]string{staffToken(r), "aml", "5", caseID, resolution, basicAuthUser, basicAuthPassword})
+	Response(response, err, w, r)
+	return
+}
+
+// Escalate a case
+func AMLCaseEscalate(w http.ResponseWriter, r *http.Request) {
+	basicAuthUser, basicAuthPassword, err := getBasicAuthFromHeader(r)
+	if err != nil {
+		Response("", err, w, r)
+		return
+	}
+
+	vars := mux.Vars(r)
+	caseID := vars["caseID"]
+	reason := r.FormValue("Reason")
+
+	response, err := aml.ProcessAML([]string{staffToken(r), "aml", "6", caseID, reason, basicAuthUser, basicAuthPassword})
+	Response(response, err, w, r)
+	return
+}
+
+// Export cases as a CSV report
+func AMLCaseExport(w http.ResponseWriter, r *http.Request) {
+	basicAuthUser, basicAuthPassword, err := getBasicAuthFromHeader(r)
+	if err != nil {
+		Response("", err, w, r)
+		return
+	}
+
+	status := r.FormValue("Status")
+
+	response, err := aml.ProcessAML([]string{staffToken(r), "aml", "7", status, basicAuthUser, basicAuthPassword})
+	if err != nil {
+		Response("", err, w, r)
+		return
+	}
+
+	report, _ := response.(string)
+	FileResponse([]byte(report), "text/csv; charset=UTF-8", "aml-cases.csv", w, r)
+	return
+}
+
+// Sanctions (staff)
+// Reload the list
+func SanctionsReload(w http.ResponseWriter, r *http.Request) {
+	basicAuthUser, basicAuthPassword, err := getBasicAuthFromHeader(r)
+	if err != nil {
+		Response("", err, w, r)
+		return
+	}
+
+	response, err := sanctions.ProcessSanctions([]string{staffToken(r), "sanctions", "1", basicAuthUser, basicAuthPassword})
+	Response(response, err, w, r)
+	return
+}
+
+// Screen a name
+func SanctionsScreen(w http.ResponseWriter, r *http.Request) {
+	basicAuthUser, basicAuthPassword, err := getBasicAuthFromHeader(r)
+	if err != nil {
+		Response("", err, w, r)
+		return
+	}
+
+	name := r.FormValue("Name")
+
+	response, err := sanctions.ProcessSanctions([]string{staffToken(r), "sanctions", "2", name, basicAuthUser, basicAuthPassword})
+	Response(response, err, w, r)
+	return
+}
+
+// List reviews waiting on a decision
+func SanctionsReviewList(w http.ResponseWriter, r *http.Request) {
+	basicAuthUser, basicAuthPassword, err := getBasicAuthFromHeader(r)
+	if err != nil {
+		Response("", err, w, r)
+		return
+	}
+
+	response, err := sanctions.ProcessSanctions([]string{staffToken(r), "sanctions", "3", basicAuthUser, basicAuthPassword})
+	Response(response, err, w, r)
+	return
+}
+
+// Resolve a review
+func SanctionsReviewResolve(w http.ResponseWriter, r *http.Request) {
+	basicAuthUser, basicAuthPassword, err := getBasicAuthFromHeader(r)
+	if err != nil {
+		Response("", err, w, r)
+		return
+	}
+
+	vars := mux.Vars(r)
+	reviewID := vars["reviewID"]
+	decision := r.FormValue("Decision")
+
+	response, err := sanctions.ProcessSanctions([]string{staffToken(r), "sanctions", "4", reviewID, decision, basicAuthUser, basicAuthPassword})
+	Response(response, err, w, r)
+	return
+}
+
+// List security events, optionally of one type and from a timestamp (staff)
+func AuditIndex(w http.ResponseWriter, r *http.Request) {
+	basicAuthUser, basicAuthPassword, err := getBasicAuthFromHeader(r)
+	if err != nil {
+		Response("", err, w, r)
+		return
+	}
+
+	eventType := r.FormValue("Type")
+	from := r.FormValue("From")
+
+	data := []string{staffToken(r), "audit", "1", eventType, from, basicAuthUser, basicAuthPassword}
+	_, err = appauth.CheckPermission(data, appauth.PERMISSION_AUDIT)
+	if err != nil {
+		Response("", err, w, r)
+		return
+	}
+
+	response, err := audit.ProcessAudit(data)
+	Response(response, err, w, r)
+	return
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"net/http"
 
+	"github.com/bvnk/bank/appauth"
 	"github.com/gorilla/mux"
 )
 
@@ -51,6 +52,97 @@ var routes = Routes{
 		"/auth/account",
 		AuthRemove,
 	},
+	// Swap a refresh token for new tokens
+	Route{
+		"AuthRefresh",
+		"POST",
+		"/auth/refresh",
+		AuthRefresh,
+	},
+	// List the devices logged in on
+	Route{
+		"AuthSessions",
+		"GET",
+		"/auth/sessions",
+		AuthSessions,
+	},
+	// Log out a device
+	Route{
+		"AuthSessionRemove",
+		"DELETE",
+		"/auth/sessions/{sessionID}",
+		AuthSessionRemove,
+	},
+	// Log out everywhere
+	Route{
+		"AuthSessionsRemove",
+		"DELETE",
+		"/auth/sessions",
+		AuthSessionsRemove,
+	},
+	// Second step of logging in with a one-time code
+	Route{
+		"AuthLoginTOTP",
+		"POST",
+		"/auth/login/totp",
+		AuthLoginTOTP,
+	},
+	// Start two-factor enrolment
+	Route{
+		"AuthTOTPEnrol",
+		"POST",
+		"/auth/totp",
+		AuthTOTPEnrol,
+	},
+	// Confirm two-factor enrolment, giving the recovery codes
+	Route{
+		"AuthTOTPConfirm",
+		"POST",
+		"/auth/totp/confirm",
+		AuthTOTPConfirm,
+	},
+	// Disable two-factor
+	Route{
+		"AuthTOTPRemove",
+		"DELETE",
+		"/auth/totp",
+		AuthTOTPRemove,
+	},
+	// Approve the next payment that needs a one-time code
+	Route{
+		"AuthStepUp",
+		"POST",
+		"/auth/stepup",
+		AuthStepUp,
+	},
+	// Change password
+	Route{
+		"AuthPasswordChange",
+		"POST",
+		"/auth/password",
+		AuthPasswordChange,
+	},
+	// Forgot password, sends a reset code
+	Route{
+		"AuthPasswordForgot",
+		"POST",
+		"/auth/password/forgot",
+		AuthPasswordForgot,
+	},
+	// Reset password with the code
+	Route{
+		"AuthPasswordReset",
+		"POST",
+		"/auth/password/reset",
+		AuthPasswordReset,
+	},
+	// Unlock a user locked out after failed logins
+	Route{
+		"AuthUnlock",
+		"POST",
+		"/auth/unlock/{authUser}",
+		AuthUnlock,
+	},
 	// Accounts
 	// Get account details
 	Route{
@@ -101,6 +193,27 @@ var routes = Routes{
 		"/accountPushToken",
 		AccountTokenDelete,
 	},
+	// Set account overdraft
+	Route{
+		"AccountOverdraft",
+		"POST",
+		"/account/{accountId}/overdraft",
+		AccountOverdraft,
+	},
+	// Freeze account
+	Route{
+		"AccountFreeze",
+		"POST",
+		"/account/{accountId}/freeze",
+		AccountFreeze,
+	},
+	// Unfreeze account
+	Route{
+		"AccountUnfreeze",
+		"DELETE",
+		"/account/{accountId}/freeze",
+		AccountUnfreeze,
+	},
 	// Search for account
 	Route{
 		"AccountSearch",
@@ -144,6 +257,42 @@ var routes = Routes{
 		"/account/merchantSearch",
 		MerchantAccountSearch,
 	},
+	// Merchant API keys
+	// List keys
+	Route{
+		"MerchantKeyList",
+		"GET",
+		"/account/merchant/{merchantID}/keys",
+		MerchantKeyList,
+	},
+	// Create key
+	Route{
+		"MerchantKeyCreate",
+		"POST",
+		"/account/merchant/{merchantID}/keys",
+		MerchantKeyCreate,
+	},
+	// Give key a new secret
+	Route{
+		"MerchantKeyRotate",
+		"POST",
+		"/account/merchant/{merchantID}/keys/{clientID}/rotate",
+		MerchantKeyRotate,
+	},
+	// Revoke key
+	Route{
+		"MerchantKeyRevoke",
+		"DELETE",
+		"/account/merchant/{merchantID}/keys/{clientID}",
+		MerchantKeyRevoke,
+	},
+	// Get token with a key
+	Route{
+		"OAuthToken",
+		"POST",
+		"/oauth/token",
+		OAuthToken,
+	},
 	// Transactions
 	// Credit initiation
 	Route{
@@ -159,6 +308,90 @@ var routes = Routes{
 		"/transaction/deposit",
 		TransactionDepositInitiation,
 	},
+	// Credit transfers from an ISO 20022 pain.001 file
+	Route{
+		"TransactionPain001",
+		"POST",
+		"/transaction/pain001",
+		TransactionPain001,
+	},
+	// pacs.008 payments from other banks, answered with a pacs.002
+	Route{
+		"InterbankPacs008",
+		"POST",
+		"/interbank/pacs008",
+		InterbankPacs008,
+	},
+	// Net unsettled interbank payments (staff)
+	Route{
+		"InterbankNetting",
+		"POST",
+		"/interbank/netting",
+		InterbankNetting,
+	},
+	// Mark a settlement as paid (staff)
+	Route{
+		"InterbankSettle",
+		"POST",
+		"/interbank/settlement/{settlementID}/settle",
+		InterbankSettle,
+	},
+	// Settlement file for a business date (staff)
+	Route{
+		"InterbankSettlementFile",
+		"GET",
+		"/interbank/settlement/{businessDate}/file",
+		InterbankSettlementFile,
+	},
+	// Reconciliation of the settlement accounts, as json or csv (staff)
+	Route{
+		"InterbankReconciliation",
+		"GET",
+		"/interbank/reconciliation/{format}",
+		InterbankReconciliation,
+	},
+	// Balances recomputed from the transactions (staff)
+	Route{
+		"TransactionReconciliation",
+		"GET",
+		"/transaction/reconciliation",
+		TransactionReconciliation,
+	},
+	// Correct balances that differ from the transactions (staff)
+	Route{
+		"TransactionReconciliationRepair",
+		"POST",
+		"/transaction/reconciliation/repair",
+		TransactionReconciliationRepair,
+	},
+	// Business date and end of day checkpoints (staff)
+	Route{
+		"EODStatus",
+		"GET",
+		"/eod",
+		EODStatus,
+	},
+	// Run the end of day (staff)
+	Route{
+		"EODRun",
+		"POST",
+		"/eod/run",
+		EODRun,
+	},
+	// Batch of payments from a merchant account, as csv or json
+	Route{
+		"TransactionBatch",
+		"POST",
+		"/transaction/batch",
+		TransactionBatch,
+	},
+	// Batch progress and the result of each payment
+	Route{
+		"TransactionBatchView",
+		"GET",
+		"/transaction/batch/{batchID}",
+		TransactionBatchView,
+	},
 	// List transactions
 	Route{
 		"TransactionList",
@@ -173,17 +406,256 @@ var routes = Routes{
 		"/transaction/list/{perPage}/{page}/{timestamp}",
 		TransactionList,
 	},
+	// Account statement as csv, pdf, xml (camt.053), ofx, qif or mt940
+	Route{
+		"TransactionStatement",
+		"GET",
+		"/transaction/statement/{from}/{to}/{format}",
+		TransactionStatement,
+	},
+	// ISO 20022 account report (52), statement (53) or debit and credit notification (54)
+	Route{
+		"TransactionCamt",
+		"GET",
+		"/transaction/camt/{camtType}/{from}/{to}",
+		TransactionCamt,
+	},
+	// Pending transactions (staff)
+	Route{
+		"TransactionPendingList",
+		"GET",
+		"/transaction/pending",
+		TransactionPendingList,
+	},
+	Route{
+		"TransactionPendingApprove",
+		"POST",
+		"/transaction/pending/{transactionID}/approve",
+		TransactionPendingApprove,
+	},
+	Route{
+		"TransactionPendingReject",
+		"POST",
+		"/transaction/pending/{transactionID}/reject",
+		TransactionPendingReject,
+	},
+	// Limits
+	// View limits for an account
+	Route{
+		"LimitsView",
+		"GET",
+		"/limits/{accountNumber}",
+		LimitsView,
+	},
+	// Lower a limit
+	Route{
+		"LimitsLower",
+		"POST",
+		"/limits",
+		LimitsLower,
+	},
+	// Set a limit (staff)
+	Route{
+		"LimitsSet",
+		"PUT",
+		"/limits",
+		LimitsSet,
+	},
+	// AML (staff)
+	// List cases
+	Route{
+		"AMLCaseList",
+		"GET",
+		"/aml/cases",
+		AMLCaseList,
+	},
+	// Export cases as a report
+	Route{
+		"AMLCaseExport",
+		"GET",
+		"/aml/cases/export",
+		AMLCaseExport,
+	},
+	// View a case
+	Route{
+		"AMLCaseView",
+		"GET",
+		"/aml/cases/{caseID}",
+		AMLCaseView,
+	},
+	// Assign a case
+	Route{
+		"AMLCaseAssign",
+		"POST",
+		"/aml/cases/{caseID}/assign",
+		AMLCaseAssign,
+	},
+	// Comment on a case
+	Route{
+		"AMLCaseComment",
+		"POST",
+		"/aml/cases/{caseID}/comment",
+		AMLCaseComment,
+	},
+	// Close a case
+	Route{
+		"AMLCaseClose",
+		"POST",
+		"/aml/cases/{caseID}/close",
+		AMLCaseClose,
+	},
+	// Escalate a case
+	Route{
+		"AMLCaseEscalate",
+		"POST",
+		"/aml/cases/{caseID}/escalate",
+		AMLCaseEscalate,
+	},
+	// Sanctions (staff)
+	// Reload the list
+	Route{
+		"SanctionsReload",
+		"POST",
+		"/sanctions/reload",
+		SanctionsReload,
+	},
+	// Screen a name
+	Route{
+		"SanctionsScreen",
+		"GET",
+		"/sanctions/screen",
+		SanctionsScreen,
+	},
+	// List reviews waiting on a decision
+	Route{
+		"SanctionsReviewList",
+		"GET",
+		"/sanctions/reviews",
+		SanctionsReviewList,
+	},
+	// Resolve a review
+	Route{
+		"SanctionsReviewResolve",
+		"POST",
+		"/sanctions/reviews/{reviewID}",
+		SanctionsReviewResolve,
+	},
+	// Audit
+	// List security events
+	Route{
+		"AuditIndex",
+		"GET",
+		"/audit",
+		AuditIndex,
+	},
+	// Staff
+	// List staff users
+	Route{
+		"StaffIndex",
+		"GET",
+		"/staff",
+		StaffIndex,
+	},
+	// Add staff user
+	Route{
+		"StaffCreate",
+		"POST",
+		"/staff",
+		StaffCreate,
+	},
+	// Change staff user's role
+	Route{
+		"StaffUpdate",
+		"PUT",
+		"/staff/{username}",
+		StaffUpdate,
+	},
+	// Disable staff user
+	Route{
+		"StaffDisable",
+		"DELETE",
+		"/staff/{username}",
+		StaffDisable,
+	},
+	// Client certificates
+	// List client certificates
+	Route{
+		"CertificateIndex",
+		"GET",
+		"/certificates",
+		CertificateIndex,
+	},
+	// Enrol client certificate
+	Route{
+		"CertificateCreate",
+		"POST",
+		"/certificates",
+		CertificateCreate,
+	},
+	// Revoke client certificate
+	Route{
+		"CertificateRevoke",
+		"DELETE",
+		"/certificates/{fingerprint}",
+		CertificateRevoke,
+	},
+}
+
+// Permission a staff route needs, checked before its handler. The packages
+// check again themselves, as the same actions can be sent over TCP.
+var routePermissions = map[string]string{
+	"AuthUnlock":                      appauth.PERMISSION_UNLOCK,
+	"AccountOverdraft":                appauth.PERMISSION_OVERDRAFT,
+	"AccountFreeze":                   appauth.PERMISSION_FREEZE,
+	"AccountUnfreeze":                 appauth.PERMISSION_FREEZE,
+	"TransactionDepositInitiation":    appauth.PERMISSION_DEPOSIT,
+	"TransactionReconciliation":       appauth.PERMISSION_RECONCILE,
+	"TransactionReconciliationRepair": appauth.PERMISSION_RECONCILE,
+	"TransactionPendingList":          appauth.PERMISSION_PAYMENT_REVIEW,
+	"TransactionPendingApprove":       appauth.PERMISSION_PAYMENT_REVIEW,
+	"TransactionPendingReject":        appauth.PERMISSION_PAYMENT_REVIEW,
+	"LimitsSet":                       appauth.PERMISSION_LIMITS,
+	"InterbankNetting":                appauth.PERMISSION_INTERBANK,
+	"InterbankSettle":                 appauth.PERMISSION_INTERBANK,
+	"InterbankSettlementFile":         appauth.PERMISSION_INTERBANK,
+	"InterbankReconciliation":         appauth.PERMISSION_INTERBANK,
+	"EODStatus":                       appauth.PERMISSION_EOD,
+	"EODRun":                          appauth.PERMISSION_EOD,
+	"AMLCaseList":                     appauth.PERMISSION_AML,
+	"AMLCaseView":                     appauth.PERMISSION_AML,
+	"AMLCaseAssign":                   appauth.PERMISSION_AML,
+	"AMLCaseComment":                  appauth.PERMISSION_AML,
+	"AMLCaseEscalate":                 appauth.PERMISSION_AML,
+	"AMLCaseClose":                    appauth.PERMISSION_AML,
+	"AMLCaseExport":                   appauth.PERMISSION_AML,
+	"SanctionsScreen":                 appauth.PERMISSION_SANCTIONS,
+	"SanctionsReload":                 appauth.PERMISSION_SANCTIONS,
+	"SanctionsReviewList":             appauth.PERMISSION_SANCTIONS,
+	"SanctionsReviewResolve":          appauth.PERMISSION_SANCTIONS,
+	"AuditIndex":                      appauth.PERMISSION_AUDIT,
+	"StaffIndex":                      appauth.PERMISSION_STAFF,
+	"StaffCreate":                     appauth.PERMISSION_STAFF,
+	"StaffUpdate":                     appauth.PERMISSION_STAFF,
+	"StaffDisable":                    appauth.PERMISSION_STAFF,
+	"CertificateIndex":                appauth.PERMISSION_CERTIFICATES,
+	"CertificateCreate":               appauth.PERMISSION_CERTIFICATES,
+	"CertificateRevoke":               appauth.PERMISSION_CERTIFICATES,
 }
 
 func NewRouter() *mux.Router {
 
 	router := mux.NewRouter().StrictSlash(true)
 	for _, route := range routes {
+		handler := route.HandlerFunc
+		if permission, ok := routePermissions[route.Name]; ok {
+			handler = requirePermission(permission, handler)
+		}
+
 		router.
 			Methods(route.Method).
 			Path(route.Pattern).
 			Name(route.Name).
-			Handler(route.HandlerFunc)
+			Handler(handler)
 	}
 
 	return router
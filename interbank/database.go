@@ -0,0 +1,429 @@
+package interbank
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/bvnk/bank/configuration"
+	"github.com/shopspring/decimal"
+)
+
+var Config configuration.Configuration
+
+func SetConfig(config *configuration.Configuration) {
+	Config = *config
+}
+
+// Execer is what queuing a payment needs from the database. Both *sql.DB and
+// *sql.Tx satisfy it, so a payment is queued in the same database transaction
+// that debits the sender.
+type Execer interface {
+	Prepare(query string) (*sql.Stmt, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// QueuePayment saves a pacs.008 message for delivery
+func QueuePayment(db Execer, payment Payment) (id int64, err error) {
+	insertStatement := "INSERT INTO interbank_payments (`transactionID`, `bankNumber`, `senderAccountNumber`, `messageID`, `message`, `status`, `error`, `timestamp`, `nextAttempt`) "
+	insertStatement += "VALUES(?, ?, ?, ?, ?, ?, '', ?, ?)"
+	stmtIns, err := db.Prepare(insertStatement)
+	if err != nil {
+		return 0, errors.New("interbank.QueuePayment: " + err.Error())
+	}
+	defer stmtIns.Close()
+
+	t := time.Now()
+	sqlTime := int32(t.Unix())
+
+	res, err := stmtIns.Exec(payment.TransactionID, payment.BankNumber, payment.SenderAccountNumber, payment.MessageID, payment.Message, PAYMENT_STATUS_QUEUED, sqlTime, sqlTime)
+	if err != nil {
+		return 0, errors.New("interbank.QueuePayment: " + err.Error())
+	}
+
+	id, err = res.LastInsertId()
+	if err != nil {
+		return 0, errors.New("interbank.QueuePayment: " + err.Error())
+	}
+	return
+}
+
+// DuePayments lists queued payments whose next delivery is due
+func DuePayments() (payments []Payment, err error) {
+	rows, err := Config.Db.Query("SELECT `id`, `transactionID`, `bankNumber`, `senderAccountNumber`, `messageID`, `message`, `status`, `attempts`, `error`, `timestamp`, `nextAttempt`, `completedTimestamp` FROM `interbank_payments` WHERE `status` = ? AND `nextAttempt` <= ? ORDER BY `id` LIMIT ?", PAYMENT_STATUS_QUEUED, time.Now().Unix(), DUE_PAYMENTS_MAX)
+	if err != nil {
+		return nil, errors.New("interbank.DuePayments: " + err.Error())
+	}
+	defer rows.Close()
+
+	payments, err = scanPayments(rows)
+	if err != nil {
+		return nil, errors.New("interbank.DuePayments: " + err.Error())
+	}
+	return
+}
+
+func getPayments(status string) (payments []Payment, err error) {
+	rows, err := Config.Db.Query("SELECT `id`, `transactionID`, `bankNumber`, `senderAccountNumber`, `messageID`, `message`, `status`, `attempts`, `error`, `timestamp`, `nextAttempt`, `completedTimestamp` FROM `interbank_payments` WHERE `status` = ? ORDER BY `id`", status)
+	if err != nil {
+		return nil, errors.New("interbank.getPayments: " + err.Error())
+	}
+	defer rows.Close()
+
+	payments, err = scanPayments(rows)
+	if err != nil {
+		return nil, errors.New("interbank.getPayments: " + err.Error())
+	}
+	return
+}
+
+func scanPayments(rows *sql.Rows) (payments []Payment, err error) {
+	for rows.Next() {
+		p := Payment{}
+		if err := rows.Scan(&p.ID, &p.TransactionID, &p.BankNumber, &p.SenderAccountNumber, &p.MessageID, &p.Message, &p.Status, &p.Attempts, &p.Error, &p.Timestamp, &p.NextAttempt, &p.CompletedTimestamp); err != nil {
+			return nil, err
+		}
+		payments = append(payments, p)
+	}
+	return
+}
+
+// ClaimPayment takes a due payment for delivery so no other worker sends it at the same time.
+// claimed is false if the payment was no longer due.
+func ClaimPayment(paymentID int64) (claimed bool, err error) {
+	now := time.Now().Unix()
+	res, err := Config.Db.Exec("UPDATE `interbank_payments` SET `nextAttempt` = ? WHERE `id` = ? AND `status` = ? AND `nextAttempt` <= ?", now+CLAIM_SECONDS, paymentID, PAYMENT_STATUS_QUEUED, now)
+	if err != nil {
+		return false, errors.New("interbank.ClaimPayment: " + err.Error())
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, errors.New("interbank.ClaimPayment: " + err.Error())
+	}
+
+	claimed = affected == 1
+	return
+}
+
+// RetryPaymentLater records a failed delivery. The payment fails once it runs out of attempts.
+func RetryPaymentLater(payment Payment, reason string) (err error) {
+	attempts := payment.Attempts + 1
+	t := time.Now()
+
+	status, nextAttempt, completed := PAYMENT_STATUS_QUEUED, t.Add(RetryDelay(attempts)).Unix(), int64(0)
+	if attempts >= MAX_ATTEMPTS {
+		status, completed = PAYMENT_STATUS_FAILED, t.Unix()
+	}
+
+	_, err = Config.Db.Exec("UPDATE `interbank_payments` SET `status` = ?, `attempts` = ?, `error` = ?, `nextAttempt` = ?, `completedTimestamp` = ? WHERE `id` = ?", status, attempts, reason, nextAttempt, completed, payment.ID)
+	if err != nil {
+		return errors.New("interbank.RetryPaymentLater: " + err.Error())
+	}
+	return
+}
+
+// CompletePayment records the receiving bank's answer to a payment
+func CompletePayment(payment Payment, status string, reason string) (err error) {
+	_, err = Config.Db.Exec("UPDATE `interbank_payments` SET `status` = ?, `attempts` = ?, `error` = ?, `completedTimestamp` = ? WHERE `id` = ?", status, payment.Attempts+1, reason, time.Now().Unix(), payment.ID)
+	if err != nil {
+		return errors.New("interbank.CompletePayment: " + err.Error())
+	}
+	return
+}
+
+// requeuePayment gives a failed payment a new set of attempts
+func requeuePayment(paymentID int64) (requeued bool, err error) {
+	res, err := Config.Db.Exec("UPDATE `interbank_payments` SET `status` = ?, `attempts` = 0, `nextAttempt` = 0, `completedTimestamp` = 0 WHERE `id` = ? AND `status` = ?", PAYMENT_STATUS_QUEUED, paymentID, PAYMENT_STATUS_FAILED)
+	if err != nil {
+		return false, errors.New("interbank.requeuePayment: " + err.Error())
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, errors.New("interbank.requeuePayment: " + err.Error())
+	}
+
+	requeued = affected == 1
+	return
+}
+
+// GetReceived looks up a transfer already received from a bank.
+// found is false if the transfer is new.
+func GetReceived(bankNumber string, txID string) (received Received, found bool, err error) {
+	err = Config.Db.QueryRow("SELECT `bankNumber`, `messageID`, `txID`, `transactionID`, `status`, `reason`, `info`, `timestamp` FROM `interbank_received` WHERE `bankNumber` = ? AND `txID` = ?", bankNumber, txID).Scan(&received.BankNumber, &received.MessageID, &received.TxID, &received.TransactionID, &received.Status, &received.Reason, &received.Info, &received.Timestamp)
+	switch {
+	case err == sql.ErrNoRows:
+		return Received{}, false, nil
+	case err != nil:
+		return Received{}, false, errors.New("interbank.GetReceived: " + err.Error())
+	}
+
+	found = true
+	return
+}
+
+// SaveReceived records what was done with a transfer. A transfer can only be saved once
+// per bank, so saving it in the same database transaction as the credit stops it
+// being credited twice.
+func SaveReceived(db Execer, received Received) (err error) {
+	insertStatement := "INSERT INTO interbank_received (`bankNumber`, `messageID`, `txID`, `transactionID`, `status`, `reason`, `info`, `timestamp`) "
+	insertStatement += "VALUES(?, ?, ?, ?, ?, ?, ?, ?)"
+	stmtIns, err := db.Prepare(insertStatement)
+	if err != nil {
+		return errors.New("interbank.SaveReceived: " + err.Error())
+	}
+	defer stmtIns.Close()
+
+	t := time.Now()
+	sqlTime := int32(t.Unix())
+
+	_, err = stmtIns.Exec(received.BankNumber, received.MessageID, received.TxID, received.TransactionID, received.Status, received.Reason, received.Info, sqlTime)
+	if err != nil {
+		return errors.New("interbank.SaveReceived: " + err.Error())
+	}
+	return
+}
+
+// updateSettlementAccount applies a movement to a nostro or vostro account, opening it if needed
+func updateSettlementAccount(db Execer, account string, bankNumber string, amount decimal.Decimal, sqlTime int32) (err error) {
+	updateStatement := "INSERT INTO bank_account (`type`, `bankNumber`, `balance`, `timestamp`) VALUES(?, ?, ?, ?) "
+	updateStatement += "ON DUPLICATE KEY UPDATE `balance` = (`balance` + VALUES(`balance`)), `timestamp` = VALUES(`timestamp`)"
+	stmtUpd, err := db.Prepare(updateStatement)
+	if err != nil {
+		return errors.New("interbank.updateSettlementAccount: " + err.Error())
+	}
+	defer stmtUpd.Close()
+
+	_, err = stmtUpd.Exec(account, bankNumber, amount, sqlTime)
+	if err != nil {
+		return errors.New("interbank.updateSettlementAccount: " + err.Error())
+	}
+	return
+}
+
+func saveMovement(db Execer, movement Movement) (err error) {
+	insertStatement := "INSERT INTO bank_transactions (`transaction`, `type`, `account`, `senderBankNumber`, `receiverBankNumber`, `transactionAmount`, `feeAmount`, `transactionID`, `settlementID`, `timestamp`) "
+	insertStatement += "VALUES(?, ?, ?, ?, ?, ?, 0, ?, ?, ?)"
+	stmtIns, err := db.Prepare(insertStatement)
+	if err != nil {
+		return errors.New("interbank.saveMovement: " + err.Error())
+	}
+	defer stmtIns.Close()
+
+	_, err = stmtIns.Exec(movement.Transaction, movement.Type, movement.Account, movement.SenderBankNumber, movement.ReceiverBankNumber, movement.Amount, movement.TransactionID, movement.SettlementID, movement.Timestamp)
+	if err != nil {
+		return errors.New("interbank.saveMovement: " + err.Error())
+	}
+	return
+}
+
+func scanMovements(rows *sql.Rows) (movements []Movement, err error) {
+	for rows.Next() {
+		m := Movement{}
+		if err := rows.Scan(&m.ID, &m.Transaction, &m.Type, &m.Account, &m.SenderBankNumber, &m.ReceiverBankNumber, &m.Amount, &m.TransactionID, &m.SettlementID, &m.Timestamp); err != nil {
+			return nil, err
+		}
+		movements = append(movements, m)
+	}
+	return
+}
+
+// getSettlementMovements lists every movement on the nostro and vostro accounts
+func getSettlementMovements() (movements []Movement, err error) {
+	rows, err := Config.Db.Query("SELECT `id`, `transaction`, `type`, `account`, `senderBankNumber`, `receiverBankNumber`, `transactionAmount`, `transactionID`, `settlementID`, `timestamp` FROM `bank_transactions` WHERE `account` IN (?, ?) ORDER BY `id`", ACCOUNT_NOSTRO, ACCOUNT_VOSTRO)
+	if err != nil {
+		return nil, errors.New("interbank.getSettlementMovements: " + err.Error())
+	}
+	defer rows.Close()
+
+	movements, err = scanMovements(rows)
+	if err != nil {
+		return nil, errors.New("interbank.getSettlementMovements: " + err.Error())
+	}
+	return
+}
+
+// getBusinessDate gives the business date the end of day keeps, which
+// interbank cannot ask the eod package for as eod runs the netting
+func getBusinessDate() (businessDate string, err error) {
+	err = Config.Db.QueryRow("SELECT `businessDate` FROM `business_date` WHERE `id` = 1").Scan(&businessDate)
+	if err != nil {
+		return "", errors.New("interbank.getBusinessDate: " + err.Error())
+	}
+	return
+}
+
+// saveNetting settles every payment movement not yet in a settlement whose
+// payment takes effect by the business date. Payments booked after it was
+// closed are left for the next business date. The movements are locked while
+// netting so a payment posted meanwhile waits for the next run.
+func saveNetting(businessDate string) (settlements []Settlement, err error) {
+	tx, err := Config.Db.Begin()
+	if err != nil {
+		return nil, errors.New("interbank.saveNetting: " + err.Error())
+	}
+	defer tx.Rollback()
+
+	// A movement takes effect with the payment it is for, a return with the original payment
+	valueDateBy := "SELECT `id` FROM `transactions` WHERE `valueDate` <= ?"
+
+	rows, err := tx.Query("SELECT `id`, `transaction`, `type`, `account`, `senderBankNumber`, `receiverBankNumber`, `transactionAmount`, `transactionID`, `settlementID`, `timestamp` FROM `bank_transactions` WHERE `transaction` = ? AND `account` IN (?, ?) AND `settlementID` = 0 AND `transactionID` IN ("+valueDateBy+") ORDER BY `id` FOR UPDATE", MOVEMENT_PACS, ACCOUNT_NOSTRO, ACCOUNT_VOSTRO, businessDate)
+	if err != nil {
+		return nil, errors.New("interbank.saveNetting: " + err.Error())
+	}
+	movements, err := scanMovements(rows)
+	rows.Close()
+	if err != nil {
+		return nil, errors.New("interbank.saveNetting: " + err.Error())
+	}
+	if len(movements) == 0 {
+		return []Settlement{}, nil
+	}
+	maxID := movements[len(movements)-1].ID
+
+	t := time.Now()
+	sqlTime := int32(t.Unix())
+
+	settlements = netPositions(movements, businessDate)
+	for i := range settlements {
+		settlements[i].Timestamp = sqlTime
+		res, err := tx.Exec("INSERT INTO interbank_settlements (`bankNumber`, `businessDate`, `paymentsOut`, `amountOut`, `returns`, `amountReturned`, `paymentsIn`, `amountIn`, `netAmount`, `status`, `timestamp`) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+			settlements[i].BankNumber, settlements[i].BusinessDate, settlements[i].PaymentsOut, settlements[i].AmountOut, settlements[i].Returns, settlements[i].AmountReturned, settlements[i].PaymentsIn, settlements[i].AmountIn, settlements[i].NetAmount, settlements[i].Status, sqlTime)
+		if err != nil {
+			return nil, errors.New("interbank.saveNetting: " + err.Error())
+		}
+		settlements[i].ID, err = res.LastInsertId()
+		if err != nil {
+			return nil, errors.New("interbank.saveNetting: " + err.Error())
+		}
+
+		_, err = tx.Exec("UPDATE `bank_transactions` SET `settlementID` = ? WHERE `transaction` = ? AND `account` IN (?, ?) AND `settlementID` = 0 AND `id` <= ? AND `transactionID` IN ("+valueDateBy+") AND (`senderBankNumber` = ? OR `receiverBankNumber` = ?)",
+			settlements[i].ID, MOVEMENT_PACS, ACCOUNT_NOSTRO, ACCOUNT_VOSTRO, maxID, businessDate, settlements[i].BankNumber, settlements[i].BankNumber)
+		if err != nil {
+			return nil, errors.New("interbank.saveNetting: " + err.Error())
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, errors.New("interbank.saveNetting: " + err.Error())
+	}
+	return
+}
+
+// saveSettled marks a pending settlement as paid and replenishes the settlement accounts.
+// settled is false if the settlement was not pending.
+func saveSettled(settlementID int64) (settled bool, err error) {
+	tx, err := Config.Db.Begin()
+	if err != nil {
+		return false, errors.New("interbank.saveSettled: " + err.Error())
+	}
+	defer tx.Rollback()
+
+	settlement := Settlement{}
+	err = tx.QueryRow("SELECT `id`, `bankNumber`, `businessDate`, `paymentsOut`, `amountOut`, `returns`, `amountReturned`, `paymentsIn`, `amountIn`, `netAmount`, `status`, `timestamp`, `settledTimestamp` FROM `interbank_settlements` WHERE `id` = ? AND `status` = ? FOR UPDATE", settlementID, SETTLEMENT_STATUS_PENDING).Scan(&settlement.ID, &settlement.BankNumber, &settlement.BusinessDate, &settlement.PaymentsOut, &settlement.AmountOut, &settlement.Returns, &settlement.AmountReturned, &settlement.PaymentsIn, &settlement.AmountIn, &settlement.NetAmount, &settlement.Status, &settlement.Timestamp, &settlement.SettledTimestamp)
+	switch {
+	case err == sql.ErrNoRows:
+		return false, nil
+	case err != nil:
+		return false, errors.New("interbank.saveSettled: " + err.Error())
+	}
+
+	_, err = tx.Exec("UPDATE `interbank_settlements` SET `status` = ?, `settledTimestamp` = ? WHERE `id` = ?", SETTLEMENT_STATUS_SETTLED, time.Now().Unix(), settlementID)
+	if err != nil {
+		return false, errors.New("interbank.saveSettled: " + err.Error())
+	}
+
+	for _, movement := range settlementMovements(settlement) {
+		err = PostMovement(tx, movement)
+		if err != nil {
+			return false, errors.New("interbank.saveSettled: " + err.Error())
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return false, errors.New("interbank.saveSettled: " + err.Error())
+	}
+
+	settled = true
+	return
+}
+
+func getSettlements(businessDate string) (settlements []Settlement, err error) {
+	rows, err := Config.Db.Query("SELECT `id`, `bankNumber`, `businessDate`, `paymentsOut`, `amountOut`, `returns`, `amountReturned`, `paymentsIn`, `amountIn`, `netAmount`, `status`, `timestamp`, `settledTimestamp` FROM `interbank_settlements` WHERE `businessDate` = ? ORDER BY `id`", businessDate)
+	if err != nil {
+		return nil, errors.New("interbank.getSettlements: " + err.Error())
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		s := Settlement{}
+		if err := rows.Scan(&s.ID, &s.BankNumber, &s.BusinessDate, &s.PaymentsOut, &s.AmountOut, &s.Returns, &s.AmountReturned, &s.PaymentsIn, &s.AmountIn, &s.NetAmount, &s.Status, &s.Timestamp, &s.SettledTimestamp); err != nil {
+			return nil, errors.New("interbank.getSettlements: " + err.Error())
+		}
+		settlements = append(settlements, s)
+	}
+	return
+}
+
+// getSettlementAccounts lists the nostro and vostro account balances
+func getSettlementAccounts() (balances []AccountReconciliation, err error) {
+	rows, err := Config.Db.Query("SELECT `type`, `bankNumber`, `balance` FROM `bank_account` WHERE `type` IN (?, ?) ORDER BY `type`, `bankNumber`", ACCOUNT_NOSTRO, ACCOUNT_VOSTRO)
+	if err != nil {
+		return nil, errors.New("interbank.getSettlementAccounts: " + err.Error())
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		a := AccountReconciliation{}
+		if err := rows.Scan(&a.Account, &a.BankNumber, &a.Balance); err != nil {
+			return nil, errors.New("interbank.getSettlementAccounts: " + err.Error())
+		}
+		balances = append(balances, a)
+	}
+	return
+}
+
+// getReconciliationBreaks finds interbank payments without the movements they should have posted
+func getReconciliationBreaks() (breaks []ReconciliationBreak, err error) {
+	checks := []struct {
+		issue string
+		query string
+		args  []interface{}
+	}{
+		{
+			"Payment out not posted to nostro account",
+			"SELECT p.`transactionID`, p.`bankNumber` FROM `interbank_payments` p LEFT JOIN `bank_transactions` b ON b.`transactionID` = p.`transactionID` AND b.`transaction` = ? AND b.`type` = ? AND b.`account` = ? WHERE b.`id` IS NULL ORDER BY p.`id`",
+			[]interface{}{MOVEMENT_PACS, MOVEMENT_PAYMENT, ACCOUNT_NOSTRO},
+		},
+		{
+			"Rejected payment not returned to nostro account",
+			"SELECT p.`transactionID`, p.`bankNumber` FROM `interbank_payments` p LEFT JOIN `bank_transactions` b ON b.`transactionID` = p.`transactionID` AND b.`transaction` = ? AND b.`type` = ? AND b.`account` = ? WHERE p.`status` = ? AND b.`id` IS NULL ORDER BY p.`id`",
+			[]interface{}{MOVEMENT_PACS, MOVEMENT_RETURN, ACCOUNT_NOSTRO, PAYMENT_STATUS_REJECTED},
+		},
+		{
+			"Payment in not posted to vostro account",
+			"SELECT r.`transactionID`, r.`bankNumber` FROM `interbank_received` r LEFT JOIN `bank_transactions` b ON b.`transactionID` = r.`transactionID` AND b.`transaction` = ? AND b.`type` = ? AND b.`account` = ? WHERE r.`status` = ? AND b.`id` IS NULL ORDER BY r.`timestamp`",
+			[]interface{}{MOVEMENT_PACS, MOVEMENT_PAYMENT, ACCOUNT_VOSTRO, STATUS_SETTLED},
+		},
+	}
+
+	for _, check := range checks {
+		rows, err := Config.Db.Query(check.query, check.args...)
+		if err != nil {
+			return nil, errors.New("interbank.getReconciliationBreaks: " + err.Error())
+		}
+		for rows.Next() {
+			b := ReconciliationBreak{Issue: check.issue}
+			if err := rows.Scan(&b.TransactionID, &b.BankNumber); err != nil {
+				rows.Close()
+				return nil, errors.New("interbank.getReconciliationBreaks: " + err.Error())
+			}
+			breaks = append(breaks, b)
+		}
+		rows.Close()
+	}
+	return
+}
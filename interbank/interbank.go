@@ -0,0 +1,288 @@
+package interbank
+
+/*
+Interbank package moves payments between this bank and its peers.
+
+Payments to an account in another bank are queued as pacs.008 messages when the
+sender's account is debited. The messages are delivered to the receiving bank's
+HTTP API, which credits its account holder and replies with a pacs.002. A
+rejected payment is returned to the sender. Messages that cannot be delivered
+are retried until they fail, after which staff can queue them again.
+
+Peers are configured by bank number, along with the URL of their API and the
+secret both banks use to authenticate each other.
+
+Every payment is posted to a settlement account for its peer: payments out to
+our nostro account with the peer, payments in to the peer's vostro account with
+us. A netting run at the end of the day turns the unsettled payments into one
+net position per peer, which staff mark as settled once it has been paid. The
+reconciliation report checks the settlement accounts against their movements.
+
+All PACS transactions are staff only, the basic auth user and password are
+always the last two values.
+
+PACS transactions are as follows:
+1 - ListPayments
+2 - RetryPayment
+3 - RunNetting
+4 - Settle
+5 - SettlementFile
+6 - Reconciliation
+
+*/
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bvnk/bank/accounts"
+	"github.com/bvnk/bank/appauth"
+	"github.com/bvnk/bank/configuration"
+)
+
+const (
+	PAYMENT_STATUS_QUEUED   = "queued"
+	PAYMENT_STATUS_ACCEPTED = "accepted"
+	PAYMENT_STATUS_REJECTED = "rejected"
+	PAYMENT_STATUS_FAILED   = "failed"
+
+	// Path peers receive pacs.008 messages on
+	PACS_008_PATH = "/interbank/pacs008"
+
+	// How often queued payments are delivered
+	POLL_INTERVAL = 5 * time.Second
+	// How long a delivery may take before another worker can pick the payment up
+	CLAIM_SECONDS = 120
+	// Retries back off from RETRY_SECONDS doubling up to MAX_RETRY_SECONDS
+	RETRY_SECONDS     = 10
+	MAX_RETRY_SECONDS = 3600
+	// Deliveries are given up on after this many attempts
+	MAX_ATTEMPTS = 10
+
+	SEND_TIMEOUT     = 30 * time.Second
+	MAX_REPLY_BYTES  = 1 << 20
+	DUE_PAYMENTS_MAX = 100
+)
+
+// Payment is a pacs.008 message waiting for, or done with, delivery to another bank
+type Payment struct {
+	ID                  int64
+	TransactionID       int64
+	BankNumber          string
+	SenderAccountNumber string
+	MessageID           string
+	Message             string
+	Status              string
+	Attempts            int
+	Error               string
+	Timestamp           int32
+	NextAttempt         int32
+	CompletedTimestamp  int32
+}
+
+// Received is a transfer from another bank and what was done with it
+type Received struct {
+	BankNumber    string
+	MessageID     string
+	TxID          string
+	TransactionID int64
+	Status        string
+	Reason        string
+	Info          string
+	Timestamp     int32
+}
+
+func ProcessPACS(data []string) (result interface{}, err error) {
+	if len(data) < 5 {
+		return "", errors.New("interbank.ProcessPACS: Not all data is present")
+	}
+
+	// ~pacs~type~...~basicAuthUser~basicAuthPassword
+	_, err = appauth.CheckPermission(data, appauth.PERMISSION_INTERBANK)
+	if err != nil {
+		return "", errors.New("interbank.ProcessPACS: " + err.Error())
+	}
+
+	switch data[2] {
+	// List payments to other banks
+	case "1":
+		// ~pacs~1~status~basicAuthUser~basicAuthPassword
+		if len(data) < 6 {
+			return "", errors.New("interbank.ProcessPACS: Not all data is present")
+		}
+		result, err = getPayments(data[3])
+		if err != nil {
+			return "", errors.New("interbank.ProcessPACS: " + err.Error())
+		}
+	// Queue a failed payment for delivery again
+	case "2":
+		// ~pacs~2~paymentID~basicAuthUser~basicAuthPassword
+		if len(data) < 6 {
+			return "", errors.New("interbank.ProcessPACS: Not all data is present")
+		}
+		result, err = retryPayment(data[3])
+		if err != nil {
+			return "", errors.New("interbank.ProcessPACS: " + err.Error())
+		}
+	// Net unsettled payments into a settlement per peer
+	case "3":
+		// ~pacs~3~businessDate~basicAuthUser~basicAuthPassword
+		if len(data) < 6 {
+			return "", errors.New("interbank.ProcessPACS: Not all data is present")
+		}
+		result, err = RunNetting(data[3])
+		if err != nil {
+			return "", errors.New("interbank.ProcessPACS: " + err.Error())
+		}
+	// Mark a settlement as paid
+	case "4":
+		// ~pacs~4~settlementID~basicAuthUser~basicAuthPassword
+		if len(data) < 6 {
+			return "", errors.New("interbank.ProcessPACS: Not all data is present")
+		}
+		result, err = settle(data[3])
+		if err != nil {
+			return "", errors.New("interbank.ProcessPACS: " + err.Error())
+		}
+	// Settlement file for a business date
+	case "5":
+		// ~pacs~5~businessDate~basicAuthUser~basicAuthPassword
+		if len(data) < 6 {
+			return "", errors.New("interbank.ProcessPACS: Not all data is present")
+		}
+		result, err = settlementFile(data[3])
+		if err != nil {
+			return "", errors.New("interbank.ProcessPACS: " + err.Error())
+		}
+	// Reconciliation report
+	case "6":
+		// ~pacs~6~format~basicAuthUser~basicAuthPassword
+		if len(data) < 6 {
+			return "", errors.New("interbank.ProcessPACS: Not all data is present")
+		}
+		result, err = reconcile(data[3])
+		if err != nil {
+			return "", errors.New("interbank.ProcessPACS: " + err.Error())
+		}
+	default:
+		return "", errors.New("interbank.ProcessPACS: PACS type not valid")
+	}
+
+	return
+}
+
+// GetPeer looks up the bank a payment is sent to
+func GetPeer(bankNumber string) (peer configuration.Peer, err error) {
+	peer, ok := Config.Peers[bankNumber]
+	if !ok || peer.URL == "" {
+		return configuration.Peer{}, errors.New("interbank.GetPeer: Bank " + bankNumber + " is not a peer")
+	}
+	return
+}
+
+// CheckPeer authenticates a bank sending us a message
+func CheckPeer(bankNumber string, secret string) (err error) {
+	peer, err := GetPeer(bankNumber)
+	if err != nil || peer.Secret == "" {
+		return errors.New("interbank.CheckPeer: Peer not valid")
+	}
+	if subtle.ConstantTimeCompare([]byte(peer.Secret), []byte(secret)) != 1 {
+		return errors.New("interbank.CheckPeer: Peer not valid")
+	}
+	return
+}
+
+// Send delivers a pacs.008 message to a peer and returns its pacs.002 reply
+func Send(bankNumber string, message []byte) (reply []byte, err error) {
+	peer, err := GetPeer(bankNumber)
+	if err != nil {
+		return nil, errors.New("interbank.Send: " + err.Error())
+	}
+
+	client, err := peerClient(peer)
+	if err != nil {
+		return nil, errors.New("interbank.Send: " + err.Error())
+	}
+
+	req, err := http.NewRequest("POST", strings.TrimRight(peer.URL, "/")+PACS_008_PATH, bytes.NewReader(message))
+	if err != nil {
+		return nil, errors.New("interbank.Send: " + err.Error())
+	}
+	req.Header.Set("Content-Type", "application/xml; charset=UTF-8")
+	// Peers know us by our bank number and the secret we share with them
+	req.SetBasicAuth(accounts.LocalBankNumber(), peer.Secret)
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, errors.New("interbank.Send: " + err.Error())
+	}
+	defer res.Body.Close()
+
+	reply, err = ioutil.ReadAll(io.LimitReader(res.Body, MAX_REPLY_BYTES))
+	if err != nil {
+		return nil, errors.New("interbank.Send: " + err.Error())
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.New("interbank.Send: Peer replied " + res.Status + ". " + string(reply))
+	}
+	return
+}
+
+func peerClient(peer configuration.Peer) (client *http.Client, err error) {
+	tlsConfig := &tls.Config{}
+	if peer.CACertPath != "" {
+		cert, err := ioutil.ReadFile(peer.CACertPath)
+		if err != nil {
+			return nil, errors.New("interbank.peerClient: Could not read peer certificate. " + err.Error())
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(cert) {
+			return nil, errors.New("interbank.peerClient: Peer certificate not valid")
+		}
+		tlsConfig.RootCAs = roots
+	}
+
+	client = &http.Client{
+		Timeout:   SEND_TIMEOUT,
+		Transport: &http.Transport{TLSClientConfig: tlsConfig},
+	}
+	return
+}
+
+// RetryDelay is how long to wait before the next delivery after attempts failed ones
+func RetryDelay(attempts int) time.Duration {
+	delay := RETRY_SECONDS
+	for i := 1; i < attempts && delay < MAX_RETRY_SECONDS; i++ {
+		delay *= 2
+	}
+	if delay > MAX_RETRY_SECONDS {
+		delay = MAX_RETRY_SECONDS
+	}
+	return time.Duration(delay) * time.Second
+}
+
+func retryPayment(paymentIDStr string) (result string, err error) {
+	paymentID, err := strconv.ParseInt(paymentIDStr, 10, 64)
+	if err != nil {
+		return "", errors.New("interbank.retryPayment: Payment ID not valid")
+	}
+
+	requeued, err := requeuePayment(paymentID)
+	if err != nil {
+		return "", errors.New("interbank.retryPayment: " + err.Error())
+	}
+	if !requeued {
+		return "", errors.New("interbank.retryPayment: Only failed payments can be retried")
+	}
+
+	return "Payment queued", nil
+}
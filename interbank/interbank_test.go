@@ -0,0 +1,54 @@
+package interbank
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bvnk/bank/configuration"
+)
+
+func TestCheckPeer(t *testing.T) {
+	Config.Peers = map[string]configuration.Peer{
+		"bank-b": {URL: "https://localhost:8444", Secret: "secret"},
+		"bank-c": {URL: "https://localhost:8445"},
+	}
+	defer func() { Config.Peers = nil }()
+
+	tests := []struct {
+		bankNumber string
+		secret     string
+		valid      bool
+	}{
+		{"bank-b", "secret", true},
+		{"bank-b", "wrong", false},
+		{"bank-b", "", false},
+		{"bank-c", "", false},
+		{"bank-d", "secret", false},
+	}
+
+	for _, test := range tests {
+		err := CheckPeer(test.bankNumber, test.secret)
+		if (err == nil) != test.valid {
+			t.Errorf("CheckPeer does not pass for %v. Looking for %v, got %v", test.bankNumber, test.valid, err)
+		}
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		attempts int
+		expected time.Duration
+	}{
+		{1, 10 * time.Second},
+		{2, 20 * time.Second},
+		{4, 80 * time.Second},
+		{20, MAX_RETRY_SECONDS * time.Second},
+	}
+
+	for _, test := range tests {
+		delay := RetryDelay(test.attempts)
+		if delay != test.expected {
+			t.Errorf("RetryDelay does not pass. Looking for %v, got %v", test.expected, delay)
+		}
+	}
+}
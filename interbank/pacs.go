@@ -0,0 +1,434 @@
+package interbank
+
+import (
+	"encoding/xml"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bvnk/bank/money"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	PACS_008_NAMESPACE        = "urn:iso:std:iso:20022:tech:xsd:pacs.008.001.02"
+	PACS_008_NAMESPACE_PREFIX = "urn:iso:std:iso:20022:tech:xsd:pacs.008.001."
+	PACS_002_NAMESPACE        = "urn:iso:std:iso:20022:tech:xsd:pacs.002.001.03"
+	PACS_002_NAMESPACE_PREFIX = "urn:iso:std:iso:20022:tech:xsd:pacs.002.001."
+
+	// Transaction and group statuses used in pacs.002
+	STATUS_SETTLED  = "ACSC"
+	STATUS_PARTIAL  = "PART"
+	STATUS_REJECTED = "RJCT"
+
+	REASON_FORMAT     = "FF01"
+	REASON_ACCOUNT    = "AC01"
+	REASON_CLOSED     = "AC04"
+	REASON_BLOCKED    = "AC06"
+	REASON_AMOUNT     = "AM12"
+	REASON_CURRENCY   = "AM03"
+	REASON_AGENT      = "AGNT"
+	REASON_REGULATORY = "RR04"
+	REASON_NARRATIVE  = "NARR"
+
+	ADDITIONAL_INFO_LENGTH = 105
+	MESSAGE_ID_LENGTH      = 35
+)
+
+// Transfer is one customer payment from one bank to another
+type Transfer struct {
+	// The sending bank's transaction ID
+	TransactionID   string
+	Amount          decimal.Decimal
+	Currency        string
+	DebtorName      string
+	DebtorAccount   string
+	DebtorBank      string
+	CreditorAccount string
+	CreditorBank    string
+	Description     string
+}
+
+// Status is what the receiving bank did with one transfer
+type Status struct {
+	TransactionID string
+	Status        string
+	Reason        string
+	Info          string
+}
+
+// pacs.008 FIToFICustomerCreditTransfer
+type pacs008Document struct {
+	XMLName  xml.Name       `xml:"Document"`
+	Xmlns    string         `xml:"xmlns,attr"`
+	Transfer pacs008Message `xml:"FIToFICstmrCdtTrf"`
+}
+
+type pacs008Message struct {
+	GrpHdr      pacs008GroupHeader
+	CdtTrfTxInf []pacs008Transaction
+}
+
+type pacs008GroupHeader struct {
+	MsgId             string
+	CreDtTm           string
+	NbOfTxs           string
+	TtlIntrBkSttlmAmt *pacsAmount `xml:"TtlIntrBkSttlmAmt,omitempty"`
+	IntrBkSttlmDt     string      `xml:"IntrBkSttlmDt,omitempty"`
+	SttlmInf          pacsSettlement
+	InstgAgt          *pacsAgent `xml:"InstgAgt,omitempty"`
+	InstdAgt          *pacsAgent `xml:"InstdAgt,omitempty"`
+}
+
+type pacsSettlement struct {
+	SttlmMtd string
+}
+
+type pacs008Transaction struct {
+	PmtId          pacsPaymentId
+	IntrBkSttlmAmt pacsAmount
+	ChrgBr         string
+	Dbtr           pacsParty
+	DbtrAcct       *pacsAccount `xml:"DbtrAcct,omitempty"`
+	DbtrAgt        pacsAgent
+	CdtrAgt        pacsAgent
+	Cdtr           pacsParty
+	CdtrAcct       *pacsAccount    `xml:"CdtrAcct,omitempty"`
+	RmtInf         *pacsRemittance `xml:"RmtInf,omitempty"`
+}
+
+type pacsPaymentId struct {
+	InstrId    string `xml:"InstrId,omitempty"`
+	EndToEndId string
+	TxId       string
+}
+
+type pacsAmount struct {
+	Ccy   string `xml:"Ccy,attr"`
+	Value string `xml:",chardata"`
+}
+
+type pacsParty struct {
+	Nm string `xml:"Nm,omitempty"`
+}
+
+type pacsAccount struct {
+	Id pacsAccountId
+}
+
+type pacsAccountId struct {
+	IBAN string       `xml:"IBAN,omitempty"`
+	Othr *pacsOtherId `xml:"Othr,omitempty"`
+}
+
+type pacsAgent struct {
+	FinInstnId pacsInstitutionId
+}
+
+type pacsInstitutionId struct {
+	BIC  string       `xml:"BIC,omitempty"`
+	Othr *pacsOtherId `xml:"Othr,omitempty"`
+}
+
+type pacsOtherId struct {
+	Id string
+}
+
+type pacsRemittance struct {
+	Ustrd []string
+}
+
+// pacs.002 FIToFIPaymentStatusReport
+type pacs002Document struct {
+	XMLName xml.Name      `xml:"Document"`
+	Xmlns   string        `xml:"xmlns,attr"`
+	Report  pacs002Report `xml:"FIToFIPmtStsRpt"`
+}
+
+type pacs002Report struct {
+	GrpHdr            pacs002GroupHeader
+	OrgnlGrpInfAndSts pacs002GroupStatus
+	TxInfAndSts       []pacs002TransactionStatus `xml:"TxInfAndSts,omitempty"`
+}
+
+type pacs002GroupHeader struct {
+	MsgId   string
+	CreDtTm string
+}
+
+type pacs002GroupStatus struct {
+	OrgnlMsgId   string
+	OrgnlMsgNmId string
+	GrpSts       string
+	StsRsnInf    *pacsReason `xml:"StsRsnInf,omitempty"`
+}
+
+type pacs002TransactionStatus struct {
+	OrgnlTxId string
+	TxSts     string
+	StsRsnInf *pacsReason `xml:"StsRsnInf,omitempty"`
+}
+
+type pacsReason struct {
+	Rsn      pacsCode
+	AddtlInf string `xml:"AddtlInf,omitempty"`
+}
+
+type pacsCode struct {
+	Cd string
+}
+
+// CreditTransfer008 builds a pacs.008 message from bankNumber for the transfers
+func CreditTransfer008(messageID string, bankNumber string, transfers []Transfer, created time.Time) (content []byte, err error) {
+	if len(transfers) == 0 {
+		return nil, errors.New("interbank.CreditTransfer008: No transfers in message")
+	}
+
+	total := decimal.Zero
+	document := pacs008Document{Xmlns: PACS_008_NAMESPACE}
+	for _, transfer := range transfers {
+		total = total.Add(transfer.Amount)
+
+		transaction := pacs008Transaction{
+			PmtId:          pacsPaymentId{InstrId: transfer.TransactionID, EndToEndId: transfer.TransactionID, TxId: transfer.TransactionID},
+			IntrBkSttlmAmt: pacsAmount{Ccy: transfer.Currency, Value: money.Format(transfer.Amount)},
+			// Each bank takes its own charges
+			ChrgBr:   "SLEV",
+			Dbtr:     pacsParty{Nm: transfer.DebtorName},
+			DbtrAcct: pacsAccountOf(transfer.DebtorAccount),
+			DbtrAgt:  pacsAgentOf(transfer.DebtorBank),
+			CdtrAgt:  pacsAgentOf(transfer.CreditorBank),
+			CdtrAcct: pacsAccountOf(transfer.CreditorAccount),
+		}
+		if transfer.Description != "" {
+			transaction.RmtInf = &pacsRemittance{Ustrd: []string{pacsText(transfer.Description, 140)}}
+		}
+		document.Transfer.CdtTrfTxInf = append(document.Transfer.CdtTrfTxInf, transaction)
+	}
+
+	instructed := pacsAgentOf(transfers[0].CreditorBank)
+	instructing := pacsAgentOf(bankNumber)
+	document.Transfer.GrpHdr = pacs008GroupHeader{
+		MsgId:             messageID,
+		CreDtTm:           created.Format("2006-01-02T15:04:05"),
+		NbOfTxs:           strconv.Itoa(len(transfers)),
+		TtlIntrBkSttlmAmt: &pacsAmount{Ccy: transfers[0].Currency, Value: money.Format(total)},
+		IntrBkSttlmDt:     created.Format("2006-01-02"),
+		SttlmInf:          pacsSettlement{SttlmMtd: "CLRG"},
+		InstgAgt:          &instructing,
+		InstdAgt:          &instructed,
+	}
+
+	content, err = marshalPacs(document)
+	if err != nil {
+		return nil, errors.New("interbank.CreditTransfer008: " + err.Error())
+	}
+	return
+}
+
+// ParsePacs008 reads the transfers in a pacs.008 message. A message that does
+// not parse or whose header does not match its transfers is rejected as a whole.
+func ParsePacs008(content []byte) (messageID string, transfers []Transfer, err error) {
+	document := pacs008Document{}
+	err = xml.Unmarshal(content, &document)
+	if err != nil {
+		return "", nil, errors.New("interbank.ParsePacs008: Could not read message. " + err.Error())
+	}
+	if !strings.HasPrefix(document.XMLName.Space, PACS_008_NAMESPACE_PREFIX) {
+		return "", nil, errors.New("interbank.ParsePacs008: Not a pacs.008 message")
+	}
+
+	header := document.Transfer.GrpHdr
+	messageID = strings.TrimSpace(header.MsgId)
+	if messageID == "" {
+		return "", nil, errors.New("interbank.ParsePacs008: MsgId is missing")
+	}
+	if header.NbOfTxs != strconv.Itoa(len(document.Transfer.CdtTrfTxInf)) {
+		return messageID, nil, errors.New("interbank.ParsePacs008: NbOfTxs does not match the number of transactions")
+	}
+
+	total := decimal.Zero
+	for _, transaction := range document.Transfer.CdtTrfTxInf {
+		amount, err := decimal.NewFromString(strings.TrimSpace(transaction.IntrBkSttlmAmt.Value))
+		if err != nil {
+			return messageID, nil, errors.New("interbank.ParsePacs008: Amount of " + transaction.PmtId.TxId + " is not valid")
+		}
+		if strings.TrimSpace(transaction.PmtId.TxId) == "" {
+			return messageID, nil, errors.New("interbank.ParsePacs008: TxId is missing")
+		}
+		total = total.Add(amount)
+
+		transfers = append(transfers, Transfer{
+			TransactionID:   strings.TrimSpace(transaction.PmtId.TxId),
+			Amount:          amount,
+			Currency:        transaction.IntrBkSttlmAmt.Ccy,
+			DebtorName:      strings.TrimSpace(transaction.Dbtr.Nm),
+			DebtorAccount:   pacsAccountNumber(transaction.DbtrAcct),
+			DebtorBank:      pacsBankNumber(transaction.DbtrAgt),
+			CreditorAccount: pacsAccountNumber(transaction.CdtrAcct),
+			CreditorBank:    pacsBankNumber(transaction.CdtrAgt),
+			Description:     strings.Join(pacsRemittanceOf(transaction.RmtInf), " "),
+		})
+	}
+
+	if header.TtlIntrBkSttlmAmt != nil {
+		sum, err := decimal.NewFromString(strings.TrimSpace(header.TtlIntrBkSttlmAmt.Value))
+		if err != nil || !sum.Equals(total) {
+			return messageID, nil, errors.New("interbank.ParsePacs008: TtlIntrBkSttlmAmt does not match the sum of the transactions")
+		}
+	}
+
+	return
+}
+
+// StatusReport002 replies to a pacs.008 message. A group reason rejects the
+// whole message, otherwise statuses holds the status of each transfer.
+func StatusReport002(originalMessageID string, groupReason string, groupInfo string, statuses []Status, created time.Time) (content []byte, err error) {
+	report := pacs002Report{
+		GrpHdr: pacs002GroupHeader{
+			MsgId:   pacsText("STS-"+originalMessageID, MESSAGE_ID_LENGTH),
+			CreDtTm: created.Format("2006-01-02T15:04:05"),
+		},
+		OrgnlGrpInfAndSts: pacs002GroupStatus{
+			OrgnlMsgId:   originalMessageID,
+			OrgnlMsgNmId: "pacs.008.001.02",
+		},
+	}
+
+	if groupReason != "" {
+		report.OrgnlGrpInfAndSts.GrpSts = STATUS_REJECTED
+		report.OrgnlGrpInfAndSts.StsRsnInf = pacsReasonOf(groupReason, groupInfo)
+	} else {
+		report.OrgnlGrpInfAndSts.GrpSts = groupStatus(statuses)
+		for _, status := range statuses {
+			transactionStatus := pacs002TransactionStatus{OrgnlTxId: status.TransactionID, TxSts: status.Status}
+			if status.Status == STATUS_REJECTED {
+				transactionStatus.StsRsnInf = pacsReasonOf(status.Reason, status.Info)
+			}
+			report.TxInfAndSts = append(report.TxInfAndSts, transactionStatus)
+		}
+	}
+
+	content, err = marshalPacs(pacs002Document{Xmlns: PACS_002_NAMESPACE, Report: report})
+	if err != nil {
+		return nil, errors.New("interbank.StatusReport002: " + err.Error())
+	}
+	return
+}
+
+// ParsePacs002 reads the status of each transfer from a pacs.002 reply.
+// A rejected group rejects every transfer in the original message.
+func ParsePacs002(content []byte) (groupStatus string, groupReason string, statuses []Status, err error) {
+	document := pacs002Document{}
+	err = xml.Unmarshal(content, &document)
+	if err != nil {
+		return "", "", nil, errors.New("interbank.ParsePacs002: Could not read message. " + err.Error())
+	}
+	if !strings.HasPrefix(document.XMLName.Space, PACS_002_NAMESPACE_PREFIX) {
+		return "", "", nil, errors.New("interbank.ParsePacs002: Not a pacs.002 message")
+	}
+
+	group := document.Report.OrgnlGrpInfAndSts
+	groupStatus = group.GrpSts
+	if group.StsRsnInf != nil {
+		groupReason = reasonText(group.StsRsnInf)
+	}
+
+	for _, transaction := range document.Report.TxInfAndSts {
+		status := Status{TransactionID: transaction.OrgnlTxId, Status: transaction.TxSts}
+		if transaction.StsRsnInf != nil {
+			status.Reason = transaction.StsRsnInf.Rsn.Cd
+			status.Info = transaction.StsRsnInf.AddtlInf
+		}
+		statuses = append(statuses, status)
+	}
+	return
+}
+
+// groupStatus is settled if every transfer settled, rejected if none did and partial otherwise
+func groupStatus(statuses []Status) string {
+	settled := 0
+	for _, status := range statuses {
+		if status.Status == STATUS_SETTLED {
+			settled++
+		}
+	}
+
+	switch settled {
+	case len(statuses):
+		return STATUS_SETTLED
+	case 0:
+		return STATUS_REJECTED
+	}
+	return STATUS_PARTIAL
+}
+
+func reasonText(reason *pacsReason) string {
+	if reason.AddtlInf == "" {
+		return reason.Rsn.Cd
+	}
+	return reason.Rsn.Cd + " " + reason.AddtlInf
+}
+
+func pacsReasonOf(code string, info string) *pacsReason {
+	return &pacsReason{Rsn: pacsCode{Cd: code}, AddtlInf: pacsText(info, ADDITIONAL_INFO_LENGTH)}
+}
+
+func pacsAccountOf(accountNumber string) *pacsAccount {
+	if accountNumber == "" {
+		return nil
+	}
+	return &pacsAccount{Id: pacsAccountId{Othr: &pacsOtherId{Id: accountNumber}}}
+}
+
+func pacsAgentOf(bankNumber string) pacsAgent {
+	return pacsAgent{FinInstnId: pacsInstitutionId{Othr: &pacsOtherId{Id: bankNumber}}}
+}
+
+func pacsAccountNumber(account *pacsAccount) string {
+	if account == nil {
+		return ""
+	}
+	if account.Id.IBAN != "" {
+		return strings.TrimSpace(account.Id.IBAN)
+	}
+	if account.Id.Othr == nil {
+		return ""
+	}
+	return strings.TrimSpace(account.Id.Othr.Id)
+}
+
+func pacsBankNumber(agent pacsAgent) string {
+	if agent.FinInstnId.BIC != "" {
+		return strings.TrimSpace(agent.FinInstnId.BIC)
+	}
+	if agent.FinInstnId.Othr == nil {
+		return ""
+	}
+	return strings.TrimSpace(agent.FinInstnId.Othr.Id)
+}
+
+func pacsRemittanceOf(remittance *pacsRemittance) []string {
+	if remittance == nil {
+		return nil
+	}
+	return remittance.Ustrd
+}
+
+// pacsText cuts text down to the length a field allows
+func pacsText(text string, length int) string {
+	runes := []rune(text)
+	if len(runes) > length {
+		return string(runes[:length])
+	}
+	return text
+}
+
+func marshalPacs(document interface{}) (content []byte, err error) {
+	body, err := xml.MarshalIndent(document, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), body...), nil
+}
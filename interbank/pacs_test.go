@@ -0,0 +1,151 @@
+package interbank
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func testTransfers() []Transfer {
+	return []Transfer{
+		{
+			TransactionID:   "11",
+			Amount:          decimal.NewFromFloat(20.5),
+			Currency:        "USD",
+			DebtorName:      "Redelinghuys,Kyle",
+			DebtorAccount:   "sender",
+			DebtorBank:      "bank-a",
+			CreditorAccount: "receiver",
+			CreditorBank:    "bank-b",
+			Description:     "Rent",
+		},
+		{
+			TransactionID:   "12",
+			Amount:          decimal.NewFromFloat(5),
+			Currency:        "USD",
+			DebtorAccount:   "sender",
+			DebtorBank:      "bank-a",
+			CreditorAccount: "receiver2",
+			CreditorBank:    "bank-b",
+		},
+	}
+}
+
+func TestCreditTransfer008(t *testing.T) {
+	content, err := CreditTransfer008("PACS008-11", "bank-a", testTransfers(), time.Now())
+	if err != nil {
+		t.Fatalf("CreditTransfer008 does not pass. Looking for %v, got %v", nil, err)
+	}
+	if !strings.Contains(string(content), PACS_008_NAMESPACE) || !strings.Contains(string(content), `<TtlIntrBkSttlmAmt Ccy="USD">25.50</TtlIntrBkSttlmAmt>`) {
+		t.Errorf("CreditTransfer008 header does not pass. Looking for %v, got %v", "namespace and total 25.50", string(content))
+	}
+
+	// Elements must be in schema order
+	order := []string{"<MsgId>", "<NbOfTxs>", "<SttlmInf>", "<InstgAgt>", "<InstdAgt>", "<CdtTrfTxInf>", "<PmtId>", "<IntrBkSttlmAmt", "<ChrgBr>", "<Dbtr>", "<DbtrAcct>", "<DbtrAgt>", "<CdtrAgt>", "<Cdtr>", "<CdtrAcct>", "<RmtInf>"}
+	last := -1
+	for _, element := range order {
+		i := strings.Index(string(content), element)
+		if i <= last {
+			t.Errorf("CreditTransfer008 element order does not pass. Looking for %v after position %v, got %v", element, last, i)
+		}
+		last = i
+	}
+
+	messageID, transfers, err := ParsePacs008(content)
+	if err != nil {
+		t.Fatalf("ParsePacs008 does not pass. Looking for %v, got %v", nil, err)
+	}
+	if messageID != "PACS008-11" || len(transfers) != 2 {
+		t.Fatalf("ParsePacs008 message does not pass. Looking for %v, got %v %v", "PACS008-11 and 2 transfers", messageID, transfers)
+	}
+
+	expected := testTransfers()[0]
+	transfer := transfers[0]
+	if transfer.TransactionID != expected.TransactionID || !transfer.Amount.Equals(expected.Amount) || transfer.DebtorName != expected.DebtorName ||
+		transfer.DebtorAccount != expected.DebtorAccount || transfer.DebtorBank != expected.DebtorBank ||
+		transfer.CreditorAccount != expected.CreditorAccount || transfer.CreditorBank != expected.CreditorBank || transfer.Description != expected.Description {
+		t.Errorf("ParsePacs008 transfer does not pass. Looking for %v, got %v", expected, transfer)
+	}
+}
+
+func TestParsePacs008Invalid(t *testing.T) {
+	content, _ := CreditTransfer008("PACS008-11", "bank-a", testTransfers(), time.Now())
+
+	tests := []struct {
+		from      string
+		to        string
+		messageID string
+	}{
+		{"pacs.008.001.02", "pain.001.001.03", ""},
+		{"<MsgId>PACS008-11</MsgId>", "<MsgId></MsgId>", ""},
+		{"<NbOfTxs>2</NbOfTxs>", "<NbOfTxs>3</NbOfTxs>", "PACS008-11"},
+		{">25.50<", ">26.00<", "PACS008-11"},
+		{">5.00<", ">five<", "PACS008-11"},
+		{"<TxId>12</TxId>", "<TxId></TxId>", "PACS008-11"},
+	}
+
+	for _, test := range tests {
+		messageID, _, err := ParsePacs008([]byte(strings.Replace(string(content), test.from, test.to, 1)))
+		if err == nil || messageID != test.messageID {
+			t.Errorf("ParsePacs008Invalid does not pass for %v. Looking for %v, got %v %v", test.to, test.messageID, messageID, err)
+		}
+	}
+}
+
+func TestStatusReport002(t *testing.T) {
+	statuses := []Status{
+		{TransactionID: "11", Status: STATUS_SETTLED},
+		{TransactionID: "12", Status: STATUS_REJECTED, Reason: REASON_ACCOUNT, Info: "Creditor account not found"},
+	}
+
+	content, err := StatusReport002("PACS008-11", "", "", statuses, time.Now())
+	if err != nil {
+		t.Fatalf("StatusReport002 does not pass. Looking for %v, got %v", nil, err)
+	}
+
+	groupStatus, groupReason, parsed, err := ParsePacs002(content)
+	if err != nil {
+		t.Fatalf("ParsePacs002 does not pass. Looking for %v, got %v", nil, err)
+	}
+	if groupStatus != STATUS_PARTIAL || groupReason != "" || len(parsed) != 2 {
+		t.Fatalf("ParsePacs002 group does not pass. Looking for %v, got %v %v %v", "PART", groupStatus, groupReason, parsed)
+	}
+	if parsed[0] != statuses[0] || parsed[1] != statuses[1] {
+		t.Errorf("ParsePacs002 statuses does not pass. Looking for %v, got %v", statuses, parsed)
+	}
+}
+
+func TestStatusReport002Rejected(t *testing.T) {
+	content, err := StatusReport002("PACS008-11", REASON_FORMAT, "NbOfTxs does not match", nil, time.Now())
+	if err != nil {
+		t.Fatalf("StatusReport002Rejected does not pass. Looking for %v, got %v", nil, err)
+	}
+
+	groupStatus, groupReason, statuses, _ := ParsePacs002(content)
+	if groupStatus != STATUS_REJECTED || groupReason != "FF01 NbOfTxs does not match" || len(statuses) != 0 {
+		t.Errorf("StatusReport002Rejected does not pass. Looking for %v, got %v %v %v", "RJCT FF01", groupStatus, groupReason, statuses)
+	}
+}
+
+func TestGroupStatus(t *testing.T) {
+	settled := Status{Status: STATUS_SETTLED}
+	rejected := Status{Status: STATUS_REJECTED}
+
+	tests := []struct {
+		statuses []Status
+		expected string
+	}{
+		{[]Status{settled, settled}, STATUS_SETTLED},
+		{[]Status{rejected}, STATUS_REJECTED},
+		{[]Status{settled, rejected}, STATUS_PARTIAL},
+	}
+
+	for _, test := range tests {
+		status := groupStatus(test.statuses)
+		if status != test.expected {
+			t.Errorf("GroupStatus does not pass. Looking for %v, got %v", test.expected, status)
+		}
+	}
+}
@@ -0,0 +1,415 @@
+package interbank
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/bvnk/bank/accounts"
+	"github.com/bvnk/bank/money"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	// Accounts the bank keeps. Nostro is our account with a correspondent bank,
+	// vostro is the correspondent's account with us.
+	ACCOUNT_HOLDING = "holding"
+	ACCOUNT_NOSTRO  = "nostro"
+	ACCOUNT_VOSTRO  = "vostro"
+
+	// Movements are recorded with the message that caused them
+	MOVEMENT_PACS       = "pacs"
+	MOVEMENT_SETTLEMENT = "sttl"
+	MOVEMENT_PAYMENT    = 8
+	MOVEMENT_RETURN     = 4
+	MOVEMENT_SETTLED    = 1
+
+	SETTLEMENT_STATUS_PENDING = "pending"
+	SETTLEMENT_STATUS_SETTLED = "settled"
+
+	BUSINESS_DATE_FORMAT = "2006-01-02"
+)
+
+// Movement is one posting to a settlement account
+type Movement struct {
+	ID                 int64
+	Transaction        string
+	Type               int
+	Account            string
+	SenderBankNumber   string
+	ReceiverBankNumber string
+	Amount             decimal.Decimal
+	TransactionID      int64
+	SettlementID       int64
+	Timestamp          int32
+}
+
+// Settlement is the net position with one correspondent bank from a netting run.
+// A positive net amount is paid to the correspondent, a negative one is received from it.
+type Settlement struct {
+	ID               int64
+	BankNumber       string
+	BusinessDate     string
+	PaymentsOut      int
+	AmountOut        decimal.Decimal
+	Returns          int
+	AmountReturned   decimal.Decimal
+	PaymentsIn       int
+	AmountIn         decimal.Decimal
+	NetAmount        decimal.Decimal
+	Status           string
+	Timestamp        int32
+	SettledTimestamp int32
+}
+
+// Reconciliation compares the settlement accounts with their movements and
+// the interbank payments with the movements they should have posted
+type Reconciliation struct {
+	Timestamp int32
+	Accounts  []AccountReconciliation
+	Breaks    []ReconciliationBreak
+}
+
+type AccountReconciliation struct {
+	Account    string
+	BankNumber string
+	Balance    decimal.Decimal
+	Movements  decimal.Decimal
+	Difference decimal.Decimal
+	Unsettled  decimal.Decimal
+}
+
+type ReconciliationBreak struct {
+	TransactionID int64
+	BankNumber    string
+	Issue         string
+}
+
+// File is a settlement file or report ready to be downloaded
+type File struct {
+	Content     []byte
+	ContentType string
+	FileName    string
+}
+
+// PaymentOut is a payment to a customer of a correspondent, taken from our nostro account
+func PaymentOut(transactionID int64, bankNumber string, amount decimal.Decimal) Movement {
+	return Movement{Transaction: MOVEMENT_PACS, Type: MOVEMENT_PAYMENT, Account: ACCOUNT_NOSTRO, SenderBankNumber: accounts.LocalBankNumber(), ReceiverBankNumber: bankNumber, Amount: amount, TransactionID: transactionID}
+}
+
+// PaymentReturned gives back to our nostro account a payment the correspondent rejected
+func PaymentReturned(transactionID int64, bankNumber string, amount decimal.Decimal) Movement {
+	return Movement{Transaction: MOVEMENT_PACS, Type: MOVEMENT_RETURN, Account: ACCOUNT_NOSTRO, SenderBankNumber: bankNumber, ReceiverBankNumber: accounts.LocalBankNumber(), Amount: amount, TransactionID: transactionID}
+}
+
+// PaymentIn is a payment from a customer of a correspondent, taken from its vostro account
+func PaymentIn(transactionID int64, bankNumber string, amount decimal.Decimal) Movement {
+	return Movement{Transaction: MOVEMENT_PACS, Type: MOVEMENT_PAYMENT, Account: ACCOUNT_VOSTRO, SenderBankNumber: bankNumber, ReceiverBankNumber: accounts.LocalBankNumber(), Amount: amount, TransactionID: transactionID}
+}
+
+// peerBankNumber is the correspondent a movement is with
+func (movement Movement) peerBankNumber() string {
+	if movement.SenderBankNumber == accounts.LocalBankNumber() {
+		return movement.ReceiverBankNumber
+	}
+	return movement.SenderBankNumber
+}
+
+// balanceEffect is what a movement does to its account's balance. Money sent to a
+// correspondent reduces our nostro account and adds to its vostro account.
+func (movement Movement) balanceEffect() decimal.Decimal {
+	toPeer := movement.SenderBankNumber == accounts.LocalBankNumber()
+	if (movement.Account == ACCOUNT_NOSTRO) == toPeer {
+		return movement.Amount.Neg()
+	}
+	return movement.Amount
+}
+
+// PostMovement records a movement and applies it to its settlement account.
+// The account is opened on its first movement.
+func PostMovement(db Execer, movement Movement) (err error) {
+	t := time.Now()
+	sqlTime := int32(t.Unix())
+
+	err = updateSettlementAccount(db, movement.Account, movement.peerBankNumber(), movement.balanceEffect(), sqlTime)
+	if err != nil {
+		return errors.New("interbank.PostMovement: " + err.Error())
+	}
+
+	movement.Timestamp = sqlTime
+	err = saveMovement(db, movement)
+	if err != nil {
+		return errors.New("interbank.PostMovement: " + err.Error())
+	}
+	return
+}
+
+// RunNetting nets the unsettled payments with each correspondent into a settlement.
+// The business date is the one the bank is booking for if none is given.
+func RunNetting(businessDate string) (settlements []Settlement, err error) {
+	if businessDate == "" {
+		businessDate, err = getBusinessDate()
+		if err != nil {
+			return nil, errors.New("interbank.RunNetting: " + err.Error())
+		}
+	}
+	if _, err := time.Parse(BUSINESS_DATE_FORMAT, businessDate); err != nil {
+		return nil, errors.New("interbank.RunNetting: Business date not valid, must be YYYY-MM-DD")
+	}
+
+	settlements, err = saveNetting(businessDate)
+	if err != nil {
+		return nil, errors.New("interbank.RunNetting: " + err.Error())
+	}
+	return
+}
+
+// netPositions works out the settlement with each correspondent from its unsettled payments
+func netPositions(movements []Movement, businessDate string) (settlements []Settlement) {
+	byBank := map[string]*Settlement{}
+	bankNumbers := []string{}
+
+	for _, movement := range movements {
+		if movement.Transaction != MOVEMENT_PACS {
+			continue
+		}
+
+		bankNumber := movement.peerBankNumber()
+		settlement, ok := byBank[bankNumber]
+		if !ok {
+			settlement = &Settlement{
+				BankNumber:     bankNumber,
+				BusinessDate:   businessDate,
+				AmountOut:      decimal.Zero,
+				AmountReturned: decimal.Zero,
+				AmountIn:       decimal.Zero,
+				Status:         SETTLEMENT_STATUS_PENDING,
+			}
+			byBank[bankNumber] = settlement
+			bankNumbers = append(bankNumbers, bankNumber)
+		}
+
+		switch {
+		case movement.Account == ACCOUNT_NOSTRO && movement.Type == MOVEMENT_PAYMENT:
+			settlement.PaymentsOut++
+			settlement.AmountOut = settlement.AmountOut.Add(movement.Amount)
+		case movement.Account == ACCOUNT_NOSTRO && movement.Type == MOVEMENT_RETURN:
+			settlement.Returns++
+			settlement.AmountReturned = settlement.AmountReturned.Add(movement.Amount)
+		case movement.Account == ACCOUNT_VOSTRO && movement.Type == MOVEMENT_PAYMENT:
+			settlement.PaymentsIn++
+			settlement.AmountIn = settlement.AmountIn.Add(movement.Amount)
+		}
+	}
+
+	sort.Strings(bankNumbers)
+	for _, bankNumber := range bankNumbers {
+		settlement := byBank[bankNumber]
+		settlement.NetAmount = settlement.AmountOut.Sub(settlement.AmountReturned).Sub(settlement.AmountIn)
+		settlements = append(settlements, *settlement)
+	}
+	return
+}
+
+// settlementMovements brings the nostro and vostro accounts back once a settlement is paid
+func settlementMovements(settlement Settlement) (movements []Movement) {
+	local := accounts.LocalBankNumber()
+
+	// What we paid out less what came back was taken from our nostro account
+	out := settlement.AmountOut.Sub(settlement.AmountReturned)
+	if out.Sign() != 0 {
+		movements = append(movements, Movement{Transaction: MOVEMENT_SETTLEMENT, Type: MOVEMENT_SETTLED, Account: ACCOUNT_NOSTRO, SenderBankNumber: settlement.BankNumber, ReceiverBankNumber: local, Amount: out, SettlementID: settlement.ID})
+	}
+	// What the correspondent paid in was taken from its vostro account
+	if settlement.AmountIn.Sign() != 0 {
+		movements = append(movements, Movement{Transaction: MOVEMENT_SETTLEMENT, Type: MOVEMENT_SETTLED, Account: ACCOUNT_VOSTRO, SenderBankNumber: local, ReceiverBankNumber: settlement.BankNumber, Amount: settlement.AmountIn, SettlementID: settlement.ID})
+	}
+	return
+}
+
+func settle(settlementIDStr string) (result string, err error) {
+	settlementID, err := strconv.ParseInt(settlementIDStr, 10, 64)
+	if err != nil {
+		return "", errors.New("interbank.settle: Settlement ID not valid")
+	}
+
+	settled, err := saveSettled(settlementID)
+	if err != nil {
+		return "", errors.New("interbank.settle: " + err.Error())
+	}
+	if !settled {
+		return "", errors.New("interbank.settle: Settlement is not pending")
+	}
+
+	return "Settlement settled", nil
+}
+
+func settlementFile(businessDate string) (file File, err error) {
+	if _, err := time.Parse(BUSINESS_DATE_FORMAT, businessDate); err != nil {
+		return File{}, errors.New("interbank.settlementFile: Business date not valid, must be YYYY-MM-DD")
+	}
+
+	settlements, err := getSettlements(businessDate)
+	if err != nil {
+		return File{}, errors.New("interbank.settlementFile: " + err.Error())
+	}
+
+	content, err := settlementCSV(settlements)
+	if err != nil {
+		return File{}, errors.New("interbank.settlementFile: " + err.Error())
+	}
+
+	return File{Content: content, ContentType: "text/csv; charset=UTF-8", FileName: "settlement-" + businessDate + ".csv"}, nil
+}
+
+// settlementCSV lists what is paid to or received from each correspondent
+func settlementCSV(settlements []Settlement) (content []byte, err error) {
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+
+	writer.Write([]string{"Settlement ID", "Bank Number", "Business Date", "Payments Out", "Amount Out", "Returns", "Amount Returned", "Payments In", "Amount In", "Net Amount", "Direction", "Status"})
+	for _, settlement := range settlements {
+		direction := "none"
+		switch settlement.NetAmount.Sign() {
+		case 1:
+			direction = "pay"
+		case -1:
+			direction = "receive"
+		}
+
+		writer.Write([]string{
+			strconv.FormatInt(settlement.ID, 10),
+			settlement.BankNumber,
+			settlement.BusinessDate,
+			strconv.Itoa(settlement.PaymentsOut),
+			money.Format(settlement.AmountOut),
+			strconv.Itoa(settlement.Returns),
+			money.Format(settlement.AmountReturned),
+			strconv.Itoa(settlement.PaymentsIn),
+			money.Format(settlement.AmountIn),
+			money.Format(settlement.NetAmount.Abs()),
+			direction,
+			settlement.Status,
+		})
+	}
+
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return nil, errors.New("interbank.settlementCSV: " + err.Error())
+	}
+	return buf.Bytes(), nil
+}
+
+func reconcile(format string) (result interface{}, err error) {
+	report := Reconciliation{Timestamp: int32(time.Now().Unix())}
+
+	balances, err := getSettlementAccounts()
+	if err != nil {
+		return nil, errors.New("interbank.reconcile: " + err.Error())
+	}
+	movements, err := getSettlementMovements()
+	if err != nil {
+		return nil, errors.New("interbank.reconcile: " + err.Error())
+	}
+	report.Accounts = reconcileAccounts(balances, movements)
+
+	report.Breaks, err = getReconciliationBreaks()
+	if err != nil {
+		return nil, errors.New("interbank.reconcile: " + err.Error())
+	}
+
+	switch format {
+	case "json":
+		return report, nil
+	case "csv":
+		content, err := reconciliationCSV(report)
+		if err != nil {
+			return nil, errors.New("interbank.reconcile: " + err.Error())
+		}
+		return File{Content: content, ContentType: "text/csv; charset=UTF-8", FileName: "reconciliation-" + today() + ".csv"}, nil
+	}
+	return nil, errors.New("interbank.reconcile: Format not valid, must be one of json, csv")
+}
+
+// reconcileAccounts checks each settlement account's balance against the sum of its movements
+func reconcileAccounts(balances []AccountReconciliation, movements []Movement) (reconciled []AccountReconciliation) {
+	type key struct{ account, bankNumber string }
+	totals := map[key]*AccountReconciliation{}
+	keys := []key{}
+
+	for _, balance := range balances {
+		k := key{balance.Account, balance.BankNumber}
+		b := balance
+		b.Movements, b.Unsettled = decimal.Zero, decimal.Zero
+		totals[k] = &b
+		keys = append(keys, k)
+	}
+
+	for _, movement := range movements {
+		k := key{movement.Account, movement.peerBankNumber()}
+		total, ok := totals[k]
+		if !ok {
+			// Movements on an account that does not exist are a break in themselves
+			total = &AccountReconciliation{Account: movement.Account, BankNumber: k.bankNumber, Balance: decimal.Zero, Movements: decimal.Zero, Unsettled: decimal.Zero}
+			totals[k] = total
+			keys = append(keys, k)
+		}
+
+		total.Movements = total.Movements.Add(movement.balanceEffect())
+		if movement.Transaction == MOVEMENT_PACS && movement.SettlementID == 0 {
+			total.Unsettled = total.Unsettled.Add(movement.balanceEffect())
+		}
+	}
+
+	for _, k := range keys {
+		total := totals[k]
+		total.Difference = total.Balance.Sub(total.Movements)
+		reconciled = append(reconciled, *total)
+	}
+	return
+}
+
+func reconciliationCSV(report Reconciliation) (content []byte, err error) {
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+
+	writer.Write([]string{"Account", "Bank Number", "Balance", "Movements", "Difference", "Unsettled"})
+	for _, account := range report.Accounts {
+		writer.Write([]string{
+			account.Account,
+			account.BankNumber,
+			money.Format(account.Balance),
+			money.Format(account.Movements),
+			money.Format(account.Difference),
+			money.Format(account.Unsettled),
+		})
+	}
+
+	writer.Write([]string{})
+	writer.Write([]string{"Transaction ID", "Bank Number", "Issue"})
+	for _, reconciliationBreak := range report.Breaks {
+		writer.Write([]string{strconv.FormatInt(reconciliationBreak.TransactionID, 10), reconciliationBreak.BankNumber, reconciliationBreak.Issue})
+	}
+
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return nil, errors.New("interbank.reconciliationCSV: " + err.Error())
+	}
+	return buf.Bytes(), nil
+}
+
+// today is the calendar date in the bank's time zone
+func today() string {
+	return time.Now().In(location()).Format(BUSINESS_DATE_FORMAT)
+}
+
+func location() *time.Location {
+	loc, err := time.LoadLocation(Config.TimeZone)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
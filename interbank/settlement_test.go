@@ -0,0 +1,143 @@
+package interbank
+
+import (
+	"encoding/csv"
+	"strings"
+	"testing"
+
+	"github.com/bvnk/bank/accounts"
+	"github.com/shopspring/decimal"
+)
+
+func TestBalanceEffect(t *testing.T) {
+	amount := decimal.NewFromFloat(10)
+	local := accounts.LocalBankNumber()
+
+	tests := []struct {
+		movement Movement
+		expected decimal.Decimal
+	}{
+		{PaymentOut(1, "bank-b", amount), amount.Neg()},
+		{PaymentReturned(1, "bank-b", amount), amount},
+		{PaymentIn(2, "bank-b", amount), amount.Neg()},
+		{Movement{Account: ACCOUNT_VOSTRO, SenderBankNumber: local, ReceiverBankNumber: "bank-b", Amount: amount}, amount},
+	}
+
+	for _, test := range tests {
+		effect := test.movement.balanceEffect()
+		if !effect.Equals(test.expected) {
+			t.Errorf("BalanceEffect does not pass for %v. Looking for %v, got %v", test.movement, test.expected, effect)
+		}
+		if bankNumber := test.movement.peerBankNumber(); bankNumber != "bank-b" {
+			t.Errorf("PeerBankNumber does not pass. Looking for %v, got %v", "bank-b", bankNumber)
+		}
+	}
+}
+
+func testMovements() []Movement {
+	return []Movement{
+		PaymentOut(1, "bank-b", decimal.NewFromFloat(100)),
+		PaymentOut(2, "bank-b", decimal.NewFromFloat(50)),
+		PaymentReturned(2, "bank-b", decimal.NewFromFloat(50)),
+		PaymentIn(3, "bank-b", decimal.NewFromFloat(30)),
+		PaymentIn(4, "bank-c", decimal.NewFromFloat(20)),
+		{Transaction: MOVEMENT_SETTLEMENT, Type: MOVEMENT_SETTLED, Account: ACCOUNT_NOSTRO, SenderBankNumber: "bank-c", ReceiverBankNumber: accounts.LocalBankNumber(), Amount: decimal.NewFromFloat(5)},
+	}
+}
+
+func TestNetPositions(t *testing.T) {
+	settlements := netPositions(testMovements(), "2016-01-04")
+	if len(settlements) != 2 {
+		t.Fatalf("NetPositions does not pass. Looking for %v, got %v", 2, len(settlements))
+	}
+
+	b := settlements[0]
+	if b.BankNumber != "bank-b" || b.BusinessDate != "2016-01-04" || b.Status != SETTLEMENT_STATUS_PENDING ||
+		b.PaymentsOut != 2 || !b.AmountOut.Equals(decimal.NewFromFloat(150)) ||
+		b.Returns != 1 || !b.AmountReturned.Equals(decimal.NewFromFloat(50)) ||
+		b.PaymentsIn != 1 || !b.AmountIn.Equals(decimal.NewFromFloat(30)) ||
+		!b.NetAmount.Equals(decimal.NewFromFloat(70)) {
+		t.Errorf("NetPositions bank-b does not pass. Looking for %v, got %v", "net 70", b)
+	}
+
+	c := settlements[1]
+	if c.BankNumber != "bank-c" || c.PaymentsOut != 0 || c.PaymentsIn != 1 || !c.NetAmount.Equals(decimal.NewFromFloat(-20)) {
+		t.Errorf("NetPositions bank-c does not pass. Looking for %v, got %v", "net -20", c)
+	}
+}
+
+func TestSettlementMovements(t *testing.T) {
+	settlements := netPositions(testMovements(), "2016-01-04")
+	settlements[0].ID = 7
+
+	// Settling brings the accounts back by what the payments took from them
+	total := decimal.Zero
+	for _, movement := range testMovements()[:4] {
+		total = total.Add(movement.balanceEffect())
+	}
+	for _, movement := range settlementMovements(settlements[0]) {
+		if movement.SettlementID != 7 || movement.Transaction != MOVEMENT_SETTLEMENT {
+			t.Errorf("SettlementMovements does not pass. Looking for %v, got %v", "settlement 7", movement)
+		}
+		total = total.Add(movement.balanceEffect())
+	}
+	if !total.Equals(decimal.Zero) {
+		t.Errorf("SettlementMovements does not pass. Looking for %v, got %v", decimal.Zero, total)
+	}
+
+	if movements := settlementMovements(Settlement{AmountOut: decimal.Zero, AmountReturned: decimal.Zero, AmountIn: decimal.Zero}); len(movements) != 0 {
+		t.Errorf("SettlementMovements empty does not pass. Looking for %v, got %v", 0, movements)
+	}
+}
+
+func TestSettlementCSV(t *testing.T) {
+	settlements := netPositions(testMovements(), "2016-01-04")
+	content, err := settlementCSV(settlements)
+	if err != nil {
+		t.Fatalf("SettlementCSV does not pass. Looking for %v, got %v", nil, err)
+	}
+
+	records, err := csv.NewReader(strings.NewReader(string(content))).ReadAll()
+	if err != nil || len(records) != 3 {
+		t.Fatalf("SettlementCSV does not pass. Looking for %v, got %v %v", 3, len(records), err)
+	}
+	if records[1][1] != "bank-b" || records[1][9] != "70.00" || records[1][10] != "pay" {
+		t.Errorf("SettlementCSV bank-b does not pass. Looking for %v, got %v", "70.00 pay", records[1])
+	}
+	if records[2][1] != "bank-c" || records[2][9] != "20.00" || records[2][10] != "receive" {
+		t.Errorf("SettlementCSV bank-c does not pass. Looking for %v, got %v", "20.00 receive", records[2])
+	}
+}
+
+func TestReconcileAccounts(t *testing.T) {
+	balances := []AccountReconciliation{
+		{Account: ACCOUNT_NOSTRO, BankNumber: "bank-b", Balance: decimal.NewFromFloat(-100)},
+		{Account: ACCOUNT_VOSTRO, BankNumber: "bank-b", Balance: decimal.NewFromFloat(-30)},
+		{Account: ACCOUNT_VOSTRO, BankNumber: "bank-c", Balance: decimal.NewFromFloat(-25)},
+	}
+
+	reconciled := reconcileAccounts(balances, testMovements())
+	if len(reconciled) != 4 {
+		t.Fatalf("ReconcileAccounts does not pass. Looking for %v, got %v", 4, len(reconciled))
+	}
+
+	tests := []struct {
+		account    string
+		bankNumber string
+		difference decimal.Decimal
+		unsettled  decimal.Decimal
+	}{
+		{ACCOUNT_NOSTRO, "bank-b", decimal.Zero, decimal.NewFromFloat(-100)},
+		{ACCOUNT_VOSTRO, "bank-b", decimal.Zero, decimal.NewFromFloat(-30)},
+		{ACCOUNT_VOSTRO, "bank-c", decimal.NewFromFloat(-5), decimal.NewFromFloat(-20)},
+		// The settlement movement has no account behind it
+		{ACCOUNT_NOSTRO, "bank-c", decimal.NewFromFloat(-5), decimal.Zero},
+	}
+
+	for i, test := range tests {
+		a := reconciled[i]
+		if a.Account != test.account || a.BankNumber != test.bankNumber || !a.Difference.Equals(test.difference) || !a.Unsettled.Equals(test.unsettled) {
+			t.Errorf("ReconcileAccounts does not pass. Looking for %v, got %v", test, a)
+		}
+	}
+}
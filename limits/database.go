@@ -0,0 +1,178 @@
+package limits
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"gopkg.in/redis.v3"
+
+	"github.com/bvnk/bank/configuration"
+	"github.com/shopspring/decimal"
+)
+
+var Config configuration.Configuration
+
+// Counters and held reservations, kept in Redis
+var counters counterStore
+
+func SetConfig(config *configuration.Configuration) {
+	Config = *config
+	counters = redisCounters{Config.Redis}
+}
+
+// counterStore is what counters are kept in, Redis outside of tests
+type counterStore interface {
+	// Get gives found false if the key does not exist or expired
+	Get(key string) (value string, found bool, err error)
+	Set(key string, value string, ttl time.Duration) error
+	// IncrBy adds to a key, starting from zero, and gives what it now holds
+	IncrBy(key string, value int64) (total int64, err error)
+	Expire(key string, ttl time.Duration) error
+	Del(key string) error
+}
+
+// redisCounters keeps counters in Redis
+type redisCounters struct {
+	client *redis.Client
+}
+
+func (r redisCounters) Get(key string) (value string, found bool, err error) {
+	value, err = r.client.Get(key).Result()
+	if err == redis.Nil {
+		return "", false, nil
+	} else if err != nil {
+		return "", false, err
+	}
+	return value, true, nil
+}
+
+func (r redisCounters) Set(key string, value string, ttl time.Duration) error {
+	return r.client.Set(key, value, ttl).Err()
+}
+
+func (r redisCounters) IncrBy(key string, value int64) (total int64, err error) {
+	return r.client.IncrBy(key, value).Result()
+}
+
+func (r redisCounters) Expire(key string, ttl time.Duration) error {
+	return r.client.Expire(key, ttl).Err()
+}
+
+func (r redisCounters) Del(key string) error {
+	return r.client.Del(key).Err()
+}
+
+func getLimits(scope string, target string) (overrides map[string]decimal.Decimal, err error) {
+	rows, err := Config.Db.Query("SELECT `period`, `amount` FROM `accounts_limits` WHERE `scope` = ? AND `target` = ?", scope, target)
+	if err != nil {
+		return nil, errors.New("limits.getLimits: " + err.Error())
+	}
+	defer rows.Close()
+
+	overrides = make(map[string]decimal.Decimal)
+	for rows.Next() {
+		var period string
+		var amount decimal.Decimal
+		if err := rows.Scan(&period, &amount); err != nil {
+			return nil, errors.New("limits.getLimits: " + err.Error())
+		}
+		overrides[period] = amount
+	}
+
+	return
+}
+
+func saveLimit(scope string, target string, period string, amount decimal.Decimal) (err error) {
+	insertStatement := "INSERT INTO accounts_limits (`scope`, `target`, `period`, `amount`, `timestamp`) "
+	insertStatement += "VALUES(?, ?, ?, ?, ?) ON DUPLICATE KEY UPDATE `amount` = VALUES(`amount`), `timestamp` = VALUES(`timestamp`)"
+	stmtIns, err := Config.Db.Prepare(insertStatement)
+	if err != nil {
+		return errors.New("limits.saveLimit: " + err.Error())
+	}
+	defer stmtIns.Close() // Close the statement when we leave main() / the program terminates
+
+	t := time.Now()
+	sqlTime := int32(t.Unix())
+
+	_, err = stmtIns.Exec(scope, target, period, amount, sqlTime)
+	if err != nil {
+		return errors.New("limits.saveLimit: " + err.Error())
+	}
+
+	return
+}
+
+func removeLimit(scope string, target string, period string) (err error) {
+	deleteStatement := "DELETE FROM accounts_limits WHERE `scope` = ? AND `target` = ? AND `period` = ?"
+	stmtDel, err := Config.Db.Prepare(deleteStatement)
+	if err != nil {
+		return errors.New("limits.removeLimit: " + err.Error())
+	}
+	defer stmtDel.Close()
+
+	_, err = stmtDel.Exec(scope, target, period)
+	if err != nil {
+		return errors.New("limits.removeLimit: " + err.Error())
+	}
+
+	return
+}
+
+func counterKey(scope string, target string, period string, t time.Time) string {
+	return "limits:" + scope + ":" + target + ":" + period + ":" + periodKey(period, t)
+}
+
+// heldKey is where the reservation of a payment held for review is kept
+func heldKey(transactionID int64) string {
+	return "limits:held:" + strconv.FormatInt(transactionID, 10)
+}
+
+// counterUnits is an amount as counters hold it, rounded up
+func counterUnits(amount decimal.Decimal) int64 {
+	return amount.Shift(COUNTER_PLACES).Ceil().IntPart()
+}
+
+func getCounter(scope string, target string, period string, t time.Time) (used decimal.Decimal, err error) {
+	value, found, err := counters.Get(counterKey(scope, target, period, t))
+	if err != nil {
+		return decimal.Zero, errors.New("limits.getCounter: Could not get counter. " + err.Error())
+	}
+	if !found {
+		return decimal.Zero, nil
+	}
+
+	minorUnits, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return decimal.Zero, errors.New("limits.getCounter: Counter is not an integer. " + err.Error())
+	}
+
+	used = decimal.New(minorUnits, -COUNTER_PLACES)
+	return
+}
+
+// incrementCounter adds minorUnits to a counter and gives what it now holds
+func incrementCounter(key string, minorUnits int64, ttl time.Duration) (used decimal.Decimal, err error) {
+	total, err := counters.IncrBy(key, minorUnits)
+	if err != nil {
+		return decimal.Zero, errors.New("limits.incrementCounter: Could not increment counter. " + err.Error())
+	}
+	err = counters.Expire(key, ttl)
+	if err != nil {
+		return decimal.Zero, errors.New("limits.incrementCounter: Could not set counter expiry. " + err.Error())
+	}
+	return decimal.New(total, -COUNTER_PLACES), nil
+}
+
+// decrementCounters takes amount off the target's counters for the periods t
+// falls in. One that already expired comes back below zero, which is never
+// read as its period has passed, and expires again.
+func decrementCounters(scope string, target string, amount decimal.Decimal, t time.Time) (err error) {
+	for _, period := range counterPeriods {
+		_, err = incrementCounter(counterKey(scope, target, period, t), -counterUnits(amount), periodTTL(period))
+		if err != nil {
+			return errors.New("limits.decrementCounters: " + err.Error())
+		}
+	}
+	return
+}
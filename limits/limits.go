@@ -0,0 +1,523 @@
+package limits
+
+/*
+Limits package enforces spending limits and velocity controls.
+
+Default limits are set per account type (AccountLimits) and per account holder
+(HolderLimits) in the configuration. Overrides for a single account or a single
+holder are stored in the accounts_limits table. Holders may only lower their own
+limits, staff may raise them.
+
+Amounts already spent in the current day, week and month are kept as counters in
+Redis. A payment is added to them before it is made and taken off again if it is
+not, so payments made at the same time cannot together go over a limit.
+
+Limits transactions are as follows:
+1 - ViewLimits
+2 - LowerLimit
+3 - SetLimit (staff)
+
+*/
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bvnk/bank/accounts"
+	"github.com/bvnk/bank/appauth"
+	"github.com/bvnk/bank/configuration"
+	"github.com/bvnk/bank/money"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	SCOPE_ACCOUNT = "account"
+	SCOPE_HOLDER  = "holder"
+	SCOPE_DEPOSIT = "deposit"
+
+	PERIOD_TRANSACTION = "transaction"
+	PERIOD_DAILY       = "daily"
+	PERIOD_WEEKLY      = "weekly"
+	PERIOD_MONTHLY     = "monthly"
+
+	// Counters are kept in Redis as integers of this many decimal places
+	COUNTER_PLACES = 4
+)
+
+// The periods that have counters, in the order they are checked
+var counterPeriods = []string{PERIOD_DAILY, PERIOD_WEEKLY, PERIOD_MONTHLY}
+
+// LimitStatus is a single limit with the amount used and remaining in the current period
+type LimitStatus struct {
+	Scope     string
+	Period    string
+	Limit     decimal.Decimal
+	Used      decimal.Decimal
+	Remaining decimal.Decimal
+}
+
+func ProcessLimits(data []string) (result interface{}, err error) {
+	if len(data) < 4 {
+		return "", errors.New("limits.ProcessLimits: Not all data is present")
+	}
+
+	switch data[2] {
+	// View limits
+	case "1":
+		// token~limits~1~accountNumber
+		result, err = viewLimits(data)
+		if err != nil {
+			return "", errors.New("limits.ProcessLimits: " + err.Error())
+		}
+	// Lower a limit
+	case "2":
+		// token~limits~2~scope~accountNumber~period~amount
+		if len(data) < 7 {
+			return "", errors.New("limits.ProcessLimits: Not all data is present")
+		}
+		result, err = lowerLimit(data)
+		if err != nil {
+			return "", errors.New("limits.ProcessLimits: " + err.Error())
+		}
+	// Set a limit (staff)
+	case "3":
+		// ~limits~3~scope~target~period~amount~basicAuthUser~basicAuthPassword
+		if len(data) < 9 {
+			return "", errors.New("limits.ProcessLimits: Not all data is present")
+		}
+		result, err = setLimit(data)
+		if err != nil {
+			return "", errors.New("limits.ProcessLimits: " + err.Error())
+		}
+	default:
+		return "", errors.New("limits.ProcessLimits: No valid option chosen")
+	}
+
+	return
+}
+
+func viewLimits(data []string) (result []LimitStatus, err error) {
+	tokenUser, err := appauth.GetUserFromToken(data[0])
+	if err != nil {
+		return nil, errors.New("limits.viewLimits: " + err.Error())
+	}
+	accountNumber := data[3]
+	err = accounts.CheckUserAccountValidFromToken(tokenUser, accountNumber)
+	if err != nil {
+		return nil, errors.New("limits.viewLimits: " + err.Error())
+	}
+	account, err := accounts.GetAccountByAccountNumber(accountNumber)
+	if err != nil {
+		return nil, errors.New("limits.viewLimits: " + err.Error())
+	}
+
+	accountLimits, err := effectiveLimits(SCOPE_ACCOUNT, accountNumber, Config.AccountLimits[account.Type])
+	if err != nil {
+		return nil, errors.New("limits.viewLimits: " + err.Error())
+	}
+	holderLimits, err := effectiveLimits(SCOPE_HOLDER, tokenUser, Config.HolderLimits)
+	if err != nil {
+		return nil, errors.New("limits.viewLimits: " + err.Error())
+	}
+
+	now := time.Now()
+	for _, scopeLimits := range []struct {
+		scope  string
+		target string
+		limits configuration.Limits
+	}{
+		{SCOPE_ACCOUNT, accountNumber, accountLimits},
+		{SCOPE_HOLDER, tokenUser, holderLimits},
+	} {
+		for _, period := range []string{PERIOD_TRANSACTION, PERIOD_DAILY, PERIOD_WEEKLY, PERIOD_MONTHLY} {
+			limit := limitForPeriod(scopeLimits.limits, period)
+			if limit.Sign() == 0 {
+				continue
+			}
+			used := decimal.Zero
+			if period != PERIOD_TRANSACTION {
+				used, err = getCounter(scopeLimits.scope, scopeLimits.target, period, now)
+				if err != nil {
+					return nil, errors.New("limits.viewLimits: " + err.Error())
+				}
+			}
+			result = append(result, LimitStatus{scopeLimits.scope, period, limit, used, remaining(limit, used)})
+		}
+	}
+
+	return
+}
+
+func lowerLimit(data []string) (result string, err error) {
+	tokenUser, err := appauth.GetUserFromToken(data[0])
+	if err != nil {
+		return "", errors.New("limits.lowerLimit: " + err.Error())
+	}
+
+	scope := data[3]
+	period := data[5]
+	amount, err := parseLimitAmount(data[6])
+	if err != nil {
+		return "", errors.New("limits.lowerLimit: " + err.Error())
+	}
+	if amount.Sign() == 0 {
+		return "", errors.New("limits.lowerLimit: A limit can only be removed by staff")
+	}
+
+	var target string
+	var current configuration.Limits
+	switch scope {
+	case SCOPE_ACCOUNT:
+		target = data[4]
+		err = accounts.CheckUserAccountValidFromToken(tokenUser, target)
+		if err != nil {
+			return "", errors.New("limits.lowerLimit: " + err.Error())
+		}
+		account, err := accounts.GetAccountByAccountNumber(target)
+		if err != nil {
+			return "", errors.New("limits.lowerLimit: " + err.Error())
+		}
+		current, err = effectiveLimits(SCOPE_ACCOUNT, target, Config.AccountLimits[account.Type])
+		if err != nil {
+			return "", errors.New("limits.lowerLimit: " + err.Error())
+		}
+	case SCOPE_HOLDER:
+		// Holders can only change their own limits
+		target = tokenUser
+		current, err = effectiveLimits(SCOPE_HOLDER, target, Config.HolderLimits)
+		if err != nil {
+			return "", errors.New("limits.lowerLimit: " + err.Error())
+		}
+	default:
+		return "", errors.New("limits.lowerLimit: Scope not valid, must be one of account, holder")
+	}
+
+	if !validPeriod(period) {
+		return "", errors.New("limits.lowerLimit: Period not valid, must be one of transaction, daily, weekly, monthly")
+	}
+
+	// A zero limit is no limit, so any amount lowers it
+	currentLimit := limitForPeriod(current, period)
+	if currentLimit.Sign() != 0 && amount.Cmp(currentLimit) == 1 {
+		return "", errors.New("limits.lowerLimit: Limits can only be lowered. Current " + period + " limit is " + currentLimit.String())
+	}
+
+	err = saveLimit(scope, target, period, amount)
+	if err != nil {
+		return "", errors.New("limits.lowerLimit: " + err.Error())
+	}
+
+	result = "Limit updated"
+	return
+}
+
+func setLimit(data []string) (result string, err error) {
+	_, err = appauth.CheckPermission(data[:9], appauth.PERMISSION_LIMITS)
+	if err != nil {
+		return "", errors.New("limits.setLimit: " + err.Error())
+	}
+
+	scope := data[3]
+	target := data[4]
+	period := data[5]
+	amount, err := parseLimitAmount(data[6])
+	if err != nil {
+		return "", errors.New("limits.setLimit: " + err.Error())
+	}
+
+	switch scope {
+	case SCOPE_ACCOUNT:
+		_, err = accounts.GetAccountByAccountNumber(target)
+		if err != nil {
+			return "", errors.New("limits.setLimit: " + err.Error())
+		}
+	case SCOPE_HOLDER:
+		if target == "" {
+			return "", errors.New("limits.setLimit: Holder identification number cannot be empty")
+		}
+	default:
+		return "", errors.New("limits.setLimit: Scope not valid, must be one of account, holder")
+	}
+
+	if !validPeriod(period) {
+		return "", errors.New("limits.setLimit: Period not valid, must be one of transaction, daily, weekly, monthly")
+	}
+
+	// A zero amount removes the override, the configured default applies again
+	if amount.Sign() == 0 {
+		err = removeLimit(scope, target, period)
+		if err != nil {
+			return "", errors.New("limits.setLimit: " + err.Error())
+		}
+		return "Limit removed", nil
+	}
+
+	err = saveLimit(scope, target, period, amount)
+	if err != nil {
+		return "", errors.New("limits.setLimit: " + err.Error())
+	}
+
+	result = "Limit updated"
+	return
+}
+
+// Reservation is an amount added to the counters before a payment or deposit
+// is made, so that ones made at the same time cannot together go over a limit.
+// Release takes it off again if the payment is not made.
+type Reservation struct {
+	// Target of the counters the amount was added to, by scope
+	Targets map[string]string
+	Amount  decimal.Decimal
+	Time    int64
+}
+
+// ReservePayment adds an outgoing payment of amount from accountNumber by
+// holderID to the account and holder counters, if it stays within their limits.
+// The returned error names the limit that would be exceeded and what remains of it.
+func ReservePayment(holderID string, accountNumber string, accountType string, amount decimal.Decimal) (reservation Reservation, err error) {
+	accountLimits, err := effectiveLimits(SCOPE_ACCOUNT, accountNumber, Config.AccountLimits[accountType])
+	if err != nil {
+		return Reservation{}, errors.New("limits.ReservePayment: " + err.Error())
+	}
+	holderLimits, err := effectiveLimits(SCOPE_HOLDER, holderID, Config.HolderLimits)
+	if err != nil {
+		return Reservation{}, errors.New("limits.ReservePayment: " + err.Error())
+	}
+
+	now := time.Now()
+	err = reserveLimits(SCOPE_ACCOUNT, accountNumber, accountLimits, amount, now)
+	if err != nil {
+		return Reservation{}, errors.New("limits.ReservePayment: " + err.Error())
+	}
+	err = reserveLimits(SCOPE_HOLDER, holderID, holderLimits, amount, now)
+	if err != nil {
+		releaseErr := decrementCounters(SCOPE_ACCOUNT, accountNumber, amount, now)
+		if releaseErr != nil {
+			return Reservation{}, errors.New("limits.ReservePayment: " + releaseErr.Error())
+		}
+		return Reservation{}, errors.New("limits.ReservePayment: " + err.Error())
+	}
+
+	reservation = Reservation{Targets: map[string]string{SCOPE_ACCOUNT: accountNumber, SCOPE_HOLDER: holderID}, Amount: amount, Time: now.Unix()}
+	return
+}
+
+// ReserveDeposit adds a deposit of amount into accountNumber to the account
+// deposit counters, if it stays within the deposit limits for the account type
+func ReserveDeposit(accountNumber string, accountType string, amount decimal.Decimal) (reservation Reservation, err error) {
+	now := time.Now()
+	err = reserveLimits(SCOPE_DEPOSIT, accountNumber, Config.DepositLimits[accountType], amount, now)
+	if err != nil {
+		return Reservation{}, errors.New("limits.ReserveDeposit: " + err.Error())
+	}
+
+	reservation = Reservation{Targets: map[string]string{SCOPE_DEPOSIT: accountNumber}, Amount: amount, Time: now.Unix()}
+	return
+}
+
+// Release takes a reservation off the counters of the periods it was made in.
+// An empty reservation is left alone.
+func Release(reservation Reservation) (err error) {
+	t := time.Unix(reservation.Time, 0)
+	for scope, target := range reservation.Targets {
+		err = decrementCounters(scope, target, reservation.Amount, t)
+		if err != nil {
+			return errors.New("limits.Release: " + err.Error())
+		}
+	}
+	return
+}
+
+// HoldReservation keeps the reservation of a payment held for review, so it
+// can be released if the payment is rejected. It is kept as long as a monthly
+// counter, after which there is nothing left to release.
+func HoldReservation(transactionID int64, reservation Reservation) (err error) {
+	value, err := json.Marshal(reservation)
+	if err != nil {
+		return errors.New("limits.HoldReservation: Could not encode reservation. " + err.Error())
+	}
+	err = counters.Set(heldKey(transactionID), string(value), periodTTL(PERIOD_MONTHLY))
+	if err != nil {
+		return errors.New("limits.HoldReservation: Could not keep reservation. " + err.Error())
+	}
+	return
+}
+
+// ReleaseHeld releases the reservation of a held payment that was rejected.
+// A payment with no reservation kept is left alone.
+func ReleaseHeld(transactionID int64) (err error) {
+	value, found, err := counters.Get(heldKey(transactionID))
+	if err != nil {
+		return errors.New("limits.ReleaseHeld: Could not get reservation. " + err.Error())
+	}
+	if !found {
+		return
+	}
+
+	reservation := Reservation{}
+	err = json.Unmarshal([]byte(value), &reservation)
+	if err != nil {
+		return errors.New("limits.ReleaseHeld: Could not decode reservation. " + err.Error())
+	}
+	err = Release(reservation)
+	if err != nil {
+		return errors.New("limits.ReleaseHeld: " + err.Error())
+	}
+	err = counters.Del(heldKey(transactionID))
+	if err != nil {
+		return errors.New("limits.ReleaseHeld: Could not remove reservation. " + err.Error())
+	}
+	return
+}
+
+// reserveLimits adds amount to the target's counters unless that takes one
+// over its limit. Each counter is incremented first and compared after, so
+// the check and the increment are one step, and taken off again if it is over.
+func reserveLimits(scope string, target string, limits configuration.Limits, amount decimal.Decimal, now time.Time) (err error) {
+	if limits.PerTransaction.Sign() != 0 && amount.Cmp(limits.PerTransaction) == 1 {
+		return limitError(scope, PERIOD_TRANSACTION, limits.PerTransaction, decimal.Zero)
+	}
+
+	minorUnits := counterUnits(amount)
+	reserved := []string{}
+	for _, period := range counterPeriods {
+		key := counterKey(scope, target, period, now)
+		used, err := incrementCounter(key, minorUnits, periodTTL(period))
+		if err != nil {
+			return errors.New("limits.reserveLimits: " + unreserve(reserved, minorUnits, err).Error())
+		}
+		reserved = append(reserved, key)
+
+		limit := limitForPeriod(limits, period)
+		if limit.Sign() != 0 && used.Cmp(limit) == 1 {
+			return unreserve(reserved, minorUnits, limitError(scope, period, limit, remaining(limit, used.Sub(decimal.New(minorUnits, -COUNTER_PLACES)))))
+		}
+	}
+
+	return
+}
+
+// unreserve takes minorUnits off counters reserveLimits added to, and gives
+// the reason it did, or why they could not be taken off
+func unreserve(keys []string, minorUnits int64, reason error) error {
+	for _, key := range keys {
+		_, err := counters.IncrBy(key, -minorUnits)
+		if err != nil {
+			return errors.New("Could not release counter. " + err.Error())
+		}
+	}
+	return reason
+}
+
+// limitError gives what is left of a period's limit, a per transaction limit
+// has nothing used up to leave
+func limitError(scope string, period string, limit decimal.Decimal, left decimal.Decimal) error {
+	if period == PERIOD_TRANSACTION {
+		return fmt.Errorf("Exceeds %s per transaction limit of %s", scope, money.Format(limit))
+	}
+	return fmt.Errorf("Exceeds %s %s limit of %s. Remaining: %s", scope, period, money.Format(limit), money.Format(left))
+}
+
+// effectiveLimits overlays any stored overrides for the target onto the defaults
+func effectiveLimits(scope string, target string, defaults configuration.Limits) (limits configuration.Limits, err error) {
+	overrides, err := getLimits(scope, target)
+	if err != nil {
+		return configuration.Limits{}, errors.New("limits.effectiveLimits: " + err.Error())
+	}
+
+	limits = defaults
+	for period, amount := range overrides {
+		switch period {
+		case PERIOD_TRANSACTION:
+			limits.PerTransaction = amount
+		case PERIOD_DAILY:
+			limits.Daily = amount
+		case PERIOD_WEEKLY:
+			limits.Weekly = amount
+		case PERIOD_MONTHLY:
+			limits.Monthly = amount
+		}
+	}
+	return
+}
+
+func limitForPeriod(limits configuration.Limits, period string) decimal.Decimal {
+	switch period {
+	case PERIOD_TRANSACTION:
+		return limits.PerTransaction
+	case PERIOD_DAILY:
+		return limits.Daily
+	case PERIOD_WEEKLY:
+		return limits.Weekly
+	case PERIOD_MONTHLY:
+		return limits.Monthly
+	}
+	return decimal.Zero
+}
+
+func remaining(limit decimal.Decimal, used decimal.Decimal) decimal.Decimal {
+	left := limit.Sub(used)
+	if left.Sign() == -1 {
+		return decimal.Zero
+	}
+	return left
+}
+
+func validPeriod(period string) bool {
+	switch period {
+	case PERIOD_TRANSACTION, PERIOD_DAILY, PERIOD_WEEKLY, PERIOD_MONTHLY:
+		return true
+	}
+	return false
+}
+
+func parseLimitAmount(amount string) (decimal.Decimal, error) {
+	amountDecimal, err := decimal.NewFromString(strings.TrimRight(amount, "\x00"))
+	if err != nil {
+		return decimal.Zero, errors.New("limits.parseLimitAmount: Could not convert limit amount to decimal. " + err.Error())
+	}
+	if amountDecimal.Sign() == -1 {
+		return decimal.Zero, errors.New("limits.parseLimitAmount: Limit amount cannot be negative")
+	}
+	return amountDecimal, nil
+}
+
+// periodKey identifies the current day, ISO week or month for a counter
+func periodKey(period string, t time.Time) string {
+	t = t.In(location())
+	switch period {
+	case PERIOD_DAILY:
+		return t.Format("20060102")
+	case PERIOD_WEEKLY:
+		year, week := t.ISOWeek()
+		return strconv.Itoa(year) + "W" + fmt.Sprintf("%02d", week)
+	case PERIOD_MONTHLY:
+		return t.Format("200601")
+	}
+	return ""
+}
+
+// periodTTL is how long a counter must live to cover its whole period
+func periodTTL(period string) time.Duration {
+	switch period {
+	case PERIOD_DAILY:
+		return 2 * 24 * time.Hour
+	case PERIOD_WEEKLY:
+		return 8 * 24 * time.Hour
+	case PERIOD_MONTHLY:
+		return 32 * 24 * time.Hour
+	}
+	return 0
+}
+
+func location() *time.Location {
+	loc, err := time.LoadLocation(Config.TimeZone)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
@@ -0,0 +1,221 @@
+package limits
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bvnk/bank/configuration"
+	"github.com/shopspring/decimal"
+)
+
+func TestProcessLimits(t *testing.T) {
+	data := []string{"", "limits", "1"}
+	_, err := ProcessLimits(data)
+	if err == nil {
+		t.Errorf("ProcessLimits does not pass. Looking for %v, got %v", "Not all data is present", nil)
+	}
+
+	data = []string{"", "limits", "2", "account"}
+	_, err = ProcessLimits(data)
+	if err == nil {
+		t.Errorf("ProcessLimits LowerLimit does not pass. Looking for %v, got %v", "Not all data is present", nil)
+	}
+
+	data = []string{"", "limits", "3", "account", "", "daily", "100"}
+	_, err = ProcessLimits(data)
+	if err == nil {
+		t.Errorf("ProcessLimits SetLimit does not pass. Looking for %v, got %v", "Not all data is present", nil)
+	}
+
+	data = []string{"", "limits", "99", ""}
+	_, err = ProcessLimits(data)
+	if err == nil {
+		t.Errorf("ProcessLimits does not pass. Looking for %v, got %v", "No valid option chosen", nil)
+	}
+}
+
+func TestPeriodKey(t *testing.T) {
+	Config = configuration.Configuration{TimeZone: "UTC"}
+	ti := time.Date(2016, time.January, 3, 12, 0, 0, 0, time.UTC)
+
+	if key := periodKey(PERIOD_DAILY, ti); key != "20160103" {
+		t.Errorf("PeriodKey daily does not pass. Looking for %v, got %v", "20160103", key)
+	}
+	// 3 January 2016 falls in the last ISO week of 2015
+	if key := periodKey(PERIOD_WEEKLY, ti); key != "2015W53" {
+		t.Errorf("PeriodKey weekly does not pass. Looking for %v, got %v", "2015W53", key)
+	}
+	if key := periodKey(PERIOD_MONTHLY, ti); key != "201601" {
+		t.Errorf("PeriodKey monthly does not pass. Looking for %v, got %v", "201601", key)
+	}
+}
+
+// memoryCounters keeps counters for tests, without expiring them
+type memoryCounters struct {
+	mu     sync.Mutex
+	values map[string]string
+}
+
+func setTestCounters() *memoryCounters {
+	m := &memoryCounters{values: map[string]string{}}
+	counters = m
+	return m
+}
+
+func (m *memoryCounters) Get(key string) (value string, found bool, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	value, found = m.values[key]
+	return
+}
+
+func (m *memoryCounters) Set(key string, value string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.values[key] = value
+	return nil
+}
+
+func (m *memoryCounters) IncrBy(key string, value int64) (total int64, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	total, _ = strconv.ParseInt(m.values[key], 10, 64)
+	total += value
+	m.values[key] = strconv.FormatInt(total, 10)
+	return
+}
+
+func (m *memoryCounters) Expire(key string, ttl time.Duration) error {
+	return nil
+}
+
+func (m *memoryCounters) Del(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.values, key)
+	return nil
+}
+
+func TestReserveLimitsPerTransaction(t *testing.T) {
+	setTestCounters()
+	limits := configuration.Limits{PerTransaction: decimal.NewFromFloat(500)}
+
+	err := reserveLimits(SCOPE_ACCOUNT, "account", limits, decimal.NewFromFloat(500), time.Now())
+	if err != nil {
+		t.Errorf("ReserveLimitsPerTransaction does not pass. Looking for %v, got %v", nil, err)
+	}
+
+	err = reserveLimits(SCOPE_ACCOUNT, "account", limits, decimal.NewFromFloat(500.01), time.Now())
+	if err == nil {
+		t.Errorf("ReserveLimitsPerTransaction does not pass. Looking for %v, got %v", "Exceeds account per transaction limit", nil)
+	} else if !strings.HasSuffix(err.Error(), "account per transaction limit of 500.00") {
+		t.Errorf("ReserveLimitsPerTransaction does not pass. Looking for %v, got %v", "account per transaction limit of 500.00", err)
+	}
+}
+
+func TestReserveLimits(t *testing.T) {
+	Config = configuration.Configuration{TimeZone: "UTC"}
+	setTestCounters()
+	limits := configuration.Limits{Daily: decimal.NewFromFloat(100), Weekly: decimal.NewFromFloat(80)}
+	now := time.Now()
+
+	if err := reserveLimits(SCOPE_ACCOUNT, "account", limits, decimal.NewFromFloat(60), now); err != nil {
+		t.Fatalf("ReserveLimits does not pass. Looking for %v, got %v", nil, err)
+	}
+	err := reserveLimits(SCOPE_ACCOUNT, "account", limits, decimal.NewFromFloat(30), now)
+	if err == nil || !strings.Contains(err.Error(), "weekly limit of 80.00. Remaining: 20.00") {
+		t.Errorf("ReserveLimits weekly does not pass. Looking for %v, got %v", "weekly limit of 80.00. Remaining: 20.00", err)
+	}
+
+	// Going over a later period takes the amount off the earlier ones again
+	for _, period := range counterPeriods {
+		if used, _ := getCounter(SCOPE_ACCOUNT, "account", period, now); !used.Equals(decimal.NewFromFloat(60)) {
+			t.Errorf("ReserveLimits %v counter does not pass. Looking for %v, got %v", period, 60, used)
+		}
+	}
+}
+
+func TestReserveLimitsConcurrent(t *testing.T) {
+	Config = configuration.Configuration{TimeZone: "UTC"}
+	setTestCounters()
+	limits := configuration.Limits{Daily: decimal.NewFromFloat(100)}
+	now := time.Now()
+
+	// However many are made at once, together they stay within the limit
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	reserved := decimal.Zero
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := reserveLimits(SCOPE_HOLDER, "holder", limits, decimal.NewFromFloat(10), now); err == nil {
+				mu.Lock()
+				reserved = reserved.Add(decimal.NewFromFloat(10))
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	used, _ := getCounter(SCOPE_HOLDER, "holder", PERIOD_DAILY, now)
+	if reserved.Cmp(limits.Daily) == 1 || !used.Equals(reserved) {
+		t.Errorf("ReserveLimitsConcurrent does not pass. Looking for %v, got %v reserved and %v counted", "at most 100", reserved, used)
+	}
+}
+
+func TestReleaseHeld(t *testing.T) {
+	Config = configuration.Configuration{TimeZone: "UTC"}
+	setTestCounters()
+
+	reservation, err := ReserveDeposit("account", "cheque", decimal.NewFromFloat(80))
+	if err != nil {
+		t.Fatalf("ReleaseHeld does not pass. Looking for %v, got %v", nil, err)
+	}
+	if err := HoldReservation(1, reservation); err != nil {
+		t.Fatalf("ReleaseHeld hold does not pass. Looking for %v, got %v", nil, err)
+	}
+
+	// Rejecting the held payment gives the amount back, once
+	for i := 0; i < 2; i++ {
+		if err := ReleaseHeld(1); err != nil {
+			t.Errorf("ReleaseHeld %v does not pass. Looking for %v, got %v", i, nil, err)
+		}
+	}
+	used, _ := getCounter(SCOPE_DEPOSIT, "account", PERIOD_DAILY, time.Unix(reservation.Time, 0))
+	if !used.Equals(decimal.Zero) {
+		t.Errorf("ReleaseHeld counter does not pass. Looking for %v, got %v", 0, used)
+	}
+}
+
+func TestRemaining(t *testing.T) {
+	left := remaining(decimal.NewFromFloat(100), decimal.NewFromFloat(40))
+	if !left.Equals(decimal.NewFromFloat(60)) {
+		t.Errorf("Remaining does not pass. Looking for %v, got %v", 60, left)
+	}
+
+	left = remaining(decimal.NewFromFloat(100), decimal.NewFromFloat(140))
+	if !left.Equals(decimal.Zero) {
+		t.Errorf("Remaining does not pass. Looking for %v, got %v", 0, left)
+	}
+}
+
+func TestParseLimitAmount(t *testing.T) {
+	_, err := parseLimitAmount("-1")
+	if err == nil {
+		t.Errorf("ParseLimitAmount does not pass. Looking for %v, got %v", "Limit amount cannot be negative", nil)
+	}
+
+	_, err = parseLimitAmount("not a number")
+	if err == nil {
+		t.Errorf("ParseLimitAmount does not pass. Looking for %v, got %v", "Could not convert limit amount to decimal", nil)
+	}
+
+	amount, err := parseLimitAmount("250.50")
+	if err != nil || !amount.Equals(decimal.NewFromFloat(250.50)) {
+		t.Errorf("ParseLimitAmount does not pass. Looking for %v, got %v (%v)", "250.50", amount, err)
+	}
+}
@@ -8,7 +8,12 @@ import (
 	"os"
 	"runtime"
 
+	"github.com/bvnk/bank/accounts"
 	"github.com/bvnk/bank/configuration"
+	"github.com/bvnk/bank/eod"
+	"github.com/bvnk/bank/interbank"
+	"github.com/bvnk/bank/money"
+	"github.com/bvnk/bank/transactions"
 )
 
 const (
@@ -20,11 +25,13 @@ const (
 )
 
 var logPath *string
+var repair *bool
 
 func main() {
 	argClientServer := flag.String("mode", "server", "Mode to run the service in")
 	configPath := flag.String("configPath", "/etc/bvnk/config.json", "Config path absolute location. Default /etc/bvnk/config.json")
 	logPath = flag.String("logPath", "/var/log/bvnk/bank.log", "Log path absolute location. Default /var/log/bvnk/bank.log")
+	repair = flag.Bool("repair", false, "Correct the balances found by -mode reconcile, or convert the columns with -mode convertmoney. Default only reports them")
 	flag.Parse()
 
 	configuration.SetConfigPath(*configPath)
@@ -63,13 +70,126 @@ func parseArguments(arg string) (err error) {
 		for {
 			runServer("no-tls")
 		}
+	case "eod":
+		// Run the end of day once, e.g. from cron after close of business
+		err := runEOD()
+		if err != nil {
+			log.Fatalf("Could not run end of day. %v", err)
+		}
+		break
+	case "reconcile":
+		// Recompute balances from the transactions, and repair them with -repair
+		err := runReconcile(!*repair)
+		if err != nil {
+			log.Fatalf("Could not reconcile. %v", err)
+		}
+		break
+	case "convertmoney":
+		// Move float amount columns to decimal once, converting them with -repair
+		err := runConvertMoney(!*repair)
+		if err != nil {
+			log.Fatalf("Could not convert money columns. %v", err)
+		}
+		break
 	default:
-		return errors.New("No valid option chosen. Valid options: client, clientNoTLS, server, serverNoTLS")
+		return errors.New("No valid option chosen. Valid options: client, clientNoTLS, server, serverNoTLS, eod, reconcile, convertmoney")
 	}
 
 	return
 }
 
+func runEOD() (err error) {
+	// Load app config
+	Config, err := configuration.LoadConfig()
+	if err != nil {
+		return errors.New("main.runEOD: " + err.Error())
+	}
+
+	// Set config in the packages the jobs use
+	accounts.SetConfig(&Config)
+	interbank.SetConfig(&Config)
+	eod.SetConfig(&Config)
+
+	status, err := eod.Run()
+	if err != nil {
+		bLog(3, err.Error(), trace())
+		return errors.New("main.runEOD: " + err.Error())
+	}
+
+	message := "End of day done. Business date " + status.State.BusinessDate + " is " + status.State.Status
+	fmt.Println(message)
+	bLog(1, message, trace())
+	return
+}
+
+func runReconcile(dryRun bool) (err error) {
+	// Load app config
+	Config, err := configuration.LoadConfig()
+	if err != nil {
+		return errors.New("main.runReconcile: " + err.Error())
+	}
+
+	accounts.SetConfig(&Config)
+	transactions.SetConfig(&Config)
+
+	result, err := transactions.Reconcile(dryRun)
+	if err != nil {
+		bLog(3, err.Error(), trace())
+		return errors.New("main.runReconcile: " + err.Error())
+	}
+
+	for _, discrepancy := range result.Discrepancies {
+		fmt.Printf("%s %s: balance %s expected %s, available %s expected %s, repaired %v\n", discrepancy.AccountNumber, discrepancy.Issue,
+			discrepancy.AccountBalance.String(), discrepancy.ExpectedAccountBalance.String(),
+			discrepancy.AvailableBalance.String(), discrepancy.ExpectedAvailableBalance.String(), discrepancy.Repaired)
+	}
+	fmt.Printf("Fees: holding account %s expected %s, repaired %v\n", result.Fees.Balance.String(), result.Fees.Expected.String(), result.Fees.Repaired)
+
+	message := fmt.Sprintf("Reconciled %d accounts, %d discrepancies", result.Accounts, len(result.Discrepancies))
+	if dryRun {
+		message += ". Dry run, run with -repair to correct them"
+	}
+	fmt.Println(message)
+	bLog(1, message, trace())
+	return
+}
+
+func runConvertMoney(dryRun bool) (err error) {
+	// Load app config
+	Config, err := configuration.LoadConfig()
+	if err != nil {
+		return errors.New("main.runConvertMoney: " + err.Error())
+	}
+
+	money.SetConfig(&Config)
+
+	conversion, err := money.Convert(dryRun)
+	if err != nil {
+		bLog(3, err.Error(), trace())
+		return errors.New("main.runConvertMoney: " + err.Error())
+	}
+
+	for _, row := range conversion.Rows {
+		fmt.Printf("%s.%s %s %s: float %s expected %s, stored as %s\n", row.Table, row.Column, row.Key, row.Issue,
+			row.Float.String(), row.Expected.String(), row.Converted.String())
+	}
+	converted := 0
+	for _, column := range conversion.Columns {
+		if column.Converted {
+			converted++
+		}
+		fmt.Printf("%s.%s: %s, %d rows, converted %v\n", column.Table, column.Column, column.Type, column.Rows, column.Converted)
+	}
+
+	message := fmt.Sprintf("Converted %d of %d columns, %d amounts not stored exactly", converted, len(conversion.Columns), len(conversion.Rows))
+	if dryRun {
+		message += ". Dry run, stop the bank and run with -repair to convert them"
+	}
+	fmt.Println(message)
+	bLog(1, message, trace())
+	return
+}
+
 // Simple log function for logging to a file
 func bLog(logLevel int, message string, functionName string) (err error) {
 	f, err := os.OpenFile(*logPath, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
@@ -0,0 +1,163 @@
+package money
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/bvnk/bank/configuration"
+	"github.com/shopspring/decimal"
+)
+
+var Config configuration.Configuration
+
+func SetConfig(config *configuration.Configuration) {
+	Config = *config
+}
+
+// Conversion is what converting the float columns did, or would do
+type Conversion struct {
+	Timestamp int32
+	DryRun    bool
+	Columns   []ColumnConversion
+	Rows      []RowConversion
+}
+
+type ColumnConversion struct {
+	Table     string
+	Column    string
+	Type      string
+	Rows      int
+	Converted bool
+}
+
+// RowConversion is an amount that did not survive the float exactly.
+// Float is the value the float held, Expected the amount it was most likely
+// given and Converted what is stored from now on.
+type RowConversion struct {
+	Table     string
+	Column    string
+	Key       string
+	Issue     string
+	Float     decimal.Decimal
+	Expected  decimal.Decimal
+	Converted decimal.Decimal
+}
+
+// Convert moves every float amount column to COLUMN_TYPE and reports the amounts
+// that were not stored exactly. Columns that are already decimal are left alone.
+// The bank must not be taking payments while it runs, as the columns are changed
+// one at a time.
+func Convert(dryRun bool) (conversion Conversion, err error) {
+	conversion = Conversion{Timestamp: int32(time.Now().Unix()), DryRun: dryRun, Columns: []ColumnConversion{}, Rows: []RowConversion{}}
+
+	for _, column := range COLUMNS {
+		columnType, found, err := getColumnType(column)
+		if err != nil {
+			return Conversion{}, errors.New("money.Convert: " + err.Error())
+		}
+		// Tables from migrations that have not been run yet
+		if !found {
+			continue
+		}
+
+		result := ColumnConversion{Table: column.Table, Column: column.Column, Type: columnType}
+		if columnType != "float" && columnType != "double" {
+			conversion.Columns = append(conversion.Columns, result)
+			continue
+		}
+
+		rows, count, err := checkColumn(column)
+		if err != nil {
+			return Conversion{}, errors.New("money.Convert: " + err.Error())
+		}
+		result.Rows = count
+		conversion.Rows = append(conversion.Rows, rows...)
+
+		if !dryRun {
+			err = convertColumn(column, rows)
+			if err != nil {
+				return Conversion{}, errors.New("money.Convert: " + err.Error())
+			}
+			result.Converted = true
+		}
+		conversion.Columns = append(conversion.Columns, result)
+	}
+	return
+}
+
+// getColumnType gives the type of a column in the bank's database.
+// found is false if the table does not exist.
+func getColumnType(column Column) (columnType string, found bool, err error) {
+	err = Config.Db.QueryRow("SELECT `DATA_TYPE` FROM `information_schema`.`COLUMNS` WHERE `TABLE_SCHEMA` = DATABASE() AND `TABLE_NAME` = ? AND `COLUMN_NAME` = ?", column.Table, column.Column).Scan(&columnType)
+	switch {
+	case err == sql.ErrNoRows:
+		return "", false, nil
+	case err != nil:
+		return "", false, errors.New("money.getColumnType: " + err.Error())
+	}
+	return columnType, true, nil
+}
+
+// checkColumn reads every float in a column and gives the ones that do not convert exactly
+func checkColumn(column Column) (conversions []RowConversion, count int, err error) {
+	// A prepared statement returns floats in binary, as text they are cut to six digits
+	stmt, err := Config.Db.Prepare("SELECT " + column.Key + ", `" + column.Column + "` FROM `" + column.Table + "`")
+	if err != nil {
+		return nil, 0, errors.New("money.checkColumn: " + err.Error())
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query()
+	if err != nil {
+		return nil, 0, errors.New("money.checkColumn: " + err.Error())
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var key string
+		var value float64
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, 0, errors.New("money.checkColumn: " + err.Error())
+		}
+		count++
+
+		issue, expected, converted, _ := checkFloat(float32(value))
+		if issue == "" {
+			continue
+		}
+		conversions = append(conversions, RowConversion{
+			Table:     column.Table,
+			Column:    column.Column,
+			Key:       key,
+			Issue:     issue,
+			Float:     decimal.NewFromFloat(float64(float32(value))),
+			Expected:  expected,
+			Converted: converted,
+		})
+	}
+	return
+}
+
+// convertColumn changes a column to decimal, then corrects the amounts the
+// database would have converted from the float's binary value
+func convertColumn(column Column, conversions []RowConversion) (err error) {
+	_, err = Config.Db.Exec("ALTER TABLE `" + column.Table + "` MODIFY `" + column.Column + "` " + COLUMN_TYPE + " NOT NULL")
+	if err != nil {
+		return errors.New("money.convertColumn: " + err.Error())
+	}
+
+	stmtUpd, err := Config.Db.Prepare("UPDATE `" + column.Table + "` SET `" + column.Column + "` = ? WHERE " + column.Key + " = ?")
+	if err != nil {
+		return errors.New("money.convertColumn: " + err.Error())
+	}
+	defer stmtUpd.Close()
+
+	for _, conversion := range conversions {
+		_, err = stmtUpd.Exec(conversion.Converted, conversion.Key)
+		if err != nil {
+			return errors.New("money.convertColumn: " + err.Error())
+		}
+	}
+	return
+}
@@ -0,0 +1,102 @@
+package money
+
+/*
+Money package holds how amounts are stored.
+
+Amounts are DECIMAL columns with SCALE decimal places, read and written as
+decimal.Decimal so they round-trip exactly. They were floats before, which
+kept about seven significant digits. Convert moves the float columns of an
+existing database over and reports every value that did not survive the float
+exactly.
+*/
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/shopspring/decimal"
+)
+
+const (
+	// Decimal places amounts are stored to. A fee rate of 0.01% on an amount in
+	// cents needs six.
+	SCALE = 6
+	// Column type amounts are stored as
+	COLUMN_TYPE = "DECIMAL(19,6)"
+
+	ISSUE_CONVERSION = "conversion"
+	ISSUE_PRECISION  = "precision"
+)
+
+// Column is a table column that holds an amount. Key identifies a row in the table.
+type Column struct {
+	Table  string
+	Key    string
+	Column string
+}
+
+// Every column that holds an amount
+var COLUMNS = []Column{
+	{"transactions", "`id`", "transactionAmount"},
+	{"transactions", "`id`", "feeAmount"},
+	{"accounts", "`id`", "accountBalance"},
+	{"accounts", "`id`", "overdraft"},
+	{"accounts", "`id`", "availableBalance"},
+	{"bank_account", "`id`", "balance"},
+	{"bank_transactions", "`id`", "transactionAmount"},
+	{"bank_transactions", "`id`", "feeAmount"},
+	{"accounts_limits", "`id`", "amount"},
+	{"aml_alerts", "`id`", "amount"},
+	{"transactions_batches", "`id`", "totalAmount"},
+	{"transactions_batches_lines", "`id`", "amount"},
+	{"interbank_settlements", "`id`", "amountOut"},
+	{"interbank_settlements", "`id`", "amountReturned"},
+	{"interbank_settlements", "`id`", "amountIn"},
+	{"interbank_settlements", "`id`", "netAmount"},
+	{"accounts_closing_balances", "CONCAT(`businessDate`, ' ', `accountNumber`)", "accountBalance"},
+	{"accounts_closing_balances", "CONCAT(`businessDate`, ' ', `accountNumber`)", "availableBalance"},
+}
+
+// Round gives an amount to the decimal places it is stored to
+func Round(amount decimal.Decimal) decimal.Decimal {
+	return amount.Round(SCALE)
+}
+
+// Valid is false if storing an amount would round it
+func Valid(amount decimal.Decimal) bool {
+	return Round(amount).Equals(amount)
+}
+
+// Format writes an amount to the decimal places it is stored to, leaving off
+// zeros past the second so whole cents still read as 1.50. Statements and
+// reports use it so the lines add up to the totals shown.
+func Format(amount decimal.Decimal) string {
+	value := amount.StringFixed(SCALE)
+	cents := strings.Index(value, ".") + 3
+	return value[:cents] + strings.TrimRight(value[cents:], "0")
+}
+
+// convertFloat finds the amount a float column was given. A float holds the
+// binary value nearest to the amount written, which is the shortest decimal
+// that reads back as the same float. Converting the column in the database
+// uses the binary value instead, which is direct.
+func convertFloat(value float32) (expected decimal.Decimal, converted decimal.Decimal, direct decimal.Decimal) {
+	expected, _ = decimal.NewFromString(strconv.FormatFloat(float64(value), 'f', -1, 32))
+	converted = Round(expected)
+	direct = Round(decimal.NewFromFloat(float64(value)))
+	return
+}
+
+// checkFloat gives the issue with converting a float, or an empty issue if there is none
+func checkFloat(value float32) (issue string, expected decimal.Decimal, converted decimal.Decimal, direct decimal.Decimal) {
+	expected, converted, direct = convertFloat(value)
+	switch {
+	case !converted.Equals(expected):
+		// The float held more decimal places than are stored
+		issue = ISSUE_PRECISION
+	case !direct.Equals(converted):
+		// Converting in the database would have changed the amount
+		issue = ISSUE_CONVERSION
+	}
+	return
+}
@@ -0,0 +1,67 @@
+package money
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestCheckFloat(t *testing.T) {
+	tests := []struct {
+		value     float32
+		issue     string
+		converted string
+	}{
+		{0.1, "", "0.1"},
+		{20, "", "20"},
+		// The database would convert the binary value to 123456.7891
+		{123456.79, ISSUE_CONVERSION, "123456.79"},
+		{1.0000001, ISSUE_PRECISION, "1"},
+	}
+
+	for _, test := range tests {
+		issue, _, converted, _ := checkFloat(test.value)
+		expected, _ := decimal.NewFromString(test.converted)
+		if issue != test.issue || !converted.Equals(expected) {
+			t.Errorf("CheckFloat does not pass for %v. Looking for %v %v, got %v %v", test.value, test.issue, test.converted, issue, converted)
+		}
+	}
+}
+
+func TestValid(t *testing.T) {
+	tests := []struct {
+		amount string
+		valid  bool
+	}{
+		{"20", true},
+		{"0.000001", true},
+		{"0.0000001", false},
+	}
+
+	for _, test := range tests {
+		amount, _ := decimal.NewFromString(test.amount)
+		if Valid(amount) != test.valid {
+			t.Errorf("Valid does not pass for %v. Looking for %v, got %v", test.amount, test.valid, Valid(amount))
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		amount    string
+		formatted string
+	}{
+		{"20", "20.00"},
+		{"1.5", "1.50"},
+		{"-0.125", "-0.125"},
+		{"0.000001", "0.000001"},
+		{"0.0000004", "0.00"},
+	}
+
+	for _, test := range tests {
+		amount, _ := decimal.NewFromString(test.amount)
+		if formatted := Format(amount); formatted != test.formatted {
+			t.Errorf("Format does not pass for %v. Looking for %v, got %v", test.amount, test.formatted, formatted)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package sanctions
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/bvnk/bank/configuration"
+)
+
+var Config configuration.Configuration
+
+func SetConfig(config *configuration.Configuration) {
+	Config = *config
+}
+
+func saveReview(accountNumber string, result Result) (err error) {
+	insertStatement := "INSERT INTO sanctions_reviews (`accountNumber`, `name`, `matchedName`, `entryID`, `score`, `status`, `reviewer`, `timestamp`) "
+	insertStatement += "VALUES(?, ?, ?, ?, ?, ?, '', ?)"
+	stmtIns, err := Config.Db.Prepare(insertStatement)
+	if err != nil {
+		return errors.New("sanctions.saveReview: " + err.Error())
+	}
+	defer stmtIns.Close()
+
+	t := time.Now()
+	sqlTime := int32(t.Unix())
+
+	_, err = stmtIns.Exec(accountNumber, result.Name, result.MatchedName, result.EntryID, result.Score, REVIEW_STATUS_PENDING, sqlTime)
+	if err != nil {
+		return errors.New("sanctions.saveReview: " + err.Error())
+	}
+	return
+}
+
+func getReview(reviewID int64) (review Review, err error) {
+	err = Config.Db.QueryRow("SELECT `id`, `accountNumber`, `name`, `matchedName`, `entryID`, `score`, `status`, `reviewer`, `timestamp` FROM `sanctions_reviews` WHERE `id` = ?", reviewID).Scan(&review.ID, &review.AccountNumber, &review.Name, &review.MatchedName, &review.EntryID, &review.Score, &review.Status, &review.Reviewer, &review.Timestamp)
+	switch {
+	case err == sql.ErrNoRows:
+		return Review{}, errors.New("sanctions.getReview: Review not found")
+	case err != nil:
+		return Review{}, errors.New("sanctions.getReview: " + err.Error())
+	}
+	return
+}
+
+func getPendingReviews() (reviews []Review, err error) {
+	rows, err := Config.Db.Query("SELECT `id`, `accountNumber`, `name`, `matchedName`, `entryID`, `score`, `status`, `reviewer`, `timestamp` FROM `sanctions_reviews` WHERE `status` = ? ORDER BY `id`", REVIEW_STATUS_PENDING)
+	if err != nil {
+		return nil, errors.New("sanctions.getPendingReviews: " + err.Error())
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		r := Review{}
+		if err := rows.Scan(&r.ID, &r.AccountNumber, &r.Name, &r.MatchedName, &r.EntryID, &r.Score, &r.Status, &r.Reviewer, &r.Timestamp); err != nil {
+			return nil, errors.New("sanctions.getPendingReviews: " + err.Error())
+		}
+		reviews = append(reviews, r)
+	}
+	return
+}
+
+func updateReview(reviewID int64, status string, reviewer string) (err error) {
+	updateStatement := "UPDATE sanctions_reviews SET `status` = ?, `reviewer` = ?, `timestamp` = ? WHERE `id` = ?"
+	stmtUpd, err := Config.Db.Prepare(updateStatement)
+	if err != nil {
+		return errors.New("sanctions.updateReview: " + err.Error())
+	}
+	defer stmtUpd.Close()
+
+	t := time.Now()
+	sqlTime := int32(t.Unix())
+
+	_, err = stmtUpd.Exec(status, reviewer, sqlTime, reviewID)
+	if err != nil {
+		return errors.New("sanctions.updateReview: " + err.Error())
+	}
+	return
+}
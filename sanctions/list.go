@@ -0,0 +1,218 @@
+package sanctions
+
+import (
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Entry is a single person or organisation on the list
+type Entry struct {
+	ID       string
+	Name     string
+	Type     string
+	Programs []string
+	Aliases  []string
+}
+
+// The loaded list, replaced as a whole on every load
+var (
+	listMutex   sync.RWMutex
+	listEntries []Entry
+	listPath    string
+	listModTime time.Time
+)
+
+// Load reads the configured list from disk and replaces the one in use
+func Load() (err error) {
+	path := Config.Sanctions.ListPath
+	if path == "" {
+		return errors.New("sanctions.Load: No list configured")
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return errors.New("sanctions.Load: " + err.Error())
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return errors.New("sanctions.Load: " + err.Error())
+	}
+	defer file.Close()
+
+	var entries []Entry
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		entries, err = parseCSV(file)
+	case ".xml":
+		entries, err = parseXML(file)
+	default:
+		return errors.New("sanctions.Load: List must be a .csv or .xml file")
+	}
+	if err != nil {
+		return errors.New("sanctions.Load: " + err.Error())
+	}
+
+	listMutex.Lock()
+	listEntries = entries
+	listPath = path
+	listModTime = info.ModTime()
+	listMutex.Unlock()
+
+	return
+}
+
+// currentEntries returns the list in use, loading it first if the file changed
+func currentEntries() (entries []Entry, err error) {
+	info, err := os.Stat(Config.Sanctions.ListPath)
+	if err != nil {
+		return nil, errors.New("sanctions.currentEntries: " + err.Error())
+	}
+
+	listMutex.RLock()
+	stale := listPath != Config.Sanctions.ListPath || !info.ModTime().Equal(listModTime)
+	listMutex.RUnlock()
+
+	if stale {
+		err = Load()
+		if err != nil {
+			return nil, errors.New("sanctions.currentEntries: " + err.Error())
+		}
+	}
+
+	listMutex.RLock()
+	entries = listEntries
+	listMutex.RUnlock()
+	return
+}
+
+func listSize() int {
+	listMutex.RLock()
+	defer listMutex.RUnlock()
+	return len(listEntries)
+}
+
+// parseCSV reads a list in the OFAC sdn.csv layout:
+// ent_num,SDN_Name,SDN_Type,Program,...
+// Further rows with the same ent_num are aliases of that entry.
+func parseCSV(r io.Reader) (entries []Entry, err error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+
+	index := make(map[string]int)
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.New("sanctions.parseCSV: " + err.Error())
+		}
+		if len(record) < 2 {
+			continue
+		}
+
+		id := strings.TrimSpace(record[0])
+		name := csvValue(record, 1)
+		if id == "" || name == "" || strings.EqualFold(id, "ent_num") {
+			continue
+		}
+
+		if i, ok := index[id]; ok {
+			entries[i].Aliases = append(entries[i].Aliases, name)
+			continue
+		}
+
+		entry := Entry{ID: id, Name: name, Type: csvValue(record, 2)}
+		if program := csvValue(record, 3); program != "" {
+			entry.Programs = strings.Split(program, "] [")
+			for i := range entry.Programs {
+				entry.Programs[i] = strings.Trim(entry.Programs[i], "[] ")
+			}
+		}
+		index[id] = len(entries)
+		entries = append(entries, entry)
+	}
+
+	return
+}
+
+// OFAC uses -0- for empty values
+func csvValue(record []string, i int) string {
+	if i >= len(record) {
+		return ""
+	}
+	value := strings.TrimSpace(record[i])
+	if value == "-0-" {
+		return ""
+	}
+	return value
+}
+
+type xmlList struct {
+	Entries []xmlEntry `xml:"sdnEntry"`
+}
+
+type xmlEntry struct {
+	UID       string   `xml:"uid"`
+	FirstName string   `xml:"firstName"`
+	LastName  string   `xml:"lastName"`
+	Type      string   `xml:"sdnType"`
+	Programs  []string `xml:"programList>program"`
+	Akas      []xmlAka `xml:"akaList>aka"`
+}
+
+type xmlAka struct {
+	FirstName string `xml:"firstName"`
+	LastName  string `xml:"lastName"`
+}
+
+// parseXML reads a list in the OFAC sdn.xml layout
+func parseXML(r io.Reader) (entries []Entry, err error) {
+	list := xmlList{}
+	err = xml.NewDecoder(r).Decode(&list)
+	if err != nil {
+		return nil, errors.New("sanctions.parseXML: " + err.Error())
+	}
+
+	for _, e := range list.Entries {
+		entry := Entry{
+			ID:       e.UID,
+			Name:     xmlName(e.LastName, e.FirstName),
+			Type:     e.Type,
+			Programs: e.Programs,
+		}
+		if entry.Name == "" {
+			continue
+		}
+		for _, aka := range e.Akas {
+			if alias := xmlName(aka.LastName, aka.FirstName); alias != "" {
+				entry.Aliases = append(entry.Aliases, alias)
+			}
+		}
+		entries = append(entries, entry)
+	}
+
+	return
+}
+
+// Names are kept as "Last, First" the same as in the CSV list
+func xmlName(lastName string, firstName string) string {
+	lastName = strings.TrimSpace(lastName)
+	firstName = strings.TrimSpace(firstName)
+	if firstName == "" {
+		return lastName
+	}
+	if lastName == "" {
+		return firstName
+	}
+	return lastName + ", " + firstName
+}
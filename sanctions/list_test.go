@@ -0,0 +1,115 @@
+package sanctions
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseCSV(t *testing.T) {
+	list := `ent_num,SDN_Name,SDN_Type,Program,Title
+36,"AEROCARIBBEAN AIRLINES",-0-,"CUBA",-0-
+173,"ANGLO-CARIBBEAN CO., LTD.",-0-,"CUBA",-0-
+2674,"BIN LADIN, Usama","individual","SDGT] [SDT",-0-
+2674,"BIN LADEN, Osama",-0-,-0-,-0-
+`
+	entries, err := parseCSV(strings.NewReader(list))
+	if err != nil {
+		t.Fatalf("ParseCSV does not pass. ERROR: %v", err)
+	}
+
+	if len(entries) != 3 {
+		t.Fatalf("ParseCSV does not pass. Looking for %v entries, got %v", 3, len(entries))
+	}
+	if entries[0].Type != "" {
+		t.Errorf("ParseCSV does not pass. Looking for empty type, got %v", entries[0].Type)
+	}
+	if entries[2].Name != "BIN LADIN, Usama" || len(entries[2].Aliases) != 1 || entries[2].Aliases[0] != "BIN LADEN, Osama" {
+		t.Errorf("ParseCSV does not pass. Aliases not grouped: %v", entries[2])
+	}
+	if len(entries[2].Programs) != 2 || entries[2].Programs[1] != "SDT" {
+		t.Errorf("ParseCSV does not pass. Looking for programs [SDGT SDT], got %v", entries[2].Programs)
+	}
+}
+
+func TestParseXML(t *testing.T) {
+	list := `<?xml version="1.0" standalone="yes"?>
+<sdnList xmlns="http://tempuri.org/sdnList.xsd">
+  <sdnEntry>
+    <uid>2674</uid>
+    <firstName>Usama</firstName>
+    <lastName>BIN LADIN</lastName>
+    <sdnType>Individual</sdnType>
+    <programList>
+      <program>SDGT</program>
+      <program>SDT</program>
+    </programList>
+    <akaList>
+      <aka>
+        <uid>1</uid>
+        <type>a.k.a.</type>
+        <firstName>Osama</firstName>
+        <lastName>BIN LADEN</lastName>
+      </aka>
+    </akaList>
+  </sdnEntry>
+  <sdnEntry>
+    <uid>6366</uid>
+    <lastName>AL QAIDA</lastName>
+    <sdnType>Entity</sdnType>
+  </sdnEntry>
+</sdnList>`
+	entries, err := parseXML(strings.NewReader(list))
+	if err != nil {
+		t.Fatalf("ParseXML does not pass. ERROR: %v", err)
+	}
+
+	if len(entries) != 2 {
+		t.Fatalf("ParseXML does not pass. Looking for %v entries, got %v", 2, len(entries))
+	}
+	if entries[0].Name != "BIN LADIN, Usama" || entries[0].ID != "2674" {
+		t.Errorf("ParseXML does not pass. Looking for %v, got %v", "BIN LADIN, Usama", entries[0].Name)
+	}
+	if len(entries[0].Aliases) != 1 || entries[0].Aliases[0] != "BIN LADEN, Osama" {
+		t.Errorf("ParseXML does not pass. Looking for alias %v, got %v", "BIN LADEN, Osama", entries[0].Aliases)
+	}
+	if entries[1].Name != "AL QAIDA" {
+		t.Errorf("ParseXML does not pass. Looking for %v, got %v", "AL QAIDA", entries[1].Name)
+	}
+}
+
+func TestCurrentEntriesReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sanctions")
+	if err != nil {
+		t.Fatalf("CurrentEntriesReload does not pass. ERROR: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "sdn.csv")
+	err = ioutil.WriteFile(path, []byte("1,\"FIRST ENTRY\",-0-,-0-\n"), 0644)
+	if err != nil {
+		t.Fatalf("CurrentEntriesReload does not pass. ERROR: %v", err)
+	}
+	Config.Sanctions.ListPath = path
+
+	entries, err := currentEntries()
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("CurrentEntriesReload does not pass. Looking for %v entries, got %v (%v)", 1, len(entries), err)
+	}
+
+	// A changed file is picked up on the next screening
+	err = ioutil.WriteFile(path, []byte("1,\"FIRST ENTRY\",-0-,-0-\n2,\"SECOND ENTRY\",-0-,-0-\n"), 0644)
+	if err != nil {
+		t.Fatalf("CurrentEntriesReload does not pass. ERROR: %v", err)
+	}
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(path, later, later)
+
+	entries, err = currentEntries()
+	if err != nil || len(entries) != 2 {
+		t.Errorf("CurrentEntriesReload does not pass. Looking for %v entries, got %v (%v)", 2, len(entries), err)
+	}
+}
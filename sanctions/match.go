@@ -0,0 +1,147 @@
+package sanctions
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// bestMatch finds the entry closest to name, across entry names and aliases
+func bestMatch(name string, entries []Entry) (best *Entry, bestScore float64) {
+	tokens := nameTokens(name)
+	if len(tokens) == 0 {
+		return nil, 0
+	}
+
+	for i := range entries {
+		for _, candidate := range append([]string{entries[i].Name}, entries[i].Aliases...) {
+			score := similarity(tokens, nameTokens(candidate))
+			if score > bestScore {
+				best = &entries[i]
+				bestScore = score
+			}
+		}
+	}
+	return
+}
+
+// similarity scores two names from 0 to 1.
+// Word order is ignored, and for names of more than one word each word of the
+// shorter name is matched to its closest word in the longer, so a missing middle
+// name does not hide a match.
+func similarity(a []string, b []string) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+
+	score := jaroWinkler(sortedName(a), sortedName(b))
+	if len(a) < 2 || len(b) < 2 {
+		return score
+	}
+
+	shorter, longer := a, b
+	if len(shorter) > len(longer) {
+		shorter, longer = longer, shorter
+	}
+	total := 0.
+	for _, s := range shorter {
+		best := 0.
+		for _, l := range longer {
+			if jw := jaroWinkler(s, l); jw > best {
+				best = jw
+			}
+		}
+		total += best
+	}
+
+	if tokenScore := total / float64(len(shorter)); tokenScore > score {
+		return tokenScore
+	}
+	return score
+}
+
+// nameTokens lowercases a name and splits it into words, dropping punctuation
+func nameTokens(name string) []string {
+	return strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+func sortedName(tokens []string) string {
+	sorted := append([]string{}, tokens...)
+	sort.Strings(sorted)
+	return strings.Join(sorted, " ")
+}
+
+// jaroWinkler is the Jaro-Winkler similarity of two strings, from 0 to 1
+func jaroWinkler(a string, b string) float64 {
+	s1 := []rune(a)
+	s2 := []rune(b)
+	if len(s1) == 0 && len(s2) == 0 {
+		return 1
+	}
+	if len(s1) == 0 || len(s2) == 0 {
+		return 0
+	}
+
+	matchDistance := len(s1)
+	if len(s2) > matchDistance {
+		matchDistance = len(s2)
+	}
+	matchDistance = matchDistance/2 - 1
+	if matchDistance < 0 {
+		matchDistance = 0
+	}
+
+	s1Matches := make([]bool, len(s1))
+	s2Matches := make([]bool, len(s2))
+	matches := 0
+	for i := range s1 {
+		start := i - matchDistance
+		if start < 0 {
+			start = 0
+		}
+		end := i + matchDistance + 1
+		if end > len(s2) {
+			end = len(s2)
+		}
+		for j := start; j < end; j++ {
+			if s2Matches[j] || s1[i] != s2[j] {
+				continue
+			}
+			s1Matches[i] = true
+			s2Matches[j] = true
+			matches++
+			break
+		}
+	}
+	if matches == 0 {
+		return 0
+	}
+
+	transpositions := 0
+	k := 0
+	for i := range s1 {
+		if !s1Matches[i] {
+			continue
+		}
+		for !s2Matches[k] {
+			k++
+		}
+		if s1[i] != s2[k] {
+			transpositions++
+		}
+		k++
+	}
+
+	m := float64(matches)
+	jaro := (m/float64(len(s1)) + m/float64(len(s2)) + (m-float64(transpositions)/2)/m) / 3
+
+	// Common prefix of up to 4 characters
+	prefix := 0
+	for prefix < 4 && prefix < len(s1) && prefix < len(s2) && s1[prefix] == s2[prefix] {
+		prefix++
+	}
+
+	return jaro + float64(prefix)*0.1*(1-jaro)
+}
@@ -0,0 +1,76 @@
+package sanctions
+
+import (
+	"math"
+	"testing"
+)
+
+func TestJaroWinkler(t *testing.T) {
+	tst := []struct {
+		a        string
+		b        string
+		expected float64
+	}{
+		{"martha", "marhta", 0.961},
+		{"dwayne", "duane", 0.840},
+		{"dixon", "dicksonx", 0.813},
+		{"same", "same", 1},
+		{"abc", "xyz", 0},
+	}
+
+	for _, test := range tst {
+		score := jaroWinkler(test.a, test.b)
+		if math.Abs(score-test.expected) > 0.001 {
+			t.Errorf("JaroWinkler does not pass for %v, %v. Looking for %v, got %v", test.a, test.b, test.expected, score)
+		}
+	}
+}
+
+func TestNameTokens(t *testing.T) {
+	tokens := nameTokens("BIN LADIN, Usama")
+	if sortedName(tokens) != "bin ladin usama" {
+		t.Errorf("NameTokens does not pass. Looking for %v, got %v", "bin ladin usama", sortedName(tokens))
+	}
+}
+
+func TestBestMatch(t *testing.T) {
+	entries := []Entry{
+		Entry{ID: "1", Name: "BIN LADIN, Usama", Aliases: []string{"BIN LADEN, Osama"}},
+		Entry{ID: "2", Name: "AL-QAIDA"},
+	}
+
+	tst := []struct {
+		name     string
+		entryID  string
+		minScore float64
+		maxScore float64
+	}{
+		// Word order and case do not matter
+		{"Usama Bin Ladin", "1", 1, 1},
+		// Alias with a missing word
+		{"Osama Laden", "1", 0.95, 1},
+		// Spelling variation
+		{"Usama Bin Ladn", "1", 0.9, 1},
+		{"al qaida", "2", 1, 1},
+		{"Kyle Redelinghuys", "", 0, 0.85},
+	}
+
+	for _, test := range tst {
+		entry, score := bestMatch(test.name, entries)
+		if score < test.minScore || score > test.maxScore {
+			t.Errorf("BestMatch does not pass for %v. Looking for %v - %v, got %v", test.name, test.minScore, test.maxScore, score)
+		}
+		if test.entryID != "" && (entry == nil || entry.ID != test.entryID) {
+			t.Errorf("BestMatch does not pass for %v. Looking for entry %v, got %v", test.name, test.entryID, entry)
+		}
+	}
+}
+
+func TestAction(t *testing.T) {
+	Config.Sanctions.ReviewScore = 0
+	Config.Sanctions.BlockScore = 0
+
+	if action(0.5) != ACTION_ALLOW || action(DEFAULT_REVIEW_SCORE) != ACTION_REVIEW || action(DEFAULT_BLOCK_SCORE) != ACTION_BLOCK {
+		t.Errorf("Action does not pass. Default thresholds not applied")
+	}
+}
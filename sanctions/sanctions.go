@@ -0,0 +1,231 @@
+package sanctions
+
+/*
+Sanctions package screens names against a local sanctions and PEP list.
+
+The list is loaded from Config.Sanctions.ListPath, in an OFAC like CSV or XML
+format (see list.go). It is loaded again whenever the file changes, or when staff
+ask for a reload, so a new list can be dropped in without a restart.
+
+Names are matched fuzzily. A match at or above the review score queues the action
+for review, a match at or above the block score refuses it.
+
+All sanctions transactions are staff only, the basic auth user and password are
+always the last two values.
+
+Sanctions transactions are as follows:
+1 - ReloadList
+2 - ScreenName
+3 - ListReviews
+4 - ResolveReview
+
+*/
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/bvnk/bank/appauth"
+)
+
+const (
+	ACTION_ALLOW  = "allow"
+	ACTION_REVIEW = "review"
+	ACTION_BLOCK  = "block"
+
+	DEFAULT_REVIEW_SCORE = 0.85
+	DEFAULT_BLOCK_SCORE  = 0.95
+
+	REVIEW_STATUS_PENDING   = "pending"
+	REVIEW_STATUS_CLEARED   = "cleared"
+	REVIEW_STATUS_CONFIRMED = "confirmed"
+)
+
+// Result is the outcome of screening a name, with the closest entry on the list
+type Result struct {
+	Action      string
+	Score       float64
+	Name        string
+	MatchedName string
+	EntryID     string
+	Programs    []string
+}
+
+type Review struct {
+	ID            int64
+	AccountNumber string
+	Name          string
+	MatchedName   string
+	EntryID       string
+	Score         float64
+	Status        string
+	Reviewer      string
+	Timestamp     int32
+}
+
+func ProcessSanctions(data []string) (result interface{}, err error) {
+	if len(data) < 5 {
+		return "", errors.New("sanctions.ProcessSanctions: Not all data is present")
+	}
+
+	// ~sanctions~type~...~basicAuthUser~basicAuthPassword
+	reviewer, err := appauth.CheckPermission(data, appauth.PERMISSION_SANCTIONS)
+	if err != nil {
+		return "", errors.New("sanctions.ProcessSanctions: " + err.Error())
+	}
+
+	switch data[2] {
+	// Reload the list from disk
+	case "1":
+		// ~sanctions~1~basicAuthUser~basicAuthPassword
+		err = Load()
+		if err != nil {
+			return "", errors.New("sanctions.ProcessSanctions: " + err.Error())
+		}
+		result = "List loaded with " + strconv.Itoa(listSize()) + " entries"
+	// Screen a single name
+	case "2":
+		// ~sanctions~2~name~basicAuthUser~basicAuthPassword
+		if len(data) < 6 {
+			return "", errors.New("sanctions.ProcessSanctions: Not all data is present")
+		}
+		result, err = Screen(data[3])
+		if err != nil {
+			return "", errors.New("sanctions.ProcessSanctions: " + err.Error())
+		}
+	// List reviews waiting on a decision
+	case "3":
+		// ~sanctions~3~basicAuthUser~basicAuthPassword
+		result, err = getPendingReviews()
+		if err != nil {
+			return "", errors.New("sanctions.ProcessSanctions: " + err.Error())
+		}
+	// Resolve a review
+	case "4":
+		// ~sanctions~4~reviewID~decision~basicAuthUser~basicAuthPassword
+		if len(data) < 7 {
+			return "", errors.New("sanctions.ProcessSanctions: Not all data is present")
+		}
+		result, err = resolveReview(data[3], data[4], reviewer)
+		if err != nil {
+			return "", errors.New("sanctions.ProcessSanctions: " + err.Error())
+		}
+	default:
+		return "", errors.New("sanctions.ProcessSanctions: No valid option chosen")
+	}
+
+	return
+}
+
+// Screen matches a name against the list.
+// Every name is allowed if no list is configured.
+func Screen(name string) (result Result, err error) {
+	result = Result{Action: ACTION_ALLOW, Name: name}
+	if Config.Sanctions.ListPath == "" {
+		return
+	}
+
+	entries, err := currentEntries()
+	if err != nil {
+		return Result{}, errors.New("sanctions.Screen: " + err.Error())
+	}
+
+	entry, score := bestMatch(name, entries)
+	if entry == nil {
+		return
+	}
+
+	result.Score = score
+	result.MatchedName = entry.Name
+	result.EntryID = entry.ID
+	result.Programs = entry.Programs
+	result.Action = action(score)
+	return
+}
+
+// ScreenAll screens each name and returns the worst result
+func ScreenAll(names ...string) (result Result, err error) {
+	result = Result{Action: ACTION_ALLOW}
+	for _, name := range names {
+		r, err := Screen(name)
+		if err != nil {
+			return Result{}, errors.New("sanctions.ScreenAll: " + err.Error())
+		}
+		if r.Score > result.Score {
+			result = r
+		}
+	}
+	return
+}
+
+// RecordReview queues an account for review after a screening match
+func RecordReview(accountNumber string, result Result) (err error) {
+	err = saveReview(accountNumber, result)
+	if err != nil {
+		return errors.New("sanctions.RecordReview: " + err.Error())
+	}
+	return
+}
+
+// resolveAccount applies a review's decision to the account it held. The
+// accounts package sets it, as it screens accounts here and so cannot be
+// imported by this package.
+var resolveAccount func(accountNumber string, decision string) error
+
+// SetAccountResolver sets what resolving a review does to its account
+func SetAccountResolver(resolver func(accountNumber string, decision string) error) {
+	resolveAccount = resolver
+}
+
+func resolveReview(reviewIDStr string, decision string, reviewer string) (result string, err error) {
+	reviewID, err := strconv.ParseInt(reviewIDStr, 10, 64)
+	if err != nil {
+		return "", errors.New("sanctions.resolveReview: Review ID not valid")
+	}
+
+	review, err := getReview(reviewID)
+	if err != nil {
+		return "", errors.New("sanctions.resolveReview: " + err.Error())
+	}
+	if review.Status != REVIEW_STATUS_PENDING {
+		return "", errors.New("sanctions.resolveReview: Review already resolved")
+	}
+
+	if decision != REVIEW_STATUS_CLEARED && decision != REVIEW_STATUS_CONFIRMED {
+		return "", errors.New("sanctions.resolveReview: Decision not valid, must be one of cleared, confirmed")
+	}
+	if resolveAccount == nil {
+		return "", errors.New("sanctions.resolveReview: Accounts not set up to be resolved")
+	}
+
+	err = updateReview(reviewID, decision, reviewer)
+	if err != nil {
+		return "", errors.New("sanctions.resolveReview: " + err.Error())
+	}
+	err = resolveAccount(review.AccountNumber, decision)
+	if err != nil {
+		return "", errors.New("sanctions.resolveReview: " + err.Error())
+	}
+
+	result = "Review " + decision
+	return
+}
+
+func action(score float64) string {
+	reviewScore := Config.Sanctions.ReviewScore
+	if reviewScore == 0 {
+		reviewScore = DEFAULT_REVIEW_SCORE
+	}
+	blockScore := Config.Sanctions.BlockScore
+	if blockScore == 0 {
+		blockScore = DEFAULT_BLOCK_SCORE
+	}
+
+	switch {
+	case score >= blockScore:
+		return ACTION_BLOCK
+	case score >= reviewScore:
+		return ACTION_REVIEW
+	}
+	return ACTION_ALLOW
+}
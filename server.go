@@ -4,16 +4,25 @@ import (
 	"bytes"
 	"crypto/rand"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"net"
 	"strings"
 
 	"github.com/bvnk/bank/accounts"
+	"github.com/bvnk/bank/aml"
 	"github.com/bvnk/bank/appauth"
+	"github.com/bvnk/bank/audit"
 	"github.com/bvnk/bank/configuration"
+	"github.com/bvnk/bank/eod"
+	"github.com/bvnk/bank/fraud"
+	"github.com/bvnk/bank/interbank"
+	"github.com/bvnk/bank/limits"
 	"github.com/bvnk/bank/push"
+	"github.com/bvnk/bank/sanctions"
 	"github.com/bvnk/bank/transactions"
 )
 
@@ -30,6 +39,16 @@ func runServer(mode string) (message string, err error) {
 	transactions.SetConfig(&Config)
 	appauth.SetConfig(&Config)
 	push.SetConfig(&Config)
+	limits.SetConfig(&Config)
+	fraud.SetConfig(&Config)
+	aml.SetConfig(&Config)
+	sanctions.SetConfig(&Config)
+	interbank.SetConfig(&Config)
+	eod.SetConfig(&Config)
+	audit.SetConfig(&Config)
+
+	// Deliver payments to other banks
+	go transactions.RunInterbank()
 
 	switch mode {
 	case "tls":
@@ -38,8 +57,13 @@ func runServer(mode string) (message string, err error) {
 			return "", err
 		}
 
-		// Load config and generate seed
-		config := tls.Config{Certificates: []tls.Certificate{cert}, ClientAuth: tls.RequireAnyClientCert}
+		clientCAs, err := loadClientCAs(Config.ClientCAPath)
+		if err != nil {
+			return "", err
+		}
+
+		// Load config and generate seed. Clients need a certificate the CA signed.
+		config := tls.Config{Certificates: []tls.Certificate{cert}, ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
 		config.Rand = rand.Reader
 
 		// Listen for incoming connections.
@@ -95,8 +119,22 @@ func handleTCPRequest(conn net.Conn) (err error) {
 	}
 	s := string(buf[:])
 
+	// An enrolled client certificate says who is connecting
+	certificateToken := ""
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		certificateToken, err = clientCertificateToken(tlsConn)
+		if err != nil {
+			conn.Write([]byte("0~" + err.Error() + "\n"))
+			conn.Close()
+			return err
+		}
+		if certificateToken != "" {
+			defer appauth.RemoveToken(certificateToken)
+		}
+	}
+
 	// Process
-	result, err := processCommand(s)
+	result, err := processCommand(s, remoteHost(conn.RemoteAddr().String()), certificateToken)
 
 	// Convert response to text
 	// @FIXME Use JSON for now. Convert to correct response (val1~val2~val3~...) later
@@ -118,7 +156,66 @@ func handleTCPRequest(conn net.Conn) (err error) {
 	return
 }
 
-func processCommand(text string) (result interface{}, err error) {
+// remoteHost is the address a request came from without its port, which
+// changes with every connection
+func remoteHost(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// loadClientCAs reads the CA certificates client certificates must be signed by
+func loadClientCAs(path string) (pool *x509.CertPool, err error) {
+	if path == "" {
+		return nil, errors.New("server.loadClientCAs: ClientCAPath is required to check client certificates")
+	}
+	pem, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.New("server.loadClientCAs: " + err.Error())
+	}
+	pool = x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("server.loadClientCAs: No certificates found in " + path)
+	}
+	return
+}
+
+// clientCertificateToken gives a token for whoever the connection's verified
+// certificate is enrolled as, or none if it is not enrolled. A revoked
+// certificate is refused.
+func clientCertificateToken(conn *tls.Conn) (token string, err error) {
+	state := conn.ConnectionState()
+	if len(state.PeerCertificates) == 0 {
+		return "", nil
+	}
+	token, err = appauth.CertificateToken(state.PeerCertificates[0])
+	if err != nil {
+		return "", errors.New("server.clientCertificateToken: " + err.Error())
+	}
+	return
+}
+
+// tokenNotRequired is true for the commands that are sent without a token, with
+// a 0 in its place. These are the ones that get a token or set up a user to get
+// one with: creating an account and its password, logging in with or without a
+// TOTP code, refreshing a session, resetting a password and the client
+// credentials grant. Every other command needs a valid token.
+func tokenNotRequired(command []string) bool {
+	if len(command) < 3 || command[0] != "0" {
+		return false
+	}
+	switch command[1] + "~" + command[2] {
+	case "acmt~1", "appauth~2", "appauth~3", "appauth~5", "appauth~12", "appauth~16", "appauth~17", "appauth~21":
+		return true
+	}
+	return false
+}
+
+// processCommand runs a command. A certificateToken, from the connection's
+// client certificate, is used in place of the token the command was sent with.
+func processCommand(text string, remoteAddr string, certificateToken string) (result interface{}, err error) {
 	// Commands are received split by tilde (~)
 	// command~DATA
 	cleanText := strings.Replace(text, "\n", "", -1)
@@ -134,12 +231,12 @@ func processCommand(text string) (result interface{}, err error) {
 	// Remove null termination from data
 	command[len(command)-1] = string(bytes.Trim([]byte(command[len(command)-1]), "\x00"))
 
-	// Check application auth. This is always the first value, if no token a 0 is sent
-	isCreateAccount := (command[0] == "0" && command[1] == "acmt" && command[2] == "1")
-	isLogIn := (command[0] == "0" && command[1] == "appauth" && command[2] == "2")
-	isCreateUserPassword := (command[0] == "0" && command[1] == "appauth" && command[2] == "3")
+	if certificateToken != "" {
+		command[0] = certificateToken
+	}
 
-	if !isCreateAccount || !isLogIn || !isCreateUserPassword {
+	// Check application auth. This is always the first value, if no token a 0 is sent
+	if !tokenNotRequired(command) {
 		err := appauth.CheckToken(command[0])
 		if err != nil {
 			return "", errors.New("server.processCommand: " + err.Error())
@@ -152,7 +249,7 @@ func processCommand(text string) (result interface{}, err error) {
 		if command[2] == "help" {
 			return "Format of appauth: appauth~userName~password", nil
 		}
-		result, err = appauth.ProcessAppAuth(command)
+		result, err = appauth.ProcessAppAuthFrom(command, remoteAddr)
 		if err != nil {
 			return "", errors.New("server.processCommand: " + err.Error())
 		}
@@ -166,16 +263,53 @@ func processCommand(text string) (result interface{}, err error) {
 		if err != nil {
 			return "", errors.New("server.processCommand: " + err.Error())
 		}
+	case "limits":
+		result, err = limits.ProcessLimits(command)
+		if err != nil {
+			return "", errors.New("server.processCommand: " + err.Error())
+		}
+	case "aml":
+		result, err = aml.ProcessAML(command)
+		if err != nil {
+			return "", errors.New("server.processCommand: " + err.Error())
+		}
+	case "sanctions":
+		result, err = sanctions.ProcessSanctions(command)
+		if err != nil {
+			return "", errors.New("server.processCommand: " + err.Error())
+		}
 	case "camt":
+		result, err = transactions.ProcessCAMT(command)
+		if err != nil {
+			return "", errors.New("server.processCommand: " + err.Error())
+		}
 	case "acmt":
 		// Check "help"
 		if command[2] == "help" {
 			return "", nil // @TODO Help section
 		}
 		result, err = accounts.ProcessAccount(command)
+	case "pacs":
+		result, err = interbank.ProcessPACS(command)
+		if err != nil {
+			return "", errors.New("server.processCommand: " + err.Error())
+		}
+	case "eod":
+		result, err = eod.ProcessEOD(command)
+		if err != nil {
+			return "", errors.New("server.processCommand: " + err.Error())
+		}
+	case "audit":
+		_, err = appauth.CheckPermission(command, appauth.PERMISSION_AUDIT)
+		if err != nil {
+			return "", errors.New("server.processCommand: " + err.Error())
+		}
+		result, err = audit.ProcessAudit(command)
+		if err != nil {
+			return "", errors.New("server.processCommand: " + err.Error())
+		}
 	case "remt":
 	case "reda":
-	case "pacs":
 	case "auth":
 		break
 	default:
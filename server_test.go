@@ -2,6 +2,7 @@ package main
 
 import (
 	"net"
+	"strings"
 	"testing"
 )
 
@@ -43,3 +44,37 @@ func TestHandleTCPRequest(t *testing.T) {
 	}
 
 }
+
+func TestTokenNotRequired(t *testing.T) {
+	tests := []struct {
+		command  string
+		expected bool
+	}{
+		{"0~acmt~1", true},
+		{"0~appauth~2", true},
+		{"0~appauth~3", true},
+		{"0~appauth~5", true},
+		{"0~appauth~12", true},
+		{"0~appauth~16", true},
+		{"0~appauth~17", true},
+		{"0~appauth~21", true},
+		// Only sent without a token
+		{"token~appauth~2", false},
+		{"0~acmt~1000", false},
+		{"0~appauth~1", false},
+		{"0~appauth~4", false},
+		{"0~appauth~14", false},
+		{"0~appauth~18", false},
+		{"0~appauth~26", false},
+		{"0~pain~1", false},
+		{"0~limits~1", false},
+		{"0~appauth", false},
+	}
+
+	for _, test := range tests {
+		result := tokenNotRequired(strings.Split(test.command, "~"))
+		if result != test.expected {
+			t.Errorf("TokenNotRequired %v does not pass. Looking for %v, got %v", test.command, test.expected, result)
+		}
+	}
+}
@@ -0,0 +1,14 @@
+/*
+Overrides of the configured spending limits, for a single account or a single account holder.
+A missing row means the configured default applies.
+*/
+CREATE TABLE IF NOT EXISTS accounts_limits (
+`id` int NOT NULL AUTO_INCREMENT,
+`scope` enum('account', 'holder') NOT NULL,
+`target` varchar(200) NOT NULL,
+`period` enum('transaction', 'daily', 'weekly', 'monthly') NOT NULL,
+`amount` float NOT NULL,
+`timestamp` int NOT NULL,
+PRIMARY KEY (`id`),
+UNIQUE KEY `accounts_limits_scope_target_period` (`scope`, `target`, `period`)
+);
@@ -0,0 +1,15 @@
+/*
+Transactions held in pending, with who held them and why
+*/
+CREATE TABLE IF NOT EXISTS transactions_holds (
+`id` int NOT NULL AUTO_INCREMENT,
+`transactionID` int NOT NULL,
+`source` varchar(20) NOT NULL,
+`score` int NOT NULL DEFAULT 0,
+`reasons` text NOT NULL,
+`timestamp` int NOT NULL,
+PRIMARY KEY (`id`)
+);
+
+CREATE INDEX transactions_holds_transaction_id
+ON transactions_holds (transactionID);
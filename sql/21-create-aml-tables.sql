@@ -0,0 +1,52 @@
+/*
+AML cases group the alerts raised on an account while it is under review
+*/
+CREATE TABLE IF NOT EXISTS aml_cases (
+`id` int NOT NULL AUTO_INCREMENT,
+`accountNumber` char(36) NOT NULL,
+`status` enum('open', 'escalated', 'closed') NOT NULL DEFAULT 'open',
+`assignee` varchar(200) NOT NULL DEFAULT '',
+`resolution` text NOT NULL,
+`created` int NOT NULL,
+`timestamp` int NOT NULL,
+PRIMARY KEY (`id`)
+);
+
+CREATE INDEX aml_cases_account_number
+ON aml_cases (accountNumber);
+
+/*
+AML alerts are raised by the monitoring rules on a single transaction
+*/
+CREATE TABLE IF NOT EXISTS aml_alerts (
+`id` int NOT NULL AUTO_INCREMENT,
+`caseID` int NOT NULL,
+`rule` varchar(50) NOT NULL,
+`accountNumber` char(36) NOT NULL,
+`transactionID` int NOT NULL,
+`amount` float NOT NULL,
+`details` text NOT NULL,
+`timestamp` int NOT NULL,
+PRIMARY KEY (`id`)
+);
+
+CREATE INDEX aml_alerts_case_id
+ON aml_alerts (caseID);
+
+CREATE INDEX aml_alerts_rule_account_number
+ON aml_alerts (rule, accountNumber);
+
+/*
+Comments and actions taken by analysts on a case
+*/
+CREATE TABLE IF NOT EXISTS aml_cases_comments (
+`id` int NOT NULL AUTO_INCREMENT,
+`caseID` int NOT NULL,
+`author` varchar(200) NOT NULL,
+`comment` text NOT NULL,
+`timestamp` int NOT NULL,
+PRIMARY KEY (`id`)
+);
+
+CREATE INDEX aml_cases_comments_case_id
+ON aml_cases_comments (caseID);
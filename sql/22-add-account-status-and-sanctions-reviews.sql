@@ -0,0 +1,22 @@
+ALTER TABLE accounts
+ADD `status` enum('active', 'pending', 'frozen', 'closed') NOT NULL DEFAULT 'active'
+AFTER `type`;
+
+/*
+Accounts queued for review after matching the sanctions list
+*/
+CREATE TABLE IF NOT EXISTS sanctions_reviews (
+`id` int NOT NULL AUTO_INCREMENT,
+`accountNumber` char(36) NOT NULL,
+`name` text NOT NULL,
+`matchedName` text NOT NULL,
+`entryID` varchar(50) NOT NULL,
+`score` float NOT NULL,
+`status` enum('pending', 'cleared', 'confirmed') NOT NULL DEFAULT 'pending',
+`reviewer` varchar(200) NOT NULL DEFAULT '',
+`timestamp` int NOT NULL,
+PRIMARY KEY (`id`)
+);
+
+CREATE INDEX sanctions_reviews_status
+ON sanctions_reviews (status);
@@ -0,0 +1,6 @@
+ALTER TABLE transactions_holds
+ADD `status` enum('pending', 'approved', 'rejected') NOT NULL DEFAULT 'pending',
+ADD `comment` text NULL,
+ADD `reviewer` varchar(200) NOT NULL DEFAULT '',
+ADD `reviewedTimestamp` int NOT NULL DEFAULT 0
+AFTER `reasons`;
@@ -0,0 +1,39 @@
+/*
+Batches of payments submitted together from one account
+*/
+CREATE TABLE IF NOT EXISTS transactions_batches (
+`id` int NOT NULL AUTO_INCREMENT,
+`senderAccountNumber` char(36) NOT NULL,
+`mode` enum('atomic', 'best-effort') NOT NULL,
+`status` enum('pending', 'running', 'completed', 'failed') NOT NULL DEFAULT 'pending',
+`lineCount` int NOT NULL,
+`totalAmount` float NOT NULL,
+`error` text NOT NULL,
+`timestamp` int NOT NULL,
+`completedTimestamp` int NOT NULL DEFAULT 0,
+PRIMARY KEY (`id`)
+);
+
+CREATE INDEX transactions_batches_sender_account_number
+ON transactions_batches (senderAccountNumber);
+
+/*
+One payment in a batch and what happened to it
+*/
+CREATE TABLE IF NOT EXISTS transactions_batches_lines (
+`id` int NOT NULL AUTO_INCREMENT,
+`batchID` int NOT NULL,
+`line` int NOT NULL,
+`receiverAccountNumber` char(36) NOT NULL,
+`receiverBankNumber` varchar(36) NOT NULL DEFAULT '',
+`amount` float NOT NULL,
+`desc` text NOT NULL,
+`reference` varchar(100) NOT NULL DEFAULT '',
+`status` enum('pending', 'approved', 'held', 'failed', 'rolled-back') NOT NULL DEFAULT 'pending',
+`transactionID` int NOT NULL DEFAULT 0,
+`error` text NOT NULL,
+PRIMARY KEY (`id`)
+);
+
+CREATE INDEX transactions_batches_lines_batch_id
+ON transactions_batches_lines (batchID);
@@ -0,0 +1,39 @@
+/*
+pacs.008 messages to other banks and their delivery
+*/
+CREATE TABLE IF NOT EXISTS interbank_payments (
+`id` int NOT NULL AUTO_INCREMENT,
+`transactionID` int NOT NULL,
+`bankNumber` varchar(36) NOT NULL,
+`senderAccountNumber` char(36) NOT NULL,
+`messageID` varchar(35) NOT NULL,
+`message` text NOT NULL,
+`status` enum('queued', 'accepted', 'rejected', 'failed') NOT NULL DEFAULT 'queued',
+`attempts` int NOT NULL DEFAULT 0,
+`error` text NOT NULL,
+`timestamp` int NOT NULL,
+`nextAttempt` int NOT NULL DEFAULT 0,
+`completedTimestamp` int NOT NULL DEFAULT 0,
+PRIMARY KEY (`id`),
+UNIQUE KEY `interbank_payments_transaction_id` (`transactionID`)
+);
+
+CREATE INDEX interbank_payments_status_next_attempt
+ON interbank_payments (status, nextAttempt);
+
+/*
+Transfers received from other banks. A transfer is only credited once per bank
+*/
+CREATE TABLE IF NOT EXISTS interbank_received (
+`id` int NOT NULL AUTO_INCREMENT,
+`bankNumber` varchar(36) NOT NULL,
+`messageID` varchar(35) NOT NULL,
+`txID` varchar(35) NOT NULL,
+`transactionID` int NOT NULL DEFAULT 0,
+`status` char(4) NOT NULL,
+`reason` char(4) NOT NULL DEFAULT '',
+`info` varchar(105) NOT NULL DEFAULT '',
+`timestamp` int NOT NULL,
+PRIMARY KEY (`id`),
+UNIQUE KEY `interbank_received_bank_number_tx_id` (`bankNumber`, `txID`)
+);
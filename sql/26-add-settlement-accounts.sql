@@ -0,0 +1,46 @@
+/*
+The bank account holds one row per account the bank keeps: the holding account
+for fees, and a nostro and vostro account for every correspondent bank
+*/
+ALTER TABLE bank_account
+ADD `type` enum('holding', 'nostro', 'vostro') NOT NULL DEFAULT 'holding' AFTER `id`,
+ADD `bankNumber` varchar(36) NOT NULL DEFAULT '' AFTER `type`;
+
+CREATE UNIQUE INDEX bank_account_type_bank_number
+ON bank_account (`type`, `bankNumber`);
+
+/*
+Bank transactions record every movement on the settlement accounts
+*/
+ALTER TABLE bank_transactions
+ADD `account` enum('holding', 'nostro', 'vostro') NOT NULL DEFAULT 'holding' AFTER `type`,
+ADD `transactionID` int NOT NULL DEFAULT 0,
+ADD `settlementID` int NOT NULL DEFAULT 0;
+
+CREATE INDEX bank_transactions_settlement_id
+ON bank_transactions (settlementID);
+CREATE INDEX bank_transactions_transaction_id
+ON bank_transactions (transactionID);
+
+/*
+Net positions with each correspondent bank from a netting run
+*/
+CREATE TABLE IF NOT EXISTS interbank_settlements (
+`id` int NOT NULL AUTO_INCREMENT,
+`bankNumber` varchar(36) NOT NULL,
+`businessDate` date NOT NULL,
+`paymentsOut` int NOT NULL,
+`amountOut` float NOT NULL,
+`returns` int NOT NULL,
+`amountReturned` float NOT NULL,
+`paymentsIn` int NOT NULL,
+`amountIn` float NOT NULL,
+`netAmount` float NOT NULL,
+`status` enum('pending', 'settled') NOT NULL DEFAULT 'pending',
+`timestamp` int NOT NULL,
+`settledTimestamp` int NOT NULL DEFAULT 0,
+PRIMARY KEY (`id`)
+);
+
+CREATE INDEX interbank_settlements_business_date
+ON interbank_settlements (businessDate);
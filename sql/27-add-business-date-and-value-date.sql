@@ -0,0 +1,58 @@
+/*
+The business date the bank is booking transactions for, and the end of day lock
+*/
+CREATE TABLE IF NOT EXISTS business_date (
+`id` int NOT NULL,
+`businessDate` date NOT NULL,
+`status` enum('open', 'eod', 'bod') NOT NULL DEFAULT 'open',
+`lockedBy` varchar(36) NOT NULL DEFAULT '',
+`lockedUntil` int NOT NULL DEFAULT 0,
+`timestamp` int NOT NULL,
+PRIMARY KEY (`id`)
+);
+
+/* This table must be seeded */
+INSERT INTO `business_date` (`id`, `businessDate`, `status`, `timestamp`) VALUES (1, CURDATE(), 'open', UNIX_TIMESTAMP());
+
+/*
+How far each end and beginning of day job got for a business date
+*/
+CREATE TABLE IF NOT EXISTS eod_checkpoints (
+`id` int NOT NULL AUTO_INCREMENT,
+`businessDate` date NOT NULL,
+`phase` enum('eod', 'bod') NOT NULL,
+`job` varchar(50) NOT NULL,
+`status` enum('running', 'done', 'failed') NOT NULL,
+`attempts` int NOT NULL DEFAULT 0,
+`error` text NOT NULL,
+`startedTimestamp` int NOT NULL,
+`completedTimestamp` int NOT NULL DEFAULT 0,
+PRIMARY KEY (`id`),
+UNIQUE KEY `eod_checkpoints_job` (`businessDate`, `phase`, `job`)
+);
+
+/*
+Account balances at the end of each business date
+*/
+CREATE TABLE IF NOT EXISTS accounts_closing_balances (
+`businessDate` date NOT NULL,
+`accountNumber` char(36) NOT NULL,
+`accountBalance` float NOT NULL,
+`availableBalance` float NOT NULL,
+`timestamp` int NOT NULL,
+PRIMARY KEY (`businessDate`, `accountNumber`)
+);
+
+/*
+The date a transaction takes effect on, separate from when it was booked
+*/
+ALTER TABLE transactions
+ADD `valueDate` date NULL AFTER `timestamp`;
+
+UPDATE transactions SET `valueDate` = DATE(FROM_UNIXTIME(`timestamp`));
+
+ALTER TABLE transactions
+MODIFY `valueDate` date NOT NULL;
+
+CREATE INDEX transactions_value_date
+ON transactions (valueDate);
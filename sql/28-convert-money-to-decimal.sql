@@ -0,0 +1,44 @@
+/*
+Store amounts as exact decimals instead of floats.
+On a database that already has transactions run `bank -mode convertmoney` first
+to see the amounts the floats did not hold exactly, then stop the bank and run
+`bank -mode convertmoney -repair`. It converts the same columns and corrects
+those amounts, after which this file changes nothing.
+*/
+ALTER TABLE `transactions`
+MODIFY `transactionAmount` DECIMAL(19,6) NOT NULL,
+MODIFY `feeAmount` DECIMAL(19,6) NOT NULL;
+
+ALTER TABLE `accounts`
+MODIFY `accountBalance` DECIMAL(19,6) NOT NULL,
+MODIFY `overdraft` DECIMAL(19,6) NOT NULL,
+MODIFY `availableBalance` DECIMAL(19,6) NOT NULL;
+
+ALTER TABLE `bank_account`
+MODIFY `balance` DECIMAL(19,6) NOT NULL;
+
+ALTER TABLE `bank_transactions`
+MODIFY `transactionAmount` DECIMAL(19,6) NOT NULL,
+MODIFY `feeAmount` DECIMAL(19,6) NOT NULL;
+
+ALTER TABLE `accounts_limits`
+MODIFY `amount` DECIMAL(19,6) NOT NULL;
+
+ALTER TABLE `aml_alerts`
+MODIFY `amount` DECIMAL(19,6) NOT NULL;
+
+ALTER TABLE `transactions_batches`
+MODIFY `totalAmount` DECIMAL(19,6) NOT NULL;
+
+ALTER TABLE `transactions_batches_lines`
+MODIFY `amount` DECIMAL(19,6) NOT NULL;
+
+ALTER TABLE `interbank_settlements`
+MODIFY `amountOut` DECIMAL(19,6) NOT NULL,
+MODIFY `amountReturned` DECIMAL(19,6) NOT NULL,
+MODIFY `amountIn` DECIMAL(19,6) NOT NULL,
+MODIFY `netAmount` DECIMAL(19,6) NOT NULL;
+
+ALTER TABLE `accounts_closing_balances`
+MODIFY `accountBalance` DECIMAL(19,6) NOT NULL,
+MODIFY `availableBalance` DECIMAL(19,6) NOT NULL;
@@ -0,0 +1,28 @@
+/*
+One-time code secrets for two-factor authentication, one per login
+*/
+CREATE TABLE IF NOT EXISTS accounts_user_totp (
+`id` int NOT NULL AUTO_INCREMENT,
+`authUser` char(36) NOT NULL,
+`secret` varchar(64) NOT NULL,
+`status` enum('pending', 'active') NOT NULL DEFAULT 'pending',
+`lastStep` bigint NOT NULL DEFAULT 0,
+`timestamp` int NOT NULL,
+PRIMARY KEY (`id`),
+UNIQUE KEY `accounts_user_totp_auth_user` (`authUser`)
+);
+
+/*
+Single use codes to log in with when the authenticator is lost. Only a hash is kept
+*/
+CREATE TABLE IF NOT EXISTS accounts_user_recovery_codes (
+`id` int NOT NULL AUTO_INCREMENT,
+`authUser` char(36) NOT NULL,
+`codeHash` char(64) NOT NULL,
+`used` tinyint NOT NULL DEFAULT 0,
+`timestamp` int NOT NULL,
+PRIMARY KEY (`id`)
+);
+
+CREATE INDEX accounts_user_recovery_codes_auth_user
+ON accounts_user_recovery_codes (authUser);
@@ -0,0 +1,19 @@
+/*
+Security events and privileged actions, who did them and from where
+*/
+CREATE TABLE IF NOT EXISTS audit_events (
+`id` int NOT NULL AUTO_INCREMENT,
+`type` varchar(50) NOT NULL,
+`actor` varchar(200) NOT NULL DEFAULT '',
+`subject` varchar(200) NOT NULL DEFAULT '',
+`remoteAddr` varchar(45) NOT NULL DEFAULT '',
+`detail` text NOT NULL,
+`timestamp` int NOT NULL,
+PRIMARY KEY (`id`)
+);
+
+CREATE INDEX audit_events_type_timestamp
+ON audit_events (`type`, `timestamp`);
+
+CREATE INDEX audit_events_actor
+ON audit_events (actor);
@@ -0,0 +1,7 @@
+/*
+Passwords are now PHC strings, $argon2id$v=19$m=...,t=...,p=...$salt$hash,
+with their own salt. The salt column is only kept for hashes made before,
+which are replaced as those users log in.
+*/
+ALTER TABLE accounts_user_auth
+MODIFY `salt` char(128) NOT NULL DEFAULT '';
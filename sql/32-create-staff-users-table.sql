@@ -0,0 +1,12 @@
+/*
+Staff users and their roles. HttpAuthUser from the config is also an admin.
+*/
+CREATE TABLE IF NOT EXISTS staff_users (
+`username` varchar(100) NOT NULL,
+`password` varchar(255) NOT NULL,
+`role` varchar(20) NOT NULL,
+`status` varchar(20) NOT NULL DEFAULT 'active',
+`createdBy` varchar(100) NOT NULL DEFAULT '',
+`timestamp` int NOT NULL,
+PRIMARY KEY (`username`)
+);
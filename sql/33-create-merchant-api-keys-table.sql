@@ -0,0 +1,21 @@
+/*
+API keys merchants call the API with. The client ID is what a key is shown by,
+only a hash of the secret is kept. A rotated key's previous secret works until
+previousSecretExpires.
+*/
+CREATE TABLE IF NOT EXISTS merchant_api_keys (
+`clientID` varchar(32) NOT NULL,
+`merchantID` char(36) NOT NULL,
+`accountHolderIdentificationNumber` varchar(255) NOT NULL,
+`secretHash` char(64) NOT NULL,
+`previousSecretHash` char(64) NOT NULL DEFAULT '',
+`previousSecretExpires` int NOT NULL DEFAULT 0,
+`scopes` varchar(255) NOT NULL,
+`status` varchar(20) NOT NULL DEFAULT 'active',
+`lastUsed` int NOT NULL DEFAULT 0,
+`timestamp` int NOT NULL,
+PRIMARY KEY (`clientID`)
+);
+
+CREATE INDEX merchant_api_keys_merchant_id
+ON merchant_api_keys (merchantID);
@@ -0,0 +1,17 @@
+/*
+Client certificates enrolled for the TCP server, by the SHA-256 fingerprint of
+their public key. Each is a merchant, acting for one of its account holders
+with scopes, or a staff user. A revoked certificate cannot connect.
+*/
+CREATE TABLE IF NOT EXISTS client_certificates (
+`fingerprint` char(64) NOT NULL,
+`subject` varchar(255) NOT NULL DEFAULT '',
+`kind` varchar(20) NOT NULL,
+`identity` varchar(100) NOT NULL,
+`accountHolderIdentificationNumber` varchar(255) NOT NULL DEFAULT '',
+`scopes` varchar(255) NOT NULL DEFAULT '',
+`status` varchar(20) NOT NULL DEFAULT 'active',
+`createdBy` varchar(100) NOT NULL DEFAULT '',
+`timestamp` int NOT NULL,
+PRIMARY KEY (`fingerprint`)
+);
@@ -0,0 +1,497 @@
+package transactions
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/bvnk/bank/accounts"
+	"github.com/bvnk/bank/appauth"
+	"github.com/bvnk/bank/limits"
+	"github.com/bvnk/bank/money"
+	"github.com/bvnk/bank/push"
+	"github.com/paulmach/go.geo"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	BATCH_MODE_ATOMIC      = "atomic"
+	BATCH_MODE_BEST_EFFORT = "best-effort"
+
+	BATCH_FORMAT_CSV  = "csv"
+	BATCH_FORMAT_JSON = "json"
+
+	BATCH_STATUS_PENDING   = "pending"
+	BATCH_STATUS_RUNNING   = "running"
+	BATCH_STATUS_COMPLETED = "completed"
+	BATCH_STATUS_FAILED    = "failed"
+
+	BATCH_LINE_PENDING     = "pending"
+	BATCH_LINE_APPROVED    = "approved"
+	BATCH_LINE_HELD        = "held"
+	BATCH_LINE_FAILED      = "failed"
+	BATCH_LINE_ROLLED_BACK = "rolled-back"
+
+	BATCH_MAX_LINES = 1000
+
+	// Only merchant accounts can pay out in batches
+	BATCH_ACCOUNT_TYPE = "merchant"
+)
+
+// Batch is a number of payments from one account submitted together.
+// In atomic mode either every payment is made or none are, in best effort
+// mode each payment stands on its own.
+type Batch struct {
+	ID                  int64
+	SenderAccountNumber string
+	Mode                string
+	Status              string
+	LineCount           int
+	Processed           int
+	Succeeded           int
+	Failed              int
+	TotalAmount         decimal.Decimal
+	Error               string
+	Timestamp           int32
+	CompletedTimestamp  int32
+	Lines               []BatchLine
+}
+
+// BatchLine is one payment in a batch
+type BatchLine struct {
+	Line          int
+	Receiver      AccountHolder
+	Amount        decimal.Decimal
+	Desc          string
+	Reference     string
+	Status        string
+	TransactionID int64
+	Error         string
+}
+
+// batchTransfer is a payment as given in a JSON batch
+type batchTransfer struct {
+	Receiver    string
+	Amount      decimal.Decimal
+	Description string
+	Reference   string
+}
+
+// createBatch validates every payment in a batch and checks the sender can
+// cover all of them before anything is paid. The payments are made in the
+// background, the batch ID is returned straight away to follow progress.
+func createBatch(data []string) (result string, err error) {
+	tokenUser, merchantID, err := appauth.GetUserFromScopedToken(data[0], appauth.SCOPE_PAYMENTS_CREATE)
+	if err != nil {
+		return "", errors.New("payments.createBatch: " + err.Error())
+	}
+
+	senderAccountNumber := data[3]
+	mode := data[4]
+	format := data[5]
+	// The payload may itself contain the separator
+	payload := strings.Join(data[6:], "~")
+
+	if mode != BATCH_MODE_ATOMIC && mode != BATCH_MODE_BEST_EFFORT {
+		return "", errors.New("payments.createBatch: Mode not valid, must be one of atomic, best-effort")
+	}
+
+	err = accounts.CheckUserAccountValidFromToken(tokenUser, senderAccountNumber)
+	if err != nil {
+		return "", errors.New("payments.createBatch: Sender not valid")
+	}
+	err = accounts.CheckMerchantAccount(merchantID, senderAccountNumber)
+	if err != nil {
+		return "", errors.New("payments.createBatch: Sender not valid")
+	}
+	senderAccount, err := accounts.GetAccountByAccountNumber(senderAccountNumber)
+	if err != nil {
+		return "", errors.New("payments.createBatch: Sender not valid")
+	}
+	if senderAccount.Type != BATCH_ACCOUNT_TYPE {
+		return "", errors.New("payments.createBatch: Batches can only be paid from merchant accounts")
+	}
+	err = accounts.CheckAccountActive(senderAccountNumber)
+	if err != nil {
+		return "", errors.New("payments.createBatch: " + err.Error())
+	}
+
+	lines, err := parseBatch(format, payload)
+	if err != nil {
+		return "", errors.New("payments.createBatch: " + err.Error())
+	}
+
+	validateBatchLines(senderAccountNumber, lines)
+	if mode == BATCH_MODE_ATOMIC {
+		for _, line := range lines {
+			if line.Status == BATCH_LINE_FAILED {
+				return "", errors.New("payments.createBatch: Line " + strconv.Itoa(line.Line) + ": " + line.Error)
+			}
+		}
+	}
+
+	total := batchTotal(lines)
+	if total.Sign() == 0 {
+		return "", errors.New("payments.createBatch: No valid payments in batch")
+	}
+	balanceAvailable, err := checkBalance(Config.Db, AccountHolder{senderAccountNumber, ""})
+	if err != nil {
+		return "", errors.New("payments.createBatch: " + err.Error())
+	}
+
+	transactions := []PAINTrans{}
+	for _, line := range lines {
+		if line.Status != BATCH_LINE_FAILED {
+			transactions = append(transactions, batchTransaction(senderAccountNumber, line))
+		}
+	}
+	err = checkStepUp(data[0], transactions)
+	if err != nil {
+		return "", errors.New("payments.createBatch: " + err.Error())
+	}
+	if balanceAvailable.Cmp(total) == -1 {
+		return "", errors.New("payments.createBatch: Insufficient funds available for batch total of " + money.Format(total))
+	}
+
+	batch := Batch{
+		SenderAccountNumber: senderAccountNumber,
+		Mode:                mode,
+		Status:              BATCH_STATUS_PENDING,
+		LineCount:           len(lines),
+		TotalAmount:         total,
+		Lines:               lines,
+	}
+	batch.ID, err = saveBatch(batch)
+	if err != nil {
+		return "", errors.New("payments.createBatch: " + err.Error())
+	}
+
+	go executeBatch(tokenUser, batch)
+
+	result = strconv.FormatInt(batch.ID, 10)
+	return
+}
+
+func viewBatch(data []string) (result Batch, err error) {
+	tokenUser, merchantID, err := appauth.GetUserFromScopedToken(data[0], appauth.SCOPE_PAYMENTS_READ)
+	if err != nil {
+		return Batch{}, errors.New("payments.viewBatch: " + err.Error())
+	}
+
+	batchID, err := strconv.ParseInt(data[3], 10, 64)
+	if err != nil {
+		return Batch{}, errors.New("payments.viewBatch: Batch ID not valid")
+	}
+
+	result, err = getBatch(batchID)
+	if err != nil {
+		return Batch{}, errors.New("payments.viewBatch: " + err.Error())
+	}
+
+	// Only the holder of the paying account can see the batch
+	err = accounts.CheckUserAccountValidFromToken(tokenUser, result.SenderAccountNumber)
+	if err != nil {
+		return Batch{}, errors.New("payments.viewBatch: Batch not found")
+	}
+	err = accounts.CheckMerchantAccount(merchantID, result.SenderAccountNumber)
+	if err != nil {
+		return Batch{}, errors.New("payments.viewBatch: Batch not found")
+	}
+
+	result.Lines, err = getBatchLines(batchID)
+	if err != nil {
+		return Batch{}, errors.New("payments.viewBatch: " + err.Error())
+	}
+	summariseBatch(&result)
+
+	return
+}
+
+// parseBatch reads payments given as CSV, with the columns receiver, amount,
+// description and an optional reference, or as a JSON list of the same fields.
+// A receiver is an account number, optionally followed by @bankNumber.
+func parseBatch(format string, payload string) (lines []BatchLine, err error) {
+	transfers := []batchTransfer{}
+
+	switch format {
+	case BATCH_FORMAT_CSV:
+		r := csv.NewReader(strings.NewReader(payload))
+		r.FieldsPerRecord = -1
+		r.TrimLeadingSpace = true
+		for {
+			record, err := r.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, errors.New("payments.parseBatch: Could not read CSV. " + err.Error())
+			}
+			// Skip an optional header row
+			if len(transfers) == 0 && strings.EqualFold(strings.TrimSpace(record[0]), "receiver") {
+				continue
+			}
+			if len(record) < 3 {
+				return nil, errors.New("payments.parseBatch: Line " + strconv.Itoa(len(transfers)+1) + " must have receiver, amount and description")
+			}
+
+			amount, err := decimal.NewFromString(strings.TrimSpace(record[1]))
+			if err != nil {
+				return nil, errors.New("payments.parseBatch: Line " + strconv.Itoa(len(transfers)+1) + ": Could not convert amount to decimal")
+			}
+			transfer := batchTransfer{Receiver: record[0], Amount: amount, Description: record[2]}
+			if len(record) > 3 {
+				transfer.Reference = record[3]
+			}
+			transfers = append(transfers, transfer)
+		}
+	case BATCH_FORMAT_JSON:
+		decoder := json.NewDecoder(bytes.NewReader([]byte(payload)))
+		err = decoder.Decode(&transfers)
+		if err != nil {
+			return nil, errors.New("payments.parseBatch: Could not read JSON. " + err.Error())
+		}
+	default:
+		return nil, errors.New("payments.parseBatch: Format not valid, must be one of csv, json")
+	}
+
+	if len(transfers) == 0 {
+		return nil, errors.New("payments.parseBatch: No payments in batch")
+	}
+	if len(transfers) > BATCH_MAX_LINES {
+		return nil, errors.New("payments.parseBatch: No more than " + strconv.Itoa(BATCH_MAX_LINES) + " payments allowed in a batch")
+	}
+
+	for i, transfer := range transfers {
+		receiver := strings.SplitN(strings.TrimSpace(transfer.Receiver), "@", 2)
+		line := BatchLine{
+			Line:      i + 1,
+			Receiver:  AccountHolder{receiver[0], ""},
+			Amount:    transfer.Amount,
+			Desc:      strings.TrimSpace(transfer.Description),
+			Reference: strings.TrimSpace(transfer.Reference),
+			Status:    BATCH_LINE_PENDING,
+		}
+		if len(receiver) > 1 {
+			line.Receiver.BankNumber = localBankNumber(receiver[1])
+		}
+
+		if line.Receiver.AccountNumber == "" {
+			return nil, errors.New("payments.parseBatch: Line " + strconv.Itoa(line.Line) + ": Receiver cannot be empty")
+		}
+		if line.Amount.Sign() <= 0 {
+			return nil, errors.New("payments.parseBatch: Line " + strconv.Itoa(line.Line) + ": Amount must be more than zero")
+		}
+		lines = append(lines, line)
+	}
+
+	return
+}
+
+// validateBatchLines marks lines that cannot be paid as failed
+func validateBatchLines(senderAccountNumber string, lines []BatchLine) {
+	for i := range lines {
+		line := &lines[i]
+		switch {
+		case line.Receiver.AccountNumber == senderAccountNumber:
+			line.Error = "Cannot pay the sending account"
+		case line.Receiver.BankNumber != "":
+			line.Error = "Only payments to accounts in this bank can be batched"
+		default:
+			if _, err := accounts.GetAccountByAccountNumber(line.Receiver.AccountNumber); err != nil {
+				line.Error = "Recipient user not found"
+			} else if err := accounts.CheckAccountActive(line.Receiver.AccountNumber); err != nil {
+				line.Error = "Recipient account is not active"
+			}
+		}
+		if line.Error != "" {
+			line.Status = BATCH_LINE_FAILED
+		}
+	}
+}
+
+// batchTotal is what the lines still to be paid take from the sender, fees included
+func batchTotal(lines []BatchLine) (total decimal.Decimal) {
+	total = decimal.Zero
+	for _, line := range lines {
+		if line.Status == BATCH_LINE_FAILED {
+			continue
+		}
+		total = total.Add(heldAmount(batchTransaction("", line)))
+	}
+	return
+}
+
+func batchTransaction(senderAccountNumber string, line BatchLine) PAINTrans {
+	desc := line.Desc
+	if line.Reference != "" {
+		desc = strings.TrimSpace(desc + " " + line.Reference)
+	}
+
+	return PAINTrans{
+		PainType: 1,
+		Sender:   AccountHolder{senderAccountNumber, ""},
+		Receiver: line.Receiver,
+		Amount:   line.Amount,
+		Fee:      decimal.NewFromFloat(TRANSACTION_FEE),
+		Geo:      *geo.NewPoint(0, 0),
+		Desc:     desc,
+		Status:   "approved",
+	}
+}
+
+// executeBatch makes the payments and sends the sender a single notification when done
+func executeBatch(tokenUser string, batch Batch) {
+	_ = setBatchStatus(batch.ID, BATCH_STATUS_RUNNING, "")
+
+	status, batchError := BATCH_STATUS_COMPLETED, ""
+	if batch.Mode == BATCH_MODE_ATOMIC {
+		err := executeBatchAtomic(tokenUser, &batch)
+		if err != nil {
+			status, batchError = BATCH_STATUS_FAILED, err.Error()
+		}
+	} else {
+		executeBatchBestEffort(tokenUser, &batch)
+	}
+
+	_ = setBatchStatus(batch.ID, status, batchError)
+
+	summariseBatch(&batch)
+	go push.SendNotification(batch.SenderAccountNumber, batchSummaryMessage(batch, status), 1, "default")
+}
+
+// executeBatchAtomic makes every payment in one database transaction, the
+// first payment to fail rolls back all of them
+func executeBatchAtomic(tokenUser string, batch *Batch) (err error) {
+	tx, err := Config.Db.Begin()
+	if err != nil {
+		failBatchLines(batch, -1, err.Error())
+		return errors.New("payments.executeBatchAtomic: " + err.Error())
+	}
+
+	// Limits are not part of the database transaction, so they are given back
+	// if it is rolled back
+	saved := []PAINTrans{}
+	reservations := []limits.Reservation{}
+	for i := range batch.Lines {
+		line := &batch.Lines[i]
+		transaction, holds, reservation, err := checkCreditTransfer(tx, tokenUser, batchTransaction(batch.SenderAccountNumber, *line), 0, 0)
+		reservations = append(reservations, reservation)
+		if err == nil {
+			line.TransactionID, err = saveCreditTransfer(tx, transaction, holds)
+		}
+		if err != nil {
+			_ = tx.Rollback()
+			releaseBatchLimits(reservations)
+			failBatchLines(batch, i, err.Error())
+			return errors.New("payments.executeBatchAtomic: Line " + strconv.Itoa(line.Line) + ": " + err.Error())
+		}
+
+		line.Status = BATCH_LINE_APPROVED
+		if transaction.Status == "pending" {
+			line.Status = BATCH_LINE_HELD
+		}
+		saved = append(saved, transaction)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		releaseBatchLimits(reservations)
+		failBatchLines(batch, -1, err.Error())
+		return errors.New("payments.executeBatchAtomic: " + err.Error())
+	}
+
+	for i, line := range batch.Lines {
+		holdLimits(line.TransactionID, saved[i], reservations[i])
+		_ = updateBatchLine(batch.ID, line)
+		monitorCreditTransfer(line.TransactionID, saved[i])
+		if line.Status == BATCH_LINE_APPROVED {
+			go push.SendNotification(line.Receiver.AccountNumber, "💸 Payment received!", 1, "default")
+		}
+	}
+	return
+}
+
+// releaseBatchLimits gives back the limits reserved for a rolled back batch
+func releaseBatchLimits(reservations []limits.Reservation) {
+	for _, reservation := range reservations {
+		releaseLimits(reservation)
+	}
+}
+
+// failBatchLines records a rolled back atomic batch. The line that failed
+// keeps its error, every other line is rolled back.
+func failBatchLines(batch *Batch, failed int, reason string) {
+	for i := range batch.Lines {
+		line := &batch.Lines[i]
+		line.TransactionID = 0
+		line.Status = BATCH_LINE_ROLLED_BACK
+		line.Error = ""
+		if i == failed {
+			line.Status = BATCH_LINE_FAILED
+			line.Error = reason
+		}
+		_ = updateBatchLine(batch.ID, *line)
+	}
+}
+
+// executeBatchBestEffort makes each payment on its own, a failed payment does not stop the rest
+func executeBatchBestEffort(tokenUser string, batch *Batch) {
+	for i := range batch.Lines {
+		line := &batch.Lines[i]
+		if line.Status == BATCH_LINE_FAILED {
+			continue
+		}
+
+		transaction, holds, reservation, err := checkCreditTransfer(Config.Db, tokenUser, batchTransaction(batch.SenderAccountNumber, *line), 0, 0)
+		if err == nil {
+			line.TransactionID, err = saveCreditTransfer(Config.Db, transaction, holds)
+			if err != nil {
+				releaseLimits(reservation)
+			} else {
+				holdLimits(line.TransactionID, transaction, reservation)
+			}
+		}
+
+		switch {
+		case err != nil:
+			line.Status = BATCH_LINE_FAILED
+			line.Error = err.Error()
+		case transaction.Status == "pending":
+			line.Status = BATCH_LINE_HELD
+			monitorCreditTransfer(line.TransactionID, transaction)
+		default:
+			line.Status = BATCH_LINE_APPROVED
+			monitorCreditTransfer(line.TransactionID, transaction)
+			go push.SendNotification(line.Receiver.AccountNumber, "💸 Payment received!", 1, "default")
+		}
+
+		_ = updateBatchLine(batch.ID, *line)
+	}
+}
+
+// summariseBatch counts the lines by outcome
+func summariseBatch(batch *Batch) {
+	batch.Processed, batch.Succeeded, batch.Failed = 0, 0, 0
+	for _, line := range batch.Lines {
+		switch line.Status {
+		case BATCH_LINE_APPROVED, BATCH_LINE_HELD:
+			batch.Succeeded++
+		case BATCH_LINE_FAILED, BATCH_LINE_ROLLED_BACK:
+			batch.Failed++
+		default:
+			continue
+		}
+		batch.Processed++
+	}
+}
+
+func batchSummaryMessage(batch Batch, status string) string {
+	if status == BATCH_STATUS_FAILED {
+		return "🚫 Batch " + strconv.FormatInt(batch.ID, 10) + " failed, no payments were made"
+	}
+	return "📦 Batch " + strconv.FormatInt(batch.ID, 10) + " done: " + strconv.Itoa(batch.Succeeded) + " paid, " + strconv.Itoa(batch.Failed) + " failed"
+}
@@ -0,0 +1,105 @@
+package transactions
+
+import (
+	"testing"
+
+	"github.com/bvnk/bank/accounts"
+	"github.com/shopspring/decimal"
+)
+
+func TestParseBatchCSV(t *testing.T) {
+	payload := "receiver,amount,description,reference\n" +
+		"receiver1,10.00,Salary,JAN\n" +
+		"receiver2@" + accounts.BANK_NUMBER + ",20.5,\"Salary, bonus\"\n" +
+		"receiver3@otherbank,5,Expenses\n"
+
+	lines, err := parseBatch(BATCH_FORMAT_CSV, payload)
+	if err != nil {
+		t.Fatalf("ParseBatchCSV does not pass. Looking for %v, got %v", nil, err)
+	}
+	if len(lines) != 3 {
+		t.Fatalf("ParseBatchCSV lines does not pass. Looking for %v, got %v", 3, len(lines))
+	}
+
+	if lines[0].Line != 1 || lines[0].Receiver.AccountNumber != "receiver1" || !lines[0].Amount.Equals(decimal.NewFromFloat(10)) || lines[0].Reference != "JAN" {
+		t.Errorf("ParseBatchCSV first line does not pass. Looking for %v, got %v", "1 receiver1 10 JAN", lines[0])
+	}
+	if lines[1].Receiver.BankNumber != "" || lines[1].Desc != "Salary, bonus" {
+		t.Errorf("ParseBatchCSV local bank does not pass. Looking for %v, got %v", "Salary, bonus", lines[1])
+	}
+	if lines[2].Receiver.BankNumber != "otherbank" || lines[2].Status != BATCH_LINE_PENDING {
+		t.Errorf("ParseBatchCSV other bank does not pass. Looking for %v, got %v", "otherbank", lines[2])
+	}
+}
+
+func TestParseBatchJSON(t *testing.T) {
+	payload := `[{"Receiver":"receiver1","Amount":"10.00","Description":"Salary"},{"Receiver":"receiver2","Amount":2.5,"Description":"Expenses","Reference":"R-2"}]`
+
+	lines, err := parseBatch(BATCH_FORMAT_JSON, payload)
+	if err != nil {
+		t.Fatalf("ParseBatchJSON does not pass. Looking for %v, got %v", nil, err)
+	}
+	if len(lines) != 2 || !lines[1].Amount.Equals(decimal.NewFromFloat(2.5)) || lines[1].Reference != "R-2" || lines[1].Line != 2 {
+		t.Errorf("ParseBatchJSON does not pass. Looking for %v, got %v", "2 lines", lines)
+	}
+}
+
+func TestParseBatchInvalid(t *testing.T) {
+	tests := []struct {
+		format  string
+		payload string
+	}{
+		{"xml", "receiver1,10,Salary"},
+		{BATCH_FORMAT_CSV, ""},
+		{BATCH_FORMAT_CSV, "receiver1,10"},
+		{BATCH_FORMAT_CSV, "receiver1,ten,Salary"},
+		{BATCH_FORMAT_CSV, "receiver1,-10,Salary"},
+		{BATCH_FORMAT_CSV, ",10,Salary"},
+		{BATCH_FORMAT_JSON, `{"Receiver":"receiver1"}`},
+		{BATCH_FORMAT_JSON, `[]`},
+	}
+
+	for _, test := range tests {
+		_, err := parseBatch(test.format, test.payload)
+		if err == nil {
+			t.Errorf("ParseBatchInvalid does not pass for %v. Looking for %v, got %v", test.payload, "error", nil)
+		}
+	}
+}
+
+func TestBatchTotal(t *testing.T) {
+	lines := []BatchLine{
+		{Line: 1, Amount: decimal.NewFromFloat(100), Status: BATCH_LINE_PENDING},
+		{Line: 2, Amount: decimal.NewFromFloat(50), Status: BATCH_LINE_FAILED},
+		{Line: 3, Amount: decimal.NewFromFloat(200), Status: BATCH_LINE_PENDING},
+	}
+
+	// Fees are included, failed lines are not
+	expected := decimal.NewFromFloat(300.03)
+	total := batchTotal(lines)
+	if !total.Equals(expected) {
+		t.Errorf("BatchTotal does not pass. Looking for %v, got %v", expected, total)
+	}
+}
+
+func TestSummariseBatch(t *testing.T) {
+	batch := Batch{
+		ID: 7,
+		Lines: []BatchLine{
+			{Status: BATCH_LINE_APPROVED},
+			{Status: BATCH_LINE_HELD},
+			{Status: BATCH_LINE_FAILED},
+			{Status: BATCH_LINE_PENDING},
+		},
+	}
+
+	summariseBatch(&batch)
+	if batch.Processed != 3 || batch.Succeeded != 2 || batch.Failed != 1 {
+		t.Errorf("SummariseBatch does not pass. Looking for %v, got %v", "3 2 1", batch)
+	}
+
+	message := batchSummaryMessage(batch, BATCH_STATUS_COMPLETED)
+	if message != "📦 Batch 7 done: 2 paid, 1 failed" {
+		t.Errorf("BatchSummaryMessage does not pass. Looking for %v, got %v", "📦 Batch 7 done: 2 paid, 1 failed", message)
+	}
+}
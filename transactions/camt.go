@@ -0,0 +1,434 @@
+package transactions
+
+import (
+	"encoding/xml"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/bvnk/bank/money"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	CAMT_052_NAMESPACE = "urn:iso:std:iso:20022:tech:xsd:camt.052.001.04"
+	CAMT_053_NAMESPACE = "urn:iso:std:iso:20022:tech:xsd:camt.053.001.04"
+	CAMT_054_NAMESPACE = "urn:iso:std:iso:20022:tech:xsd:camt.054.001.04"
+
+	CAMT_CREDIT = "CRDT"
+	CAMT_DEBIT  = "DBIT"
+)
+
+func ProcessCAMT(data []string) (result interface{}, err error) {
+	if len(data) < 3 {
+		return "", errors.New("payments.ProcessCAMT: Not all data is present.")
+	}
+
+	camtType, err := strconv.ParseInt(data[2], 10, 64)
+	if err != nil {
+		return "", errors.New("payments.ProcessCAMT: Could not get type of CAMT transaction. " + err.Error())
+	}
+
+	switch camtType {
+	case 52, 54:
+		//token~camt~type~accountNumber~from~to
+		if len(data) < 6 {
+			return "", errors.New("payments.ProcessCAMT: Not all data is present.")
+		}
+		result, err = accountCamtMessage(data, camtType)
+		if err != nil {
+			return "", errors.New("payments.ProcessCAMT: " + err.Error())
+		}
+	case 53:
+		//token~camt~type~accountNumber~from~to
+		if len(data) < 6 {
+			return "", errors.New("payments.ProcessCAMT: Not all data is present.")
+		}
+		result, err = accountStatement(data, STATEMENT_FORMAT_XML)
+		if err != nil {
+			return "", errors.New("payments.ProcessCAMT: " + err.Error())
+		}
+	case 1000:
+		//token~camt~type~accountNumber~from~to~format
+		if len(data) < 7 {
+			return "", errors.New("payments.ProcessCAMT: Not all data is present.")
+		}
+		result, err = accountStatement(data, data[6])
+		if err != nil {
+			return "", errors.New("payments.ProcessCAMT: " + err.Error())
+		}
+	default:
+		return "", errors.New("payments.ProcessCAMT: No valid option chosen")
+	}
+
+	return
+}
+
+// ISO 20022 bank to customer cash management messages.
+// Statements (camt.053), reports (camt.052) and notifications (camt.054)
+// share the same account report layout.
+type camtDocument struct {
+	XMLName      xml.Name     `xml:"Document"`
+	Xmlns        string       `xml:"xmlns,attr"`
+	Report       *camtMessage `xml:"BkToCstmrAcctRpt,omitempty"`
+	Statement    *camtMessage `xml:"BkToCstmrStmt,omitempty"`
+	Notification *camtMessage `xml:"BkToCstmrDbtCdtNtfctn,omitempty"`
+}
+
+type camtMessage struct {
+	GrpHdr camtGroupHeader
+	Rpt    *camtAccountReport `xml:"Rpt,omitempty"`
+	Stmt   *camtAccountReport `xml:"Stmt,omitempty"`
+	Ntfctn *camtAccountReport `xml:"Ntfctn,omitempty"`
+}
+
+type camtGroupHeader struct {
+	MsgId   string
+	CreDtTm string
+}
+
+type camtAccountReport struct {
+	Id        string
+	CreDtTm   string
+	FrToDt    *camtDateTimePeriod `xml:"FrToDt,omitempty"`
+	Acct      camtAccount
+	Bal       []camtBalance   `xml:"Bal,omitempty"`
+	TxsSummry *camtTxsSummary `xml:"TxsSummry,omitempty"`
+	Ntry      []camtEntry     `xml:"Ntry,omitempty"`
+}
+
+type camtDateTimePeriod struct {
+	FrDtTm string
+	ToDtTm string
+}
+
+type camtAccount struct {
+	Id   camtAccountId
+	Ccy  string     `xml:"Ccy,omitempty"`
+	Ownr *camtParty `xml:"Ownr,omitempty"`
+}
+
+type camtAccountId struct {
+	Othr camtOtherId
+}
+
+type camtOtherId struct {
+	Id string
+}
+
+type camtParty struct {
+	Nm string
+}
+
+type camtAmount struct {
+	Ccy   string `xml:"Ccy,attr"`
+	Value string `xml:",chardata"`
+}
+
+type camtBalance struct {
+	Tp        camtBalanceType
+	Amt       camtAmount
+	CdtDbtInd string
+	Dt        camtDate
+}
+
+type camtBalanceType struct {
+	CdOrPrtry camtCode
+}
+
+type camtCode struct {
+	Cd string
+}
+
+type camtDate struct {
+	Dt   string `xml:"Dt,omitempty"`
+	DtTm string `xml:"DtTm,omitempty"`
+}
+
+type camtTxsSummary struct {
+	TtlCdtNtries camtNumberAndSum
+	TtlDbtNtries camtNumberAndSum
+}
+
+type camtNumberAndSum struct {
+	NbOfNtries string
+	Sum        string
+}
+
+type camtEntry struct {
+	NtryRef     string
+	Amt         camtAmount
+	CdtDbtInd   string
+	Sts         string
+	BookgDt     camtDate
+	ValDt       camtDate
+	AcctSvcrRef string
+	BkTxCd      camtBankTransactionCode
+	Chrgs       *camtCharges `xml:"Chrgs,omitempty"`
+	NtryDtls    camtEntryDetails
+}
+
+type camtBankTransactionCode struct {
+	Domn camtDomain
+}
+
+type camtDomain struct {
+	Cd   string
+	Fmly camtFamily
+}
+
+type camtFamily struct {
+	Cd        string
+	SubFmlyCd string
+}
+
+type camtCharges struct {
+	TtlChrgsAndTaxAmt camtAmount
+	Rcrd              camtChargesRecord
+}
+
+type camtChargesRecord struct {
+	Amt       camtAmount
+	CdtDbtInd string
+}
+
+type camtEntryDetails struct {
+	TxDtls camtTransactionDetails
+}
+
+type camtTransactionDetails struct {
+	Refs      camtReferences
+	RltdPties *camtRelatedParties `xml:"RltdPties,omitempty"`
+	RmtInf    *camtRemittance     `xml:"RmtInf,omitempty"`
+}
+
+type camtReferences struct {
+	AcctSvcrRef string
+	EndToEndId  string
+}
+
+type camtRelatedParties struct {
+	DbtrAcct *camtPartyAccount `xml:"DbtrAcct,omitempty"`
+	CdtrAcct *camtPartyAccount `xml:"CdtrAcct,omitempty"`
+}
+
+type camtPartyAccount struct {
+	Id camtAccountId
+}
+
+type camtRemittance struct {
+	Ustrd string
+}
+
+// accountCamtMessage generates an account report (camt.052) or a debit and
+// credit notification (camt.054) for a period
+func accountCamtMessage(data []string, camtType int64) (result StatementFile, err error) {
+	statement, err := authorisedStatement(data[0], data[3], data[4], data[5])
+	if err != nil {
+		return StatementFile{}, errors.New("payments.accountCamtMessage: " + err.Error())
+	}
+
+	fileName := "-" + statement.AccountNumber + "-" + data[4] + "-" + data[5] + ".xml"
+	switch camtType {
+	case 52:
+		result.Content, err = reportCamt052(statement)
+		result.FileName = "report" + fileName
+	case 54:
+		result.Content, err = notificationCamt054(statement)
+		result.FileName = "notification" + fileName
+	default:
+		return StatementFile{}, errors.New("payments.accountCamtMessage: CAMT type not valid")
+	}
+	if err != nil {
+		return StatementFile{}, errors.New("payments.accountCamtMessage: " + err.Error())
+	}
+
+	result.ContentType = "application/xml; charset=UTF-8"
+	return
+}
+
+// reportCamt052 is an account report. While the period has not ended the
+// last balance is interim (ITBD) as of when the report was made.
+func reportCamt052(statement Statement) (content []byte, err error) {
+	report := camtReport(statement, "RPT")
+
+	closing := camtBalanceOf("CLBD", statement.ClosingBalance, statement.Currency, camtDate{Dt: statement.To.AddDate(0, 0, -1).Format(STATEMENT_DATE_FORMAT)})
+	if statement.Created.Before(statement.To) {
+		closing = camtBalanceOf("ITBD", statement.ClosingBalance, statement.Currency, camtDate{DtTm: camtDateTime(statement.Created)})
+	}
+	report.Bal = []camtBalance{
+		camtBalanceOf("OPBD", statement.OpeningBalance, statement.Currency, camtDate{Dt: statement.From.Format(STATEMENT_DATE_FORMAT)}),
+		closing,
+	}
+
+	document := camtDocument{
+		Xmlns: CAMT_052_NAMESPACE,
+		Report: &camtMessage{
+			GrpHdr: camtGroupHeader{report.Id, report.CreDtTm},
+			Rpt:    &report,
+		},
+	}
+
+	content, err = marshalCamt(document)
+	if err != nil {
+		return nil, errors.New("payments.reportCamt052: " + err.Error())
+	}
+	return
+}
+
+func statementCamt053(statement Statement) (content []byte, err error) {
+	report := camtReport(statement, "STMT")
+	report.Bal = []camtBalance{
+		camtBalanceOf("OPBD", statement.OpeningBalance, statement.Currency, camtDate{Dt: statement.From.Format(STATEMENT_DATE_FORMAT)}),
+		camtBalanceOf("CLBD", statement.ClosingBalance, statement.Currency, camtDate{Dt: statement.To.AddDate(0, 0, -1).Format(STATEMENT_DATE_FORMAT)}),
+	}
+
+	document := camtDocument{
+		Xmlns: CAMT_053_NAMESPACE,
+		Statement: &camtMessage{
+			GrpHdr: camtGroupHeader{report.Id, report.CreDtTm},
+			Stmt:   &report,
+		},
+	}
+
+	content, err = marshalCamt(document)
+	if err != nil {
+		return nil, errors.New("payments.statementCamt053: " + err.Error())
+	}
+	return
+}
+
+// notificationCamt054 notifies every debit and credit in the period, it has no balances
+func notificationCamt054(statement Statement) (content []byte, err error) {
+	report := camtReport(statement, "NTFCTN")
+
+	document := camtDocument{
+		Xmlns: CAMT_054_NAMESPACE,
+		Notification: &camtMessage{
+			GrpHdr: camtGroupHeader{report.Id, report.CreDtTm},
+			Ntfctn: &report,
+		},
+	}
+
+	content, err = marshalCamt(document)
+	if err != nil {
+		return nil, errors.New("payments.notificationCamt054: " + err.Error())
+	}
+	return
+}
+
+// camtReport sets up the account report for a statement, without balances
+func camtReport(statement Statement, prefix string) (report camtAccountReport) {
+	report = camtAccountReport{
+		Id:      prefix + "-" + statement.AccountNumber + "-" + strconv.FormatInt(statement.Created.Unix(), 10),
+		CreDtTm: camtDateTime(statement.Created),
+		FrToDt:  &camtDateTimePeriod{camtDateTime(statement.From), camtDateTime(statement.To)},
+		Acct: camtAccount{
+			Id:  camtAccountId{camtOtherId{statement.AccountNumber}},
+			Ccy: statement.Currency,
+		},
+	}
+	if statement.AccountHolderName != "" {
+		report.Acct.Ownr = &camtParty{statement.AccountHolderName}
+	}
+
+	credits, debits := 0, 0
+	for _, line := range statement.Lines {
+		if line.Amount.Sign() < 0 {
+			debits++
+		} else {
+			credits++
+		}
+		report.Ntry = append(report.Ntry, camtEntryOf(line, statement.AccountNumber, statement.Currency))
+	}
+	report.TxsSummry = &camtTxsSummary{
+		TtlCdtNtries: camtNumberAndSum{strconv.Itoa(credits), money.Format(statement.TotalCredits)},
+		TtlDbtNtries: camtNumberAndSum{strconv.Itoa(debits), money.Format(statement.TotalDebits)},
+	}
+
+	return
+}
+
+func camtEntryOf(line StatementLine, accountNumber string, currency string) (entry camtEntry) {
+	reference := strconv.Itoa(int(line.TransactionID))
+	indicator, amount := camtSigned(line.Amount)
+
+	entry = camtEntry{
+		NtryRef:     reference,
+		Amt:         camtAmount{currency, amount},
+		CdtDbtInd:   indicator,
+		Sts:         "BOOK",
+		BookgDt:     camtDate{DtTm: camtDateTime(line.Timestamp)},
+		ValDt:       camtDate{Dt: line.ValueDate},
+		AcctSvcrRef: reference,
+		BkTxCd:      camtTransactionCode(line.PainType, indicator),
+		NtryDtls: camtEntryDetails{camtTransactionDetails{
+			Refs: camtReferences{reference, "NOTPROVIDED"},
+		}},
+	}
+
+	if line.Fee.Sign() != 0 {
+		fee := camtAmount{currency, money.Format(line.Fee)}
+		entry.Chrgs = &camtCharges{fee, camtChargesRecord{fee, CAMT_DEBIT}}
+	}
+
+	// Deposits come from the bank, there is no counterparty account
+	if line.PainType != 1000 && line.Counterparty != "" {
+		parties := &camtRelatedParties{}
+		if indicator == CAMT_DEBIT {
+			parties.CdtrAcct = &camtPartyAccount{camtAccountId{camtOtherId{line.Counterparty}}}
+		} else {
+			parties.DbtrAcct = &camtPartyAccount{camtAccountId{camtOtherId{line.Counterparty}}}
+		}
+		entry.NtryDtls.TxDtls.RltdPties = parties
+	}
+	if line.Description != "" {
+		entry.NtryDtls.TxDtls.RmtInf = &camtRemittance{line.Description}
+	}
+
+	return
+}
+
+// camtTransactionCode uses the ISO bank transaction codes for payments (PMNT)
+// issued (ICDT) or received (RCDT), and for cash deposits at the counter (CNTR)
+func camtTransactionCode(painType int64, indicator string) camtBankTransactionCode {
+	if painType == 1000 {
+		return camtBankTransactionCode{camtDomain{"PMNT", camtFamily{"CNTR", "CDPT"}}}
+	}
+	if indicator == CAMT_DEBIT {
+		return camtBankTransactionCode{camtDomain{"PMNT", camtFamily{"ICDT", "DMCT"}}}
+	}
+	return camtBankTransactionCode{camtDomain{"PMNT", camtFamily{"RCDT", "DMCT"}}}
+}
+
+func camtBalanceOf(code string, balance decimal.Decimal, currency string, date camtDate) camtBalance {
+	indicator, amount := camtSigned(balance)
+	return camtBalance{
+		Tp:        camtBalanceType{camtCode{code}},
+		Amt:       camtAmount{currency, amount},
+		CdtDbtInd: indicator,
+		Dt:        date,
+	}
+}
+
+// ISO amounts are never negative, the direction is given by the indicator
+func camtSigned(amount decimal.Decimal) (indicator string, value string) {
+	if amount.Sign() < 0 {
+		return CAMT_DEBIT, money.Format(amount.Neg())
+	}
+	return CAMT_CREDIT, money.Format(amount)
+}
+
+func camtDateTime(t time.Time) string {
+	return t.Format("2006-01-02T15:04:05-07:00")
+}
+
+func marshalCamt(document camtDocument) (content []byte, err error) {
+	body, err := xml.MarshalIndent(document, "", "  ")
+	if err != nil {
+		return nil, errors.New("payments.marshalCamt: " + err.Error())
+	}
+
+	content = append([]byte(xml.Header), body...)
+	return
+}
@@ -0,0 +1,277 @@
+package transactions
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Element order of the sequences used, from the camt.05x.001.04 schemas
+var camtSchemaSequences = map[string][]string{
+	"GrpHdr":    {"MsgId", "CreDtTm", "MsgRcpt", "MsgPgntn", "OrgnlBizQry", "AddtlInf"},
+	"Rpt":       {"Id", "RptPgntn", "ElctrncSeqNb", "LglSeqNb", "CreDtTm", "FrToDt", "CpyDplctInd", "RptgSrc", "Acct", "RltdAcct", "Intrst", "Bal", "TxsSummry", "Ntry", "AddtlRptInf"},
+	"Stmt":      {"Id", "StmtPgntn", "ElctrncSeqNb", "LglSeqNb", "CreDtTm", "FrToDt", "CpyDplctInd", "RptgSrc", "Acct", "RltdAcct", "Intrst", "Bal", "TxsSummry", "Ntry", "AddtlStmtInf"},
+	"Ntfctn":    {"Id", "NtfctnPgntn", "ElctrncSeqNb", "LglSeqNb", "CreDtTm", "FrToDt", "CpyDplctInd", "RptgSrc", "Acct", "RltdAcct", "Intrst", "TxsSummry", "Ntry", "AddtlNtfctnInf"},
+	"Acct":      {"Id", "Tp", "Ccy", "Nm", "Ownr", "Svcr"},
+	"Bal":       {"Tp", "CdtLine", "Amt", "CdtDbtInd", "Dt", "Avlbty"},
+	"TxsSummry": {"TtlNtries", "TtlCdtNtries", "TtlDbtNtries", "TtlNtriesPerBkTxCd"},
+	"Ntry":      {"NtryRef", "Amt", "CdtDbtInd", "RvslInd", "Sts", "BookgDt", "ValDt", "AcctSvcrRef", "Avlbty", "BkTxCd", "ComssnWvrInd", "AddtlInfInd", "AmtDtls", "Chrgs", "TechInptChanl", "Intrst", "CardTx", "NtryDtls", "AddtlNtryInf"},
+	"Chrgs":     {"TtlChrgsAndTaxAmt", "Rcrd"},
+	"TxDtls":    {"Refs", "Amt", "CdtDbtInd", "AmtDtls", "Avlbty", "BkTxCd", "Chrgs", "Intrst", "RltdPties", "RltdAgts", "Purp", "RltdRmtInf", "RmtInf"},
+	"Refs":      {"MsgId", "AcctSvcrRef", "PmtInfId", "InstrId", "EndToEndId", "TxId"},
+	"RltdPties": {"InitgPty", "Dbtr", "DbtrAcct", "UltmtDbtr", "Cdtr", "CdtrAcct", "UltmtCdtr"},
+}
+
+// Mandatory elements of the sequences used
+var camtSchemaRequired = map[string][]string{
+	"GrpHdr": {"MsgId", "CreDtTm"},
+	"Rpt":    {"Id", "CreDtTm", "Acct"},
+	"Stmt":   {"Id", "CreDtTm", "Acct", "Bal"},
+	"Ntfctn": {"Id", "CreDtTm", "Acct"},
+	"Bal":    {"Tp", "Amt", "CdtDbtInd", "Dt"},
+	"Ntry":   {"Amt", "CdtDbtInd", "Sts", "BkTxCd"},
+	"BkTxCd": {"Domn"},
+	"Domn":   {"Cd", "Fmly"},
+	"Fmly":   {"Cd", "SubFmlyCd"},
+	"FrToDt": {"FrDtTm", "ToDtTm"},
+	"Othr":   {"Id"},
+}
+
+// checkCamtOrder walks the document and checks the children of every element
+// listed above are in schema order and include the mandatory ones. It is not a
+// full schema validation, validateCamtXSD is, where the schemas are available.
+func checkCamtOrder(t *testing.T, name string, content []byte) {
+	decoder := xml.NewDecoder(bytes.NewReader(content))
+	stack := []string{}
+	children := [][]string{}
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("%v element order does not pass. Looking for %v, got %v", name, nil, err)
+		}
+
+		switch element := token.(type) {
+		case xml.StartElement:
+			if len(children) > 0 {
+				children[len(children)-1] = append(children[len(children)-1], element.Name.Local)
+			}
+			stack = append(stack, element.Name.Local)
+			children = append(children, []string{})
+		case xml.EndElement:
+			parent := stack[len(stack)-1]
+			checkCamtSequence(t, name, parent, children[len(children)-1])
+			stack = stack[:len(stack)-1]
+			children = children[:len(children)-1]
+		}
+	}
+}
+
+func checkCamtSequence(t *testing.T, name string, parent string, children []string) {
+	if sequence, ok := camtSchemaSequences[parent]; ok {
+		position := 0
+		for _, child := range children {
+			found := false
+			for i := position; i < len(sequence); i++ {
+				if sequence[i] == child {
+					position = i
+					found = true
+					break
+				}
+			}
+			if !found {
+				t.Errorf("%v element order does not pass. Looking for %v in %v, got %v", name, child, parent, children)
+			}
+		}
+	}
+
+	for _, required := range camtSchemaRequired[parent] {
+		found := false
+		for _, child := range children {
+			if child == required {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("%v element required does not pass. Looking for %v in %v, got %v", name, required, parent, children)
+		}
+	}
+}
+
+func TestCamtElementOrder(t *testing.T) {
+	statement := testStatement()
+
+	content, err := reportCamt052(statement)
+	if err != nil {
+		t.Fatalf("ReportCamt052 does not pass. Looking for %v, got %v", nil, err)
+	}
+	checkCamtOrder(t, "ReportCamt052", content)
+
+	content, err = statementCamt053(statement)
+	if err != nil {
+		t.Fatalf("StatementCamt053 does not pass. Looking for %v, got %v", nil, err)
+	}
+	checkCamtOrder(t, "StatementCamt053", content)
+
+	content, err = notificationCamt054(statement)
+	if err != nil {
+		t.Fatalf("NotificationCamt054 does not pass. Looking for %v, got %v", nil, err)
+	}
+	checkCamtOrder(t, "NotificationCamt054", content)
+}
+
+// validateCamtXSD validates the document with xmllint against its ISO 20022
+// schema, named for the namespace, in the directory CAMT_XSD_DIR gives. The
+// schemas are not kept in the repository, so without them or xmllint the test
+// is skipped.
+func validateCamtXSD(t *testing.T, name string, namespace string, content []byte) {
+	dir := os.Getenv("CAMT_XSD_DIR")
+	if dir == "" {
+		t.Skip("CAMT_XSD_DIR not set")
+	}
+	schema := filepath.Join(dir, strings.TrimPrefix(namespace, "urn:iso:std:iso:20022:tech:xsd:")+".xsd")
+	if _, err := os.Stat(schema); err != nil {
+		t.Skipf("%v not found", schema)
+	}
+	xmllint, err := exec.LookPath("xmllint")
+	if err != nil {
+		t.Skip("xmllint not found")
+	}
+
+	cmd := exec.Command(xmllint, "--noout", "--schema", schema, "-")
+	cmd.Stdin = bytes.NewReader(content)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Errorf("%v XSD does not pass. Looking for %v, got %v", name, "valid", string(output))
+	}
+}
+
+func TestCamtXSD(t *testing.T) {
+	tests := []struct {
+		name      string
+		namespace string
+		message   func(Statement) ([]byte, error)
+	}{
+		{"ReportCamt052", CAMT_052_NAMESPACE, reportCamt052},
+		{"StatementCamt053", CAMT_053_NAMESPACE, statementCamt053},
+		{"NotificationCamt054", CAMT_054_NAMESPACE, notificationCamt054},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			content, err := test.message(testStatement())
+			if err != nil {
+				t.Fatalf("%v does not pass. Looking for %v, got %v", test.name, nil, err)
+			}
+			validateCamtXSD(t, test.name, test.namespace, content)
+		})
+	}
+}
+
+func TestReportCamt052(t *testing.T) {
+	statement := testStatement()
+
+	content, err := reportCamt052(statement)
+	if err != nil {
+		t.Fatalf("ReportCamt052 does not pass. Looking for %v, got %v", nil, err)
+	}
+
+	document := camtDocument{}
+	err = xml.Unmarshal(content, &document)
+	if err != nil {
+		t.Fatalf("ReportCamt052 unmarshal does not pass. Looking for %v, got %v", nil, err)
+	}
+	if document.Xmlns != CAMT_052_NAMESPACE {
+		t.Errorf("ReportCamt052 namespace does not pass. Looking for %v, got %v", CAMT_052_NAMESPACE, document.Xmlns)
+	}
+	if document.Report == nil || document.Report.Rpt == nil {
+		t.Fatalf("ReportCamt052 Rpt does not pass. Looking for %v, got %v", "Rpt", nil)
+	}
+
+	rpt := document.Report.Rpt
+	if len(rpt.Ntry) != 3 {
+		t.Errorf("ReportCamt052 entries does not pass. Looking for %v, got %v", 3, len(rpt.Ntry))
+	}
+	if len(rpt.Bal) != 2 || rpt.Bal[1].Tp.CdOrPrtry.Cd != "CLBD" {
+		t.Errorf("ReportCamt052 closed period does not pass. Looking for %v, got %v", "CLBD", rpt.Bal)
+	}
+
+	// A period that has not ended yet gives an interim balance
+	statement.To = time.Now().AddDate(0, 0, 1)
+	content, _ = reportCamt052(statement)
+	document = camtDocument{}
+	_ = xml.Unmarshal(content, &document)
+	bal := document.Report.Rpt.Bal
+	if len(bal) != 2 || bal[1].Tp.CdOrPrtry.Cd != "ITBD" || bal[1].Dt.DtTm == "" {
+		t.Errorf("ReportCamt052 intraday does not pass. Looking for %v, got %v", "ITBD", bal)
+	}
+}
+
+func TestNotificationCamt054(t *testing.T) {
+	content, err := notificationCamt054(testStatement())
+	if err != nil {
+		t.Fatalf("NotificationCamt054 does not pass. Looking for %v, got %v", nil, err)
+	}
+
+	document := camtDocument{}
+	err = xml.Unmarshal(content, &document)
+	if err != nil {
+		t.Fatalf("NotificationCamt054 unmarshal does not pass. Looking for %v, got %v", nil, err)
+	}
+	if document.Xmlns != CAMT_054_NAMESPACE {
+		t.Errorf("NotificationCamt054 namespace does not pass. Looking for %v, got %v", CAMT_054_NAMESPACE, document.Xmlns)
+	}
+	if document.Notification == nil || document.Notification.Ntfctn == nil {
+		t.Fatalf("NotificationCamt054 Ntfctn does not pass. Looking for %v, got %v", "Ntfctn", nil)
+	}
+
+	ntfctn := document.Notification.Ntfctn
+	if len(ntfctn.Bal) != 0 {
+		t.Errorf("NotificationCamt054 balances does not pass. Looking for %v, got %v", 0, len(ntfctn.Bal))
+	}
+	if len(ntfctn.Ntry) != 3 {
+		t.Fatalf("NotificationCamt054 entries does not pass. Looking for %v, got %v", 3, len(ntfctn.Ntry))
+	}
+	if ntfctn.Ntry[0].CdtDbtInd != CAMT_CREDIT || ntfctn.Ntry[0].BkTxCd.Domn.Fmly.Cd != "CNTR" {
+		t.Errorf("NotificationCamt054 deposit does not pass. Looking for %v, got %v", "CRDT CNTR", ntfctn.Ntry[0])
+	}
+	if ntfctn.TxsSummry == nil || ntfctn.TxsSummry.TtlDbtNtries.NbOfNtries != "1" || ntfctn.TxsSummry.TtlCdtNtries.NbOfNtries != "2" {
+		t.Errorf("NotificationCamt054 summary does not pass. Looking for %v, got %v", "1 debit and 2 credits", ntfctn.TxsSummry)
+	}
+}
+
+func TestProcessCAMT(t *testing.T) {
+	data := []string{"", ""}
+	_, err := ProcessCAMT(data)
+	if err == nil {
+		t.Errorf("ProcessCAMT does not pass. Looking for %v, got %v", "Not all data is present.", nil)
+	}
+
+	data = []string{"", "", "not integer"}
+	_, err = ProcessCAMT(data)
+	if err == nil {
+		t.Errorf("ProcessCAMT CheckCamtType does not pass. Looking for %v, got %v", "Could not get type of CAMT transaction", nil)
+	}
+
+	data = []string{"", "", "52", "account"}
+	_, err = ProcessCAMT(data)
+	if err == nil {
+		t.Errorf("ProcessCAMT CamtType52 does not pass. Looking for %v, got %v", "Not all data is present.", nil)
+	}
+
+	data = []string{"", "", "99", "account", "2016-01-01", "2016-01-31"}
+	_, err = ProcessCAMT(data)
+	if err == nil {
+		t.Errorf("ProcessCAMT CamtType99 does not pass. Looking for %v, got %v", "No valid option chosen", nil)
+	}
+}
@@ -6,6 +6,9 @@ import (
 	"time"
 
 	"github.com/bvnk/bank/configuration"
+	"github.com/bvnk/bank/eod"
+	"github.com/bvnk/bank/money"
+	"github.com/paulmach/go.geo"
 	"github.com/shopspring/decimal"
 )
 
@@ -15,14 +18,21 @@ func SetConfig(config *configuration.Configuration) {
 	Config = *config
 }
 
-func savePainTransaction(transaction PAINTrans) (id int64, err error) {
+// execer is what the ledger updates need from the database. Both *sql.DB and
+// *sql.Tx satisfy it, so a number of payments can be made in one database transaction.
+type execer interface {
+	Prepare(query string) (*sql.Stmt, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func savePainTransaction(db execer, transaction PAINTrans) (id int64, err error) {
 	// Prepare statement for inserting data
 	// Construct geoText. These values are already cleared
 	geoText := transaction.Geo.ToWKT()
-	insertStatement := "INSERT INTO transactions (`transaction`, `type`, `senderAccountNumber`, `senderBankNumber`, `receiverAccountNumber`, `receiverBankNumber`, `transactionAmount`, `feeAmount`, `desc`, `timestamp`, `status`, `geo`) "
-	insertStatement += "VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, GeomFromText(?))"
+	insertStatement := "INSERT INTO transactions (`transaction`, `type`, `senderAccountNumber`, `senderBankNumber`, `receiverAccountNumber`, `receiverBankNumber`, `transactionAmount`, `feeAmount`, `desc`, `timestamp`, `valueDate`, `status`, `geo`) "
+	insertStatement += "VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, GeomFromText(?))"
 
-	stmtIns, err := Config.Db.Prepare(insertStatement)
+	stmtIns, err := db.Prepare(insertStatement)
 	if err != nil {
 		return 0, errors.New("payments.savePainTransaction: " + err.Error())
 	}
@@ -32,18 +42,24 @@ func savePainTransaction(transaction PAINTrans) (id int64, err error) {
 	sqlTime := int32(t.Unix())
 	transaction.Timestamp = sqlTime
 
+	// Transactions booked after the business date is closed take effect the next business day
+	valueDate, err := eod.ValueDate()
+	if err != nil {
+		return 0, errors.New("payments.savePainTransaction: " + err.Error())
+	}
+
 	// The feePerc is a percentage, convert to amount
-	feeAmount := transaction.Amount.Mul(transaction.Fee)
+	feeAmount := money.Round(transaction.Amount.Mul(transaction.Fee))
 
 	res, err := stmtIns.Exec("pain", transaction.PainType, transaction.Sender.AccountNumber, transaction.Sender.BankNumber, transaction.Receiver.AccountNumber, transaction.Receiver.BankNumber,
-		transaction.Amount, feeAmount, transaction.Desc, transaction.Timestamp, transaction.Status, geoText)
-
-	id, _ = res.LastInsertId()
+		transaction.Amount, feeAmount, transaction.Desc, transaction.Timestamp, valueDate, transaction.Status, geoText)
 
 	if err != nil {
 		return 0, errors.New("payments.savePainTransaction: " + err.Error())
 	}
 
+	id, _ = res.LastInsertId()
+
 	return
 }
 
@@ -58,7 +74,7 @@ func removePainTransaction(transaction PAINTrans) (err error) {
 	defer stmtDel.Close() // Close the statement when we leave main() / the program terminates
 
 	// The feePerc is a percentage, convert to amount
-	feeAmount := transaction.Amount.Mul(transaction.Fee)
+	feeAmount := money.Round(transaction.Amount.Mul(transaction.Fee))
 
 	_, err = stmtDel.Exec("pain", transaction.PainType, transaction.Sender.AccountNumber, transaction.Sender.BankNumber, transaction.Receiver.AccountNumber, transaction.Receiver.BankNumber,
 		transaction.Amount, feeAmount)
@@ -71,31 +87,31 @@ func removePainTransaction(transaction PAINTrans) (err error) {
 }
 
 //func updateAccounts(sender AccountHolder, receiver AccountHolder, transactionAmount float64, transactionFee float64) {
-func updateAccounts(transaction PAINTrans) (err error) {
+func updateAccounts(db execer, transaction PAINTrans) (err error) {
 	t := time.Now()
 	sqlTime := int32(t.Unix())
 
 	// The feePerc is a percentage, convert to amount
-	feeAmount := transaction.Amount.Mul(transaction.Fee)
+	feeAmount := money.Round(transaction.Amount.Mul(transaction.Fee))
 
 	switch transaction.PainType {
 	// Payment
 	case 1:
-		err = processCreditInitiation(transaction, sqlTime, feeAmount)
+		err = processCreditInitiation(db, transaction, sqlTime, feeAmount)
 		if err != nil {
 			return errors.New("payments.updateAccounts: " + err.Error())
 		}
 		break
 	// Deposit
 	case 1000:
-		err = processDepositInitiation(transaction, sqlTime, feeAmount)
+		err = processDepositInitiation(db, transaction, sqlTime, feeAmount)
 		if err != nil {
 			return errors.New("payments.updateAccounts: " + err.Error())
 		}
 		break
 	}
 
-	err = updateBankHoldingAccount(feeAmount, sqlTime)
+	err = updateBankHoldingAccount(db, feeAmount, sqlTime)
 	if err != nil {
 		return errors.New("payments.updateAccounts: " + err.Error())
 	}
@@ -104,11 +120,11 @@ func updateAccounts(transaction PAINTrans) (err error) {
 
 }
 
-func updateBankHoldingAccount(feeAmount decimal.Decimal, sqlTime int32) (err error) {
+func updateBankHoldingAccount(db execer, feeAmount decimal.Decimal, sqlTime int32) (err error) {
 	// Add fees to bank holding account
-	// Only one row in this account for now - only holds single holding bank's balance
-	updateBank := "UPDATE `bank_account` SET `balance` = (`balance` + ?), `timestamp` = ?"
-	stmtUpdBank, err := Config.Db.Prepare(updateBank)
+	// Settlement accounts with other banks are kept in the same table
+	updateBank := "UPDATE `bank_account` SET `balance` = (`balance` + ?), `timestamp` = ? WHERE `type` = 'holding'"
+	stmtUpdBank, err := db.Prepare(updateBank)
 	if err != nil {
 		return errors.New("payments.updateBankHoldingAccount: " + err.Error())
 	}
@@ -123,8 +139,8 @@ func updateBankHoldingAccount(feeAmount decimal.Decimal, sqlTime int32) (err err
 }
 
 // @TODO Look at using accounts.getAccountDetails here
-func checkBalance(account AccountHolder) (balance decimal.Decimal, err error) {
-	err = Config.Db.QueryRow("SELECT `availableBalance` FROM `accounts` WHERE `accountNumber` = ?", account.AccountNumber).Scan(&balance)
+func checkBalance(db execer, account AccountHolder) (balance decimal.Decimal, err error) {
+	err = db.QueryRow("SELECT `availableBalance` FROM `accounts` WHERE `accountNumber` = ?", account.AccountNumber).Scan(&balance)
 	switch {
 	case err == sql.ErrNoRows:
 		return decimal.NewFromFloat(0.), errors.New("payments.checkBalance: Could not retrieve account details. Account not found.")
@@ -135,11 +151,11 @@ func checkBalance(account AccountHolder) (balance decimal.Decimal, err error) {
 	return
 }
 
-func processCreditInitiation(transaction PAINTrans, sqlTime int32, feeAmount decimal.Decimal) (err error) {
+func processCreditInitiation(db execer, transaction PAINTrans, sqlTime int32, feeAmount decimal.Decimal) (err error) {
 	// Only update if account local
 	if transaction.Sender.BankNumber == "" {
 		updateSenderStatement := "UPDATE accounts SET `accountBalance` = (`accountBalance` - ?), `availableBalance` = (`availableBalance` - ?), `timestamp` = ? WHERE `accountNumber` = ? "
-		stmtUpdSender, err := Config.Db.Prepare(updateSenderStatement)
+		stmtUpdSender, err := db.Prepare(updateSenderStatement)
 		if err != nil {
 			return errors.New("payments.processCreditInitiation: " + err.Error())
 		}
@@ -159,7 +175,7 @@ func processCreditInitiation(transaction PAINTrans, sqlTime int32, feeAmount dec
 	// Only update if account local
 	if transaction.Receiver.BankNumber == "" {
 		updateStatementReceiver := "UPDATE accounts SET `accountBalance` = (`accountBalance` + ?), `availableBalance` = (`availableBalance` + ?), `timestamp` = ? WHERE `accountNumber` = ? "
-		stmtUpdReceiver, err := Config.Db.Prepare(updateStatementReceiver)
+		stmtUpdReceiver, err := db.Prepare(updateStatementReceiver)
 		if err != nil {
 			return errors.New("payments.processCreditInitiation: " + err.Error())
 		}
@@ -171,12 +187,82 @@ func processCreditInitiation(transaction PAINTrans, sqlTime int32, feeAmount dec
 			return errors.New("payments.processCreditInitiation: " + err.Error())
 		}
 	} else {
-		// Drop onto ledger
+		// The receiving bank credits its account holder
+		err = queueInterbankPayment(db, transaction)
+		if err != nil {
+			return errors.New("payments.processCreditInitiation: " + err.Error())
+		}
+	}
+	return
+}
+
+// holdSenderFunds reserves the amount and fee of a pending payment against the
+// sender's available balance. The account balance only moves once it is approved.
+func holdSenderFunds(db execer, transaction PAINTrans) (err error) {
+	if transaction.Sender.BankNumber != "" {
+		return
+	}
+
+	t := time.Now()
+	sqlTime := int32(t.Unix())
+
+	updateSenderStatement := "UPDATE accounts SET `availableBalance` = (`availableBalance` - ?), `timestamp` = ? WHERE `accountNumber` = ? "
+	stmtUpdSender, err := db.Prepare(updateSenderStatement)
+	if err != nil {
+		return errors.New("payments.holdSenderFunds: " + err.Error())
+	}
+	defer stmtUpdSender.Close()
+
+	_, err = stmtUpdSender.Exec(heldAmount(transaction), sqlTime, transaction.Sender.AccountNumber)
+	if err != nil {
+		return errors.New("payments.holdSenderFunds: " + err.Error())
+	}
+	return
+}
+
+// releaseSenderFunds gives back what holdSenderFunds reserved
+func releaseSenderFunds(db execer, transaction PAINTrans) (err error) {
+	if transaction.Sender.BankNumber != "" {
+		return
+	}
+
+	t := time.Now()
+	sqlTime := int32(t.Unix())
+
+	updateSenderStatement := "UPDATE accounts SET `availableBalance` = (`availableBalance` + ?), `timestamp` = ? WHERE `accountNumber` = ? "
+	stmtUpdSender, err := db.Prepare(updateSenderStatement)
+	if err != nil {
+		return errors.New("payments.releaseSenderFunds: " + err.Error())
+	}
+	defer stmtUpdSender.Close()
+
+	_, err = stmtUpdSender.Exec(heldAmount(transaction), sqlTime, transaction.Sender.AccountNumber)
+	if err != nil {
+		return errors.New("payments.releaseSenderFunds: " + err.Error())
 	}
 	return
 }
 
-func processDepositInitiation(transaction PAINTrans, sqlTime int32, feeAmount decimal.Decimal) (err error) {
+func saveTransactionHold(db execer, transactionId int64, source string, score int, reasons string) (err error) {
+	insertStatement := "INSERT INTO transactions_holds (`transactionID`, `source`, `score`, `reasons`, `timestamp`) "
+	insertStatement += "VALUES(?, ?, ?, ?, ?)"
+	stmtIns, err := db.Prepare(insertStatement)
+	if err != nil {
+		return errors.New("payments.saveTransactionHold: " + err.Error())
+	}
+	defer stmtIns.Close()
+
+	t := time.Now()
+	sqlTime := int32(t.Unix())
+
+	_, err = stmtIns.Exec(transactionId, source, score, reasons, sqlTime)
+	if err != nil {
+		return errors.New("payments.saveTransactionHold: " + err.Error())
+	}
+	return
+}
+
+func processDepositInitiation(db execer, transaction PAINTrans, sqlTime int32, feeAmount decimal.Decimal) (err error) {
 	// We don't update sender as it is deposit
 	// Update receiver account
 	// The total received amount is the deposited amount minus the fee
@@ -184,7 +270,7 @@ func processDepositInitiation(transaction PAINTrans, sqlTime int32, feeAmount de
 	// Only update if account local
 	if transaction.Receiver.BankNumber == "" {
 		updateStatementReceiver := "UPDATE accounts SET `accountBalance` = (`accountBalance` + ?), `availableBalance` = (`availableBalance` + ?), `timestamp` = ? WHERE `accountNumber` = ? "
-		stmtUpdReceiver, err := Config.Db.Prepare(updateStatementReceiver)
+		stmtUpdReceiver, err := db.Prepare(updateStatementReceiver)
 		if err != nil {
 			return errors.New("payments.processDepositInitiation: " + err.Error())
 		}
@@ -202,7 +288,7 @@ func processDepositInitiation(transaction PAINTrans, sqlTime int32, feeAmount de
 }
 
 func getTransactionList(accountNumber string, offset int, perPage int) (allTransactions []PAINTrans, err error) {
-	rows, err := Config.Db.Query("SELECT `id`, `type`, `senderAccountNumber`, `senderBankNumber`, `receiverAccountNumber`, `receiverBankNumber`, `transactionAmount`, `feeAmount`, `desc`, `timestamp`, `status`, `geo` FROM `transactions` WHERE `senderAccountNumber` = ? OR `receiverAccountNumber` = ?  ORDER BY `id` DESC LIMIT ?, ?", accountNumber, accountNumber, offset, perPage)
+	rows, err := Config.Db.Query("SELECT `id`, `type`, `senderAccountNumber`, `senderBankNumber`, `receiverAccountNumber`, `receiverBankNumber`, `transactionAmount`, `feeAmount`, `desc`, `timestamp`, `valueDate`, `status`, `geo` FROM `transactions` WHERE `senderAccountNumber` = ? OR `receiverAccountNumber` = ?  ORDER BY `id` DESC LIMIT ?, ?", accountNumber, accountNumber, offset, perPage)
 	if err != nil {
 		return []PAINTrans{}, errors.New("transactions.ListTransactions: " + err.Error())
 	}
@@ -211,7 +297,7 @@ func getTransactionList(accountNumber string, offset int, perPage int) (allTrans
 	allTransactions = []PAINTrans{}
 	for rows.Next() {
 		transaction := PAINTrans{}
-		if err := rows.Scan(&transaction.ID, &transaction.PainType, &transaction.Sender.AccountNumber, &transaction.Sender.BankNumber, &transaction.Receiver.AccountNumber, &transaction.Receiver.BankNumber, &transaction.Amount, &transaction.Fee, &transaction.Desc, &transaction.Timestamp, &transaction.Status, &transaction.Geo); err != nil {
+		if err := rows.Scan(&transaction.ID, &transaction.PainType, &transaction.Sender.AccountNumber, &transaction.Sender.BankNumber, &transaction.Receiver.AccountNumber, &transaction.Receiver.BankNumber, &transaction.Amount, &transaction.Fee, &transaction.Desc, &transaction.Timestamp, &transaction.ValueDate, &transaction.Status, &transaction.Geo); err != nil {
 			return []PAINTrans{}, errors.New("transactions.ListTransactions: " + err.Error())
 		}
 		allTransactions = append(allTransactions, transaction)
@@ -221,7 +307,7 @@ func getTransactionList(accountNumber string, offset int, perPage int) (allTrans
 }
 
 func getTransactionListAfterTimestamp(accountNumber string, offset int, perPage int, timestamp int) (allTransactions []PAINTrans, err error) {
-	rows, err := Config.Db.Query("SELECT `id`, `type`, `senderAccountNumber`, `senderBankNumber`, `receiverAccountNumber`, `receiverBankNumber`, `transactionAmount`, `feeAmount`, `desc`, `timestamp`, `status`, `geo` FROM `transactions` WHERE `timestamp` >= ? AND ( `senderAccountNumber` = ? OR `receiverAccountNumber` = ? ) ORDER BY `id` DESC LIMIT ?, ?", timestamp, accountNumber, accountNumber, offset, perPage)
+	rows, err := Config.Db.Query("SELECT `id`, `type`, `senderAccountNumber`, `senderBankNumber`, `receiverAccountNumber`, `receiverBankNumber`, `transactionAmount`, `feeAmount`, `desc`, `timestamp`, `valueDate`, `status`, `geo` FROM `transactions` WHERE `timestamp` >= ? AND ( `senderAccountNumber` = ? OR `receiverAccountNumber` = ? ) ORDER BY `id` DESC LIMIT ?, ?", timestamp, accountNumber, accountNumber, offset, perPage)
 	if err != nil {
 		return []PAINTrans{}, errors.New("transactions.ListTransactions: " + err.Error())
 	}
@@ -230,7 +316,7 @@ func getTransactionListAfterTimestamp(accountNumber string, offset int, perPage
 	allTransactions = []PAINTrans{}
 	for rows.Next() {
 		transaction := PAINTrans{}
-		if err := rows.Scan(&transaction.ID, &transaction.PainType, &transaction.Sender.AccountNumber, &transaction.Sender.BankNumber, &transaction.Receiver.AccountNumber, &transaction.Receiver.BankNumber, &transaction.Amount, &transaction.Fee, &transaction.Desc, &transaction.Timestamp, &transaction.Status, &transaction.Geo); err != nil {
+		if err := rows.Scan(&transaction.ID, &transaction.PainType, &transaction.Sender.AccountNumber, &transaction.Sender.BankNumber, &transaction.Receiver.AccountNumber, &transaction.Receiver.BankNumber, &transaction.Amount, &transaction.Fee, &transaction.Desc, &transaction.Timestamp, &transaction.ValueDate, &transaction.Status, &transaction.Geo); err != nil {
 			return []PAINTrans{}, errors.New("transactions.ListTransactions: " + err.Error())
 		}
 		allTransactions = append(allTransactions, transaction)
@@ -238,3 +324,319 @@ func getTransactionListAfterTimestamp(accountNumber string, offset int, perPage
 
 	return
 }
+
+func getApprovedTransactionsSince(accountNumber string, timestamp int32) (allTransactions []PAINTrans, err error) {
+	rows, err := Config.Db.Query("SELECT `id`, `type`, `senderAccountNumber`, `senderBankNumber`, `receiverAccountNumber`, `receiverBankNumber`, `transactionAmount`, `feeAmount`, COALESCE(`desc`, ''), `timestamp`, `valueDate`, `status` FROM `transactions` WHERE `status` = 'approved' AND `timestamp` >= ? AND ( `senderAccountNumber` = ? OR `receiverAccountNumber` = ? ) ORDER BY `timestamp`, `id`", timestamp, accountNumber, accountNumber)
+	if err != nil {
+		return nil, errors.New("payments.getApprovedTransactionsSince: " + err.Error())
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		transaction := PAINTrans{}
+		if err := rows.Scan(&transaction.ID, &transaction.PainType, &transaction.Sender.AccountNumber, &transaction.Sender.BankNumber, &transaction.Receiver.AccountNumber, &transaction.Receiver.BankNumber, &transaction.Amount, &transaction.Fee, &transaction.Desc, &transaction.Timestamp, &transaction.ValueDate, &transaction.Status); err != nil {
+			return nil, errors.New("payments.getApprovedTransactionsSince: " + err.Error())
+		}
+		allTransactions = append(allTransactions, transaction)
+	}
+
+	return
+}
+
+func getTransaction(transactionID int64) (transaction PAINTrans, err error) {
+	var lat, lon float64
+	err = Config.Db.QueryRow("SELECT `id`, `type`, `senderAccountNumber`, `senderBankNumber`, `receiverAccountNumber`, `receiverBankNumber`, `transactionAmount`, `feeAmount`, COALESCE(`desc`, ''), `timestamp`, `valueDate`, `status`, COALESCE(X(`geo`), 0), COALESCE(Y(`geo`), 0) FROM `transactions` WHERE `id` = ?", transactionID).Scan(&transaction.ID, &transaction.PainType, &transaction.Sender.AccountNumber, &transaction.Sender.BankNumber, &transaction.Receiver.AccountNumber, &transaction.Receiver.BankNumber, &transaction.Amount, &transaction.Fee, &transaction.Desc, &transaction.Timestamp, &transaction.ValueDate, &transaction.Status, &lat, &lon)
+	switch {
+	case err == sql.ErrNoRows:
+		return PAINTrans{}, errors.New("payments.getTransaction: Transaction not found")
+	case err != nil:
+		return PAINTrans{}, errors.New("payments.getTransaction: " + err.Error())
+	}
+
+	transaction.Geo = *geo.NewPoint(lat, lon)
+	return
+}
+
+func getPendingTransactions() (pending []PendingTransaction, err error) {
+	rows, err := Config.Db.Query("SELECT t.`id`, t.`type`, t.`senderAccountNumber`, t.`senderBankNumber`, t.`receiverAccountNumber`, t.`receiverBankNumber`, t.`transactionAmount`, t.`feeAmount`, COALESCE(t.`desc`, ''), t.`timestamp`, t.`valueDate`, t.`status`, COALESCE(X(t.`geo`), 0), COALESCE(Y(t.`geo`), 0), COALESCE(s.`accountHolderName`, ''), COALESCE(r.`accountHolderName`, '') "+
+		"FROM `transactions` t LEFT JOIN `accounts` s ON s.`accountNumber` = t.`senderAccountNumber` LEFT JOIN `accounts` r ON r.`accountNumber` = t.`receiverAccountNumber` "+
+		"WHERE t.`status` = 'pending' ORDER BY t.`id`")
+	if err != nil {
+		return nil, errors.New("payments.getPendingTransactions: " + err.Error())
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		p := PendingTransaction{}
+		tr := &p.Transaction
+		if err := rows.Scan(&tr.ID, &tr.PainType, &tr.Sender.AccountNumber, &tr.Sender.BankNumber, &tr.Receiver.AccountNumber, &tr.Receiver.BankNumber, &tr.Amount, &tr.Fee, &tr.Desc, &tr.Timestamp, &tr.ValueDate, &tr.Status, &p.Lat, &p.Lon, &p.SenderName, &p.ReceiverName); err != nil {
+			return nil, errors.New("payments.getPendingTransactions: " + err.Error())
+		}
+		tr.Geo = *geo.NewPoint(p.Lat, p.Lon)
+		pending = append(pending, p)
+	}
+
+	return
+}
+
+func getTransactionHolds(transactionID int64) (holds []Hold, err error) {
+	rows, err := Config.Db.Query("SELECT `source`, `score`, `reasons`, `timestamp` FROM `transactions_holds` WHERE `transactionID` = ? ORDER BY `id`", transactionID)
+	if err != nil {
+		return nil, errors.New("payments.getTransactionHolds: " + err.Error())
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		hold := Hold{}
+		if err := rows.Scan(&hold.Source, &hold.Score, &hold.Reasons, &hold.Timestamp); err != nil {
+			return nil, errors.New("payments.getTransactionHolds: " + err.Error())
+		}
+		holds = append(holds, hold)
+	}
+
+	return
+}
+
+// setPendingTransactionStatus moves a pending transaction to status.
+// claimed is false if the transaction was no longer pending.
+func setPendingTransactionStatus(db execer, transactionID int64, status string) (claimed bool, err error) {
+	stmtUpd, err := db.Prepare("UPDATE `transactions` SET `status` = ? WHERE `id` = ? AND `status` = 'pending'")
+	if err != nil {
+		return false, errors.New("payments.setPendingTransactionStatus: " + err.Error())
+	}
+	defer stmtUpd.Close()
+
+	res, err := stmtUpd.Exec(status, transactionID)
+	if err != nil {
+		return false, errors.New("payments.setPendingTransactionStatus: " + err.Error())
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, errors.New("payments.setPendingTransactionStatus: " + err.Error())
+	}
+
+	claimed = affected == 1
+	return
+}
+
+func reviewTransactionHolds(db execer, transactionID int64, status string, comment string, reviewer string) (err error) {
+	updateStatement := "UPDATE transactions_holds SET `status` = ?, `comment` = ?, `reviewer` = ?, `reviewedTimestamp` = ? WHERE `transactionID` = ?"
+	stmtUpd, err := db.Prepare(updateStatement)
+	if err != nil {
+		return errors.New("payments.reviewTransactionHolds: " + err.Error())
+	}
+	defer stmtUpd.Close()
+
+	t := time.Now()
+	sqlTime := int32(t.Unix())
+
+	_, err = stmtUpd.Exec(status, comment, reviewer, sqlTime, transactionID)
+	if err != nil {
+		return errors.New("payments.reviewTransactionHolds: " + err.Error())
+	}
+	return
+}
+
+// saveBatch saves a batch with all of its lines, either everything is saved or nothing is
+func saveBatch(batch Batch) (id int64, err error) {
+	tx, err := Config.Db.Begin()
+	if err != nil {
+		return 0, errors.New("payments.saveBatch: " + err.Error())
+	}
+
+	t := time.Now()
+	sqlTime := int32(t.Unix())
+
+	res, err := tx.Exec("INSERT INTO transactions_batches (`senderAccountNumber`, `mode`, `status`, `lineCount`, `totalAmount`, `error`, `timestamp`) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		batch.SenderAccountNumber, batch.Mode, batch.Status, batch.LineCount, batch.TotalAmount, batch.Error, sqlTime)
+	if err != nil {
+		_ = tx.Rollback()
+		return 0, errors.New("payments.saveBatch: " + err.Error())
+	}
+	id, err = res.LastInsertId()
+	if err != nil {
+		_ = tx.Rollback()
+		return 0, errors.New("payments.saveBatch: " + err.Error())
+	}
+
+	stmtIns, err := tx.Prepare("INSERT INTO transactions_batches_lines (`batchID`, `line`, `receiverAccountNumber`, `receiverBankNumber`, `amount`, `desc`, `reference`, `status`, `error`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		_ = tx.Rollback()
+		return 0, errors.New("payments.saveBatch: " + err.Error())
+	}
+	defer stmtIns.Close()
+
+	for _, line := range batch.Lines {
+		_, err = stmtIns.Exec(id, line.Line, line.Receiver.AccountNumber, line.Receiver.BankNumber, line.Amount, line.Desc, line.Reference, line.Status, line.Error)
+		if err != nil {
+			_ = tx.Rollback()
+			return 0, errors.New("payments.saveBatch: " + err.Error())
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, errors.New("payments.saveBatch: " + err.Error())
+	}
+	return
+}
+
+// setBatchStatus moves a batch on, a batch that has finished gets its completed time set
+func setBatchStatus(batchID int64, status string, batchError string) (err error) {
+	completed := int32(0)
+	if status == BATCH_STATUS_COMPLETED || status == BATCH_STATUS_FAILED {
+		completed = int32(time.Now().Unix())
+	}
+
+	_, err = Config.Db.Exec("UPDATE transactions_batches SET `status` = ?, `error` = ?, `completedTimestamp` = ? WHERE `id` = ?", status, batchError, completed, batchID)
+	if err != nil {
+		return errors.New("payments.setBatchStatus: " + err.Error())
+	}
+	return
+}
+
+func updateBatchLine(batchID int64, line BatchLine) (err error) {
+	_, err = Config.Db.Exec("UPDATE transactions_batches_lines SET `status` = ?, `transactionID` = ?, `error` = ? WHERE `batchID` = ? AND `line` = ?", line.Status, line.TransactionID, line.Error, batchID, line.Line)
+	if err != nil {
+		return errors.New("payments.updateBatchLine: " + err.Error())
+	}
+	return
+}
+
+func getBatch(batchID int64) (batch Batch, err error) {
+	err = Config.Db.QueryRow("SELECT `id`, `senderAccountNumber`, `mode`, `status`, `lineCount`, `totalAmount`, `error`, `timestamp`, `completedTimestamp` FROM transactions_batches WHERE `id` = ?", batchID).Scan(&batch.ID, &batch.SenderAccountNumber, &batch.Mode, &batch.Status, &batch.LineCount, &batch.TotalAmount, &batch.Error, &batch.Timestamp, &batch.CompletedTimestamp)
+	switch {
+	case err == sql.ErrNoRows:
+		return Batch{}, errors.New("payments.getBatch: Batch not found")
+	case err != nil:
+		return Batch{}, errors.New("payments.getBatch: " + err.Error())
+	}
+	return
+}
+
+func getBatchLines(batchID int64) (lines []BatchLine, err error) {
+	rows, err := Config.Db.Query("SELECT `line`, `receiverAccountNumber`, `receiverBankNumber`, `amount`, `desc`, `reference`, `status`, `transactionID`, `error` FROM transactions_batches_lines WHERE `batchID` = ? ORDER BY `line`", batchID)
+	if err != nil {
+		return nil, errors.New("payments.getBatchLines: " + err.Error())
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		line := BatchLine{}
+		if err := rows.Scan(&line.Line, &line.Receiver.AccountNumber, &line.Receiver.BankNumber, &line.Amount, &line.Desc, &line.Reference, &line.Status, &line.TransactionID, &line.Error); err != nil {
+			return nil, errors.New("payments.getBatchLines: " + err.Error())
+		}
+		lines = append(lines, line)
+	}
+
+	return
+}
+
+// returnSenderFunds reverses an approved payment the receiving bank rejected. The sender
+// gets the amount and fee back. returned is false if the payment was no longer approved.
+func returnSenderFunds(db execer, transaction PAINTrans) (returned bool, err error) {
+	stmtUpdStatus, err := db.Prepare("UPDATE `transactions` SET `status` = 'rejected' WHERE `id` = ? AND `status` = 'approved'")
+	if err != nil {
+		return false, errors.New("payments.returnSenderFunds: " + err.Error())
+	}
+	defer stmtUpdStatus.Close()
+
+	res, err := stmtUpdStatus.Exec(transaction.ID)
+	if err != nil {
+		return false, errors.New("payments.returnSenderFunds: " + err.Error())
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, errors.New("payments.returnSenderFunds: " + err.Error())
+	}
+	if affected != 1 {
+		return false, nil
+	}
+
+	t := time.Now()
+	sqlTime := int32(t.Unix())
+
+	// Fees are stored as an amount
+	returnedAmount := transaction.Amount.Add(transaction.Fee)
+	updateSenderStatement := "UPDATE accounts SET `accountBalance` = (`accountBalance` + ?), `availableBalance` = (`availableBalance` + ?), `timestamp` = ? WHERE `accountNumber` = ? "
+	stmtUpdSender, err := db.Prepare(updateSenderStatement)
+	if err != nil {
+		return false, errors.New("payments.returnSenderFunds: " + err.Error())
+	}
+	defer stmtUpdSender.Close()
+
+	_, err = stmtUpdSender.Exec(returnedAmount, returnedAmount, sqlTime, transaction.Sender.AccountNumber)
+	if err != nil {
+		return false, errors.New("payments.returnSenderFunds: " + err.Error())
+	}
+
+	err = updateBankHoldingAccount(db, transaction.Fee.Neg(), sqlTime)
+	if err != nil {
+		return false, errors.New("payments.returnSenderFunds: " + err.Error())
+	}
+
+	returned = true
+	return
+}
+
+func getAccountBalances(tx *sql.Tx, lock string) (balances []accountBalances, err error) {
+	rows, err := tx.Query("SELECT `accountNumber`, `accountBalance`, `overdraft`, `availableBalance` FROM `accounts` ORDER BY `accountNumber`" + lock)
+	if err != nil {
+		return nil, errors.New("payments.getAccountBalances: " + err.Error())
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		b := accountBalances{}
+		if err := rows.Scan(&b.AccountNumber, &b.AccountBalance, &b.Overdraft, &b.AvailableBalance); err != nil {
+			return nil, errors.New("payments.getAccountBalances: " + err.Error())
+		}
+		balances = append(balances, b)
+	}
+	return
+}
+
+func getHoldingBalance(tx *sql.Tx, lock string) (balance decimal.Decimal, err error) {
+	err = tx.QueryRow("SELECT `balance` FROM `bank_account` WHERE `type` = 'holding'" + lock).Scan(&balance)
+	if err != nil {
+		return decimal.Zero, errors.New("payments.getHoldingBalance: " + err.Error())
+	}
+	return
+}
+
+// getLedger adds up every transaction that moved or holds a balance, with the fees charged
+func getLedger(tx *sql.Tx) (ledger map[string]*ledgerBalance, fees decimal.Decimal, err error) {
+	rows, err := tx.Query("SELECT `type`, `senderAccountNumber`, `senderBankNumber`, `receiverAccountNumber`, `receiverBankNumber`, `transactionAmount`, `feeAmount`, `status` FROM `transactions` WHERE `transaction` = 'pain' AND `status` IN ('approved', 'pending')")
+	if err != nil {
+		return nil, decimal.Zero, errors.New("payments.getLedger: " + err.Error())
+	}
+	defer rows.Close()
+
+	ledger = map[string]*ledgerBalance{}
+	fees = decimal.Zero
+	for rows.Next() {
+		transaction := PAINTrans{}
+		if err := rows.Scan(&transaction.PainType, &transaction.Sender.AccountNumber, &transaction.Sender.BankNumber, &transaction.Receiver.AccountNumber, &transaction.Receiver.BankNumber, &transaction.Amount, &transaction.Fee, &transaction.Status); err != nil {
+			return nil, decimal.Zero, errors.New("payments.getLedger: " + err.Error())
+		}
+		fees = fees.Add(applyToLedger(ledger, transaction))
+	}
+	return
+}
+
+func repairAccountBalance(tx *sql.Tx, discrepancy Discrepancy, sqlTime int32) (err error) {
+	_, err = tx.Exec("UPDATE `accounts` SET `accountBalance` = ?, `availableBalance` = ?, `timestamp` = ? WHERE `accountNumber` = ?", discrepancy.ExpectedAccountBalance, discrepancy.ExpectedAvailableBalance, sqlTime, discrepancy.AccountNumber)
+	if err != nil {
+		return errors.New("payments.repairAccountBalance: " + err.Error())
+	}
+	return
+}
+
+func repairHoldingBalance(tx *sql.Tx, balance decimal.Decimal, sqlTime int32) (err error) {
+	_, err = tx.Exec("UPDATE `bank_account` SET `balance` = ?, `timestamp` = ? WHERE `type` = 'holding'", balance, sqlTime)
+	if err != nil {
+		return errors.New("payments.repairHoldingBalance: " + err.Error())
+	}
+	return
+}
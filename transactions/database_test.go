@@ -25,9 +25,9 @@ func TestSavePainTransaction(t *testing.T) {
 	sender := AccountHolder{"accountNumSender", "bankNumSender"}
 	receiver := AccountHolder{"accountNumReceiver", "bankNumReceiver"}
 	p := geo.NewPoint(42.25, 120.2)
-	trans := PAINTrans{1, 101, sender, receiver, decimal.NewFromFloat(0.), decimal.NewFromFloat(0.), *p, "Test desc", "approved", 123123}
+	trans := PAINTrans{1, 101, sender, receiver, decimal.NewFromFloat(0.), decimal.NewFromFloat(0.), *p, "Test desc", "approved", 123123, ""}
 
-	id, err := savePainTransaction(trans)
+	id, err := savePainTransaction(Config.Db, trans)
 	if err != nil {
 		t.Errorf("DoSavePainTransaction does not pass. Looking for %v, got %v", nil, err)
 	}
@@ -50,9 +50,9 @@ func BenchmarkSavePainTransaction(b *testing.B) {
 		sender := AccountHolder{"accountNumSender", "bankNumSender"}
 		receiver := AccountHolder{"accountNumReceiver", "bankNumReceiver"}
 		p := geo.NewPoint(42.25, 120.2)
-		trans := PAINTrans{1, 101, sender, receiver, decimal.NewFromFloat(0.), decimal.NewFromFloat(0.), *p, "Test desc", "approved", 123123}
+		trans := PAINTrans{1, 101, sender, receiver, decimal.NewFromFloat(0.), decimal.NewFromFloat(0.), *p, "Test desc", "approved", 123123, ""}
 
-		_, _ = savePainTransaction(trans)
+		_, _ = savePainTransaction(Config.Db, trans)
 		_ = removePainTransaction(trans)
 	}
 }
@@ -64,7 +64,7 @@ func TestUpdateHoldingAccount(t *testing.T) {
 	ti := time.Now()
 	sqlTime := int32(ti.Unix())
 
-	err := updateBankHoldingAccount(decimal.NewFromFloat(0.), sqlTime)
+	err := updateBankHoldingAccount(Config.Db, decimal.NewFromFloat(0.), sqlTime)
 	if err != nil {
 		t.Errorf("DoUpdateHoldingAccount does not pass. Looking for %v, got %v", nil, err)
 	}
@@ -77,7 +77,7 @@ func BenchmarkUpdateHoldingAccount(b *testing.B) {
 	for n := 0; n < b.N; n++ {
 		ti := time.Now()
 		sqlTime := int32(ti.Unix())
-		_ = updateBankHoldingAccount(decimal.NewFromFloat(0.), sqlTime)
+		_ = updateBankHoldingAccount(Config.Db, decimal.NewFromFloat(0.), sqlTime)
 	}
 }
 
@@ -0,0 +1,330 @@
+package transactions
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bvnk/bank/money"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	OFX_HEADER    = `<?OFX OFXHEADER="200" VERSION="220" SECURITY="NONE" OLDFILEUID="NONE" NEWFILEUID="NONE"?>`
+	OFX_DATE      = "20060102150405"
+	QIF_DATE      = "01/02/2006"
+	MT940_DATE    = "060102"
+	MT940_LINE    = 65
+	MT940_MAX_86  = 6
+	EXPORT_ID_FEE = "-FEE"
+)
+
+// exportLine is one booking for accounting tools. Fees are split out of the
+// transaction they were charged on so they can be categorised separately.
+// ID is derived from the transaction ID so a re-import can skip lines it has already seen.
+type exportLine struct {
+	ID           string
+	Timestamp    time.Time
+	Amount       decimal.Decimal
+	Description  string
+	Counterparty string
+	PainType     int64
+	Fee          bool
+}
+
+func exportLines(transactions []PAINTrans, accountNumber string) (lines []exportLine) {
+	for _, transaction := range transactions {
+		id := strconv.Itoa(int(transaction.ID))
+		timestamp := time.Unix(int64(transaction.Timestamp), 0).In(location())
+
+		// The fee is paid by the sender of a payment, or taken off a deposit
+		paysFee := transaction.Sender.AccountNumber == accountNumber || transaction.PainType == 1000
+		fee := decimal.Zero
+		if paysFee {
+			fee = transaction.Fee
+		}
+		amount := accountMovement(transaction, accountNumber).Add(fee)
+
+		counterparty := transaction.Sender.AccountNumber
+		if transaction.Sender.AccountNumber == accountNumber {
+			counterparty = transaction.Receiver.AccountNumber
+		}
+		if transaction.PainType == 1000 {
+			counterparty = ""
+		}
+
+		lines = append(lines, exportLine{
+			ID:           id,
+			Timestamp:    timestamp,
+			Amount:       amount,
+			Description:  transaction.Desc,
+			Counterparty: counterparty,
+			PainType:     transaction.PainType,
+		})
+
+		if fee.Sign() != 0 {
+			lines = append(lines, exportLine{
+				ID:          id + EXPORT_ID_FEE,
+				Timestamp:   timestamp,
+				Amount:      fee.Neg(),
+				Description: "Fee for transaction " + id,
+				PainType:    transaction.PainType,
+				Fee:         true,
+			})
+		}
+	}
+	return
+}
+
+// OFX 2.2 bank statement response
+type ofxDocument struct {
+	XMLName xml.Name `xml:"OFX"`
+	SignOn  ofxSignOn
+	Bank    ofxBankMessages
+}
+
+type ofxSignOn struct {
+	XMLName  xml.Name  `xml:"SIGNONMSGSRSV1"`
+	Status   ofxStatus `xml:"SONRS>STATUS"`
+	DtServer string    `xml:"SONRS>DTSERVER"`
+	Language string    `xml:"SONRS>LANGUAGE"`
+}
+
+type ofxStatus struct {
+	Code     int    `xml:"CODE"`
+	Severity string `xml:"SEVERITY"`
+}
+
+type ofxBankMessages struct {
+	XMLName   xml.Name             `xml:"BANKMSGSRSV1"`
+	TrnUID    string               `xml:"STMTTRNRS>TRNUID"`
+	Status    ofxStatus            `xml:"STMTTRNRS>STATUS"`
+	Statement ofxStatementResponse `xml:"STMTTRNRS>STMTRS"`
+}
+
+type ofxStatementResponse struct {
+	CurDef       string             `xml:"CURDEF"`
+	BankAcctFrom ofxBankAccount     `xml:"BANKACCTFROM"`
+	TranList     ofxTransactionList `xml:"BANKTRANLIST"`
+	LedgerBal    ofxBalance         `xml:"LEDGERBAL"`
+}
+
+type ofxBankAccount struct {
+	BankID   string `xml:"BANKID"`
+	AcctID   string `xml:"ACCTID"`
+	AcctType string `xml:"ACCTTYPE"`
+}
+
+type ofxTransactionList struct {
+	DtStart      string           `xml:"DTSTART"`
+	DtEnd        string           `xml:"DTEND"`
+	Transactions []ofxTransaction `xml:"STMTTRN"`
+}
+
+type ofxTransaction struct {
+	TrnType  string `xml:"TRNTYPE"`
+	DtPosted string `xml:"DTPOSTED"`
+	TrnAmt   string `xml:"TRNAMT"`
+	FitID    string `xml:"FITID"`
+	Name     string `xml:"NAME,omitempty"`
+	Memo     string `xml:"MEMO,omitempty"`
+}
+
+type ofxBalance struct {
+	BalAmt string `xml:"BALAMT"`
+	DtAsOf string `xml:"DTASOF"`
+}
+
+func exportOFX(statement Statement) (content []byte, err error) {
+	document := ofxDocument{
+		SignOn: ofxSignOn{
+			Status:   ofxStatus{0, "INFO"},
+			DtServer: ofxDateTime(statement.Created),
+			Language: "ENG",
+		},
+		Bank: ofxBankMessages{
+			TrnUID: "0",
+			Status: ofxStatus{0, "INFO"},
+			Statement: ofxStatementResponse{
+				CurDef:       statement.Currency,
+				BankAcctFrom: ofxBankAccount{statement.BankNumber, statement.AccountNumber, "CHECKING"},
+				TranList: ofxTransactionList{
+					DtStart: ofxDateTime(statement.From),
+					DtEnd:   ofxDateTime(statement.To),
+				},
+				LedgerBal: ofxBalance{money.Format(statement.ClosingBalance), ofxDateTime(statement.To)},
+			},
+		},
+	}
+
+	for _, line := range exportLines(statement.Transactions, statement.AccountNumber) {
+		document.Bank.Statement.TranList.Transactions = append(document.Bank.Statement.TranList.Transactions, ofxTransaction{
+			TrnType:  ofxTransactionType(line),
+			DtPosted: ofxDateTime(line.Timestamp),
+			TrnAmt:   money.Format(line.Amount),
+			FitID:    line.ID,
+			Name:     truncate(exportPayee(line), 32),
+			Memo:     truncate(line.Description, 255),
+		})
+	}
+
+	body, err := xml.MarshalIndent(document, "", "  ")
+	if err != nil {
+		return nil, errors.New("payments.exportOFX: " + err.Error())
+	}
+
+	content = []byte(`<?xml version="1.0" encoding="UTF-8" standalone="no"?>` + "\n" + OFX_HEADER + "\n")
+	content = append(content, body...)
+	return
+}
+
+func ofxTransactionType(line exportLine) string {
+	switch {
+	case line.Fee:
+		return "FEE"
+	case line.PainType == 1000:
+		return "DEP"
+	case line.Amount.Sign() < 0:
+		return "DEBIT"
+	default:
+		return "CREDIT"
+	}
+}
+
+// OFX dates are given in UTC so importers do not need to parse the zone name
+func ofxDateTime(t time.Time) string {
+	return t.UTC().Format(OFX_DATE) + ".000[0:GMT]"
+}
+
+// exportQIF writes the bank account type of the Quicken Interchange Format.
+// QIF has no transaction ID, the N (number) field is used by importers instead.
+func exportQIF(statement Statement) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("!Type:Bank\n")
+	for _, line := range exportLines(statement.Transactions, statement.AccountNumber) {
+		fmt.Fprintf(&buf, "D%s\n", line.Timestamp.Format(QIF_DATE))
+		fmt.Fprintf(&buf, "T%s\n", money.Format(line.Amount))
+		fmt.Fprintf(&buf, "N%s\n", line.ID)
+		if payee := exportPayee(line); payee != "" {
+			fmt.Fprintf(&buf, "P%s\n", qifText(payee))
+		}
+		if line.Description != "" {
+			fmt.Fprintf(&buf, "M%s\n", qifText(line.Description))
+		}
+		if line.Fee {
+			buf.WriteString("LBank Charges\n")
+		}
+		buf.WriteString("^\n")
+	}
+	return buf.Bytes()
+}
+
+// Every QIF field is a single line
+func qifText(s string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(s)
+}
+
+// exportMT940 writes a SWIFT MT940 customer statement, the message text
+// block only, as accepted by most accounting tools.
+func exportMT940(statement Statement) []byte {
+	var buf bytes.Buffer
+	writeField := func(tag string, value string) {
+		buf.WriteString(":" + tag + ":" + value + "\r\n")
+	}
+
+	writeField("20", mt940Text(truncate("STMT"+statement.From.Format(MT940_DATE), 16)))
+	writeField("25", mt940Account(statement.AccountNumber))
+	writeField("28C", "1")
+	writeField("60F", mt940Balance(statement.OpeningBalance, statement.From, statement.Currency))
+
+	for _, line := range exportLines(statement.Transactions, statement.AccountNumber) {
+		mark, amount := mt940Amount(line.Amount)
+		code := "NTRF"
+		if line.Fee {
+			code = "NCHG"
+		} else if line.PainType == 1000 {
+			code = "NMSC"
+		}
+		writeField("61", line.Timestamp.Format(MT940_DATE)+line.Timestamp.Format("0102")+mark+amount+code+mt940Text(line.ID))
+
+		details := line.Description
+		if line.Counterparty != "" {
+			details = strings.TrimSpace(line.Counterparty + " " + details)
+		}
+		if details != "" {
+			writeField("86", mt940Lines(mt940Text(details)))
+		}
+	}
+
+	writeField("62F", mt940Balance(statement.ClosingBalance, statement.To.AddDate(0, 0, -1), statement.Currency))
+	buf.WriteString("-\r\n")
+	return buf.Bytes()
+}
+
+// mt940Amount gives the debit or credit mark and the amount with a decimal comma
+func mt940Amount(amount decimal.Decimal) (mark string, value string) {
+	mark = "C"
+	if amount.Sign() < 0 {
+		mark = "D"
+		amount = amount.Neg()
+	}
+	value = strings.Replace(money.Format(amount), ".", ",", 1)
+	return
+}
+
+func mt940Balance(balance decimal.Decimal, date time.Time, currency string) string {
+	mark, amount := mt940Amount(balance)
+	return mark + date.Format(MT940_DATE) + currency + amount
+}
+
+// Account identification is at most 35 characters, a UUID is shortened by dropping its dashes
+func mt940Account(accountNumber string) string {
+	if len(accountNumber) > 35 {
+		accountNumber = strings.Replace(accountNumber, "-", "", -1)
+	}
+	return truncate(mt940Text(accountNumber), 35)
+}
+
+// mt940Text replaces anything outside the SWIFT X character set
+func mt940Text(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		case strings.ContainsRune("/-?:().,'+ ", r):
+			return r
+		default:
+			return '.'
+		}
+	}, s)
+}
+
+// mt940Lines wraps narrative into at most 6 lines of 65 characters
+func mt940Lines(s string) string {
+	lines := []string{}
+	for len(s) > 0 && len(lines) < MT940_MAX_86 {
+		end := MT940_LINE
+		if len(s) < end {
+			end = len(s)
+		}
+		lines = append(lines, s[:end])
+		s = s[end:]
+	}
+	return strings.Join(lines, "\r\n")
+}
+
+// exportPayee is who the money went to or came from
+func exportPayee(line exportLine) string {
+	switch {
+	case line.Fee:
+		return "Bank charges"
+	case line.PainType == 1000:
+		return "Deposit"
+	default:
+		return line.Counterparty
+	}
+}
@@ -0,0 +1,129 @@
+package transactions
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestExportLines(t *testing.T) {
+	lines := exportLines(statementTestTransactions(), "a")
+
+	// Deposit and payment have a fee line, the received payment does not
+	tests := []struct {
+		id     string
+		amount float64
+		fee    bool
+	}{
+		{"1", 100, false},
+		{"1" + EXPORT_ID_FEE, -1, true},
+		{"2", -50, false},
+		{"2" + EXPORT_ID_FEE, -0.5, true},
+		{"3", 20, false},
+	}
+
+	if len(lines) != len(tests) {
+		t.Fatalf("ExportLines does not pass. Looking for %v, got %v", len(tests), len(lines))
+	}
+	for i, test := range tests {
+		if lines[i].ID != test.id || !lines[i].Amount.Equals(decimal.NewFromFloat(test.amount)) || lines[i].Fee != test.fee {
+			t.Errorf("ExportLines does not pass. Looking for %v, got %v", test, lines[i])
+		}
+	}
+
+	// Lines add up to the same movement as the statement
+	total := decimal.Zero
+	for _, line := range lines {
+		total = total.Add(line.Amount)
+	}
+	if !total.Equals(decimal.NewFromFloat(68.5)) {
+		t.Errorf("ExportLines total does not pass. Looking for %v, got %v", 68.5, total)
+	}
+}
+
+func TestExportOFX(t *testing.T) {
+	content, err := exportOFX(testStatement())
+	if err != nil {
+		t.Fatalf("ExportOFX does not pass. Looking for %v, got %v", nil, err)
+	}
+	if !bytes.Contains(content, []byte(`OFXHEADER="200"`)) {
+		t.Errorf("ExportOFX header does not pass. Looking for %v, got %v", `OFXHEADER="200"`, nil)
+	}
+
+	document := ofxDocument{}
+	err = xml.Unmarshal(content, &document)
+	if err != nil {
+		t.Fatalf("ExportOFX unmarshal does not pass. Looking for %v, got %v", nil, err)
+	}
+
+	statement := document.Bank.Statement
+	if statement.BankAcctFrom.AcctID != "a" {
+		t.Errorf("ExportOFX account does not pass. Looking for %v, got %v", "a", statement.BankAcctFrom.AcctID)
+	}
+	if len(statement.TranList.Transactions) != 5 {
+		t.Fatalf("ExportOFX transactions does not pass. Looking for %v, got %v", 5, len(statement.TranList.Transactions))
+	}
+	fee := statement.TranList.Transactions[3]
+	if fee.TrnType != "FEE" || fee.TrnAmt != "-0.50" || fee.FitID != "2"+EXPORT_ID_FEE {
+		t.Errorf("ExportOFX fee does not pass. Looking for %v, got %v", "FEE -0.50", fee)
+	}
+	if statement.TranList.Transactions[2].Memo != "Rent" {
+		t.Errorf("ExportOFX memo does not pass. Looking for %v, got %v", "Rent", statement.TranList.Transactions[2].Memo)
+	}
+	if statement.LedgerBal.BalAmt != "78.50" {
+		t.Errorf("ExportOFX ledger balance does not pass. Looking for %v, got %v", "78.50", statement.LedgerBal.BalAmt)
+	}
+}
+
+func TestExportQIF(t *testing.T) {
+	content := string(exportQIF(testStatement()))
+
+	if !strings.HasPrefix(content, "!Type:Bank\n") {
+		t.Errorf("ExportQIF header does not pass. Looking for %v, got %v", "!Type:Bank", content)
+	}
+	if strings.Count(content, "^\n") != 5 {
+		t.Errorf("ExportQIF records does not pass. Looking for %v, got %v", 5, strings.Count(content, "^\n"))
+	}
+	if !strings.Contains(content, "T-50.00\nN2\nPb\nMRent\n^\n") {
+		t.Errorf("ExportQIF payment does not pass. Looking for %v, got %v", "T-50.00 N2 Pb MRent", content)
+	}
+}
+
+func TestExportMT940(t *testing.T) {
+	content := string(exportMT940(testStatement()))
+
+	for _, field := range []string{":20:STMT151201\r\n", ":25:a\r\n", ":60F:C151201USD10,00\r\n", ":62F:C151231USD78,50\r\n"} {
+		if !strings.Contains(content, field) {
+			t.Errorf("ExportMT940 field does not pass. Looking for %v, got %v", field, content)
+		}
+	}
+	if strings.Count(content, ":61:") != 5 {
+		t.Errorf("ExportMT940 entries does not pass. Looking for %v, got %v", 5, strings.Count(content, ":61:"))
+	}
+	if !strings.Contains(content, "D0,50NCHG2-FEE\r\n") {
+		t.Errorf("ExportMT940 fee does not pass. Looking for %v, got %v", "D0,50NCHG2-FEE", content)
+	}
+	if !strings.HasSuffix(content, "\r\n-\r\n") {
+		t.Errorf("ExportMT940 end does not pass. Looking for %v, got %v", "-", content)
+	}
+}
+
+func TestMT940Text(t *testing.T) {
+	text := mt940Text("Café & co_1")
+	if text != "Caf. . co.1" {
+		t.Errorf("MT940Text does not pass. Looking for %v, got %v", "Caf. . co.1", text)
+	}
+
+	lines := strings.Split(mt940Lines(strings.Repeat("x", 500)), "\r\n")
+	if len(lines) != MT940_MAX_86 || len(lines[0]) != MT940_LINE {
+		t.Errorf("MT940Lines does not pass. Looking for %v, got %v", MT940_MAX_86, len(lines))
+	}
+
+	account := mt940Account("a0299975-b8e2-4358-8f1a-911ee12dbaac")
+	if account != "a0299975b8e243588f1a911ee12dbaac" {
+		t.Errorf("MT940Account does not pass. Looking for %v, got %v", "a0299975b8e243588f1a911ee12dbaac", account)
+	}
+}
@@ -0,0 +1,340 @@
+package transactions
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/bvnk/bank/accounts"
+	"github.com/bvnk/bank/interbank"
+	"github.com/bvnk/bank/money"
+	"github.com/bvnk/bank/push"
+	"github.com/bvnk/bank/sanctions"
+	"github.com/paulmach/go.geo"
+	"github.com/shopspring/decimal"
+)
+
+const INTERBANK_MESSAGE_PREFIX = "PACS008-"
+
+// queueInterbankPayment hands a payment to an account in another bank over as a
+// pacs.008 message. The sender has already been debited.
+func queueInterbankPayment(db execer, transaction PAINTrans) (err error) {
+	sender, err := accounts.GetAccountByAccountNumber(transaction.Sender.AccountNumber)
+	if err != nil {
+		return errors.New("payments.queueInterbankPayment: " + err.Error())
+	}
+
+	transactionID := strconv.FormatInt(int64(transaction.ID), 10)
+	messageID := INTERBANK_MESSAGE_PREFIX + transactionID
+	message, err := interbank.CreditTransfer008(messageID, accounts.LocalBankNumber(), []interbank.Transfer{
+		interbank.Transfer{
+			TransactionID:   transactionID,
+			Amount:          transaction.Amount,
+			Currency:        currency(),
+			DebtorName:      sender.AccountHolderName,
+			DebtorAccount:   transaction.Sender.AccountNumber,
+			DebtorBank:      accounts.LocalBankNumber(),
+			CreditorAccount: transaction.Receiver.AccountNumber,
+			CreditorBank:    transaction.Receiver.BankNumber,
+			Description:     transaction.Desc,
+		},
+	}, time.Now())
+	if err != nil {
+		return errors.New("payments.queueInterbankPayment: " + err.Error())
+	}
+
+	_, err = interbank.QueuePayment(db, interbank.Payment{
+		TransactionID:       int64(transaction.ID),
+		BankNumber:          transaction.Receiver.BankNumber,
+		SenderAccountNumber: transaction.Sender.AccountNumber,
+		MessageID:           messageID,
+		Message:             string(message),
+	})
+	if err != nil {
+		return errors.New("payments.queueInterbankPayment: " + err.Error())
+	}
+
+	err = interbank.PostMovement(db, interbank.PaymentOut(int64(transaction.ID), transaction.Receiver.BankNumber, transaction.Amount))
+	if err != nil {
+		return errors.New("payments.queueInterbankPayment: " + err.Error())
+	}
+	return
+}
+
+// RunInterbank delivers queued payments to other banks for as long as the process runs
+func RunInterbank() {
+	if len(Config.Peers) == 0 {
+		return
+	}
+
+	for {
+		deliverInterbankPayments()
+		time.Sleep(interbank.POLL_INTERVAL)
+	}
+}
+
+func deliverInterbankPayments() {
+	payments, err := interbank.DuePayments()
+	if err != nil {
+		return
+	}
+
+	for _, payment := range payments {
+		// A payment that could not be delivered is retried on a later run
+		_ = deliverInterbankPayment(payment)
+	}
+}
+
+// deliverInterbankPayment sends a queued payment and acts on the receiving bank's
+// pacs.002. Rejected payments are returned to the sender.
+func deliverInterbankPayment(payment interbank.Payment) (err error) {
+	claimed, err := interbank.ClaimPayment(payment.ID)
+	if err != nil {
+		return errors.New("payments.deliverInterbankPayment: " + err.Error())
+	}
+	if !claimed {
+		return
+	}
+
+	reply, err := interbank.Send(payment.BankNumber, []byte(payment.Message))
+	if err != nil {
+		_ = interbank.RetryPaymentLater(payment, err.Error())
+		return errors.New("payments.deliverInterbankPayment: " + err.Error())
+	}
+
+	status, reason, err := interbankPaymentStatus(reply, strconv.FormatInt(payment.TransactionID, 10))
+	if err != nil {
+		_ = interbank.RetryPaymentLater(payment, err.Error())
+		return errors.New("payments.deliverInterbankPayment: " + err.Error())
+	}
+
+	switch status {
+	case interbank.STATUS_SETTLED:
+		err = interbank.CompletePayment(payment, interbank.PAYMENT_STATUS_ACCEPTED, "")
+	case interbank.STATUS_REJECTED:
+		var returned bool
+		returned, err = returnInterbankPayment(payment.TransactionID)
+		if err != nil {
+			_ = interbank.RetryPaymentLater(payment, err.Error())
+			return errors.New("payments.deliverInterbankPayment: " + err.Error())
+		}
+		err = interbank.CompletePayment(payment, interbank.PAYMENT_STATUS_REJECTED, reason)
+		if returned {
+			go push.SendNotification(payment.SenderAccountNumber, "↩️ Payment returned by the receiving bank", 1, "default")
+		}
+	default:
+		// The receiving bank has not settled yet, it answers the same message again later
+		err = interbank.RetryPaymentLater(payment, "Status "+status)
+	}
+	if err != nil {
+		return errors.New("payments.deliverInterbankPayment: " + err.Error())
+	}
+	return
+}
+
+// interbankPaymentStatus finds what the receiving bank did with a transaction in its pacs.002
+func interbankPaymentStatus(reply []byte, transactionID string) (status string, reason string, err error) {
+	groupStatus, groupReason, statuses, err := interbank.ParsePacs002(reply)
+	if err != nil {
+		return "", "", errors.New("payments.interbankPaymentStatus: " + err.Error())
+	}
+
+	for _, s := range statuses {
+		if s.TransactionID == transactionID {
+			reason = s.Reason
+			if s.Info != "" {
+				reason += " " + s.Info
+			}
+			return s.Status, reason, nil
+		}
+	}
+
+	// A whole message is only settled or rejected without transaction statuses
+	if len(statuses) == 0 && (groupStatus == interbank.STATUS_SETTLED || groupStatus == interbank.STATUS_REJECTED) {
+		return groupStatus, groupReason, nil
+	}
+	return "", "", errors.New("payments.interbankPaymentStatus: No status for transaction " + transactionID)
+}
+
+// returnInterbankPayment gives the sender back a payment the receiving bank rejected.
+// returned is false if the payment had already been returned.
+func returnInterbankPayment(transactionID int64) (returned bool, err error) {
+	transaction, err := getTransaction(transactionID)
+	if err != nil {
+		return false, errors.New("payments.returnInterbankPayment: " + err.Error())
+	}
+
+	tx, err := Config.Db.Begin()
+	if err != nil {
+		return false, errors.New("payments.returnInterbankPayment: " + err.Error())
+	}
+
+	returned, err = returnSenderFunds(tx, transaction)
+	if err == nil && returned {
+		err = interbank.PostMovement(tx, interbank.PaymentReturned(transactionID, transaction.Receiver.BankNumber, transaction.Amount))
+	}
+	if err != nil || !returned {
+		_ = tx.Rollback()
+		if err != nil {
+			return false, errors.New("payments.returnInterbankPayment: " + err.Error())
+		}
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return false, errors.New("payments.returnInterbankPayment: " + err.Error())
+	}
+	return
+}
+
+// ProcessPacs008 credits the transfers another bank sends in a pacs.008 and
+// replies with a pacs.002. A transfer that was already received is answered
+// the same way again without being credited twice.
+func ProcessPacs008(bankNumber string, secret string, content []byte) (reply []byte, err error) {
+	err = interbank.CheckPeer(bankNumber, secret)
+	if err != nil {
+		return nil, errors.New("payments.ProcessPacs008: " + err.Error())
+	}
+
+	messageID, transfers, err := interbank.ParsePacs008(content)
+	if err != nil {
+		// A message without an ID cannot be answered
+		if messageID == "" {
+			return nil, errors.New("payments.ProcessPacs008: " + err.Error())
+		}
+		reply, err = interbank.StatusReport002(messageID, interbank.REASON_FORMAT, err.Error(), nil, time.Now())
+		if err != nil {
+			return nil, errors.New("payments.ProcessPacs008: " + err.Error())
+		}
+		return
+	}
+
+	statuses := []interbank.Status{}
+	for _, transfer := range transfers {
+		status, err := receiveInterbankTransfer(bankNumber, messageID, transfer)
+		if err != nil {
+			// Nothing is answered so the sending bank tries again
+			return nil, errors.New("payments.ProcessPacs008: " + err.Error())
+		}
+		statuses = append(statuses, status)
+	}
+
+	reply, err = interbank.StatusReport002(messageID, "", "", statuses, time.Now())
+	if err != nil {
+		return nil, errors.New("payments.ProcessPacs008: " + err.Error())
+	}
+	return
+}
+
+// receiveInterbankTransfer credits one transfer from another bank
+func receiveInterbankTransfer(bankNumber string, messageID string, transfer interbank.Transfer) (status interbank.Status, err error) {
+	received, found, err := interbank.GetReceived(bankNumber, transfer.TransactionID)
+	if err != nil {
+		return interbank.Status{}, errors.New("payments.receiveInterbankTransfer: " + err.Error())
+	}
+	if found {
+		return interbank.Status{TransactionID: received.TxID, Status: received.Status, Reason: received.Reason, Info: received.Info}, nil
+	}
+
+	received = interbank.Received{
+		BankNumber: bankNumber,
+		MessageID:  messageID,
+		TxID:       transfer.TransactionID,
+		Status:     interbank.STATUS_SETTLED,
+	}
+
+	received.Reason, received.Info = checkInterbankTransfer(bankNumber, transfer)
+	if received.Reason != "" {
+		received.Status = interbank.STATUS_REJECTED
+		err = interbank.SaveReceived(Config.Db, received)
+		if err != nil {
+			return interbank.Status{}, errors.New("payments.receiveInterbankTransfer: " + err.Error())
+		}
+		return interbank.Status{TransactionID: received.TxID, Status: received.Status, Reason: received.Reason, Info: received.Info}, nil
+	}
+
+	// The sending bank takes its own fee, nothing is charged on receipt
+	transaction := PAINTrans{
+		PainType: 1,
+		Sender:   AccountHolder{transfer.DebtorAccount, bankNumber},
+		Receiver: AccountHolder{transfer.CreditorAccount, ""},
+		Amount:   transfer.Amount,
+		Fee:      decimal.Zero,
+		Geo:      *geo.NewPoint(0, 0),
+		Desc:     transfer.Description,
+		Status:   "approved",
+	}
+
+	tx, err := Config.Db.Begin()
+	if err != nil {
+		return interbank.Status{}, errors.New("payments.receiveInterbankTransfer: " + err.Error())
+	}
+
+	received.TransactionID, err = saveCreditTransfer(tx, transaction, nil)
+	if err == nil {
+		err = interbank.SaveReceived(tx, received)
+	}
+	if err == nil {
+		err = interbank.PostMovement(tx, interbank.PaymentIn(received.TransactionID, bankNumber, transfer.Amount))
+	}
+	if err != nil {
+		_ = tx.Rollback()
+		return interbank.Status{}, errors.New("payments.receiveInterbankTransfer: " + err.Error())
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return interbank.Status{}, errors.New("payments.receiveInterbankTransfer: " + err.Error())
+	}
+
+	monitorCreditTransfer(received.TransactionID, transaction)
+	go push.SendNotification(transaction.Receiver.AccountNumber, "💸 Payment received!", 1, "default")
+
+	return interbank.Status{TransactionID: received.TxID, Status: received.Status}, nil
+}
+
+// checkInterbankTransfer gives the reason a transfer from bankNumber cannot be credited,
+// or an empty reason if it can
+func checkInterbankTransfer(bankNumber string, transfer interbank.Transfer) (reason string, info string) {
+	switch {
+	case transfer.DebtorBank != bankNumber:
+		return interbank.REASON_AGENT, "Debtor agent is not the sending bank"
+	case transfer.CreditorBank == "" || localBankNumber(transfer.CreditorBank) != "":
+		return interbank.REASON_AGENT, "Creditor agent is not this bank"
+	case transfer.Currency != currency():
+		return interbank.REASON_CURRENCY, "Currency must be " + currency()
+	case transfer.Amount.Sign() <= 0:
+		return interbank.REASON_AMOUNT, "Amount must be more than zero"
+	case !money.Valid(transfer.Amount):
+		return interbank.REASON_AMOUNT, "Amount has more than " + strconv.Itoa(money.SCALE) + " decimal places"
+	case transfer.CreditorAccount == "":
+		return interbank.REASON_ACCOUNT, "Creditor account is required"
+	}
+
+	if _, err := accounts.GetAccountByAccountNumber(transfer.CreditorAccount); err != nil {
+		return interbank.REASON_ACCOUNT, "Creditor account not found"
+	}
+	if err := accounts.CheckAccountActive(transfer.CreditorAccount); err != nil {
+		return interbank.REASON_BLOCKED, "Creditor account is not active"
+	}
+
+	screening, err := sanctions.Screen(transfer.DebtorName)
+	if err != nil {
+		return interbank.REASON_NARRATIVE, "Could not screen debtor"
+	}
+	return checkInterbankScreening(screening)
+}
+
+// checkInterbankScreening rejects a transfer whose debtor is blocked by
+// sanctions screening or needs review. The transfer is settled as soon as it
+// is accepted, so one cannot be held for review and given back after.
+func checkInterbankScreening(screening sanctions.Result) (reason string, info string) {
+	switch screening.Action {
+	case sanctions.ACTION_BLOCK:
+		return interbank.REASON_REGULATORY, "Debtor blocked by sanctions screening"
+	case sanctions.ACTION_REVIEW:
+		return interbank.REASON_REGULATORY, "Debtor needs sanctions review"
+	}
+	return
+}
@@ -0,0 +1,87 @@
+package transactions
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bvnk/bank/accounts"
+	"github.com/bvnk/bank/interbank"
+	"github.com/bvnk/bank/sanctions"
+	"github.com/shopspring/decimal"
+)
+
+func TestInterbankPaymentStatus(t *testing.T) {
+	reply, _ := interbank.StatusReport002("PACS008-11", "", "", []interbank.Status{
+		{TransactionID: "11", Status: interbank.STATUS_REJECTED, Reason: interbank.REASON_ACCOUNT, Info: "Creditor account not found"},
+	}, time.Now())
+
+	status, reason, err := interbankPaymentStatus(reply, "11")
+	if err != nil || status != interbank.STATUS_REJECTED || reason != "AC01 Creditor account not found" {
+		t.Errorf("InterbankPaymentStatus does not pass. Looking for %v, got %v %v %v", "RJCT AC01", status, reason, err)
+	}
+
+	_, _, err = interbankPaymentStatus(reply, "12")
+	if err == nil {
+		t.Errorf("InterbankPaymentStatus missing does not pass. Looking for %v, got %v", "error", nil)
+	}
+
+	// A rejected message rejects every payment in it
+	reply, _ = interbank.StatusReport002("PACS008-11", interbank.REASON_FORMAT, "MsgId is missing", nil, time.Now())
+	status, _, err = interbankPaymentStatus(reply, "11")
+	if err != nil || status != interbank.STATUS_REJECTED {
+		t.Errorf("InterbankPaymentStatus group does not pass. Looking for %v, got %v %v", "RJCT", status, err)
+	}
+}
+
+func TestCheckInterbankTransfer(t *testing.T) {
+	valid := interbank.Transfer{
+		TransactionID:   "11",
+		Amount:          decimal.NewFromFloat(10),
+		Currency:        currency(),
+		DebtorAccount:   "sender",
+		DebtorBank:      "bank-a",
+		CreditorAccount: "receiver",
+		CreditorBank:    accounts.LocalBankNumber(),
+	}
+
+	tests := []struct {
+		change func(*interbank.Transfer)
+		reason string
+	}{
+		{func(tr *interbank.Transfer) { tr.DebtorBank = "bank-c" }, interbank.REASON_AGENT},
+		{func(tr *interbank.Transfer) { tr.CreditorBank = "" }, interbank.REASON_AGENT},
+		{func(tr *interbank.Transfer) { tr.CreditorBank = "bank-c" }, interbank.REASON_AGENT},
+		{func(tr *interbank.Transfer) { tr.Currency = "XXX" }, interbank.REASON_CURRENCY},
+		{func(tr *interbank.Transfer) { tr.Amount = decimal.Zero }, interbank.REASON_AMOUNT},
+		{func(tr *interbank.Transfer) { tr.Amount = decimal.NewFromFloat(-10) }, interbank.REASON_AMOUNT},
+		{func(tr *interbank.Transfer) { tr.Amount, _ = decimal.NewFromString("10.0000001") }, interbank.REASON_AMOUNT},
+		{func(tr *interbank.Transfer) { tr.CreditorAccount = "" }, interbank.REASON_ACCOUNT},
+	}
+
+	for _, test := range tests {
+		transfer := valid
+		test.change(&transfer)
+		reason, info := checkInterbankTransfer("bank-a", transfer)
+		if reason != test.reason {
+			t.Errorf("CheckInterbankTransfer does not pass. Looking for %v, got %v (%v)", test.reason, reason, info)
+		}
+	}
+}
+
+func TestCheckInterbankScreening(t *testing.T) {
+	reason, _ := checkInterbankScreening(sanctions.Result{Action: sanctions.ACTION_BLOCK})
+	if reason != interbank.REASON_REGULATORY {
+		t.Errorf("CheckInterbankScreening block does not pass. Looking for %v, got %v", interbank.REASON_REGULATORY, reason)
+	}
+
+	// The transfer is settled once accepted, a debtor needing review is not held but rejected
+	reason, _ = checkInterbankScreening(sanctions.Result{Action: sanctions.ACTION_REVIEW, Name: "Jon Smith", MatchedName: "John Smith", EntryID: "SDN-1", Score: 0.9})
+	if reason != interbank.REASON_REGULATORY {
+		t.Errorf("CheckInterbankScreening review does not pass. Looking for %v, got %v", interbank.REASON_REGULATORY, reason)
+	}
+
+	reason, _ = checkInterbankScreening(sanctions.Result{Action: sanctions.ACTION_ALLOW})
+	if reason != "" {
+		t.Errorf("CheckInterbankScreening allow does not pass. Looking for %v, got %v", "", reason)
+	}
+}
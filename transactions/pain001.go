@@ -0,0 +1,424 @@
+package transactions
+
+import (
+	"encoding/xml"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bvnk/bank/accounts"
+	"github.com/bvnk/bank/appauth"
+	"github.com/paulmach/go.geo"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	PAIN_001_NAMESPACE_PREFIX = "urn:iso:std:iso:20022:tech:xsd:pain.001.001."
+	PAIN_002_NAMESPACE        = "urn:iso:std:iso:20022:tech:xsd:pain.002.001.06"
+	PAIN_001_MAX_TRANSACTIONS = 10000
+
+	// Transaction and group statuses used in pain.002
+	PAIN_STATUS_ACCEPTED  = "ACCP"
+	PAIN_STATUS_SETTLED   = "ACSC"
+	PAIN_STATUS_PENDING   = "PDNG"
+	PAIN_STATUS_PARTIAL   = "PART"
+	PAIN_STATUS_REJECTED  = "RJCT"
+	PAIN_REASON_FORMAT    = "FF01"
+	PAIN_REASON_AMOUNT    = "AM12"
+	PAIN_REASON_CURRENCY  = "AM03"
+	PAIN_REASON_ACCOUNT   = "AC01"
+	PAIN_REASON_DUPLICATE = "AM05"
+	PAIN_REASON_NARRATIVE = "NARR"
+
+	PAIN_ADDITIONAL_INFO_LENGTH = 105
+)
+
+// pain.001 CustomerCreditTransferInitiation. Only the elements used to make
+// payments are read, the rest of the file is ignored.
+type pain001Document struct {
+	XMLName    xml.Name
+	Initiation pain001Initiation `xml:"CstmrCdtTrfInitn"`
+}
+
+type pain001Initiation struct {
+	GrpHdr  pain001GroupHeader
+	PmtInfs []pain001PaymentInformation `xml:"PmtInf"`
+}
+
+type pain001GroupHeader struct {
+	MsgId   string
+	CreDtTm string
+	NbOfTxs string
+	CtrlSum string
+}
+
+type pain001PaymentInformation struct {
+	PmtInfId    string
+	PmtMtd      string
+	NbOfTxs     string
+	CtrlSum     string
+	DbtrAcct    pain001Account
+	DbtrAgt     pain001Agent
+	CdtTrfTxInf []pain001Transaction
+}
+
+type pain001Transaction struct {
+	PmtId    pain001PaymentId
+	Amt      pain001Amount
+	CdtrAgt  pain001Agent
+	CdtrAcct pain001Account
+	RmtInf   pain001Remittance
+}
+
+type pain001PaymentId struct {
+	InstrId    string
+	EndToEndId string
+}
+
+type pain001Amount struct {
+	InstdAmt camtAmount
+}
+
+type pain001Account struct {
+	Id struct {
+		IBAN string
+		Othr camtOtherId
+	}
+}
+
+type pain001Agent struct {
+	FinInstnId struct {
+		BIC  string
+		Othr camtOtherId
+	}
+}
+
+type pain001Remittance struct {
+	Ustrd []string
+}
+
+// pain001Result is what happened to one payment in the file
+type pain001Result struct {
+	Status        string
+	Reason        string
+	Info          string
+	TransactionID int64
+}
+
+// pain.002 CustomerPaymentStatusReport
+type pain002Document struct {
+	XMLName xml.Name      `xml:"Document"`
+	Xmlns   string        `xml:"xmlns,attr"`
+	Report  pain002Report `xml:"CstmrPmtStsRpt"`
+}
+
+type pain002Report struct {
+	GrpHdr            camtGroupHeader
+	OrgnlGrpInfAndSts pain002GroupStatus
+	OrgnlPmtInfAndSts []pain002PaymentStatus `xml:"OrgnlPmtInfAndSts,omitempty"`
+}
+
+type pain002GroupStatus struct {
+	OrgnlMsgId   string
+	OrgnlMsgNmId string
+	OrgnlNbOfTxs string `xml:"OrgnlNbOfTxs,omitempty"`
+	OrgnlCtrlSum string `xml:"OrgnlCtrlSum,omitempty"`
+	GrpSts       string
+	StsRsnInf    *pain002Reason `xml:"StsRsnInf,omitempty"`
+}
+
+type pain002PaymentStatus struct {
+	OrgnlPmtInfId string
+	OrgnlNbOfTxs  string
+	PmtInfSts     string
+	TxInfAndSts   []pain002TransactionStatus
+}
+
+type pain002TransactionStatus struct {
+	StsId           string `xml:"StsId,omitempty"`
+	OrgnlInstrId    string `xml:"OrgnlInstrId,omitempty"`
+	OrgnlEndToEndId string
+	TxSts           string
+	StsRsnInf       *pain002Reason `xml:"StsRsnInf,omitempty"`
+}
+
+type pain002Reason struct {
+	Rsn      camtCode
+	AddtlInf string `xml:"AddtlInf,omitempty"`
+}
+
+// ProcessPain001 makes the payments in a pain.001 file for the holder of the token
+// and reports on each of them in a pain.002. A file that does not validate is
+// rejected as a whole without making any payments.
+func ProcessPain001(token string, content []byte) (report []byte, err error) {
+	tokenUser, merchantID, err := appauth.GetUserFromScopedToken(token, appauth.SCOPE_PAYMENTS_CREATE)
+	if err != nil {
+		return nil, errors.New("payments.ProcessPain001: " + err.Error())
+	}
+
+	document, err := parsePain001(content)
+	if err != nil {
+		return nil, errors.New("payments.ProcessPain001: " + err.Error())
+	}
+
+	results := [][]pain001Result{}
+	reason, info := validatePain001(document)
+	if reason == "" {
+		transactions := []PAINTrans{}
+		for _, paymentInformation := range document.Initiation.PmtInfs {
+			for _, transaction := range paymentInformation.CdtTrfTxInf {
+				transactions = append(transactions, pain001PainTransaction(paymentInformation, transaction))
+			}
+		}
+		// A merchant's key can only pay from the merchant's accounts
+		for _, transaction := range transactions {
+			err = accounts.CheckMerchantAccount(merchantID, transaction.Sender.AccountNumber)
+			if err != nil {
+				return nil, errors.New("payments.ProcessPain001: Debtor account not valid")
+			}
+		}
+		err = checkStepUp(token, transactions)
+		if err != nil {
+			return nil, errors.New("payments.ProcessPain001: " + err.Error())
+		}
+
+		for _, paymentInformation := range document.Initiation.PmtInfs {
+			paymentResults := []pain001Result{}
+			for _, transaction := range paymentInformation.CdtTrfTxInf {
+				paymentResults = append(paymentResults, executePain001Transaction(tokenUser, paymentInformation, transaction))
+			}
+			results = append(results, paymentResults)
+		}
+	}
+
+	report, err = statusReportPain002(document, reason, info, results, time.Now())
+	if err != nil {
+		return nil, errors.New("payments.ProcessPain001: " + err.Error())
+	}
+	return
+}
+
+func parsePain001(content []byte) (document pain001Document, err error) {
+	err = xml.Unmarshal(content, &document)
+	if err != nil {
+		return pain001Document{}, errors.New("payments.parsePain001: Could not parse XML. " + err.Error())
+	}
+	if document.XMLName.Local != "Document" || !strings.HasPrefix(document.XMLName.Space, PAIN_001_NAMESPACE_PREFIX) {
+		return pain001Document{}, errors.New("payments.parsePain001: Not a pain.001 document")
+	}
+	return
+}
+
+// validatePain001 checks the file as a whole. The reason code is empty if it is valid.
+func validatePain001(document pain001Document) (reason string, info string) {
+	header := document.Initiation.GrpHdr
+	if strings.TrimSpace(header.MsgId) == "" {
+		return PAIN_REASON_FORMAT, "MsgId is required"
+	}
+
+	count := 0
+	total := decimal.Zero
+	endToEndIds := map[string]bool{}
+	for _, paymentInformation := range document.Initiation.PmtInfs {
+		if paymentInformation.PmtMtd != "TRF" {
+			return PAIN_REASON_FORMAT, "Payment method must be TRF in " + paymentInformation.PmtInfId
+		}
+		if pain001AccountId(paymentInformation.DbtrAcct) == "" {
+			return PAIN_REASON_ACCOUNT, "Debtor account is required in " + paymentInformation.PmtInfId
+		}
+		if len(paymentInformation.CdtTrfTxInf) == 0 {
+			return PAIN_REASON_FORMAT, "No transactions in " + paymentInformation.PmtInfId
+		}
+
+		paymentTotal := decimal.Zero
+		for _, transaction := range paymentInformation.CdtTrfTxInf {
+			endToEndId := transaction.PmtId.EndToEndId
+			if endToEndId == "" {
+				return PAIN_REASON_FORMAT, "EndToEndId is required in " + paymentInformation.PmtInfId
+			}
+			if endToEndIds[endToEndId] {
+				return PAIN_REASON_DUPLICATE, "Duplicate EndToEndId " + endToEndId
+			}
+			endToEndIds[endToEndId] = true
+
+			amount, err := decimal.NewFromString(strings.TrimSpace(transaction.Amt.InstdAmt.Value))
+			if err != nil || amount.Sign() <= 0 {
+				return PAIN_REASON_AMOUNT, "Amount not valid for " + endToEndId
+			}
+			if transaction.Amt.InstdAmt.Ccy != currency() {
+				return PAIN_REASON_CURRENCY, "Currency must be " + currency() + " for " + endToEndId
+			}
+			if pain001AccountId(transaction.CdtrAcct) == "" {
+				return PAIN_REASON_ACCOUNT, "Creditor account is required for " + endToEndId
+			}
+			paymentTotal = paymentTotal.Add(amount)
+		}
+
+		if !pain001CountMatches(paymentInformation.NbOfTxs, len(paymentInformation.CdtTrfTxInf)) {
+			return PAIN_REASON_FORMAT, "NbOfTxs does not match in " + paymentInformation.PmtInfId
+		}
+		if !pain001SumMatches(paymentInformation.CtrlSum, paymentTotal) {
+			return PAIN_REASON_FORMAT, "CtrlSum does not match in " + paymentInformation.PmtInfId
+		}
+
+		count += len(paymentInformation.CdtTrfTxInf)
+		total = total.Add(paymentTotal)
+	}
+
+	if count == 0 {
+		return PAIN_REASON_FORMAT, "No transactions in file"
+	}
+	if count > PAIN_001_MAX_TRANSACTIONS {
+		return PAIN_REASON_FORMAT, "More than " + strconv.Itoa(PAIN_001_MAX_TRANSACTIONS) + " transactions in file"
+	}
+	// The group header must give the number of transactions, the control sum is optional
+	if header.NbOfTxs == "" || !pain001CountMatches(header.NbOfTxs, count) {
+		return PAIN_REASON_FORMAT, "NbOfTxs does not match"
+	}
+	if !pain001SumMatches(header.CtrlSum, total) {
+		return PAIN_REASON_FORMAT, "CtrlSum does not match"
+	}
+
+	return "", ""
+}
+
+func pain001CountMatches(nbOfTxs string, count int) bool {
+	if nbOfTxs == "" {
+		return true
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(nbOfTxs))
+	return err == nil && n == count
+}
+
+func pain001SumMatches(ctrlSum string, total decimal.Decimal) bool {
+	if ctrlSum == "" {
+		return true
+	}
+	sum, err := decimal.NewFromString(strings.TrimSpace(ctrlSum))
+	return err == nil && sum.Equals(total)
+}
+
+func pain001AccountId(account pain001Account) string {
+	if account.Id.IBAN != "" {
+		return strings.TrimSpace(account.Id.IBAN)
+	}
+	return strings.TrimSpace(account.Id.Othr.Id)
+}
+
+func pain001AgentId(agent pain001Agent) string {
+	if agent.FinInstnId.BIC != "" {
+		return strings.TrimSpace(agent.FinInstnId.BIC)
+	}
+	return strings.TrimSpace(agent.FinInstnId.Othr.Id)
+}
+
+// pain001PainTransaction is the payment a pain.001 transaction asks for
+func pain001PainTransaction(paymentInformation pain001PaymentInformation, transaction pain001Transaction) PAINTrans {
+	amount, _ := decimal.NewFromString(strings.TrimSpace(transaction.Amt.InstdAmt.Value))
+	desc := strings.Join(transaction.RmtInf.Ustrd, " ")
+	if desc == "" {
+		desc = transaction.PmtId.EndToEndId
+	}
+
+	return PAINTrans{
+		PainType: 1,
+		Sender:   AccountHolder{pain001AccountId(paymentInformation.DbtrAcct), localBankNumber(pain001AgentId(paymentInformation.DbtrAgt))},
+		Receiver: AccountHolder{pain001AccountId(transaction.CdtrAcct), localBankNumber(pain001AgentId(transaction.CdtrAgt))},
+		Amount:   amount,
+		Fee:      decimal.NewFromFloat(TRANSACTION_FEE),
+		Geo:      *geo.NewPoint(0, 0),
+		Desc:     desc,
+		Status:   "approved",
+	}
+}
+
+// executePain001Transaction makes one payment with the same checks as a credit transfer
+func executePain001Transaction(tokenUser string, paymentInformation pain001PaymentInformation, transaction pain001Transaction) (result pain001Result) {
+	painTransaction := pain001PainTransaction(paymentInformation, transaction)
+
+	// Files carry no location
+	transactionId, status, err := creditTransfer(tokenUser, painTransaction, 0, 0)
+	if err != nil {
+		return pain001Result{Status: PAIN_STATUS_REJECTED, Reason: PAIN_REASON_NARRATIVE, Info: err.Error()}
+	}
+	if status == "pending" {
+		return pain001Result{Status: PAIN_STATUS_PENDING, TransactionID: transactionId}
+	}
+	return pain001Result{Status: PAIN_STATUS_SETTLED, TransactionID: transactionId}
+}
+
+// statusReportPain002 reports on a pain.001 file. A group reason rejects the whole file,
+// otherwise results holds the result of each transaction in each payment information block.
+func statusReportPain002(document pain001Document, groupReason string, groupInfo string, results [][]pain001Result, created time.Time) (content []byte, err error) {
+	header := document.Initiation.GrpHdr
+	report := pain002Report{
+		GrpHdr: camtGroupHeader{"STS-" + truncate(header.MsgId, 30), camtDateTime(created)},
+		OrgnlGrpInfAndSts: pain002GroupStatus{
+			OrgnlMsgId:   header.MsgId,
+			OrgnlMsgNmId: strings.TrimPrefix(document.XMLName.Space, "urn:iso:std:iso:20022:tech:xsd:"),
+			OrgnlNbOfTxs: header.NbOfTxs,
+			OrgnlCtrlSum: header.CtrlSum,
+		},
+	}
+
+	if groupReason != "" {
+		report.OrgnlGrpInfAndSts.GrpSts = PAIN_STATUS_REJECTED
+		report.OrgnlGrpInfAndSts.StsRsnInf = &pain002Reason{camtCode{groupReason}, truncate(groupInfo, PAIN_ADDITIONAL_INFO_LENGTH)}
+	} else {
+		all := []pain001Result{}
+		for i, paymentInformation := range document.Initiation.PmtInfs {
+			paymentStatus := pain002PaymentStatus{
+				OrgnlPmtInfId: paymentInformation.PmtInfId,
+				OrgnlNbOfTxs:  strconv.Itoa(len(paymentInformation.CdtTrfTxInf)),
+				PmtInfSts:     pain002Status(results[i]),
+			}
+			for j, transaction := range paymentInformation.CdtTrfTxInf {
+				result := results[i][j]
+				transactionStatus := pain002TransactionStatus{
+					OrgnlInstrId:    transaction.PmtId.InstrId,
+					OrgnlEndToEndId: transaction.PmtId.EndToEndId,
+					TxSts:           result.Status,
+				}
+				if result.TransactionID != 0 {
+					transactionStatus.StsId = strconv.FormatInt(result.TransactionID, 10)
+				}
+				if result.Status == PAIN_STATUS_REJECTED {
+					transactionStatus.StsRsnInf = &pain002Reason{camtCode{result.Reason}, truncate(result.Info, PAIN_ADDITIONAL_INFO_LENGTH)}
+				}
+				paymentStatus.TxInfAndSts = append(paymentStatus.TxInfAndSts, transactionStatus)
+			}
+			report.OrgnlPmtInfAndSts = append(report.OrgnlPmtInfAndSts, paymentStatus)
+			all = append(all, results[i]...)
+		}
+		report.OrgnlGrpInfAndSts.GrpSts = pain002Status(all)
+	}
+
+	body, err := xml.MarshalIndent(pain002Document{Xmlns: PAIN_002_NAMESPACE, Report: report}, "", "  ")
+	if err != nil {
+		return nil, errors.New("payments.statusReportPain002: " + err.Error())
+	}
+
+	content = append([]byte(xml.Header), body...)
+	return
+}
+
+// pain002Status is accepted if nothing was rejected, rejected if everything was
+// and partially accepted otherwise
+func pain002Status(results []pain001Result) string {
+	rejected := 0
+	for _, result := range results {
+		if result.Status == PAIN_STATUS_REJECTED {
+			rejected++
+		}
+	}
+
+	switch {
+	case rejected == 0:
+		return PAIN_STATUS_ACCEPTED
+	case rejected == len(results):
+		return PAIN_STATUS_REJECTED
+	default:
+		return PAIN_STATUS_PARTIAL
+	}
+}
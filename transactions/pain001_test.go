@@ -0,0 +1,173 @@
+package transactions
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testPain001 = `<?xml version="1.0" encoding="UTF-8"?>
+<Document xmlns="urn:iso:std:iso:20022:tech:xsd:pain.001.001.03">
+  <CstmrCdtTrfInitn>
+    <GrpHdr>
+      <MsgId>MSG-1</MsgId>
+      <CreDtTm>2016-01-01T10:00:00</CreDtTm>
+      <NbOfTxs>3</NbOfTxs>
+      <CtrlSum>35.50</CtrlSum>
+      <InitgPty><Nm>Payroll</Nm></InitgPty>
+    </GrpHdr>
+    <PmtInf>
+      <PmtInfId>PMT-1</PmtInfId>
+      <PmtMtd>TRF</PmtMtd>
+      <NbOfTxs>2</NbOfTxs>
+      <ReqdExctnDt>2016-01-01</ReqdExctnDt>
+      <Dbtr><Nm>Payroll</Nm></Dbtr>
+      <DbtrAcct><Id><Othr><Id>sender</Id></Othr></Id></DbtrAcct>
+      <DbtrAgt><FinInstnId><Othr><Id>bank</Id></Othr></FinInstnId></DbtrAgt>
+      <CdtTrfTxInf>
+        <PmtId><InstrId>I-1</InstrId><EndToEndId>E2E-1</EndToEndId></PmtId>
+        <Amt><InstdAmt Ccy="USD">10.00</InstdAmt></Amt>
+        <Cdtr><Nm>One</Nm></Cdtr>
+        <CdtrAcct><Id><Othr><Id>receiver1</Id></Othr></Id></CdtrAcct>
+        <RmtInf><Ustrd>Salary</Ustrd></RmtInf>
+      </CdtTrfTxInf>
+      <CdtTrfTxInf>
+        <PmtId><EndToEndId>E2E-2</EndToEndId></PmtId>
+        <Amt><InstdAmt Ccy="USD">20.50</InstdAmt></Amt>
+        <CdtrAcct><Id><Othr><Id>receiver2</Id></Othr></Id></CdtrAcct>
+      </CdtTrfTxInf>
+    </PmtInf>
+    <PmtInf>
+      <PmtInfId>PMT-2</PmtInfId>
+      <PmtMtd>TRF</PmtMtd>
+      <DbtrAcct><Id><Othr><Id>sender</Id></Othr></Id></DbtrAcct>
+      <CdtTrfTxInf>
+        <PmtId><EndToEndId>E2E-3</EndToEndId></PmtId>
+        <Amt><InstdAmt Ccy="USD">5</InstdAmt></Amt>
+        <CdtrAcct><Id><Othr><Id>receiver3</Id></Othr></Id></CdtrAcct>
+      </CdtTrfTxInf>
+    </PmtInf>
+  </CstmrCdtTrfInitn>
+</Document>`
+
+func TestParsePain001(t *testing.T) {
+	document, err := parsePain001([]byte(testPain001))
+	if err != nil {
+		t.Fatalf("ParsePain001 does not pass. Looking for %v, got %v", nil, err)
+	}
+	if len(document.Initiation.PmtInfs) != 2 || len(document.Initiation.PmtInfs[0].CdtTrfTxInf) != 2 {
+		t.Fatalf("ParsePain001 payments does not pass. Looking for %v, got %v", "2 and 2", document.Initiation.PmtInfs)
+	}
+
+	transaction := document.Initiation.PmtInfs[0].CdtTrfTxInf[0]
+	if pain001AccountId(transaction.CdtrAcct) != "receiver1" || transaction.Amt.InstdAmt.Value != "10.00" || transaction.RmtInf.Ustrd[0] != "Salary" {
+		t.Errorf("ParsePain001 transaction does not pass. Looking for %v, got %v", "receiver1 10.00 Salary", transaction)
+	}
+	if pain001AgentId(document.Initiation.PmtInfs[0].DbtrAgt) != "bank" {
+		t.Errorf("ParsePain001 debtor agent does not pass. Looking for %v, got %v", "bank", pain001AgentId(document.Initiation.PmtInfs[0].DbtrAgt))
+	}
+
+	_, err = parsePain001([]byte(strings.Replace(testPain001, "pain.001.001.03", "camt.053.001.04", 1)))
+	if err == nil {
+		t.Errorf("ParsePain001 namespace does not pass. Looking for %v, got %v", "Not a pain.001 document", nil)
+	}
+}
+
+func TestValidatePain001(t *testing.T) {
+	tests := []struct {
+		from   string
+		to     string
+		reason string
+	}{
+		{"", "", ""},
+		{"<NbOfTxs>3</NbOfTxs>", "<NbOfTxs>4</NbOfTxs>", PAIN_REASON_FORMAT},
+		{"<CtrlSum>35.50</CtrlSum>", "<CtrlSum>35.00</CtrlSum>", PAIN_REASON_FORMAT},
+		{"<NbOfTxs>2</NbOfTxs>", "<NbOfTxs>1</NbOfTxs>", PAIN_REASON_FORMAT},
+		{"<MsgId>MSG-1</MsgId>", "<MsgId></MsgId>", PAIN_REASON_FORMAT},
+		{"<PmtMtd>TRF</PmtMtd>", "<PmtMtd>CHK</PmtMtd>", PAIN_REASON_FORMAT},
+		{"E2E-2", "E2E-1", PAIN_REASON_DUPLICATE},
+		{">5<", ">-5<", PAIN_REASON_AMOUNT},
+		{`Ccy="USD">5`, `Ccy="EUR">5`, PAIN_REASON_CURRENCY},
+		{"<Id>receiver3</Id>", "<Id></Id>", PAIN_REASON_ACCOUNT},
+	}
+
+	for _, test := range tests {
+		document, err := parsePain001([]byte(strings.Replace(testPain001, test.from, test.to, 1)))
+		if err != nil {
+			t.Fatalf("ValidatePain001 parse does not pass. Looking for %v, got %v", nil, err)
+		}
+		reason, info := validatePain001(document)
+		if reason != test.reason {
+			t.Errorf("ValidatePain001 does not pass. Looking for %v, got %v (%v)", test.reason, reason, info)
+		}
+	}
+}
+
+func TestPain002Report(t *testing.T) {
+	document, _ := parsePain001([]byte(testPain001))
+	results := [][]pain001Result{
+		{
+			{Status: PAIN_STATUS_SETTLED, TransactionID: 11},
+			{Status: PAIN_STATUS_REJECTED, Reason: PAIN_REASON_NARRATIVE, Info: "payments.creditTransfer: Insufficient funds available"},
+		},
+		{
+			{Status: PAIN_STATUS_PENDING, TransactionID: 12},
+		},
+	}
+
+	content, err := statusReportPain002(document, "", "", results, time.Now())
+	if err != nil {
+		t.Fatalf("Pain002Report does not pass. Looking for %v, got %v", nil, err)
+	}
+
+	report := pain002Document{}
+	err = xml.Unmarshal(content, &report)
+	if err != nil {
+		t.Fatalf("Pain002Report unmarshal does not pass. Looking for %v, got %v", nil, err)
+	}
+	if report.Xmlns != PAIN_002_NAMESPACE {
+		t.Errorf("Pain002Report namespace does not pass. Looking for %v, got %v", PAIN_002_NAMESPACE, report.Xmlns)
+	}
+
+	group := report.Report.OrgnlGrpInfAndSts
+	if group.OrgnlMsgId != "MSG-1" || group.OrgnlMsgNmId != "pain.001.001.03" || group.GrpSts != PAIN_STATUS_PARTIAL {
+		t.Errorf("Pain002Report group does not pass. Looking for %v, got %v", "MSG-1 pain.001.001.03 PART", group)
+	}
+
+	payments := report.Report.OrgnlPmtInfAndSts
+	if len(payments) != 2 || payments[0].PmtInfSts != PAIN_STATUS_PARTIAL || payments[1].PmtInfSts != PAIN_STATUS_ACCEPTED {
+		t.Fatalf("Pain002Report payments does not pass. Looking for %v, got %v", "PART and ACCP", payments)
+	}
+
+	rejected := payments[0].TxInfAndSts[1]
+	if rejected.OrgnlEndToEndId != "E2E-2" || rejected.TxSts != PAIN_STATUS_REJECTED || rejected.StsRsnInf == nil {
+		t.Errorf("Pain002Report rejected does not pass. Looking for %v, got %v", "E2E-2 RJCT", rejected)
+	}
+	settled := payments[0].TxInfAndSts[0]
+	if settled.StsId != "11" || settled.OrgnlInstrId != "I-1" || settled.TxSts != PAIN_STATUS_SETTLED {
+		t.Errorf("Pain002Report settled does not pass. Looking for %v, got %v", "11 I-1 ACSC", settled)
+	}
+	if payments[1].TxInfAndSts[0].TxSts != PAIN_STATUS_PENDING {
+		t.Errorf("Pain002Report pending does not pass. Looking for %v, got %v", PAIN_STATUS_PENDING, payments[1].TxInfAndSts[0].TxSts)
+	}
+}
+
+func TestPain002ReportRejected(t *testing.T) {
+	document, _ := parsePain001([]byte(testPain001))
+
+	content, err := statusReportPain002(document, PAIN_REASON_FORMAT, "NbOfTxs does not match", nil, time.Now())
+	if err != nil {
+		t.Fatalf("Pain002ReportRejected does not pass. Looking for %v, got %v", nil, err)
+	}
+
+	report := pain002Document{}
+	_ = xml.Unmarshal(content, &report)
+	group := report.Report.OrgnlGrpInfAndSts
+	if group.GrpSts != PAIN_STATUS_REJECTED || group.StsRsnInf == nil || group.StsRsnInf.Rsn.Cd != PAIN_REASON_FORMAT {
+		t.Errorf("Pain002ReportRejected does not pass. Looking for %v, got %v", "RJCT FF01", group)
+	}
+	if len(report.Report.OrgnlPmtInfAndSts) != 0 {
+		t.Errorf("Pain002ReportRejected payments does not pass. Looking for %v, got %v", 0, len(report.Report.OrgnlPmtInfAndSts))
+	}
+}
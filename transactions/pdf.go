@@ -0,0 +1,101 @@
+package transactions
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// A4 in points, with text set in Courier so columns line up
+const (
+	PDF_PAGE_WIDTH     = 595
+	PDF_PAGE_HEIGHT    = 842
+	PDF_MARGIN         = 40
+	PDF_FONT_SIZE      = 8
+	PDF_LEADING        = 11
+	PDF_LINES_PER_PAGE = (PDF_PAGE_HEIGHT - 2*PDF_MARGIN) / PDF_LEADING
+)
+
+// renderPDF lays lines of text out over as many pages as needed.
+// Only what is needed for a plain text document is written: a catalog, the
+// page tree, one content stream per page and a built in font.
+func renderPDF(lines []string) []byte {
+	pages := [][]string{}
+	for len(lines) > PDF_LINES_PER_PAGE {
+		pages = append(pages, lines[:PDF_LINES_PER_PAGE])
+		lines = lines[PDF_LINES_PER_PAGE:]
+	}
+	pages = append(pages, lines)
+
+	// Objects are 1 catalog, 2 pages, 3 font, then a page and its content for each page
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Courier /Encoding /WinAnsiEncoding >>",
+	}
+	kids := []string{}
+	for i, page := range pages {
+		pageObject := len(objects) + 1
+		contentObject := pageObject + 1
+		kids = append(kids, fmt.Sprintf("%d 0 R", pageObject))
+
+		content := pdfPageContent(page, i+1, len(pages))
+		objects = append(objects,
+			fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>", PDF_PAGE_WIDTH, PDF_PAGE_HEIGHT, contentObject),
+			fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(content), content),
+		)
+	}
+	objects[1] = fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages))
+
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+
+	return buf.Bytes()
+}
+
+func pdfPageContent(lines []string, page int, pageCount int) string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "BT\n/F1 %d Tf\n%d TL\n%d %d Td\n", PDF_FONT_SIZE, PDF_LEADING, PDF_MARGIN, PDF_PAGE_HEIGHT-PDF_MARGIN)
+	for _, line := range lines {
+		fmt.Fprintf(&buf, "(%s) '\n", pdfEscape(line))
+	}
+	buf.WriteString("ET\n")
+
+	// Page number at the bottom right
+	fmt.Fprintf(&buf, "BT\n/F1 %d Tf\n%d %d Td\n(%s) Tj\nET", PDF_FONT_SIZE, PDF_PAGE_WIDTH-PDF_MARGIN-80, PDF_MARGIN/2, pdfEscape(fmt.Sprintf("Page %d of %d", page, pageCount)))
+	return buf.String()
+}
+
+// pdfEscape escapes a string for a PDF literal. The built in fonts only
+// cover Latin-1, anything outside it is replaced.
+func pdfEscape(s string) string {
+	var buf bytes.Buffer
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			buf.WriteByte('\\')
+			buf.WriteRune(r)
+		case r < 32:
+			buf.WriteByte(' ')
+		case r > 255:
+			buf.WriteByte('?')
+		case r > 126:
+			fmt.Fprintf(&buf, "\\%03o", r)
+		default:
+			buf.WriteRune(r)
+		}
+	}
+	return buf.String()
+}
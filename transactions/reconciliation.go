@@ -0,0 +1,246 @@
+package transactions
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/bvnk/bank/accounts"
+	"github.com/bvnk/bank/appauth"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	// Half of the smallest amount stored, differences smaller than this are rounding
+	RECONCILIATION_TOLERANCE = 0.0000005
+
+	ISSUE_BALANCE         = "balance"
+	ISSUE_ACCOUNT_MISSING = "account missing"
+)
+
+// Reconciliation is every account balance and the bank's fees recomputed from the transactions
+type Reconciliation struct {
+	Timestamp     int32
+	DryRun        bool
+	Accounts      int
+	Discrepancies []Discrepancy
+	Fees          FeeReconciliation
+}
+
+// Discrepancy is an account whose balances do not match its transactions
+type Discrepancy struct {
+	AccountNumber            string
+	Issue                    string
+	AccountBalance           decimal.Decimal
+	ExpectedAccountBalance   decimal.Decimal
+	AvailableBalance         decimal.Decimal
+	ExpectedAvailableBalance decimal.Decimal
+	Repaired                 bool
+}
+
+// FeeReconciliation compares the bank holding account with the fees charged
+type FeeReconciliation struct {
+	Balance    decimal.Decimal
+	Expected   decimal.Decimal
+	Difference decimal.Decimal
+	Repaired   bool
+}
+
+// ledgerBalance is what an account's balances should be from its transactions
+type ledgerBalance struct {
+	Movement decimal.Decimal
+	Held     decimal.Decimal
+}
+
+// accountBalances is an account's balances as stored
+type accountBalances struct {
+	AccountNumber    string
+	AccountBalance   decimal.Decimal
+	Overdraft        decimal.Decimal
+	AvailableBalance decimal.Decimal
+}
+
+func reconcileBalances(data []string) (result Reconciliation, err error) {
+	//~pain~1007~mode~basicAuthUser~basicAuthPassword
+	_, err = appauth.CheckPermission(data[:6], appauth.PERMISSION_RECONCILE)
+	if err != nil {
+		return Reconciliation{}, errors.New("payments.reconcileBalances: " + err.Error())
+	}
+
+	switch data[3] {
+	case "dryrun":
+		result, err = Reconcile(true)
+	case "repair":
+		result, err = Reconcile(false)
+	default:
+		return Reconciliation{}, errors.New("payments.reconcileBalances: Mode not valid, must be one of dryrun, repair")
+	}
+	if err != nil {
+		return Reconciliation{}, errors.New("payments.reconcileBalances: " + err.Error())
+	}
+	return
+}
+
+// Reconcile recomputes every account balance and the bank's fees from the
+// transactions and reports where they differ. Unless dryRun is set the stored
+// balances are corrected, with payments waiting until the repair is done.
+func Reconcile(dryRun bool) (result Reconciliation, err error) {
+	tx, err := Config.Db.Begin()
+	if err != nil {
+		return Reconciliation{}, errors.New("payments.Reconcile: " + err.Error())
+	}
+	defer tx.Rollback()
+
+	// Lock the balances before reading the transactions, so any payment
+	// committed while waiting for the locks is counted
+	lock := ""
+	if !dryRun {
+		lock = " FOR UPDATE"
+	}
+
+	balances, err := getAccountBalances(tx, lock)
+	if err != nil {
+		return Reconciliation{}, errors.New("payments.Reconcile: " + err.Error())
+	}
+	feeBalance, err := getHoldingBalance(tx, lock)
+	if err != nil {
+		return Reconciliation{}, errors.New("payments.Reconcile: " + err.Error())
+	}
+
+	ledger, fees, err := getLedger(tx)
+	if err != nil {
+		return Reconciliation{}, errors.New("payments.Reconcile: " + err.Error())
+	}
+
+	result = compareBalances(balances, ledger, feeBalance, fees)
+	result.DryRun = dryRun
+	result.Timestamp = int32(time.Now().Unix())
+	if dryRun {
+		return
+	}
+
+	sqlTime := int32(time.Now().Unix())
+	for i, discrepancy := range result.Discrepancies {
+		if discrepancy.Issue != ISSUE_BALANCE {
+			continue
+		}
+		err = repairAccountBalance(tx, discrepancy, sqlTime)
+		if err != nil {
+			return Reconciliation{}, errors.New("payments.Reconcile: " + err.Error())
+		}
+		result.Discrepancies[i].Repaired = true
+	}
+	if result.Fees.Difference.Sign() != 0 {
+		err = repairHoldingBalance(tx, result.Fees.Expected, sqlTime)
+		if err != nil {
+			return Reconciliation{}, errors.New("payments.Reconcile: " + err.Error())
+		}
+		result.Fees.Repaired = true
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return Reconciliation{}, errors.New("payments.Reconcile: " + err.Error())
+	}
+	return
+}
+
+// applyToLedger adds what a transaction does to the balances of the local accounts it touches.
+// Fees are stored as an amount. Rejected transactions never moved, or were returned.
+func applyToLedger(ledger map[string]*ledgerBalance, transaction PAINTrans) (fee decimal.Decimal) {
+	entry := func(accountNumber string) *ledgerBalance {
+		balance, ok := ledger[accountNumber]
+		if !ok {
+			balance = &ledgerBalance{Movement: decimal.Zero, Held: decimal.Zero}
+			ledger[accountNumber] = balance
+		}
+		return balance
+	}
+
+	switch transaction.Status {
+	case "approved":
+		switch transaction.PainType {
+		// Payment
+		case 1:
+			if transaction.Sender.BankNumber == "" {
+				sender := entry(transaction.Sender.AccountNumber)
+				sender.Movement = sender.Movement.Sub(transaction.Amount.Add(transaction.Fee))
+			}
+			if transaction.Receiver.BankNumber == "" {
+				receiver := entry(transaction.Receiver.AccountNumber)
+				receiver.Movement = receiver.Movement.Add(transaction.Amount)
+			}
+		// Deposit
+		case 1000:
+			if transaction.Receiver.BankNumber == "" {
+				receiver := entry(transaction.Receiver.AccountNumber)
+				receiver.Movement = receiver.Movement.Add(transaction.Amount.Sub(transaction.Fee))
+			}
+		}
+		return transaction.Fee
+	case "pending":
+		// Held against the sender's available balance until reviewed
+		if transaction.Sender.BankNumber == "" {
+			sender := entry(transaction.Sender.AccountNumber)
+			sender.Held = sender.Held.Add(transaction.Amount.Add(transaction.Fee))
+		}
+	}
+	return decimal.Zero
+}
+
+// compareBalances finds the accounts and fees that do not match the ledger
+func compareBalances(balances []accountBalances, ledger map[string]*ledgerBalance, feeBalance decimal.Decimal, fees decimal.Decimal) (result Reconciliation) {
+	tolerance := decimal.NewFromFloat(RECONCILIATION_TOLERANCE)
+	opening := decimal.NewFromFloat(accounts.OPENING_BALANCE)
+	result.Discrepancies = []Discrepancy{}
+
+	seen := map[string]bool{}
+	for _, balance := range balances {
+		seen[balance.AccountNumber] = true
+
+		movement, held := decimal.Zero, decimal.Zero
+		if entry, ok := ledger[balance.AccountNumber]; ok {
+			movement, held = entry.Movement, entry.Held
+		}
+
+		expectedAccount := opening.Add(movement)
+		expectedAvailable := expectedAccount.Add(balance.Overdraft).Sub(held)
+		if balance.AccountBalance.Sub(expectedAccount).Abs().Cmp(tolerance) >= 0 || balance.AvailableBalance.Sub(expectedAvailable).Abs().Cmp(tolerance) >= 0 {
+			result.Discrepancies = append(result.Discrepancies, Discrepancy{
+				AccountNumber:            balance.AccountNumber,
+				Issue:                    ISSUE_BALANCE,
+				AccountBalance:           balance.AccountBalance,
+				ExpectedAccountBalance:   expectedAccount,
+				AvailableBalance:         balance.AvailableBalance,
+				ExpectedAvailableBalance: expectedAvailable,
+			})
+		}
+	}
+	result.Accounts = len(balances)
+
+	// Transactions on a local account that does not exist cannot be repaired
+	missing := []string{}
+	for accountNumber := range ledger {
+		if !seen[accountNumber] {
+			missing = append(missing, accountNumber)
+		}
+	}
+	sort.Strings(missing)
+	for _, accountNumber := range missing {
+		entry := ledger[accountNumber]
+		result.Discrepancies = append(result.Discrepancies, Discrepancy{
+			AccountNumber:            accountNumber,
+			Issue:                    ISSUE_ACCOUNT_MISSING,
+			AccountBalance:           decimal.Zero,
+			ExpectedAccountBalance:   opening.Add(entry.Movement),
+			AvailableBalance:         decimal.Zero,
+			ExpectedAvailableBalance: opening.Add(entry.Movement).Sub(entry.Held),
+		})
+	}
+
+	result.Fees = FeeReconciliation{Balance: feeBalance, Expected: fees, Difference: decimal.Zero}
+	if feeBalance.Sub(fees).Abs().Cmp(tolerance) >= 0 {
+		result.Fees.Difference = feeBalance.Sub(fees)
+	}
+	return
+}
@@ -0,0 +1,95 @@
+package transactions
+
+import (
+	"testing"
+
+	"github.com/bvnk/bank/accounts"
+	"github.com/shopspring/decimal"
+)
+
+func testLedger() (ledger map[string]*ledgerBalance, fees decimal.Decimal) {
+	transactions := []PAINTrans{
+		{PainType: 1000, Receiver: AccountHolder{"a", ""}, Amount: decimal.NewFromFloat(50), Fee: decimal.NewFromFloat(0.5), Status: "approved"},
+		{PainType: 1, Sender: AccountHolder{"a", ""}, Receiver: AccountHolder{"b", ""}, Amount: decimal.NewFromFloat(20), Fee: decimal.NewFromFloat(0.2), Status: "approved"},
+		// Sent to another bank
+		{PainType: 1, Sender: AccountHolder{"b", ""}, Receiver: AccountHolder{"c", "bank-b"}, Amount: decimal.NewFromFloat(10), Fee: decimal.NewFromFloat(0.1), Status: "approved"},
+		// Received from another bank
+		{PainType: 1, Sender: AccountHolder{"d", "bank-b"}, Receiver: AccountHolder{"a", ""}, Amount: decimal.NewFromFloat(5), Fee: decimal.Zero, Status: "approved"},
+		{PainType: 1, Sender: AccountHolder{"a", ""}, Receiver: AccountHolder{"b", ""}, Amount: decimal.NewFromFloat(30), Fee: decimal.NewFromFloat(0.3), Status: "pending"},
+		{PainType: 1, Sender: AccountHolder{"a", ""}, Receiver: AccountHolder{"b", ""}, Amount: decimal.NewFromFloat(40), Fee: decimal.NewFromFloat(0.4), Status: "rejected"},
+	}
+
+	ledger = map[string]*ledgerBalance{}
+	fees = decimal.Zero
+	for _, transaction := range transactions {
+		fees = fees.Add(applyToLedger(ledger, transaction))
+	}
+	return
+}
+
+func TestApplyToLedger(t *testing.T) {
+	ledger, fees := testLedger()
+
+	tests := []struct {
+		accountNumber string
+		movement      decimal.Decimal
+		held          decimal.Decimal
+	}{
+		{"a", decimal.NewFromFloat(34.3), decimal.NewFromFloat(30.3)},
+		{"b", decimal.NewFromFloat(9.9), decimal.Zero},
+	}
+
+	for _, test := range tests {
+		entry := ledger[test.accountNumber]
+		if entry == nil || !entry.Movement.Equals(test.movement) || !entry.Held.Equals(test.held) {
+			t.Errorf("ApplyToLedger does not pass for %v. Looking for %v %v, got %v", test.accountNumber, test.movement, test.held, entry)
+		}
+	}
+	if _, ok := ledger["c"]; ok {
+		t.Errorf("ApplyToLedger does not pass. Looking for %v, got %v", "no entry for another bank's account", ledger["c"])
+	}
+	if !fees.Equals(decimal.NewFromFloat(0.8)) {
+		t.Errorf("ApplyToLedger fees does not pass. Looking for %v, got %v", 0.8, fees)
+	}
+}
+
+func TestCompareBalances(t *testing.T) {
+	ledger, fees := testLedger()
+	opening := decimal.NewFromFloat(accounts.OPENING_BALANCE)
+	overdraft := decimal.NewFromFloat(100)
+
+	balances := []accountBalances{
+		// Matches
+		{AccountNumber: "a", AccountBalance: opening.Add(decimal.NewFromFloat(34.3)), Overdraft: decimal.Zero, AvailableBalance: opening.Add(decimal.NewFromFloat(4))},
+		// Drifted
+		{AccountNumber: "b", AccountBalance: opening.Add(decimal.NewFromFloat(9.5)), Overdraft: overdraft, AvailableBalance: opening.Add(decimal.NewFromFloat(9.5)).Add(overdraft)},
+		// No transactions
+		{AccountNumber: "e", AccountBalance: opening, Overdraft: decimal.Zero, AvailableBalance: opening},
+	}
+	ledger["f"] = &ledgerBalance{Movement: decimal.NewFromFloat(1), Held: decimal.Zero}
+
+	result := compareBalances(balances, ledger, decimal.NewFromFloat(1), fees)
+	if result.Accounts != 3 || len(result.Discrepancies) != 2 {
+		t.Fatalf("CompareBalances does not pass. Looking for %v, got %v", "3 accounts and 2 discrepancies", result)
+	}
+
+	b := result.Discrepancies[0]
+	if b.AccountNumber != "b" || b.Issue != ISSUE_BALANCE || !b.ExpectedAccountBalance.Equals(opening.Add(decimal.NewFromFloat(9.9))) ||
+		!b.ExpectedAvailableBalance.Equals(opening.Add(decimal.NewFromFloat(9.9)).Add(overdraft)) {
+		t.Errorf("CompareBalances drift does not pass. Looking for %v, got %v", "b expected 109.9", b)
+	}
+
+	f := result.Discrepancies[1]
+	if f.AccountNumber != "f" || f.Issue != ISSUE_ACCOUNT_MISSING {
+		t.Errorf("CompareBalances missing account does not pass. Looking for %v, got %v", ISSUE_ACCOUNT_MISSING, f)
+	}
+
+	if !result.Fees.Difference.Equals(decimal.NewFromFloat(0.2)) {
+		t.Errorf("CompareBalances fees does not pass. Looking for %v, got %v", 0.2, result.Fees.Difference)
+	}
+
+	result = compareBalances(nil, map[string]*ledgerBalance{}, decimal.NewFromFloat(0.8000001), fees)
+	if result.Fees.Difference.Sign() != 0 || len(result.Discrepancies) != 0 {
+		t.Errorf("CompareBalances fees rounding does not pass. Looking for %v, got %v", 0, result.Fees.Difference)
+	}
+}
@@ -0,0 +1,205 @@
+package transactions
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/bvnk/bank/accounts"
+	"github.com/bvnk/bank/appauth"
+	"github.com/bvnk/bank/limits"
+	"github.com/bvnk/bank/money"
+	"github.com/bvnk/bank/push"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	REVIEW_APPROVE = 1003
+	REVIEW_REJECT  = 1004
+
+	HOLD_STATUS_PENDING  = "pending"
+	HOLD_STATUS_APPROVED = "approved"
+	HOLD_STATUS_REJECTED = "rejected"
+)
+
+// Hold is one reason a transaction was put in pending
+type Hold struct {
+	Source    string
+	Score     int
+	Reasons   string
+	Timestamp int32
+}
+
+// PendingTransaction is a transaction waiting on review, with what is needed to decide on it
+type PendingTransaction struct {
+	Transaction  PAINTrans
+	SenderName   string
+	ReceiverName string
+	Lat          float64
+	Lon          float64
+	Holds        []Hold
+}
+
+func listPendingTransactions(data []string) (result []PendingTransaction, err error) {
+	_, err = appauth.CheckPermission(data[:5], appauth.PERMISSION_PAYMENT_REVIEW)
+	if err != nil {
+		return nil, errors.New("payments.listPendingTransactions: " + err.Error())
+	}
+
+	result, err = getPendingTransactions()
+	if err != nil {
+		return nil, errors.New("payments.listPendingTransactions: " + err.Error())
+	}
+
+	for i := range result {
+		result[i].Holds, err = getTransactionHolds(int64(result[i].Transaction.ID))
+		if err != nil {
+			return nil, errors.New("payments.listPendingTransactions: " + err.Error())
+		}
+	}
+
+	return
+}
+
+// reviewPendingTransaction approves or rejects a pending transaction.
+// Approval moves the balances the same way as an unheld payment, rejection
+// gives the held funds back to the sender.
+func reviewPendingTransaction(reviewType int64, data []string) (result string, err error) {
+	reviewer, err := appauth.CheckPermission(data[:7], appauth.PERMISSION_PAYMENT_REVIEW)
+	if err != nil {
+		return "", errors.New("payments.reviewPendingTransaction: " + err.Error())
+	}
+
+	transactionID, err := strconv.ParseInt(data[3], 10, 64)
+	if err != nil {
+		return "", errors.New("payments.reviewPendingTransaction: Transaction ID not valid")
+	}
+	comment := data[4]
+	if strings.TrimSpace(comment) == "" {
+		return "", errors.New("payments.reviewPendingTransaction: Comment cannot be empty")
+	}
+
+	transaction, err := getTransaction(transactionID)
+	if err != nil {
+		return "", errors.New("payments.reviewPendingTransaction: " + err.Error())
+	}
+
+	status := HOLD_STATUS_APPROVED
+	if reviewType == REVIEW_REJECT {
+		status = HOLD_STATUS_REJECTED
+	}
+
+	// Accounts frozen or closed while the payment was held cannot make or receive it
+	if status == HOLD_STATUS_APPROVED {
+		err = checkReviewAccounts(transaction)
+		if err != nil {
+			return "", errors.New("payments.reviewPendingTransaction: " + err.Error())
+		}
+	}
+
+	// Fees are stored as an amount, the ledger expects a percentage
+	if transaction.Amount.Sign() != 0 {
+		transaction.Fee = transaction.Fee.Div(transaction.Amount)
+	}
+
+	err = applyReview(Config.Db, transaction, status, comment, reviewer)
+	if err != nil {
+		return "", errors.New("payments.reviewPendingTransaction: " + err.Error())
+	}
+
+	// A rejected payment gives back the spending limits it was counted against
+	if status == HOLD_STATUS_REJECTED {
+		err = limits.ReleaseHeld(transactionID)
+		if err != nil {
+			log.Printf("payments.reviewPendingTransaction: %v", err)
+		}
+	}
+
+	// Only accounts in this bank are told, as in checkReviewAccounts
+	if status == HOLD_STATUS_APPROVED {
+		if transaction.Sender.BankNumber == "" {
+			go push.SendNotification(transaction.Sender.AccountNumber, "💸 Payment sent!", 1, "default")
+		}
+		if transaction.Receiver.BankNumber == "" {
+			go push.SendNotification(transaction.Receiver.AccountNumber, "💸 Payment received!", 1, "default")
+		}
+		return "Transaction approved", nil
+	}
+
+	if transaction.Sender.BankNumber == "" {
+		go push.SendNotification(transaction.Sender.AccountNumber, "🚫 Payment of "+money.Format(transaction.Amount)+" was rejected", 1, "default")
+	}
+	return "Transaction rejected", nil
+}
+
+// checkReviewAccounts checks the accounts of a held payment in this bank are
+// still active, as an unheld payment's are
+func checkReviewAccounts(transaction PAINTrans) (err error) {
+	if transaction.Sender.BankNumber == "" {
+		err = accounts.CheckAccountActive(transaction.Sender.AccountNumber)
+		if err != nil {
+			return errors.New("payments.checkReviewAccounts: Sender " + err.Error())
+		}
+	}
+	if transaction.Receiver.BankNumber == "" {
+		err = accounts.CheckAccountActive(transaction.Receiver.AccountNumber)
+		if err != nil {
+			return errors.New("payments.checkReviewAccounts: Recipient " + err.Error())
+		}
+	}
+	return
+}
+
+// applyReview claims a pending transaction, so it can only be reviewed once,
+// gives back the held funds, moves the balances if it was approved and records
+// the review, all in one database transaction. A failure part way leaves it
+// pending with its funds still held.
+func applyReview(db *sql.DB, transaction PAINTrans, status string, comment string, reviewer string) (err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return errors.New("payments.applyReview: " + err.Error())
+	}
+
+	claimed, err := setPendingTransactionStatus(tx, int64(transaction.ID), status)
+	if err != nil {
+		_ = tx.Rollback()
+		return errors.New("payments.applyReview: " + err.Error())
+	}
+	if !claimed {
+		_ = tx.Rollback()
+		return errors.New("payments.applyReview: Transaction is not pending")
+	}
+
+	err = releaseSenderFunds(tx, transaction)
+	if err != nil {
+		_ = tx.Rollback()
+		return errors.New("payments.applyReview: " + err.Error())
+	}
+
+	if status == HOLD_STATUS_APPROVED {
+		err = updateAccounts(tx, transaction)
+		if err != nil {
+			_ = tx.Rollback()
+			return errors.New("payments.applyReview: " + err.Error())
+		}
+	}
+
+	err = reviewTransactionHolds(tx, int64(transaction.ID), status, comment, reviewer)
+	if err != nil {
+		_ = tx.Rollback()
+		return errors.New("payments.applyReview: " + err.Error())
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return errors.New("payments.applyReview: " + err.Error())
+	}
+	return
+}
+
+// heldAmount is what holdSenderFunds took from the sender's available balance
+func heldAmount(transaction PAINTrans) decimal.Decimal {
+	return transaction.Amount.Add(money.Round(transaction.Amount.Mul(transaction.Fee)))
+}
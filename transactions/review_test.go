@@ -0,0 +1,153 @@
+package transactions
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestHeldAmount(t *testing.T) {
+	transaction := PAINTrans{}
+	transaction.Amount = decimal.NewFromFloat(1000)
+	transaction.Fee = decimal.NewFromFloat(TRANSACTION_FEE)
+
+	held := heldAmount(transaction)
+	if !held.Equals(decimal.NewFromFloat(1000.1)) {
+		t.Errorf("HeldAmount does not pass. Looking for %v, got %v", "1000.1", held)
+	}
+
+	// A stored fee amount converted back to a percentage holds the same amount
+	transaction.Fee = decimal.NewFromFloat(0.1).Div(transaction.Amount)
+	held = heldAmount(transaction)
+	if !held.Equals(decimal.NewFromFloat(1000.1)) {
+		t.Errorf("HeldAmount from stored fee does not pass. Looking for %v, got %v", "1000.1", held)
+	}
+}
+
+// reviewDriver is a database that takes every statement and counts what it
+// was asked to do, failing the statements that contain failOn
+type reviewDriver struct {
+	mu        sync.Mutex
+	failOn    string
+	executed  []string
+	commits   int
+	rollbacks int
+}
+
+func (d *reviewDriver) Open(name string) (driver.Conn, error) {
+	return reviewConn{d}, nil
+}
+
+type reviewConn struct {
+	d *reviewDriver
+}
+
+func (c reviewConn) Prepare(query string) (driver.Stmt, error) {
+	return reviewStmt{c.d, query}, nil
+}
+
+func (c reviewConn) Close() error {
+	return nil
+}
+
+func (c reviewConn) Begin() (driver.Tx, error) {
+	return reviewTx{c.d}, nil
+}
+
+type reviewTx struct {
+	d *reviewDriver
+}
+
+func (tx reviewTx) Commit() error {
+	tx.d.mu.Lock()
+	defer tx.d.mu.Unlock()
+	tx.d.commits++
+	return nil
+}
+
+func (tx reviewTx) Rollback() error {
+	tx.d.mu.Lock()
+	defer tx.d.mu.Unlock()
+	tx.d.rollbacks++
+	return nil
+}
+
+type reviewStmt struct {
+	d     *reviewDriver
+	query string
+}
+
+func (s reviewStmt) Close() error {
+	return nil
+}
+
+func (s reviewStmt) NumInput() int {
+	return -1
+}
+
+func (s reviewStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	if s.d.failOn != "" && strings.Contains(s.query, s.d.failOn) {
+		return nil, errors.New("statement failed")
+	}
+	s.d.executed = append(s.d.executed, s.query)
+	return driver.RowsAffected(1), nil
+}
+
+func (s reviewStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return nil, errors.New("not supported")
+}
+
+var reviewDrivers = 0
+
+// openReviewDB gives a database on a new reviewDriver
+func openReviewDB(t *testing.T, failOn string) (*sql.DB, *reviewDriver) {
+	d := &reviewDriver{failOn: failOn}
+	reviewDrivers++
+	name := "review" + strconv.Itoa(reviewDrivers)
+	sql.Register(name, d)
+	db, err := sql.Open(name, "")
+	if err != nil {
+		t.Fatalf("Could not open database. %v", err)
+	}
+	return db, d
+}
+
+func TestApplyReview(t *testing.T) {
+	transaction := PAINTrans{ID: 1, PainType: 1, Amount: decimal.NewFromFloat(100), Fee: decimal.NewFromFloat(TRANSACTION_FEE)}
+	transaction.Sender.AccountNumber = "sender"
+	transaction.Receiver.AccountNumber = "receiver"
+
+	db, d := openReviewDB(t, "")
+	if err := applyReview(db, transaction, HOLD_STATUS_APPROVED, "checked", "reviewer"); err != nil {
+		t.Fatalf("ApplyReview does not pass. Looking for %v, got %v", nil, err)
+	}
+	// Claim, release, sender, receiver, holding account and holds
+	if d.commits != 1 || d.rollbacks != 0 || len(d.executed) != 6 {
+		t.Errorf("ApplyReview does not pass. Looking for %v, got %v commits, %v rollbacks and %v statements", "1 commit of 6 statements", d.commits, d.rollbacks, len(d.executed))
+	}
+}
+
+func TestApplyReviewFailure(t *testing.T) {
+	transaction := PAINTrans{ID: 1, PainType: 1, Amount: decimal.NewFromFloat(100), Fee: decimal.NewFromFloat(TRANSACTION_FEE)}
+	transaction.Sender.AccountNumber = "sender"
+	transaction.Receiver.AccountNumber = "receiver"
+
+	// Failing after the claim and the balances moved undoes all of it
+	for _, failOn := range []string{"`accountBalance`", "transactions_holds"} {
+		db, d := openReviewDB(t, failOn)
+		if err := applyReview(db, transaction, HOLD_STATUS_APPROVED, "checked", "reviewer"); err == nil {
+			t.Errorf("ApplyReviewFailure %v does not pass. Looking for %v, got %v", failOn, "error", err)
+		}
+		if d.commits != 0 || d.rollbacks != 1 || len(d.executed) < 2 {
+			t.Errorf("ApplyReviewFailure %v does not pass. Looking for %v, got %v commits, %v rollbacks after %v statements", failOn, "rollback", d.commits, d.rollbacks, len(d.executed))
+		}
+	}
+}
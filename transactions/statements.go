@@ -0,0 +1,358 @@
+package transactions
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/bvnk/bank/accounts"
+	"github.com/bvnk/bank/appauth"
+	"github.com/bvnk/bank/money"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	STATEMENT_DATE_FORMAT = "2006-01-02"
+
+	STATEMENT_FORMAT_CSV   = "csv"
+	STATEMENT_FORMAT_PDF   = "pdf"
+	STATEMENT_FORMAT_XML   = "xml"
+	STATEMENT_FORMAT_OFX   = "ofx"
+	STATEMENT_FORMAT_QIF   = "qif"
+	STATEMENT_FORMAT_MT940 = "mt940"
+
+	DEFAULT_CURRENCY = "USD"
+)
+
+// Statement is the activity on an account between two dates
+type Statement struct {
+	AccountNumber     string
+	BankNumber        string
+	AccountHolderName string
+	Currency          string
+	From              time.Time
+	To                time.Time
+	OpeningBalance    decimal.Decimal
+	ClosingBalance    decimal.Decimal
+	TotalCredits      decimal.Decimal
+	TotalDebits       decimal.Decimal
+	Lines             []StatementLine
+	Transactions      []PAINTrans
+	Created           time.Time
+}
+
+// StatementLine is a single transaction on a statement.
+// Amount is signed, negative amounts are debits and include the fee.
+type StatementLine struct {
+	TransactionID int32
+	PainType      int64
+	Timestamp     time.Time
+	ValueDate     string
+	Description   string
+	Counterparty  string
+	Amount        decimal.Decimal
+	Fee           decimal.Decimal
+	Balance       decimal.Decimal
+}
+
+// StatementFile is a generated document ready to be sent to the customer
+type StatementFile struct {
+	FileName    string
+	ContentType string
+	Content     []byte
+}
+
+func accountStatement(data []string, format string) (result StatementFile, err error) {
+	statement, err := authorisedStatement(data[0], data[3], data[4], data[5])
+	if err != nil {
+		return StatementFile{}, errors.New("payments.accountStatement: " + err.Error())
+	}
+
+	fileName := "statement-" + statement.AccountNumber + "-" + data[4] + "-" + data[5]
+	switch format {
+	case STATEMENT_FORMAT_CSV:
+		result.Content, err = statementCSV(statement)
+		result.FileName = fileName + ".csv"
+		result.ContentType = "text/csv; charset=UTF-8"
+	case STATEMENT_FORMAT_PDF:
+		result.Content = statementPDF(statement)
+		result.FileName = fileName + ".pdf"
+		result.ContentType = "application/pdf"
+	case STATEMENT_FORMAT_XML:
+		result.Content, err = statementCamt053(statement)
+		result.FileName = fileName + ".xml"
+		result.ContentType = "application/xml; charset=UTF-8"
+	case STATEMENT_FORMAT_OFX:
+		result.Content, err = exportOFX(statement)
+		result.FileName = fileName + ".ofx"
+		result.ContentType = "application/x-ofx"
+	case STATEMENT_FORMAT_QIF:
+		result.Content = exportQIF(statement)
+		result.FileName = fileName + ".qif"
+		result.ContentType = "application/qif"
+	case STATEMENT_FORMAT_MT940:
+		result.Content = exportMT940(statement)
+		result.FileName = fileName + ".sta"
+		result.ContentType = "text/plain; charset=US-ASCII"
+	default:
+		return StatementFile{}, errors.New("payments.accountStatement: Format not valid, must be one of csv, pdf, xml, ofx, qif, mt940")
+	}
+	if err != nil {
+		return StatementFile{}, errors.New("payments.accountStatement: " + err.Error())
+	}
+
+	return
+}
+
+// authorisedStatement checks the token holder owns the account before generating its statement
+func authorisedStatement(token string, accountNumber string, fromStr string, toStr string) (statement Statement, err error) {
+	tokenUser, merchantID, err := appauth.GetUserFromScopedToken(token, appauth.SCOPE_PAYMENTS_READ)
+	if err != nil {
+		return Statement{}, errors.New("payments.authorisedStatement: " + err.Error())
+	}
+	err = accounts.CheckUserAccountValidFromToken(tokenUser, accountNumber)
+	if err != nil {
+		return Statement{}, errors.New("payments.authorisedStatement: " + err.Error())
+	}
+	err = accounts.CheckMerchantAccount(merchantID, accountNumber)
+	if err != nil {
+		return Statement{}, errors.New("payments.authorisedStatement: " + err.Error())
+	}
+
+	from, to, err := parseStatementPeriod(fromStr, toStr)
+	if err != nil {
+		return Statement{}, errors.New("payments.authorisedStatement: " + err.Error())
+	}
+
+	statement, err = generateStatement(accountNumber, from, to)
+	if err != nil {
+		return Statement{}, errors.New("payments.authorisedStatement: " + err.Error())
+	}
+	return
+}
+
+// parseStatementPeriod reads from and to dates, both inclusive, in the bank's time zone.
+// The returned to is the start of the day after the last day.
+func parseStatementPeriod(fromStr string, toStr string) (from time.Time, to time.Time, err error) {
+	from, err = time.ParseInLocation(STATEMENT_DATE_FORMAT, fromStr, location())
+	if err != nil {
+		return time.Time{}, time.Time{}, errors.New("payments.parseStatementPeriod: From date must be in the format YYYY-MM-DD")
+	}
+	to, err = time.ParseInLocation(STATEMENT_DATE_FORMAT, toStr, location())
+	if err != nil {
+		return time.Time{}, time.Time{}, errors.New("payments.parseStatementPeriod: To date must be in the format YYYY-MM-DD")
+	}
+	if to.Before(from) {
+		return time.Time{}, time.Time{}, errors.New("payments.parseStatementPeriod: To date cannot be before from date")
+	}
+
+	to = to.AddDate(0, 0, 1)
+	return
+}
+
+// generateStatement works back from the current balance to the opening balance,
+// as only the current balance is stored
+func generateStatement(accountNumber string, from time.Time, to time.Time) (statement Statement, err error) {
+	account, err := accounts.GetAccountByAccountNumber(accountNumber)
+	if err != nil {
+		return Statement{}, errors.New("payments.generateStatement: " + err.Error())
+	}
+
+	transactions, err := getApprovedTransactionsSince(accountNumber, int32(from.Unix()))
+	if err != nil {
+		return Statement{}, errors.New("payments.generateStatement: " + err.Error())
+	}
+
+	// Everything since the start of the period, including after it, is taken off the current balance
+	opening := account.AccountBalance
+	inPeriod := []PAINTrans{}
+	for _, transaction := range transactions {
+		opening = opening.Sub(accountMovement(transaction, accountNumber))
+		if int64(transaction.Timestamp) < to.Unix() {
+			inPeriod = append(inPeriod, transaction)
+		}
+	}
+
+	statement = buildStatement(accountNumber, account.AccountHolderName, from, to, opening, inPeriod)
+	statement.BankNumber = account.BankNumber
+	return
+}
+
+// buildStatement adds the running balance and totals to the transactions in a period
+func buildStatement(accountNumber string, holderName string, from time.Time, to time.Time, opening decimal.Decimal, transactions []PAINTrans) (statement Statement) {
+	statement = Statement{
+		AccountNumber:     accountNumber,
+		AccountHolderName: holderName,
+		Currency:          currency(),
+		From:              from,
+		To:                to,
+		OpeningBalance:    opening,
+		Transactions:      transactions,
+		TotalCredits:      decimal.Zero,
+		TotalDebits:       decimal.Zero,
+		Created:           time.Now().In(location()),
+	}
+
+	balance := opening
+	for _, transaction := range transactions {
+		movement := accountMovement(transaction, accountNumber)
+		balance = balance.Add(movement)
+
+		if movement.Sign() < 0 {
+			statement.TotalDebits = statement.TotalDebits.Add(movement.Neg())
+		} else {
+			statement.TotalCredits = statement.TotalCredits.Add(movement)
+		}
+
+		// Only the side that paid the fee shows it
+		counterparty := transaction.Sender.AccountNumber
+		fee := decimal.Zero
+		if transaction.Sender.AccountNumber == accountNumber || transaction.PainType == 1000 {
+			fee = transaction.Fee
+		}
+		if transaction.Sender.AccountNumber == accountNumber {
+			counterparty = transaction.Receiver.AccountNumber
+		}
+
+		statement.Lines = append(statement.Lines, StatementLine{
+			TransactionID: transaction.ID,
+			PainType:      transaction.PainType,
+			Timestamp:     time.Unix(int64(transaction.Timestamp), 0).In(location()),
+			ValueDate:     transaction.ValueDate,
+			Description:   transaction.Desc,
+			Counterparty:  counterparty,
+			Amount:        movement,
+			Fee:           fee,
+			Balance:       balance,
+		})
+	}
+
+	statement.ClosingBalance = balance
+	return
+}
+
+// accountMovement is how a transaction changed the balance of an account.
+// Fees are stored as an amount. The sender of a payment pays the fee, the
+// receiver of a deposit has it taken off the deposit.
+func accountMovement(transaction PAINTrans, accountNumber string) (movement decimal.Decimal) {
+	movement = decimal.Zero
+	if transaction.PainType == 1000 {
+		if transaction.Receiver.AccountNumber == accountNumber {
+			movement = transaction.Amount.Sub(transaction.Fee)
+		}
+		return
+	}
+
+	if transaction.Sender.AccountNumber == accountNumber {
+		movement = movement.Sub(transaction.Amount.Add(transaction.Fee))
+	}
+	if transaction.Receiver.AccountNumber == accountNumber {
+		movement = movement.Add(transaction.Amount)
+	}
+	return
+}
+
+func statementCSV(statement Statement) (content []byte, err error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+
+	rows := [][]string{
+		{"Account", statement.AccountNumber},
+		{"Account holder", statement.AccountHolderName},
+		{"Currency", statement.Currency},
+		{"From", statement.From.Format(STATEMENT_DATE_FORMAT)},
+		{"To", statement.To.AddDate(0, 0, -1).Format(STATEMENT_DATE_FORMAT)},
+		{"Opening balance", money.Format(statement.OpeningBalance)},
+		{},
+		{"Date", "Value date", "TransactionID", "Description", "Counterparty", "Amount", "Fee", "Balance"},
+	}
+	for _, line := range statement.Lines {
+		rows = append(rows, []string{
+			line.Timestamp.Format(time.RFC3339),
+			line.ValueDate,
+			strconv.Itoa(int(line.TransactionID)),
+			line.Description,
+			line.Counterparty,
+			money.Format(line.Amount),
+			money.Format(line.Fee),
+			money.Format(line.Balance),
+		})
+	}
+	rows = append(rows,
+		[]string{},
+		[]string{"Total credits", money.Format(statement.TotalCredits)},
+		[]string{"Total debits", money.Format(statement.TotalDebits)},
+		[]string{"Closing balance", money.Format(statement.ClosingBalance)},
+	)
+
+	err = w.WriteAll(rows)
+	if err != nil {
+		return nil, errors.New("payments.statementCSV: " + err.Error())
+	}
+
+	content = buf.Bytes()
+	return
+}
+
+func statementPDF(statement Statement) []byte {
+	header := []string{
+		"ACCOUNT STATEMENT",
+		"",
+		"Account:          " + statement.AccountNumber,
+		"Account holder:   " + statement.AccountHolderName,
+		"Period:           " + statement.From.Format(STATEMENT_DATE_FORMAT) + " to " + statement.To.AddDate(0, 0, -1).Format(STATEMENT_DATE_FORMAT),
+		"Currency:         " + statement.Currency,
+		"Opening balance:  " + money.Format(statement.OpeningBalance),
+		"",
+		fmt.Sprintf("%-16s %-8s %-30s %14s %14s", "Date", "ID", "Description", "Amount", "Balance"),
+	}
+
+	lines := []string{}
+	for _, line := range statement.Lines {
+		lines = append(lines, fmt.Sprintf("%-16s %-8d %-30s %14s %14s",
+			line.Timestamp.Format("2006-01-02 15:04"),
+			line.TransactionID,
+			truncate(line.Description, 30),
+			money.Format(line.Amount),
+			money.Format(line.Balance),
+		))
+	}
+
+	footer := []string{
+		"",
+		"Total credits:    " + money.Format(statement.TotalCredits),
+		"Total debits:     " + money.Format(statement.TotalDebits),
+		"Closing balance:  " + money.Format(statement.ClosingBalance),
+		"",
+		"Generated " + statement.Created.Format(time.RFC1123),
+	}
+
+	doc := append(append(header, lines...), footer...)
+	return renderPDF(doc)
+}
+
+func truncate(s string, length int) string {
+	runes := []rune(s)
+	if len(runes) <= length {
+		return s
+	}
+	return string(runes[:length-3]) + "..."
+}
+
+func currency() string {
+	if Config.Currency == "" {
+		return DEFAULT_CURRENCY
+	}
+	return Config.Currency
+}
+
+func location() *time.Location {
+	loc, err := time.LoadLocation(Config.TimeZone)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
@@ -0,0 +1,223 @@
+package transactions
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func statementTestTransactions() []PAINTrans {
+	deposit := PAINTrans{}
+	deposit.ID = 1
+	deposit.PainType = 1000
+	deposit.Receiver.AccountNumber = "a"
+	deposit.Amount = decimal.NewFromFloat(100)
+	deposit.Fee = decimal.NewFromFloat(1)
+	deposit.Timestamp = 1450000000
+	deposit.ValueDate = "2015-12-13"
+
+	payment := PAINTrans{}
+	payment.ID = 2
+	payment.PainType = 1
+	payment.Sender.AccountNumber = "a"
+	payment.Receiver.AccountNumber = "b"
+	payment.Amount = decimal.NewFromFloat(50)
+	payment.Fee = decimal.NewFromFloat(0.5)
+	payment.Desc = "Rent"
+	payment.Timestamp = 1450000100
+	payment.ValueDate = "2015-12-13"
+
+	received := PAINTrans{}
+	received.ID = 3
+	received.PainType = 1
+	received.Sender.AccountNumber = "b"
+	received.Receiver.AccountNumber = "a"
+	received.Amount = decimal.NewFromFloat(20)
+	received.Fee = decimal.NewFromFloat(0.2)
+	received.Timestamp = 1450000200
+	// Booked after the business date was closed
+	received.ValueDate = "2015-12-14"
+
+	return []PAINTrans{deposit, payment, received}
+}
+
+func testStatement() Statement {
+	from := time.Date(2015, 12, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+	return buildStatement("a", "Test Holder", from, to, decimal.NewFromFloat(10), statementTestTransactions())
+}
+
+func TestAccountMovement(t *testing.T) {
+	tests := []struct {
+		transaction   int
+		accountNumber string
+		movement      float64
+	}{
+		{0, "a", 99},
+		{1, "a", -50.5},
+		{1, "b", 50},
+		{2, "a", 20},
+		{2, "c", 0},
+	}
+
+	transactions := statementTestTransactions()
+	for _, test := range tests {
+		movement := accountMovement(transactions[test.transaction], test.accountNumber)
+		if !movement.Equals(decimal.NewFromFloat(test.movement)) {
+			t.Errorf("AccountMovement does not pass. Looking for %v, got %v", test.movement, movement)
+		}
+	}
+}
+
+func TestBuildStatement(t *testing.T) {
+	statement := testStatement()
+
+	if len(statement.Lines) != 3 {
+		t.Fatalf("BuildStatement lines does not pass. Looking for %v, got %v", 3, len(statement.Lines))
+	}
+
+	balances := []float64{109, 58.5, 78.5}
+	for i, balance := range balances {
+		if !statement.Lines[i].Balance.Equals(decimal.NewFromFloat(balance)) {
+			t.Errorf("BuildStatement running balance does not pass. Looking for %v, got %v", balance, statement.Lines[i].Balance)
+		}
+	}
+
+	if !statement.ClosingBalance.Equals(decimal.NewFromFloat(78.5)) {
+		t.Errorf("BuildStatement closing balance does not pass. Looking for %v, got %v", 78.5, statement.ClosingBalance)
+	}
+	if !statement.TotalCredits.Equals(decimal.NewFromFloat(119)) {
+		t.Errorf("BuildStatement total credits does not pass. Looking for %v, got %v", 119, statement.TotalCredits)
+	}
+	if !statement.TotalDebits.Equals(decimal.NewFromFloat(50.5)) {
+		t.Errorf("BuildStatement total debits does not pass. Looking for %v, got %v", 50.5, statement.TotalDebits)
+	}
+
+	// The receiver of a payment does not see the sender's fee
+	if !statement.Lines[2].Fee.Equals(decimal.Zero) {
+		t.Errorf("BuildStatement receiver fee does not pass. Looking for %v, got %v", 0, statement.Lines[2].Fee)
+	}
+	if statement.Lines[1].Counterparty != "b" {
+		t.Errorf("BuildStatement counterparty does not pass. Looking for %v, got %v", "b", statement.Lines[1].Counterparty)
+	}
+}
+
+func TestParseStatementPeriod(t *testing.T) {
+	from, to, err := parseStatementPeriod("2016-01-01", "2016-01-31")
+	if err != nil {
+		t.Fatalf("ParseStatementPeriod does not pass. Looking for %v, got %v", nil, err)
+	}
+	if to.Sub(from) != 31*24*time.Hour {
+		t.Errorf("ParseStatementPeriod does not pass. Looking for %v, got %v", 31*24*time.Hour, to.Sub(from))
+	}
+
+	_, _, err = parseStatementPeriod("2016-02-01", "2016-01-31")
+	if err == nil {
+		t.Errorf("ParseStatementPeriod to before from does not pass. Looking for %v, got %v", "To date cannot be before from date", nil)
+	}
+
+	_, _, err = parseStatementPeriod("01/01/2016", "2016-01-31")
+	if err == nil {
+		t.Errorf("ParseStatementPeriod format does not pass. Looking for %v, got %v", "From date must be in the format YYYY-MM-DD", nil)
+	}
+}
+
+func TestStatementCSV(t *testing.T) {
+	content, err := statementCSV(testStatement())
+	if err != nil {
+		t.Fatalf("StatementCSV does not pass. Looking for %v, got %v", nil, err)
+	}
+
+	r := csv.NewReader(bytes.NewReader(content))
+	r.FieldsPerRecord = -1
+	rows, err := r.ReadAll()
+	if err != nil {
+		t.Fatalf("StatementCSV read does not pass. Looking for %v, got %v", nil, err)
+	}
+
+	last := rows[len(rows)-1]
+	if last[0] != "Closing balance" || last[1] != "78.50" {
+		t.Errorf("StatementCSV closing balance does not pass. Looking for %v, got %v", "78.50", last)
+	}
+	if rows[8][1] != "2015-12-13" {
+		t.Errorf("StatementCSV value date does not pass. Looking for %v, got %v", "2015-12-13", rows[8])
+	}
+	if rows[5][1] != "10.00" {
+		t.Errorf("StatementCSV opening balance does not pass. Looking for %v, got %v", "10.00", rows[5][1])
+	}
+}
+
+func TestRenderPDF(t *testing.T) {
+	content := renderPDF([]string{"Hello (world)"})
+	if !bytes.HasPrefix(content, []byte("%PDF-")) {
+		t.Errorf("RenderPDF header does not pass. Looking for %v, got %v", "%PDF-", string(content[:8]))
+	}
+	if !bytes.Contains(content, []byte("xref")) || !bytes.HasSuffix(content, []byte("%%EOF\n")) {
+		t.Errorf("RenderPDF trailer does not pass. Looking for %v, got %v", "xref and %%EOF", nil)
+	}
+	if !bytes.Contains(content, []byte(`Hello \(world\)`)) {
+		t.Errorf("RenderPDF escaping does not pass. Looking for %v, got %v", `Hello \(world\)`, nil)
+	}
+
+	lines := make([]string, PDF_LINES_PER_PAGE*2+1)
+	content = renderPDF(lines)
+	if !bytes.Contains(content, []byte("/Count 3")) {
+		t.Errorf("RenderPDF pages does not pass. Looking for %v, got %v", "/Count 3", nil)
+	}
+}
+
+func TestStatementCamt053(t *testing.T) {
+	content, err := statementCamt053(testStatement())
+	if err != nil {
+		t.Fatalf("StatementCamt053 does not pass. Looking for %v, got %v", nil, err)
+	}
+
+	document := camtDocument{}
+	err = xml.Unmarshal(content, &document)
+	if err != nil {
+		t.Fatalf("StatementCamt053 unmarshal does not pass. Looking for %v, got %v", nil, err)
+	}
+
+	if document.Xmlns != CAMT_053_NAMESPACE {
+		t.Errorf("StatementCamt053 namespace does not pass. Looking for %v, got %v", CAMT_053_NAMESPACE, document.Xmlns)
+	}
+	if document.Statement == nil || document.Statement.Stmt == nil {
+		t.Fatalf("StatementCamt053 Stmt does not pass. Looking for %v, got %v", "Stmt", nil)
+	}
+
+	stmt := document.Statement.Stmt
+	if document.Statement.GrpHdr.MsgId == "" || stmt.Id == "" || stmt.CreDtTm == "" {
+		t.Errorf("StatementCamt053 identification does not pass. Looking for %v, got %v", "MsgId, Id and CreDtTm", stmt.Id)
+	}
+	if stmt.Acct.Id.Othr.Id != "a" {
+		t.Errorf("StatementCamt053 account does not pass. Looking for %v, got %v", "a", stmt.Acct.Id.Othr.Id)
+	}
+	if len(stmt.Bal) != 2 || stmt.Bal[0].Tp.CdOrPrtry.Cd != "OPBD" || stmt.Bal[1].Tp.CdOrPrtry.Cd != "CLBD" {
+		t.Fatalf("StatementCamt053 balances does not pass. Looking for %v, got %v", "OPBD and CLBD", stmt.Bal)
+	}
+	if stmt.Bal[1].Amt.Value != "78.50" || stmt.Bal[1].Amt.Ccy == "" {
+		t.Errorf("StatementCamt053 closing balance does not pass. Looking for %v, got %v", "78.50", stmt.Bal[1].Amt)
+	}
+	if len(stmt.Ntry) != 3 {
+		t.Fatalf("StatementCamt053 entries does not pass. Looking for %v, got %v", 3, len(stmt.Ntry))
+	}
+
+	payment := stmt.Ntry[1]
+	if payment.CdtDbtInd != CAMT_DEBIT || payment.Amt.Value != "50.50" || payment.Sts != "BOOK" {
+		t.Errorf("StatementCamt053 debit entry does not pass. Looking for %v, got %v", "DBIT 50.50 BOOK", payment)
+	}
+	if payment.BookgDt.DtTm == "" || stmt.Ntry[2].ValDt.Dt != "2015-12-14" {
+		t.Errorf("StatementCamt053 value date does not pass. Looking for %v, got %v", "2015-12-14", stmt.Ntry[2].ValDt)
+	}
+	if payment.NtryDtls.TxDtls.RltdPties == nil || payment.NtryDtls.TxDtls.RltdPties.CdtrAcct == nil {
+		t.Errorf("StatementCamt053 creditor does not pass. Looking for %v, got %v", "b", nil)
+	}
+	if !strings.Contains(string(content), "<Ustrd>Rent</Ustrd>") {
+		t.Errorf("StatementCamt053 remittance does not pass. Looking for %v, got %v", "Rent", nil)
+	}
+}
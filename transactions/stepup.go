@@ -0,0 +1,42 @@
+package transactions
+
+import (
+	"errors"
+
+	"github.com/bvnk/bank/appauth"
+	"github.com/bvnk/bank/fraud"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	// Payments at or above this need a one-time code if Config.Auth.StepUpAmount is not set
+	DEFAULT_STEP_UP_AMOUNT = 1000
+)
+
+// stepUpRequired is true for a payment the user must approve with a one-time code
+func stepUpRequired(amount decimal.Decimal, stepUpAmount decimal.Decimal, knownPayee bool) bool {
+	if stepUpAmount.Sign() == 0 {
+		stepUpAmount = decimal.NewFromFloat(DEFAULT_STEP_UP_AMOUNT)
+	}
+	return !knownPayee || amount.Cmp(stepUpAmount) >= 0
+}
+
+// checkStepUp asks for one step-up for the payments if any of them needs it
+func checkStepUp(token string, transactions []PAINTrans) (err error) {
+	for _, transaction := range transactions {
+		known, err := fraud.IsKnownPayee(transaction.Sender.AccountNumber, transaction.Receiver.AccountNumber)
+		if err != nil {
+			return errors.New("payments.checkStepUp: " + err.Error())
+		}
+		if !stepUpRequired(transaction.Amount, Config.Auth.StepUpAmount, known) {
+			continue
+		}
+
+		err = appauth.CheckStepUp(token)
+		if err != nil {
+			return errors.New("payments.checkStepUp: " + err.Error())
+		}
+		return nil
+	}
+	return
+}
@@ -0,0 +1,31 @@
+package transactions
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestStepUpRequired(t *testing.T) {
+	tests := []struct {
+		amount     decimal.Decimal
+		stepUp     decimal.Decimal
+		knownPayee bool
+		required   bool
+	}{
+		{decimal.NewFromFloat(50), decimal.NewFromFloat(500), true, false},
+		{decimal.NewFromFloat(500), decimal.NewFromFloat(500), true, true},
+		// Any amount to someone never paid before
+		{decimal.NewFromFloat(1), decimal.NewFromFloat(500), false, true},
+		// Not configured
+		{decimal.NewFromFloat(999), decimal.Zero, true, false},
+		{decimal.NewFromFloat(DEFAULT_STEP_UP_AMOUNT), decimal.Zero, true, true},
+	}
+
+	for _, test := range tests {
+		required := stepUpRequired(test.amount, test.stepUp, test.knownPayee)
+		if required != test.required {
+			t.Errorf("StepUpRequired does not pass for %v %v %v. Looking for %v, got %v", test.amount, test.stepUp, test.knownPayee, test.required, required)
+		}
+	}
+}
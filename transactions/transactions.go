@@ -18,23 +18,60 @@ Payments mandates:
 #### Custom payments
 1000 - CustomerDepositInitiation (@FIXME Will need to implement this properly, for now we use it to demonstrate functionality)
 1001 - ListTransactions
+1002 - ListPendingTransactions (staff)
+1003 - ApprovePendingTransaction (staff)
+1004 - RejectPendingTransaction (staff)
+1005 - CreateBatch (csv or json, atomic or best-effort)
+1006 - ViewBatch
+1007 - ReconcileBalances (staff, dryrun or repair)
+
+CAMT transactions are as follows
+
+Cash management:
+52 - BankToCustomerAccountReportV04
+53 - BankToCustomerStatementV04
+54 - BankToCustomerDebitCreditNotificationV04
+
+#### Custom cash management
+1000 - AccountStatement (csv, pdf, xml, ofx, qif or mt940)
 
 */
 
 import (
 	"errors"
+	"log"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/bvnk/bank/accounts"
+	"github.com/bvnk/bank/aml"
 	"github.com/bvnk/bank/appauth"
+	"github.com/bvnk/bank/fraud"
+	"github.com/bvnk/bank/interbank"
+	"github.com/bvnk/bank/limits"
+	"github.com/bvnk/bank/money"
 	"github.com/bvnk/bank/push"
+	"github.com/bvnk/bank/sanctions"
 	"github.com/paulmach/go.geo"
 	"github.com/shopspring/decimal"
 )
 
 const TRANSACTION_FEE = 0.0001 // 0.01%
 
+// Sources that can hold a transaction in pending
+const (
+	HOLD_SOURCE_FRAUD     = "fraud"
+	HOLD_SOURCE_SANCTIONS = "sanctions"
+)
+
+// transactionHold is one reason a transaction is held in pending
+type transactionHold struct {
+	source  string
+	score   int
+	reasons string
+}
+
 // @TODO Have this struct not repeat in payments and accounts
 type AccountHolder struct {
 	AccountNumber string
@@ -52,6 +89,8 @@ type PAINTrans struct {
 	Desc      string
 	Status    string
 	Timestamp int32
+	// The business date it takes effect on, YYYY-MM-DD
+	ValueDate string
 }
 
 func ProcessPAIN(data []string) (result interface{}, err error) {
@@ -79,9 +118,9 @@ func ProcessPAIN(data []string) (result interface{}, err error) {
 		}
 		break
 	case 1000:
-		//There must be at least 8 elements
-		//token~pain~type~amount~lat~lon~desc
-		if len(data) < 8 {
+		//There must be at least 10 elements
+		//token~pain~type~accountDetails~amount~lat~lon~desc~basicAuthUser~basicAuthPassword
+		if len(data) < 10 {
 			return "", errors.New("payments.ProcessPAIN: Not all data is present.")
 		}
 		// For now we exclude customer deposits
@@ -100,6 +139,56 @@ func ProcessPAIN(data []string) (result interface{}, err error) {
 			return "", errors.New("payments.ProcessPAIN: " + err.Error())
 		}
 		break
+	case 1002:
+		//~pain~type~basicAuthUser~basicAuthPassword
+		if len(data) < 5 {
+			return "", errors.New("payments.ProcessPAIN: Not all data is present.")
+		}
+		result, err = listPendingTransactions(data)
+		if err != nil {
+			return "", errors.New("payments.ProcessPAIN: " + err.Error())
+		}
+		break
+	case 1003, 1004:
+		//~pain~type~transactionID~comment~basicAuthUser~basicAuthPassword
+		if len(data) < 7 {
+			return "", errors.New("payments.ProcessPAIN: Not all data is present.")
+		}
+		result, err = reviewPendingTransaction(painType, data)
+		if err != nil {
+			return "", errors.New("payments.ProcessPAIN: " + err.Error())
+		}
+		break
+	case 1005:
+		//token~pain~type~senderAccountNumber~mode~format~payload
+		if len(data) < 7 {
+			return "", errors.New("payments.ProcessPAIN: Not all data is present.")
+		}
+		result, err = createBatch(data)
+		if err != nil {
+			return "", errors.New("payments.ProcessPAIN: " + err.Error())
+		}
+		break
+	case 1006:
+		//token~pain~type~batchID
+		if len(data) < 4 {
+			return "", errors.New("payments.ProcessPAIN: Not all data is present.")
+		}
+		result, err = viewBatch(data)
+		if err != nil {
+			return "", errors.New("payments.ProcessPAIN: " + err.Error())
+		}
+		break
+	case 1007:
+		//~pain~type~mode~basicAuthUser~basicAuthPassword
+		if len(data) < 6 {
+			return "", errors.New("payments.ProcessPAIN: Not all data is present.")
+		}
+		result, err = reconcileBalances(data)
+		if err != nil {
+			return "", errors.New("payments.ProcessPAIN: " + err.Error())
+		}
+		break
 	}
 
 	return
@@ -116,6 +205,8 @@ func painCreditTransferInitiation(painType int64, data []string) (result string,
 	if err != nil {
 		return "", errors.New("payments.painCreditTransferInitiation: " + err.Error())
 	}
+	sender.BankNumber = localBankNumber(sender.BankNumber)
+	receiver.BankNumber = localBankNumber(receiver.BankNumber)
 
 	trAmt := strings.TrimRight(data[5], "\x00")
 	transactionAmountDecimal, err := decimal.NewFromString(trAmt)
@@ -123,71 +214,258 @@ func painCreditTransferInitiation(painType int64, data []string) (result string,
 		return "", errors.New("payments.painCreditTransferInitiation: Could not convert transaction amount to decimal. " + err.Error())
 	}
 
+	lat, err := strconv.ParseFloat(data[6], 64)
+	if err != nil {
+		return "", errors.New("payments.painCreditTransferInitiation: Could not parse coordinates into float")
+	}
+	lon, err := strconv.ParseFloat(data[7], 64)
+	if err != nil {
+		return "", errors.New("payments.painCreditTransferInitiation: Could not parse coordinates into float")
+	}
+	desc := data[8]
+
 	// Check if sender valid
-	tokenUser, err := appauth.GetUserFromToken(data[0])
+	tokenUser, merchantID, err := appauth.GetUserFromScopedToken(data[0], appauth.SCOPE_PAYMENTS_CREATE)
 	if err != nil {
 		return "", errors.New("payments.painCreditTransferInitiation: " + err.Error())
 	}
-	err = accounts.CheckUserAccountValidFromToken(tokenUser, sender.AccountNumber)
+	err = accounts.CheckMerchantAccount(merchantID, sender.AccountNumber)
 	if err != nil {
 		return "", errors.New("payments.painCreditTransferInitiation: Sender not valid")
 	}
 
-	// Check if recipient valid
-	_, err = accounts.GetAccountByAccountNumber(receiver.AccountNumber)
+	geo := *geo.NewPoint(lat, lon)
+	transaction := PAINTrans{0, painType, sender, receiver, transactionAmountDecimal, decimal.NewFromFloat(TRANSACTION_FEE), geo, desc, "approved", 0, ""}
+
+	err = checkStepUp(data[0], []PAINTrans{transaction})
 	if err != nil {
-		return "", errors.New("payments.painCreditTransferInitiation: Recipient user not found")
+		return "", errors.New("payments.painCreditTransferInitiation: " + err.Error())
 	}
 
-	lat, err := strconv.ParseFloat(data[6], 64)
+	transactionId, _, err := creditTransfer(tokenUser, transaction, lat, lon)
 	if err != nil {
-		return "", errors.New("payments.painCreditTransferInitiation: Could not parse coordinates into float")
+		return "", errors.New("payments.painCreditTransferInitiation: " + err.Error())
 	}
-	lon, err := strconv.ParseFloat(data[7], 64)
+
+	result = strconv.FormatInt(transactionId, 10)
+	return
+}
+
+// creditTransfer checks a payment from an account held by the user and saves it.
+// The status returned is pending if the payment was held for review.
+func creditTransfer(tokenUser string, transaction PAINTrans, lat float64, lon float64) (transactionId int64, status string, err error) {
+	transaction, holds, reservation, err := checkCreditTransfer(Config.Db, tokenUser, transaction, lat, lon)
 	if err != nil {
-		return "", errors.New("payments.painCreditTransferInitiation: Could not parse coordinates into float")
+		return 0, "", errors.New("payments.creditTransfer: " + err.Error())
 	}
-	desc := data[8]
 
-	geo := *geo.NewPoint(lat, lon)
-	transaction := PAINTrans{0, painType, sender, receiver, transactionAmountDecimal, decimal.NewFromFloat(TRANSACTION_FEE), geo, desc, "approved", 0}
+	transactionId, err = saveCreditTransfer(Config.Db, transaction, holds)
+	if err != nil {
+		releaseLimits(reservation)
+		return 0, "", errors.New("payments.creditTransfer: " + err.Error())
+	}
+	holdLimits(transactionId, transaction, reservation)
+	status = transaction.Status
+
+	monitorCreditTransfer(transactionId, transaction)
+
+	if status == "pending" {
+		go push.SendNotification(transaction.Sender.AccountNumber, "⏳ Payment held for review", 1, "default")
+		return
+	}
+
+	go push.SendNotification(transaction.Sender.AccountNumber, "💸 Payment sent!", 1, "default")
+	if transaction.Receiver.BankNumber == "" {
+		go push.SendNotification(transaction.Receiver.AccountNumber, "💸 Payment received!", 1, "default")
+	}
+
+	return
+}
+
+// checkCreditTransfer runs every check on a payment without saving it, and
+// reserves it against the spending limits, which must be released if it is not
+// saved. The transaction is returned as pending if any check wants it held for review.
+func checkCreditTransfer(db execer, tokenUser string, transaction PAINTrans, lat float64, lon float64) (checked PAINTrans, holds []transactionHold, reservation limits.Reservation, err error) {
+	sender := transaction.Sender
+	receiver := transaction.Receiver
+
+	err = accounts.CheckUserAccountValidFromToken(tokenUser, sender.AccountNumber)
+	if err != nil {
+		return PAINTrans{}, nil, limits.Reservation{}, errors.New("payments.checkCreditTransfer: Sender not valid")
+	}
+
+	senderAccount, err := accounts.GetAccountByAccountNumber(sender.AccountNumber)
+	if err != nil || sender.BankNumber != "" {
+		return PAINTrans{}, nil, limits.Reservation{}, errors.New("payments.checkCreditTransfer: Sender not valid")
+	}
+
+	// Pending, frozen and closed accounts cannot make or receive payments
+	err = accounts.CheckAccountActive(sender.AccountNumber)
+	if err != nil {
+		return PAINTrans{}, nil, limits.Reservation{}, errors.New("payments.checkCreditTransfer: " + err.Error())
+	}
+
+	// Check if recipient valid. Recipients in other banks are checked by their bank
+	receiverAccount := accounts.AccountDetails{}
+	if receiver.BankNumber != "" {
+		_, err = interbank.GetPeer(receiver.BankNumber)
+		if err != nil {
+			return PAINTrans{}, nil, limits.Reservation{}, errors.New("payments.checkCreditTransfer: Recipient bank not found")
+		}
+	} else {
+		receiverAccount, err = accounts.GetAccountByAccountNumber(receiver.AccountNumber)
+		if err != nil {
+			return PAINTrans{}, nil, limits.Reservation{}, errors.New("payments.checkCreditTransfer: Recipient user not found")
+		}
+		err = accounts.CheckAccountActive(receiver.AccountNumber)
+		if err != nil {
+			return PAINTrans{}, nil, limits.Reservation{}, errors.New("payments.checkCreditTransfer: " + err.Error())
+		}
+	}
 
 	// Checks for transaction (avail balance, accounts open, etc)
-	balanceAvailable, err := checkBalance(transaction.Sender)
+	balanceAvailable, err := checkBalance(db, transaction.Sender)
 	if err != nil {
-		return "", errors.New("payments.painCreditTransferInitiation: " + err.Error())
+		return PAINTrans{}, nil, limits.Reservation{}, errors.New("payments.checkCreditTransfer: " + err.Error())
 	}
 	// Comparing decimals results in -1 if <
 	if balanceAvailable.Cmp(transaction.Amount) == -1 {
-		return "", errors.New("payments.painCreditTransferInitiation: Insufficient funds available")
+		return PAINTrans{}, nil, limits.Reservation{}, errors.New("payments.checkCreditTransfer: Insufficient funds available")
 	}
 
-	// Save transaction
-	transactionId, err := processPAINTransaction(transaction)
+	// Score the payment for fraud. Suspicious payments are held for review
+	assessment, err := fraud.Evaluate(fraud.Payment{
+		SenderAccountNumber:   sender.AccountNumber,
+		ReceiverAccountNumber: receiver.AccountNumber,
+		Amount:                transaction.Amount,
+		Lat:                   lat,
+		Lon:                   lon,
+		Timestamp:             time.Now(),
+	})
 	if err != nil {
-		return "", errors.New("payments.painCreditTransferInitiation: " + err.Error())
+		return PAINTrans{}, nil, limits.Reservation{}, errors.New("payments.checkCreditTransfer: " + err.Error())
+	}
+	switch assessment.Action {
+	case fraud.ACTION_BLOCK:
+		return PAINTrans{}, nil, limits.Reservation{}, errors.New("payments.checkCreditTransfer: Payment blocked by fraud checks")
+	case fraud.ACTION_REVIEW:
+		holds = append(holds, transactionHold{HOLD_SOURCE_FRAUD, assessment.Score, strings.Join(assessment.Reasons, "; ")})
 	}
 
-	result = strconv.FormatInt(transactionId, 10)
+	// Screen both parties against the sanctions list
+	screening, err := sanctions.ScreenAll(senderAccount.AccountHolderName, receiverAccount.AccountHolderName)
+	if err != nil {
+		return PAINTrans{}, nil, limits.Reservation{}, errors.New("payments.checkCreditTransfer: " + err.Error())
+	}
+	switch screening.Action {
+	case sanctions.ACTION_BLOCK:
+		return PAINTrans{}, nil, limits.Reservation{}, errors.New("payments.checkCreditTransfer: Payment blocked by sanctions screening")
+	case sanctions.ACTION_REVIEW:
+		holds = append(holds, transactionHold{HOLD_SOURCE_SANCTIONS, int(screening.Score * 100), screening.Name + " matches " + screening.MatchedName + " (" + screening.EntryID + ")"})
+	}
+
+	// Reserve the payment against the spending limits for the account and the
+	// holder last, so no other check can fail once it is
+	reservation, err = limits.ReservePayment(tokenUser, sender.AccountNumber, senderAccount.Type, transaction.Amount)
+	if err != nil {
+		return PAINTrans{}, nil, limits.Reservation{}, errors.New("payments.checkCreditTransfer: " + err.Error())
+	}
+
+	if len(holds) > 0 {
+		transaction.Status = "pending"
+	}
+
+	checked = transaction
+	return
+}
+
+// saveCreditTransfer saves a checked payment with its holds and moves the balances
+func saveCreditTransfer(db execer, transaction PAINTrans, holds []transactionHold) (transactionId int64, err error) {
+	// Save transaction
+	transactionId, err = processPAINTransaction(db, transaction)
+	if err != nil {
+		return 0, errors.New("payments.saveCreditTransfer: " + err.Error())
+	}
 
-	go push.SendNotification(sender.AccountNumber, "💸 Payment sent!", 1, "default")
-	go push.SendNotification(receiver.AccountNumber, "💸 Payment received!", 1, "default")
+	for _, hold := range holds {
+		err = saveTransactionHold(db, transactionId, hold.source, hold.score, hold.reasons)
+		if err != nil {
+			return 0, errors.New("payments.saveCreditTransfer: " + err.Error())
+		}
+	}
 
 	return
 }
 
-func processPAINTransaction(transaction PAINTrans) (transactionId int64, err error) {
+// releaseLimits gives back the spending limits reserved for a payment or
+// deposit that was not made. It has already failed, so a failure to release
+// them is only logged.
+func releaseLimits(reservation limits.Reservation) {
+	err := limits.Release(reservation)
+	if err != nil {
+		log.Printf("payments.releaseLimits: %v", err)
+	}
+}
+
+// holdLimits keeps the reservation of a payment held for review, for a
+// rejection to release. The payment is already saved, so a failure to keep it
+// is only logged.
+func holdLimits(transactionId int64, transaction PAINTrans, reservation limits.Reservation) {
+	if transaction.Status != "pending" {
+		return
+	}
+	err := limits.HoldReservation(transactionId, reservation)
+	if err != nil {
+		log.Printf("payments.holdLimits: %v", err)
+	}
+}
+
+// monitorCreditTransfer hands a saved payment to AML monitoring.
+// Monitoring must not hold up or fail the payment, so a failure is only logged.
+func monitorCreditTransfer(transactionId int64, transaction PAINTrans) {
+	monitored := aml.Transaction{
+		ID:                    transactionId,
+		PainType:              transaction.PainType,
+		SenderAccountNumber:   transaction.Sender.AccountNumber,
+		ReceiverAccountNumber: transaction.Receiver.AccountNumber,
+		Amount:                transaction.Amount,
+		Timestamp:             time.Now(),
+	}
+	go func() {
+		err := aml.Monitor(monitored)
+		if err != nil {
+			log.Printf("payments.monitorCreditTransfer: %v", err)
+		}
+	}()
+}
+
+func processPAINTransaction(db execer, transaction PAINTrans) (transactionId int64, err error) {
 	// Test: pain~1~1b2ca241-0373-4610-abad-da7b06c50a7b@~181ac0ae-45cb-461d-b740-15ce33e4612f@~20
 
+	// Amounts with more decimal places than are stored would be rounded
+	if !money.Valid(transaction.Amount) {
+		return 0, errors.New("payments.processPAINTransaction: Amount has more than " + strconv.Itoa(money.SCALE) + " decimal places")
+	}
+
 	// Save in transaction table
-	transactionId, err = savePainTransaction(transaction)
+	transactionId, err = savePainTransaction(db, transaction)
 	if err != nil {
 		return 0, errors.New("payments.processPAINTransaction: " + err.Error())
 	}
 
+	// Pending transactions only hold the sender's funds until they are reviewed
+	if transaction.Status == "pending" {
+		err = holdSenderFunds(db, transaction)
+		if err != nil {
+			return 0, errors.New("payments.processPAINTransaction: " + err.Error())
+		}
+		return
+	}
+
 	// Amend sender and receiver accounts
 	// Amend bank's account with fee addition
-	err = updateAccounts(transaction)
+	transaction.ID = int32(transactionId)
+	err = updateAccounts(db, transaction)
 	if err != nil {
 		return 0, errors.New("payments.processPAINTransaction: " + err.Error())
 	}
@@ -206,6 +484,16 @@ func parseAccountHolder(account string) (accountHolder AccountHolder, err error)
 	return
 }
 
+// localBankNumber gives the bank number a transaction stores for bankNumber.
+// Accounts in this bank are stored without one.
+func localBankNumber(bankNumber string) string {
+	bankNumber = strings.TrimSpace(bankNumber)
+	if bankNumber == accounts.LocalBankNumber() {
+		return ""
+	}
+	return bankNumber
+}
+
 func customerDepositInitiation(painType int64, data []string) (result string, err error) {
 	// Validate input
 	// Sender is bank
@@ -255,9 +543,9 @@ func customerDepositInitiation(painType int64, data []string) (result string, er
 	// @TODO This flow show be fixed. Maybe have banks approve deposits before initiation, or
 	// immediate approval below a certain amount subject to rate limiting
 	geo := *geo.NewPoint(lat, lon)
-	transaction := PAINTrans{0, painType, sender, receiver, transactionAmountDecimal, decimal.NewFromFloat(TRANSACTION_FEE), geo, desc, "approved", 0}
+	transaction := PAINTrans{0, painType, sender, receiver, transactionAmountDecimal, decimal.NewFromFloat(TRANSACTION_FEE), geo, desc, "approved", 0, ""}
 	// Save transaction
-	transactionId, err := processPAINTransaction(transaction)
+	transactionId, err := processPAINTransaction(Config.Db, transaction)
 	if err != nil {
 		return "", errors.New("payments.CustomerDepositInitiation: " + err.Error())
 	}
@@ -270,7 +558,7 @@ func customerDepositInitiation(painType int64, data []string) (result string, er
 }
 
 func listTransactions(data []string) (result []PAINTrans, err error) {
-	tokenUser, err := appauth.GetUserFromToken(data[0])
+	tokenUser, merchantID, err := appauth.GetUserFromScopedToken(data[0], appauth.SCOPE_PAYMENTS_READ)
 	if err != nil {
 		return []PAINTrans{}, errors.New("payments.ListTransactions: " + err.Error())
 	}
@@ -279,6 +567,10 @@ func listTransactions(data []string) (result []PAINTrans, err error) {
 	if err != nil {
 		return []PAINTrans{}, errors.New("payments.ListTransactions: " + err.Error())
 	}
+	err = accounts.CheckMerchantAccount(merchantID, accountNumber)
+	if err != nil {
+		return []PAINTrans{}, errors.New("payments.ListTransactions: " + err.Error())
+	}
 
 	page, err := strconv.Atoi(data[4])
 	if err != nil {
@@ -317,6 +609,11 @@ func listTransactions(data []string) (result []PAINTrans, err error) {
 }
 
 func adminDepositInitiation(painType int64, data []string) (result string, err error) {
+	_, err = appauth.CheckPermission(data[:10], appauth.PERMISSION_DEPOSIT)
+	if err != nil {
+		return "", errors.New("payments.adminDepositInitiation: " + err.Error())
+	}
+
 	// Validate input
 	// Sender is bank
 	sender, err := parseAccountHolder("0@0")
@@ -336,7 +633,7 @@ func adminDepositInitiation(painType int64, data []string) (result string, err e
 	}
 
 	// Check if recipient valid
-	_, err = accounts.GetAccountByAccountNumber(receiver.AccountNumber)
+	receiverAccount, err := accounts.GetAccountByAccountNumber(receiver.AccountNumber)
 	if err != nil {
 		return "", errors.New("payments.adminDepositInitiation: Recipient user not found")
 	}
@@ -355,15 +652,25 @@ func adminDepositInitiation(painType int64, data []string) (result string, err e
 	// @TODO This flow show be fixed. Maybe have banks approve deposits before initiation, or
 	// immediate approval below a certain amount subject to rate limiting
 	geo := *geo.NewPoint(lat, lon)
-	transaction := PAINTrans{0, painType, sender, receiver, transactionAmountDecimal, decimal.NewFromFloat(TRANSACTION_FEE), geo, desc, "approved", 0}
+	transaction := PAINTrans{0, painType, sender, receiver, transactionAmountDecimal, decimal.NewFromFloat(TRANSACTION_FEE), geo, desc, "approved", 0, ""}
+
+	// Reserve the deposit against the deposit limits for the account
+	reservation, err := limits.ReserveDeposit(receiver.AccountNumber, receiverAccount.Type, transaction.Amount)
+	if err != nil {
+		return "", errors.New("payments.adminDepositInitiation: " + err.Error())
+	}
+
 	// Save transaction
-	transactionId, err := processPAINTransaction(transaction)
+	transactionId, err := processPAINTransaction(Config.Db, transaction)
 	if err != nil {
+		releaseLimits(reservation)
 		return "", errors.New("payments.CustomerDepositInitiation: " + err.Error())
 	}
 
 	result = strconv.FormatInt(transactionId, 10)
 
+	monitorCreditTransfer(transactionId, transaction)
+
 	go push.SendNotification(receiver.AccountNumber, "💸 Deposit received!", 1, "default")
 
 	return
@@ -26,6 +26,18 @@ func TestProcessPAIN(t *testing.T) {
 	if err == nil {
 		t.Errorf("ProcessPAIN PainType1000 does not pass. Looking for %v, got %v", "Not all data is present. Run pain~help to check for needed PAIN data", nil)
 	}
+
+	data = []string{"", "", "1002"}
+	_, err = ProcessPAIN(data)
+	if err == nil {
+		t.Errorf("ProcessPAIN PainType1002 does not pass. Looking for %v, got %v", "Not all data is present.", nil)
+	}
+
+	data = []string{"", "", "1003", "1", "comment"}
+	_, err = ProcessPAIN(data)
+	if err == nil {
+		t.Errorf("ProcessPAIN PainType1003 does not pass. Looking for %v, got %v", "Not all data is present.", nil)
+	}
 }
 
 func BenchmarkProcessPAIN(b *testing.B) {